@@ -0,0 +1,285 @@
+package com.shushu.remote.capture
+
+import android.graphics.SurfaceTexture
+import android.opengl.EGL14
+import android.opengl.EGLConfig
+import android.opengl.EGLContext
+import android.opengl.EGLDisplay
+import android.opengl.EGLExt
+import android.opengl.EGLSurface
+import android.opengl.GLES11Ext
+import android.opengl.GLES20
+import android.os.Handler
+import android.os.Looper
+import android.util.Log
+import android.view.Surface
+import java.nio.ByteBuffer
+import java.nio.ByteOrder
+import java.nio.FloatBuffer
+import java.util.concurrent.CountDownLatch
+import java.util.concurrent.TimeUnit
+
+/**
+ * 推流区域渲染器 - 用 GPU 把全屏画面裁剪、旋转、缩放后绘制到输出 Surface（H264 编码器或 ImageReader）
+ * VirtualDisplay 按原始分辨率输出到 [inputSurface]（SurfaceTexture），每帧只在 GPU 上采样裁剪区域，不经过 CPU 拷贝
+ * GL 调用都在 handler 所在线程执行
+ */
+class RegionRenderer(
+    outputSurface: Surface,
+    private val region: StreamRegion,
+    private val inputWidth: Int,
+    private val inputHeight: Int,
+    private val outputWidth: Int,
+    private val outputHeight: Int,
+    private val handler: Handler,
+    private val frameDue: () -> Boolean
+) {
+    companion object {
+        private const val TAG = "RegionRenderer"
+        private const val EGL_RECORDABLE_ANDROID = 0x3142
+        private const val SETUP_TIMEOUT_MS = 3000L
+
+        private const val VERTEX_SHADER = """
+            attribute vec4 aPosition;
+            attribute vec4 aTexCoord;
+            uniform mat4 uTexMatrix;
+            varying vec2 vTexCoord;
+            void main() {
+                gl_Position = aPosition;
+                vTexCoord = (uTexMatrix * aTexCoord).xy;
+            }
+        """
+
+        private const val FRAGMENT_SHADER = """
+            #extension GL_OES_EGL_image_external : require
+            precision mediump float;
+            varying vec2 vTexCoord;
+            uniform samplerExternalOES sTexture;
+            void main() {
+                gl_FragColor = texture2D(sTexture, vTexCoord);
+            }
+        """
+
+        // 输出画面四角（左下、右下、左上、右上），按 TRIANGLE_STRIP 绘制
+        private val POSITIONS = floatArrayOf(-1f, -1f, 1f, -1f, -1f, 1f, 1f, 1f)
+    }
+
+    private var eglDisplay: EGLDisplay = EGL14.EGL_NO_DISPLAY
+    private var eglContext: EGLContext = EGL14.EGL_NO_CONTEXT
+    private var eglSurface: EGLSurface = EGL14.EGL_NO_SURFACE
+    private var program = 0
+    private var textureId = 0
+    private var surfaceTexture: SurfaceTexture? = null
+    private val texMatrix = FloatArray(16)
+    private val positions = floatBuffer(POSITIONS)
+    private val texCoords = floatBuffer(regionTexCoords())
+
+    /** VirtualDisplay 的输出目标，尺寸为 inputWidth x inputHeight */
+    var inputSurface: Surface? = null
+        private set
+
+    init {
+        var error: Exception? = null
+        runOnHandler {
+            try {
+                setup(outputSurface)
+            } catch (e: Exception) {
+                error = e
+                releaseGl()
+            }
+        }
+        error?.let { throw it }
+        if (inputSurface == null) {
+            throw IllegalStateException("Region renderer setup timed out")
+        }
+        Log.d(TAG, "Region renderer ready: ${inputWidth}x${inputHeight} -> ${outputWidth}x${outputHeight}, $region")
+    }
+
+    private fun setup(outputSurface: Surface) {
+        eglDisplay = EGL14.eglGetDisplay(EGL14.EGL_DEFAULT_DISPLAY)
+        val version = IntArray(2)
+        check(EGL14.eglInitialize(eglDisplay, version, 0, version, 1)) { "eglInitialize failed" }
+
+        val attribs = intArrayOf(
+            EGL14.EGL_RED_SIZE, 8,
+            EGL14.EGL_GREEN_SIZE, 8,
+            EGL14.EGL_BLUE_SIZE, 8,
+            EGL14.EGL_ALPHA_SIZE, 8,
+            EGL14.EGL_RENDERABLE_TYPE, EGL14.EGL_OPENGL_ES2_BIT,
+            EGL_RECORDABLE_ANDROID, 1,
+            EGL14.EGL_NONE
+        )
+        val configs = arrayOfNulls<EGLConfig>(1)
+        val count = IntArray(1)
+        check(EGL14.eglChooseConfig(eglDisplay, attribs, 0, configs, 0, 1, count, 0) && count[0] > 0) { "eglChooseConfig failed" }
+        val config = configs[0]
+
+        eglContext = EGL14.eglCreateContext(
+            eglDisplay, config, EGL14.EGL_NO_CONTEXT,
+            intArrayOf(EGL14.EGL_CONTEXT_CLIENT_VERSION, 2, EGL14.EGL_NONE), 0
+        )
+        check(eglContext != EGL14.EGL_NO_CONTEXT) { "eglCreateContext failed" }
+        eglSurface = EGL14.eglCreateWindowSurface(eglDisplay, config, outputSurface, intArrayOf(EGL14.EGL_NONE), 0)
+        check(eglSurface != EGL14.EGL_NO_SURFACE) { "eglCreateWindowSurface failed" }
+        check(EGL14.eglMakeCurrent(eglDisplay, eglSurface, eglSurface, eglContext)) { "eglMakeCurrent failed" }
+
+        program = createProgram()
+        val textures = IntArray(1)
+        GLES20.glGenTextures(1, textures, 0)
+        textureId = textures[0]
+        GLES20.glBindTexture(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, textureId)
+        GLES20.glTexParameteri(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, GLES20.GL_TEXTURE_MIN_FILTER, GLES20.GL_LINEAR)
+        GLES20.glTexParameteri(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, GLES20.GL_TEXTURE_MAG_FILTER, GLES20.GL_LINEAR)
+        GLES20.glTexParameteri(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, GLES20.GL_TEXTURE_WRAP_S, GLES20.GL_CLAMP_TO_EDGE)
+        GLES20.glTexParameteri(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, GLES20.GL_TEXTURE_WRAP_T, GLES20.GL_CLAMP_TO_EDGE)
+
+        surfaceTexture = SurfaceTexture(textureId).apply {
+            setDefaultBufferSize(inputWidth, inputHeight)
+            setOnFrameAvailableListener({ drawFrame() }, handler)
+        }
+        inputSurface = Surface(surfaceTexture)
+    }
+
+    /**
+     * 取出最新的全屏画面，到了发送时间时绘制到输出 Surface
+     */
+    private fun drawFrame() {
+        val texture = surfaceTexture ?: return
+        try {
+            // 不绘制也要取出画面，否则 VirtualDisplay 不再产生新帧
+            texture.updateTexImage()
+            if (!frameDue()) return
+
+            texture.getTransformMatrix(texMatrix)
+            GLES20.glViewport(0, 0, outputWidth, outputHeight)
+            GLES20.glUseProgram(program)
+
+            val position = GLES20.glGetAttribLocation(program, "aPosition")
+            GLES20.glEnableVertexAttribArray(position)
+            GLES20.glVertexAttribPointer(position, 2, GLES20.GL_FLOAT, false, 0, positions)
+            val texCoord = GLES20.glGetAttribLocation(program, "aTexCoord")
+            GLES20.glEnableVertexAttribArray(texCoord)
+            GLES20.glVertexAttribPointer(texCoord, 2, GLES20.GL_FLOAT, false, 0, texCoords)
+            GLES20.glUniformMatrix4fv(GLES20.glGetUniformLocation(program, "uTexMatrix"), 1, false, texMatrix, 0)
+
+            GLES20.glActiveTexture(GLES20.GL_TEXTURE0)
+            GLES20.glBindTexture(GLES11Ext.GL_TEXTURE_EXTERNAL_OES, textureId)
+            GLES20.glDrawArrays(GLES20.GL_TRIANGLE_STRIP, 0, 4)
+
+            GLES20.glDisableVertexAttribArray(position)
+            GLES20.glDisableVertexAttribArray(texCoord)
+
+            EGLExt.eglPresentationTimeANDROID(eglDisplay, eglSurface, texture.timestamp)
+            EGL14.eglSwapBuffers(eglDisplay, eglSurface)
+        } catch (e: Exception) {
+            Log.e(TAG, "Error rendering region frame", e)
+        }
+    }
+
+    /**
+     * 输出画面四角对应的全屏纹理坐标（纹理原点在左下角）
+     */
+    private fun regionTexCoords(): FloatArray {
+        val width = region.rotatedWidth.toFloat()
+        val height = region.rotatedHeight.toFloat()
+        val corners = listOf(0f to height, width to height, 0f to 0f, width to 0f)
+        val coords = FloatArray(8)
+        corners.forEachIndexed { i, (x, y) ->
+            val (sx, sy) = region.toScreen(x, y)
+            coords[i * 2] = sx / inputWidth
+            coords[i * 2 + 1] = 1f - sy / inputHeight
+        }
+        return coords
+    }
+
+    private fun createProgram(): Int {
+        val vertex = compileShader(GLES20.GL_VERTEX_SHADER, VERTEX_SHADER)
+        val fragment = compileShader(GLES20.GL_FRAGMENT_SHADER, FRAGMENT_SHADER)
+        val program = GLES20.glCreateProgram()
+        GLES20.glAttachShader(program, vertex)
+        GLES20.glAttachShader(program, fragment)
+        GLES20.glLinkProgram(program)
+        GLES20.glDeleteShader(vertex)
+        GLES20.glDeleteShader(fragment)
+        val status = IntArray(1)
+        GLES20.glGetProgramiv(program, GLES20.GL_LINK_STATUS, status, 0)
+        if (status[0] == 0) {
+            val log = GLES20.glGetProgramInfoLog(program)
+            GLES20.glDeleteProgram(program)
+            throw IllegalStateException("Program link failed: $log")
+        }
+        return program
+    }
+
+    private fun compileShader(type: Int, source: String): Int {
+        val shader = GLES20.glCreateShader(type)
+        GLES20.glShaderSource(shader, source)
+        GLES20.glCompileShader(shader)
+        val status = IntArray(1)
+        GLES20.glGetShaderiv(shader, GLES20.GL_COMPILE_STATUS, status, 0)
+        if (status[0] == 0) {
+            val log = GLES20.glGetShaderInfoLog(shader)
+            GLES20.glDeleteShader(shader)
+            throw IllegalStateException("Shader compile failed: $log")
+        }
+        return shader
+    }
+
+    /**
+     * 释放 GL 资源，应在释放 VirtualDisplay 之后、释放输出 Surface 之前调用
+     */
+    fun release() {
+        runOnHandler { releaseGl() }
+    }
+
+    private fun releaseGl() {
+        surfaceTexture?.setOnFrameAvailableListener(null)
+        inputSurface?.release()
+        inputSurface = null
+        surfaceTexture?.release()
+        surfaceTexture = null
+
+        if (eglDisplay != EGL14.EGL_NO_DISPLAY) {
+            if (program != 0) GLES20.glDeleteProgram(program)
+            if (textureId != 0) GLES20.glDeleteTextures(1, intArrayOf(textureId), 0)
+            EGL14.eglMakeCurrent(eglDisplay, EGL14.EGL_NO_SURFACE, EGL14.EGL_NO_SURFACE, EGL14.EGL_NO_CONTEXT)
+            if (eglSurface != EGL14.EGL_NO_SURFACE) EGL14.eglDestroySurface(eglDisplay, eglSurface)
+            if (eglContext != EGL14.EGL_NO_CONTEXT) EGL14.eglDestroyContext(eglDisplay, eglContext)
+            EGL14.eglReleaseThread()
+        }
+        program = 0
+        textureId = 0
+        eglSurface = EGL14.EGL_NO_SURFACE
+        eglContext = EGL14.EGL_NO_CONTEXT
+        eglDisplay = EGL14.EGL_NO_DISPLAY
+    }
+
+    /**
+     * 在 handler 线程上执行并等待完成
+     */
+    private fun runOnHandler(block: () -> Unit) {
+        if (Looper.myLooper() == handler.looper) {
+            block()
+            return
+        }
+        val latch = CountDownLatch(1)
+        handler.post {
+            try {
+                block()
+            } finally {
+                latch.countDown()
+            }
+        }
+        latch.await(SETUP_TIMEOUT_MS, TimeUnit.MILLISECONDS)
+    }
+
+    private fun floatBuffer(values: FloatArray): FloatBuffer {
+        return ByteBuffer.allocateDirect(values.size * 4)
+            .order(ByteOrder.nativeOrder())
+            .asFloatBuffer()
+            .apply {
+                put(values)
+                position(0)
+            }
+    }
+}
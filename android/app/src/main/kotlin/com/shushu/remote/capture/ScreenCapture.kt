@@ -16,6 +16,7 @@ import java.io.ByteArrayOutputStream
 import java.util.concurrent.atomic.AtomicBoolean
 import java.util.concurrent.atomic.AtomicInteger
 import java.util.concurrent.atomic.AtomicLong
+import java.util.concurrent.atomic.AtomicReference
 
 /**
  * 屏幕采集器 - 支持 MJPEG 和 H264 双模式
@@ -44,6 +45,10 @@ class ScreenCapture(
         const val FRAME_TYPE_MJPEG: Byte = 0x01
         const val FRAME_TYPE_H264: Byte = 0x02
         const val FRAME_TYPE_H264_CONFIG: Byte = 0x03
+        const val FRAME_TYPE_THUMBNAIL: Byte = 0x04
+
+        // 缩略图超时（未取到画面时释放临时 VirtualDisplay）
+        private const val THUMBNAIL_TIMEOUT_MS = 3000L
     }
 
     // 当前采集参数
@@ -66,7 +71,14 @@ class ScreenCapture(
     private var h264VirtualDisplay: VirtualDisplay? = null
     private var h264ConfigData: ByteArray? = null  // 缓存 SPS/PPS
 
+    // 推流区域（按原始分辨率采集，由 GPU 裁剪旋转缩放到输出分辨率）
+    private var region: StreamRegion? = null
+    private var regionRenderer: RegionRenderer? = null
+    private var lastRegionFrameTime = 0L
+
     private val isCapturing = AtomicBoolean(false)
+    private val isCapturingThumbnail = AtomicBoolean(false)
+    private val pendingThumbnail = AtomicReference<ThumbnailRequest?>(null)  // 等待从推流画面截取的缩略图
     private var lastFrameTime = 0L
 
     private var handlerThread: HandlerThread? = null
@@ -84,18 +96,44 @@ class ScreenCapture(
     private var consecutiveSlowFrames = 0
     private var consecutiveFastFrames = 0
 
+    /** 设备屏幕宽度（推流区域坐标基准） */
+    val screenWidth: Int
+        get() = originalWidth
+
+    /** 设备屏幕高度 */
+    val screenHeight: Int
+        get() = originalHeight
+
     fun setFrameCallback(callback: (ByteArray) -> Unit) {
         frameCallback = callback
     }
 
-    fun startCapture(quality: Int = 80, maxFps: Int = 30) {
+    fun startCapture(quality: Int = 80, maxFps: Int = 30, maxWidth: Int = 0, maxHeight: Int = 0, region: StreamRegion? = null) {
         if (isCapturing.get()) {
-            Log.d(TAG, "Already capturing")
-            return
+            if (region == this.region) {
+                Log.d(TAG, "Already capturing")
+                return
+            }
+            // 推流区域变化，按新区域重新采集
+            stopCapture()
         }
 
         this.quality = quality
         this.maxFps = maxFps
+        this.region = region
+
+        if (region != null) {
+            // ImageReader 直接接收裁剪旋转缩放后的画面
+            val (width, height) = region.outputSize(maxWidth, maxHeight, 2)
+            currentWidth = width
+            currentHeight = height
+            Log.d(TAG, "Stream region: $region -> ${width}x${height}")
+        } else if (maxWidth > 0 || maxHeight > 0) {
+            // 推流配置限制的最大分辨率
+            val scale = resolutionScale(maxWidth, maxHeight)
+            currentWidth = (originalWidth * scale).toInt()
+            currentHeight = (originalHeight * scale).toInt()
+        }
 
         Log.d(TAG, "Starting capture: ${currentWidth}x${currentHeight}, quality=$quality, maxFps=$maxFps")
 
@@ -105,12 +143,13 @@ class ScreenCapture(
 
         createImageReader()
 
-        // 尝试使用系统权限模式创建 VirtualDisplay
-        var success = tryCreateVirtualDisplayWithSystemPermission()
-
-        // 如果系统权限模式失败，回退到 MediaProjection 模式
-        if (!success && mediaProjection != null) {
-            success = tryCreateVirtualDisplayWithMediaProjection()
+        // 推流区域模式由 processImage 控制帧率，渲染器每帧都绘制
+        val success = if (region != null) {
+            imageReader?.surface?.let { createRegionVirtualDisplay(region, it) { isCapturing.get() } } ?: false
+        } else {
+            // 尝试使用系统权限模式创建 VirtualDisplay，失败时回退到 MediaProjection 模式
+            tryCreateVirtualDisplayWithSystemPermission() ||
+                (mediaProjection != null && tryCreateVirtualDisplayWithMediaProjection())
         }
 
         if (success) {
@@ -190,17 +229,22 @@ class ScreenCapture(
     }
 
     private fun cleanup() {
-        // 清理 MJPEG 组件
+        // 先停止 VirtualDisplay 输出，再释放推流区域渲染器和它的输出目标
         virtualDisplay?.release()
         virtualDisplay = null
+        h264VirtualDisplay?.release()
+        h264VirtualDisplay = null
+        regionRenderer?.release()
+        regionRenderer = null
+
+        // 清理 MJPEG 组件
         imageReader?.close()
         imageReader = null
 
         // 清理 H264 组件
         h264Encoder?.release()
         h264Encoder = null
-        h264VirtualDisplay?.release()
-        h264VirtualDisplay = null
+        pendingThumbnail.set(null)
 
         handlerThread?.quitSafely()
         handlerThread = null
@@ -210,7 +254,7 @@ class ScreenCapture(
     /**
      * 启动 H264 模式采集
      */
-    fun startH264Capture(bitrate: Int = 2_000_000, fps: Int = 30) {
+    fun startH264Capture(bitrate: Int = 2_000_000, fps: Int = 30, maxWidth: Int = 0, maxHeight: Int = 0, region: StreamRegion? = null) {
         if (isCapturing.get()) {
             Log.d(TAG, "Already capturing, stopping first")
             stopCapture()
@@ -218,19 +262,31 @@ class ScreenCapture(
 
         currentMode = MODE_H264
         this.maxFps = fps
+        this.region = region
+
+        if (region != null) {
+            // 编码器按推流区域的输出分辨率（16 的倍数）创建
+            val (width, height) = region.outputSize(maxWidth, maxHeight, 16)
+            currentWidth = width
+            currentHeight = height
+            Log.d(TAG, "Stream region: $region -> ${width}x${height}")
+        } else {
+            // 计算采集分辨率（保持宽高比，限制最大分辨率）
+            // 未指定最大分辨率时按屏幕宽度自动缩小
+            val scale = if (maxWidth > 0 || maxHeight > 0) {
+                resolutionScale(maxWidth, maxHeight)
+            } else when {
+                originalWidth > 1920 -> 0.5f
+                originalWidth > 1080 -> 0.75f
+                else -> 1.0f
+            }
+            currentWidth = (originalWidth * scale).toInt()
+            currentHeight = (originalHeight * scale).toInt()
 
-        // 计算采集分辨率（保持宽高比，限制最大分辨率）
-        val scale = when {
-            originalWidth > 1920 -> 0.5f
-            originalWidth > 1080 -> 0.75f
-            else -> 1.0f
+            // 确保宽高是 16 的倍数（H264 编码要求）
+            currentWidth = (currentWidth / 16) * 16
+            currentHeight = (currentHeight / 16) * 16
         }
-        currentWidth = (originalWidth * scale).toInt()
-        currentHeight = (originalHeight * scale).toInt()
-
-        // 确保宽高是 16 的倍数（H264 编码要求）
-        currentWidth = (currentWidth / 16) * 16
-        currentHeight = (currentHeight / 16) * 16
 
         Log.d(TAG, "Starting H264 capture: ${currentWidth}x${currentHeight}, bitrate=$bitrate, fps=$fps")
 
@@ -254,8 +310,12 @@ class ScreenCapture(
             return
         }
 
-        // 创建 VirtualDisplay 输出到编码器 Surface
-        val success = createH264VirtualDisplay(encoderSurface)
+        // 创建 VirtualDisplay 输出到编码器 Surface，指定推流区域时经 GPU 变换后绘制到编码器
+        val success = if (region != null) {
+            createRegionVirtualDisplay(region, encoderSurface, ::regionFrameDue)
+        } else {
+            createH264VirtualDisplay(encoderSurface, currentWidth, currentHeight)
+        }
         if (success) {
             isCapturing.set(true)
             lastBandwidthCheckTime = System.currentTimeMillis()
@@ -266,17 +326,27 @@ class ScreenCapture(
         }
     }
 
+    /**
+     * 计算不超过最大分辨率的缩放比例（保持宽高比，0 表示该方向不限制）
+     */
+    private fun resolutionScale(maxWidth: Int, maxHeight: Int): Float {
+        var scale = 1.0f
+        if (maxWidth > 0) scale = minOf(scale, maxWidth.toFloat() / originalWidth)
+        if (maxHeight > 0) scale = minOf(scale, maxHeight.toFloat() / originalHeight)
+        return scale
+    }
+
     /**
      * 创建 H264 模式的 VirtualDisplay
      */
-    private fun createH264VirtualDisplay(surface: Surface): Boolean {
+    private fun createH264VirtualDisplay(surface: Surface, width: Int, height: Int): Boolean {
         return try {
             // 优先使用 MediaProjection
             if (mediaProjection != null) {
                 h264VirtualDisplay = mediaProjection.createVirtualDisplay(
                     "H264Capture",
-                    currentWidth,
-                    currentHeight,
+                    width,
+                    height,
                     density,
                     DisplayManager.VIRTUAL_DISPLAY_FLAG_AUTO_MIRROR,
                     surface,
@@ -288,8 +358,8 @@ class ScreenCapture(
                 val displayManager = context.getSystemService(Context.DISPLAY_SERVICE) as DisplayManager
                 h264VirtualDisplay = displayManager.createVirtualDisplay(
                     "H264Capture",
-                    currentWidth,
-                    currentHeight,
+                    width,
+                    height,
                     density,
                     surface,
                     DisplayManager.VIRTUAL_DISPLAY_FLAG_AUTO_MIRROR
@@ -303,6 +373,34 @@ class ScreenCapture(
         }
     }
 
+    /**
+     * 创建推流区域模式的 VirtualDisplay：按原始分辨率输出到渲染器，渲染器裁剪旋转缩放后绘制到 output
+     * frameDue 返回 false 时跳过该帧
+     */
+    private fun createRegionVirtualDisplay(region: StreamRegion, output: Surface, frameDue: () -> Boolean): Boolean {
+        val threadHandler = handler ?: return false
+        val renderer = try {
+            RegionRenderer(output, region, originalWidth, originalHeight, currentWidth, currentHeight, threadHandler, frameDue)
+        } catch (e: Exception) {
+            Log.e(TAG, "Failed to create region renderer", e)
+            return false
+        }
+        regionRenderer = renderer
+        val surface = renderer.inputSurface ?: return false
+        return createH264VirtualDisplay(surface, originalWidth, originalHeight)
+    }
+
+    /**
+     * H264 推流区域模式的帧率控制
+     */
+    private fun regionFrameDue(): Boolean {
+        if (!isCapturing.get()) return false
+        val now = System.currentTimeMillis()
+        if (now - lastRegionFrameTime < 1000L / maxFps) return false
+        lastRegionFrameTime = now
+        return true
+    }
+
     /**
      * 打包 H264 帧为二进制协议格式
      * 格式: [Type(1B)][Flags(1B)][Payload]
@@ -444,8 +542,8 @@ class ScreenCapture(
         val newWidth = (originalWidth * scale).toInt()
         val newHeight = (originalHeight * scale).toInt()
 
-        // 只有分辨率变化时才重建 VirtualDisplay
-        if (newWidth != currentWidth || newHeight != currentHeight) {
+        // 只有分辨率变化时才重建 VirtualDisplay（推流区域模式的输出分辨率由区域决定，只调整质量和帧率）
+        if (region == null && (newWidth != currentWidth || newHeight != currentHeight)) {
             currentWidth = newWidth
             currentHeight = newHeight
 
@@ -500,56 +598,25 @@ class ScreenCapture(
     private fun processImage(reader: ImageReader) {
         if (!isCapturing.get()) return
 
-        // 帧率控制
-        val now = System.currentTimeMillis()
-        val minInterval = 1000L / maxFps
-        if (now - lastFrameTime < minInterval) {
-            reader.acquireLatestImage()?.close()
-            return
-        }
-
-        // 如果待发送帧太多，跳过这一帧（丢帧策略）
-        if (pendingFrames.get() > 3) {
-            reader.acquireLatestImage()?.close()
-            Log.d(TAG, "Frame skipped due to network congestion")
-            return
-        }
-
-        lastFrameTime = now
-
         var image: Image? = null
         try {
             image = reader.acquireLatestImage() ?: return
+            sendPendingThumbnail(image)
 
-            val planes = image.planes
-            val buffer = planes[0].buffer
-            val pixelStride = planes[0].pixelStride
-            val rowStride = planes[0].rowStride
-            val rowPadding = rowStride - pixelStride * currentWidth
-
-            // 创建 Bitmap
-            val bitmap = Bitmap.createBitmap(
-                currentWidth + rowPadding / pixelStride,
-                currentHeight,
-                Bitmap.Config.ARGB_8888
-            )
-            bitmap.copyPixelsFromBuffer(buffer)
+            // 帧率控制
+            val now = System.currentTimeMillis()
+            val minInterval = 1000L / maxFps
+            if (now - lastFrameTime < minInterval) return
 
-            // 裁剪到正确尺寸
-            val croppedBitmap = if (rowPadding > 0) {
-                Bitmap.createBitmap(bitmap, 0, 0, currentWidth, currentHeight).also {
-                    bitmap.recycle()
-                }
-            } else {
-                bitmap
+            // 如果待发送帧太多，跳过这一帧（丢帧策略）
+            if (pendingFrames.get() > 3) {
+                Log.d(TAG, "Frame skipped due to network congestion")
+                return
             }
 
-            // 压缩为 JPEG
-            val outputStream = ByteArrayOutputStream()
-            croppedBitmap.compress(Bitmap.CompressFormat.JPEG, quality, outputStream)
-            croppedBitmap.recycle()
+            lastFrameTime = now
 
-            val jpegData = outputStream.toByteArray()
+            val jpegData = encodeJpeg(image, currentWidth, currentHeight, quality)
 
             // 标记待发送
             pendingFrames.incrementAndGet()
@@ -562,6 +629,152 @@ class ScreenCapture(
         }
     }
 
+    /**
+     * 将 RGBA 图像压缩为 JPEG
+     */
+    private fun encodeJpeg(image: Image, width: Int, height: Int, quality: Int): ByteArray {
+        return compressJpeg(imageToBitmap(image, width, height), quality)
+    }
+
+    /**
+     * 将 RGBA 图像转换为 Bitmap（去除行填充）
+     */
+    private fun imageToBitmap(image: Image, width: Int, height: Int): Bitmap {
+        val planes = image.planes
+        val buffer = planes[0].buffer
+        val pixelStride = planes[0].pixelStride
+        val rowStride = planes[0].rowStride
+        val rowPadding = rowStride - pixelStride * width
+
+        // 创建 Bitmap
+        val bitmap = Bitmap.createBitmap(
+            width + rowPadding / pixelStride,
+            height,
+            Bitmap.Config.ARGB_8888
+        )
+        bitmap.copyPixelsFromBuffer(buffer)
+
+        // 裁剪到正确尺寸
+        return if (rowPadding > 0) {
+            Bitmap.createBitmap(bitmap, 0, 0, width, height).also {
+                bitmap.recycle()
+            }
+        } else {
+            bitmap
+        }
+    }
+
+    /**
+     * 压缩为 JPEG 并回收 Bitmap
+     */
+    private fun compressJpeg(bitmap: Bitmap, quality: Int): ByteArray {
+        val outputStream = ByteArrayOutputStream()
+        bitmap.compress(Bitmap.CompressFormat.JPEG, quality, outputStream)
+        bitmap.recycle()
+        return outputStream.toByteArray()
+    }
+
+    /**
+     * 截取一张缩略图（服务端 screen.thumbnail 请求），不影响正在进行的推流，发送格式: [0x04][0x00][JPEG]
+     * 正在以 MJPEG 全屏模式采集时从下一帧画面缩放得到，否则需要系统权限创建临时的小尺寸 VirtualDisplay。
+     * Android 14 起一个 MediaProjection 只能创建一次 VirtualDisplay，不为缩略图额外创建，此时不回复，由服务端按超时处理
+     */
+    fun captureThumbnail(maxWidth: Int, quality: Int) {
+        if (isCapturing.get() && imageReader != null && region == null) {
+            pendingThumbnail.set(ThumbnailRequest(maxWidth, quality))
+            return
+        }
+        if (!isCapturingThumbnail.compareAndSet(false, true)) {
+            Log.d(TAG, "Thumbnail capture already in progress")
+            return
+        }
+
+        val scale = minOf(1.0f, maxWidth.toFloat() / originalWidth)
+        val width = maxOf(2, (originalWidth * scale).toInt() / 2 * 2)
+        val height = maxOf(2, (originalHeight * scale).toInt() / 2 * 2)
+
+        val thread = HandlerThread("ThumbnailThread").apply { start() }
+        val threadHandler = Handler(thread.looper)
+        val reader = ImageReader.newInstance(width, height, PixelFormat.RGBA_8888, 2)
+        var display: VirtualDisplay? = null
+        val finished = AtomicBoolean(false)
+
+        // 在缩略图线程上释放临时资源
+        val finish = {
+            if (finished.compareAndSet(false, true)) {
+                display?.release()
+                reader.close()
+                thread.quitSafely()
+                isCapturingThumbnail.set(false)
+            }
+        }
+
+        reader.setOnImageAvailableListener({ r ->
+            if (finished.get()) return@setOnImageAvailableListener
+            val image = r.acquireLatestImage() ?: return@setOnImageAvailableListener
+            try {
+                sendThumbnail(encodeJpeg(image, width, height, quality), width, height)
+            } catch (e: Exception) {
+                Log.e(TAG, "Error capturing thumbnail", e)
+            } finally {
+                image.close()
+                finish()
+            }
+        }, threadHandler)
+
+        threadHandler.post {
+            display = try {
+                val displayManager = context.getSystemService(Context.DISPLAY_SERVICE) as DisplayManager
+                displayManager.createVirtualDisplay(
+                    "Thumbnail",
+                    width,
+                    height,
+                    density,
+                    reader.surface,
+                    DisplayManager.VIRTUAL_DISPLAY_FLAG_AUTO_MIRROR
+                )
+            } catch (e: Exception) {
+                Log.w(TAG, "Thumbnail needs system permission outside full-screen MJPEG capture: ${e.message}")
+                null
+            }
+            if (display == null) {
+                finish()
+            }
+        }
+        threadHandler.postDelayed({ finish() }, THUMBNAIL_TIMEOUT_MS)
+    }
+
+    /**
+     * 有等待中的缩略图请求时，将采集到的全屏画面缩放后作为缩略图发送
+     */
+    private fun sendPendingThumbnail(image: Image) {
+        val request = pendingThumbnail.getAndSet(null) ?: return
+        try {
+            val scale = minOf(1.0f, request.maxWidth.toFloat() / image.width)
+            val width = maxOf(2, (image.width * scale).toInt() / 2 * 2)
+            val height = maxOf(2, (image.height * scale).toInt() / 2 * 2)
+            val bitmap = imageToBitmap(image, image.width, image.height)
+            val thumbnail = Bitmap.createScaledBitmap(bitmap, width, height, true)
+            if (thumbnail !== bitmap) bitmap.recycle()
+            sendThumbnail(compressJpeg(thumbnail, request.quality), width, height)
+        } catch (e: Exception) {
+            Log.e(TAG, "Error capturing thumbnail", e)
+        }
+    }
+
+    private fun sendThumbnail(jpegData: ByteArray, width: Int, height: Int) {
+        val packet = ByteArray(2 + jpegData.size).apply {
+            this[0] = FRAME_TYPE_THUMBNAIL
+            this[1] = 0x00
+            System.arraycopy(jpegData, 0, this, 2, jpegData.size)
+        }
+        pendingFrames.incrementAndGet()
+        frameCallback?.invoke(packet)
+        Log.d(TAG, "Thumbnail captured: ${width}x${height}, ${jpegData.size} bytes")
+    }
+
+    private data class ThumbnailRequest(val maxWidth: Int, val quality: Int)
+
     /**
      * 获取当前状态信息
      */
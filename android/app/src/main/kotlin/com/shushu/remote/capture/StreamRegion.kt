@@ -0,0 +1,83 @@
+package com.shushu.remote.capture
+
+/**
+ * 推流区域 - 裁剪、顺时针旋转后缩放到输出分辨率
+ * 坐标和尺寸均为设备屏幕像素
+ */
+data class StreamRegion(
+    val cropX: Int,
+    val cropY: Int,
+    val cropWidth: Int,
+    val cropHeight: Int,
+    val rotation: Int,
+    val targetWidth: Int,
+    val targetHeight: Int
+) {
+    companion object {
+        /**
+         * 解析 stream.start 中的推流区域，未指定时返回 null
+         */
+        fun fromMessage(msg: Map<*, *>, screenWidth: Int, screenHeight: Int): StreamRegion? {
+            val crop = msg["crop"] as? Map<*, *>
+            val rotation = (msg["rotation"] as? Double)?.toInt() ?: 0
+            val targetWidth = (msg["targetWidth"] as? Double)?.toInt() ?: 0
+            val targetHeight = (msg["targetHeight"] as? Double)?.toInt() ?: 0
+            if (crop == null && rotation == 0 && targetWidth == 0 && targetHeight == 0) {
+                return null
+            }
+
+            // 服务端已校验，这里只防止越界
+            val x = ((crop?.get("x") as? Double)?.toInt() ?: 0).coerceIn(0, screenWidth - 1)
+            val y = ((crop?.get("y") as? Double)?.toInt() ?: 0).coerceIn(0, screenHeight - 1)
+            val width = ((crop?.get("width") as? Double)?.toInt() ?: screenWidth).coerceIn(1, screenWidth - x)
+            val height = ((crop?.get("height") as? Double)?.toInt() ?: screenHeight).coerceIn(1, screenHeight - y)
+            val normalized = if (rotation in listOf(0, 90, 180, 270)) rotation else 0
+            return StreamRegion(x, y, width, height, normalized, targetWidth, targetHeight)
+        }
+    }
+
+    /** 旋转后的画面宽度 */
+    val rotatedWidth: Int
+        get() = if (rotation == 90 || rotation == 270) cropHeight else cropWidth
+
+    /** 旋转后的画面高度 */
+    val rotatedHeight: Int
+        get() = if (rotation == 90 || rotation == 270) cropWidth else cropHeight
+
+    /**
+     * 计算输出分辨率：按目标分辨率等比缩放，再受最大分辨率限制（0 表示不限制）
+     * 宽高向下对齐到 align 的倍数
+     */
+    fun outputSize(maxWidth: Int, maxHeight: Int, align: Int): Pair<Int, Int> {
+        val width = rotatedWidth.toFloat()
+        val height = rotatedHeight.toFloat()
+        var scale = when {
+            targetWidth > 0 && targetHeight > 0 -> minOf(targetWidth / width, targetHeight / height)
+            targetWidth > 0 -> targetWidth / width
+            targetHeight > 0 -> targetHeight / height
+            else -> 1.0f
+        }
+        if (maxWidth > 0) scale = minOf(scale, maxWidth / width)
+        if (maxHeight > 0) scale = minOf(scale, maxHeight / height)
+
+        val outWidth = maxOf(align, (width * scale).toInt() / align * align)
+        val outHeight = maxOf(align, (height * scale).toInt() / align * align)
+        return Pair(outWidth, outHeight)
+    }
+
+    /**
+     * 旋转后画面中的坐标（未缩放）转换为屏幕坐标，与服务端的触摸坐标转换一致
+     */
+    fun toScreen(x: Float, y: Float): Pair<Float, Float> {
+        val w = cropWidth.toFloat()
+        val h = cropHeight.toFloat()
+        // 逆时针转回裁剪区域内的坐标
+        val (cx, cy) = when (rotation) {
+            90 -> Pair(y, h - x)
+            180 -> Pair(w - x, h - y)
+            270 -> Pair(w - y, x)
+            else -> Pair(x, y)
+        }
+        return Pair(cropX + cx, cropY + cy)
+    }
+}
@@ -0,0 +1,137 @@
+package com.shushu.remote.chat
+
+import android.app.AlertDialog
+import android.content.Context
+import android.os.Build
+import android.os.Handler
+import android.os.Looper
+import android.text.InputFilter
+import android.util.Log
+import android.view.WindowManager
+import android.widget.EditText
+import android.widget.LinearLayout
+import android.widget.ScrollView
+import android.widget.TextView
+
+/**
+ * 会话聊天窗口 - 收到控制端的 chat.message 后弹出系统悬浮对话框，现场用户可在其中回复
+ * 窗口由现场用户关闭，下一条消息到达时重新弹出并保留本次会话的聊天记录
+ */
+class ChatWindow(private val context: Context) {
+
+    companion object {
+        private const val TAG = "ChatWindow"
+        private const val MAX_LENGTH = 2000 // 与服务端 chat.message 的长度上限一致
+    }
+
+    private val mainHandler = Handler(Looper.getMainLooper())
+    private var dialog: AlertDialog? = null
+    private var historyView: TextView? = null
+    private var scrollView: ScrollView? = null
+    private val history = StringBuilder()
+    private var currentSessionId: String? = null
+
+    /**
+     * 显示控制端发来的消息，onReply 在现场用户发送回复时调用
+     */
+    fun showMessage(sessionId: String?, text: String, onReply: (String) -> Unit) {
+        mainHandler.post {
+            if (sessionId != currentSessionId) {
+                // 新的会话不显示上一次会话的记录
+                currentSessionId = sessionId
+                history.clear()
+            }
+            append("远程协助: $text")
+            if (dialog == null) {
+                showDialog(onReply)
+            }
+        }
+    }
+
+    /**
+     * 处理 chat.ack：回复未能转发给控制端时提示现场用户
+     */
+    fun onAck(relayed: Boolean, code: String?) {
+        if (relayed) return
+        mainHandler.post {
+            val reason = if (code == "NO_SESSION") "远程协助已结束" else "发送失败"
+            append("（消息未送达：$reason）")
+        }
+    }
+
+    private fun showDialog(onReply: (String) -> Unit) {
+        val density = context.resources.displayMetrics.density
+        val padding = (16 * density).toInt()
+        val historyText = TextView(context).apply { text = history.toString() }
+        val scroll = ScrollView(context).apply {
+            addView(historyText)
+        }
+        val input = EditText(context).apply {
+            hint = "输入回复"
+            filters = arrayOf(InputFilter.LengthFilter(MAX_LENGTH))
+        }
+        val layout = LinearLayout(context).apply {
+            orientation = LinearLayout.VERTICAL
+            setPadding(padding, padding / 2, padding, 0)
+            addView(scroll, LinearLayout.LayoutParams(LinearLayout.LayoutParams.MATCH_PARENT, (200 * density).toInt()))
+            addView(input, LinearLayout.LayoutParams(LinearLayout.LayoutParams.MATCH_PARENT, LinearLayout.LayoutParams.WRAP_CONTENT))
+        }
+
+        try {
+            val newDialog = AlertDialog.Builder(context)
+                .setTitle("远程协助消息")
+                .setView(layout)
+                .setCancelable(false)
+                .setPositiveButton("发送", null)
+                .setNegativeButton("关闭") { _, _ -> closeDialog() }
+                .create()
+            @Suppress("DEPRECATION")
+            newDialog.window?.setType(
+                if (Build.VERSION.SDK_INT >= Build.VERSION_CODES.O) {
+                    WindowManager.LayoutParams.TYPE_APPLICATION_OVERLAY
+                } else {
+                    WindowManager.LayoutParams.TYPE_SYSTEM_ALERT
+                }
+            )
+            newDialog.show()
+            // 发送后保持窗口打开，便于继续对话
+            newDialog.getButton(AlertDialog.BUTTON_POSITIVE).setOnClickListener {
+                val text = input.text.toString().trim()
+                if (text.isNotEmpty()) {
+                    input.text.clear()
+                    append("我: $text")
+                    onReply(text)
+                }
+            }
+            dialog = newDialog
+            historyView = historyText
+            scrollView = scroll
+            scrollToBottom()
+        } catch (e: Exception) {
+            // 无法弹出窗口时只记录消息，下一条消息到达时重试
+            Log.e(TAG, "Failed to show chat window", e)
+        }
+    }
+
+    private fun append(line: String) {
+        if (history.isNotEmpty()) history.append('\n')
+        history.append(line)
+        historyView?.text = history.toString()
+        scrollToBottom()
+    }
+
+    private fun scrollToBottom() {
+        scrollView?.let { scroll -> scroll.post { scroll.fullScroll(ScrollView.FOCUS_DOWN) } }
+    }
+
+    private fun closeDialog() {
+        try {
+            dialog?.dismiss()
+        } catch (e: Exception) {
+            Log.w(TAG, "Failed to dismiss chat window", e)
+        }
+        dialog = null
+        historyView = null
+        scrollView = null
+    }
+}
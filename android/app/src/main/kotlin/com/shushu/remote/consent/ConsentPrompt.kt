@@ -0,0 +1,106 @@
+package com.shushu.remote.consent
+
+import android.app.AlertDialog
+import android.content.Context
+import android.os.Build
+import android.os.Handler
+import android.os.Looper
+import android.util.Log
+import android.view.WindowManager
+
+/**
+ * 现场用户同意提示 - 收到 control.pending 后弹出系统悬浮对话框，由现场用户允许或拒绝远程控制
+ * 超时或服务端取消（control.cancel）时关闭对话框，不再答复
+ */
+class ConsentPrompt(private val context: Context) {
+
+    companion object {
+        private const val TAG = "ConsentPrompt"
+    }
+
+    private val mainHandler = Handler(Looper.getMainLooper())
+    private var dialog: AlertDialog? = null
+    private var currentSessionId: String? = null
+
+    /**
+     * 显示同意提示，onReply 在现场用户选择后调用（approved, reason）
+     */
+    fun show(sessionId: String, controllerId: String?, timeoutMs: Long, onReply: (Boolean, String?) -> Unit) {
+        mainHandler.post {
+            dismissDialog()
+            currentSessionId = sessionId
+
+            var replied = false
+            val reply = { approved: Boolean, reason: String? ->
+                if (!replied && currentSessionId == sessionId) {
+                    replied = true
+                    currentSessionId = null
+                    onReply(approved, reason)
+                }
+            }
+
+            val seconds = (timeoutMs / 1000).coerceAtLeast(1)
+            val message = buildString {
+                append("远程控制端请求控制本设备")
+                if (!controllerId.isNullOrEmpty()) append("\n控制端: ").append(controllerId)
+                append("\n${seconds} 秒内未响应将自动拒绝")
+            }
+
+            try {
+                val newDialog = AlertDialog.Builder(context)
+                    .setTitle("远程控制请求")
+                    .setMessage(message)
+                    .setCancelable(false)
+                    .setPositiveButton("允许") { _, _ -> reply(true, null) }
+                    .setNegativeButton("拒绝") { _, _ -> reply(false, "rejected by user") }
+                    .create()
+                @Suppress("DEPRECATION")
+                newDialog.window?.setType(
+                    if (Build.VERSION.SDK_INT >= Build.VERSION_CODES.O) {
+                        WindowManager.LayoutParams.TYPE_APPLICATION_OVERLAY
+                    } else {
+                        WindowManager.LayoutParams.TYPE_SYSTEM_ALERT
+                    }
+                )
+                newDialog.show()
+                dialog = newDialog
+                Log.d(TAG, "Consent prompt shown: $sessionId")
+            } catch (e: Exception) {
+                // 无法弹出提示时现场用户无从同意，直接拒绝
+                Log.e(TAG, "Failed to show consent prompt", e)
+                reply(false, "prompt unavailable")
+                return@post
+            }
+
+            // 服务端同样会超时，这里只负责关闭对话框
+            mainHandler.postDelayed({
+                if (currentSessionId == sessionId) {
+                    currentSessionId = null
+                    dismissDialog()
+                }
+            }, timeoutMs)
+        }
+    }
+
+    /**
+     * 服务端取消等待中的请求（超时或控制端放弃）
+     */
+    fun cancel(sessionId: String?) {
+        mainHandler.post {
+            if (sessionId == null || sessionId == currentSessionId) {
+                Log.d(TAG, "Consent prompt cancelled: $sessionId")
+                currentSessionId = null
+                dismissDialog()
+            }
+        }
+    }
+
+    private fun dismissDialog() {
+        try {
+            dialog?.dismiss()
+        } catch (e: Exception) {
+            Log.w(TAG, "Failed to dismiss consent prompt", e)
+        }
+        dialog = null
+    }
+}
@@ -1,8 +1,10 @@
 package com.shushu.remote.input
 
+import android.os.SystemClock
 import android.util.Log
 import android.view.KeyCharacterMap
 import android.view.KeyEvent
+import android.view.MotionEvent
 import kotlin.concurrent.thread
 
 /**
@@ -19,10 +21,60 @@ class InputInjector {
     private val charMap = KeyCharacterMap.load(KeyCharacterMap.VIRTUAL_KEYBOARD)
     private val useInputManager = inputManagerWrapper.isAvailable()
 
+    // 进行中的多点触控手势：按下时间（0 表示没有手势）和最近一帧的触点
+    private var touchFrameDownTime = 0L
+    private var touchFramePointers = emptyList<TouchPoint>()
+
     init {
         Log.d(TAG, "InputInjector initialized, useInputManager=$useInputManager")
     }
 
+    /**
+     * 注入多点触控帧（input.touchframe），pointers 为当前所有触点，down/up 时 actionPointer 为新增或抬起的触点
+     * 在调用线程上同步注入以保证帧的顺序；shell 命令无法模拟多点触控，InputManager 不可用时返回 false
+     */
+    @Synchronized
+    fun injectTouchFrame(action: String, actionPointer: Int, pointers: List<TouchPoint>): Boolean {
+        if (!useInputManager) return false
+
+        val index = pointers.indexOfFirst { it.id == actionPointer }
+        val motionAction = when (action) {
+            "down" -> if (touchFrameDownTime == 0L) {
+                MotionEvent.ACTION_DOWN
+            } else {
+                MotionEvent.ACTION_POINTER_DOWN or (index shl MotionEvent.ACTION_POINTER_INDEX_SHIFT)
+            }
+            "move" -> MotionEvent.ACTION_MOVE
+            "up" -> if (pointers.size == 1) {
+                MotionEvent.ACTION_UP
+            } else {
+                MotionEvent.ACTION_POINTER_UP or (index shl MotionEvent.ACTION_POINTER_INDEX_SHIFT)
+            }
+            "cancel" -> MotionEvent.ACTION_CANCEL
+            else -> return false
+        }
+
+        if (motionAction == MotionEvent.ACTION_DOWN) {
+            touchFrameDownTime = SystemClock.uptimeMillis()
+        } else if (touchFrameDownTime == 0L) {
+            // 手势已结束（或从未开始），丢弃残留的帧
+            return action == "cancel"
+        }
+
+        // cancel 可以不携带触点，沿用最近一帧的触点
+        val framePointers = if (pointers.isEmpty()) touchFramePointers else pointers
+        val success = framePointers.isNotEmpty() &&
+            inputManagerWrapper.injectMultiTouchEvent(motionAction, touchFrameDownTime, framePointers)
+
+        if (motionAction == MotionEvent.ACTION_UP || motionAction == MotionEvent.ACTION_CANCEL) {
+            touchFrameDownTime = 0L
+            touchFramePointers = emptyList()
+        } else {
+            touchFramePointers = framePointers
+        }
+        return success
+    }
+
     /**
      * 注入点击事件
      */
@@ -10,6 +10,11 @@ import android.view.KeyEvent
 import android.view.MotionEvent
 import java.lang.reflect.Method
 
+/**
+ * 多点触控中的一个触点
+ */
+data class TouchPoint(val id: Int, val x: Float, val y: Float, val pressure: Float = 1f)
+
 /**
  * InputManager 包装器，使用反射调用系统 API 注入输入事件
  * 参考 scrcpy 实现
@@ -136,6 +141,47 @@ class InputManagerWrapper {
         return result
     }
 
+    /**
+     * 注入多点触控事件，pointers 为当前所有触点，同一手势的事件使用相同的 downTime
+     */
+    fun injectMultiTouchEvent(action: Int, downTime: Long, pointers: List<TouchPoint>): Boolean {
+        val pointerProperties = Array(pointers.size) { i ->
+            MotionEvent.PointerProperties().apply {
+                id = pointers[i].id
+                toolType = MotionEvent.TOOL_TYPE_FINGER
+            }
+        }
+        val pointerCoords = Array(pointers.size) { i ->
+            MotionEvent.PointerCoords().apply {
+                x = pointers[i].x
+                y = pointers[i].y
+                pressure = pointers[i].pressure
+                size = 1f
+            }
+        }
+
+        val event = MotionEvent.obtain(
+            downTime,                      // downTime
+            SystemClock.uptimeMillis(),    // eventTime
+            action,                        // action
+            pointers.size,                 // pointerCount
+            pointerProperties,             // pointerProperties
+            pointerCoords,                 // pointerCoords
+            0,                             // metaState
+            0,                             // buttonState
+            1f,                            // xPrecision
+            1f,                            // yPrecision
+            0,                             // deviceId
+            0,                             // edgeFlags
+            InputDevice.SOURCE_TOUCHSCREEN,// source
+            0                              // flags
+        )
+
+        val result = injectInputEvent(event)
+        event.recycle()
+        return result
+    }
+
     /**
      * 注入滚轮事件
      */
@@ -2,15 +2,21 @@ package com.shushu.remote.network
 
 import android.util.Log
 import com.shushu.remote.capture.ScreenCapture
+import com.shushu.remote.capture.StreamRegion
+import com.shushu.remote.chat.ChatWindow
 import com.shushu.remote.clipboard.ClipboardSync
+import com.shushu.remote.consent.ConsentPrompt
 import com.shushu.remote.input.InputInjector
+import com.shushu.remote.input.TouchPoint
 import com.shushu.remote.privacy.PrivacyScreenManager
 
 class MessageHandler(
     private val inputInjector: InputInjector,
     private val clipboardSync: ClipboardSync,
     private val screenCapture: ScreenCapture,
-    private val privacyScreenManager: PrivacyScreenManager?
+    private val privacyScreenManager: PrivacyScreenManager?,
+    private val consentPrompt: ConsentPrompt? = null,
+    private val chatWindow: ChatWindow? = null
 ) {
     companion object {
         private const val TAG = "MessageHandler"
@@ -19,6 +25,15 @@ class MessageHandler(
     // WebRTC 信令处理回调
     private var webRTCSignalingHandler: ((String, Map<*, *>) -> Unit)? = null
 
+    // 命令回执发送回调（requestId, 是否成功, 错误信息）
+    private var commandResultSender: ((String, Boolean, String?) -> Unit)? = null
+
+    // 同意答复发送回调（sessionId, 是否同意, 原因）
+    private var consentReplySender: ((String, Boolean, String?) -> Unit)? = null
+
+    // 聊天回复发送回调（文本）
+    private var chatSender: ((String) -> Unit)? = null
+
     /**
      * 设置 WebRTC 信令处理器
      */
@@ -26,16 +41,46 @@ class MessageHandler(
         webRTCSignalingHandler = handler
     }
 
+    /**
+     * 设置命令回执发送器，携带 requestId 的消息执行后回复 command.result
+     */
+    fun setCommandResultSender(sender: (requestId: String, success: Boolean, error: String?) -> Unit) {
+        commandResultSender = sender
+    }
+
+    /**
+     * 设置同意答复发送器，现场用户选择后回复 control.consent
+     */
+    fun setConsentReplySender(sender: (sessionId: String, approved: Boolean, reason: String?) -> Unit) {
+        consentReplySender = sender
+    }
+
+    /**
+     * 设置聊天发送器，现场用户的回复以 chat.message 发给控制端
+     */
+    fun setChatSender(sender: (text: String) -> Unit) {
+        chatSender = sender
+    }
+
     fun handleMessage(type: String, msg: Map<*, *>) {
         Log.d(TAG, "Received message type: $type")
         when (type) {
             "stream.start" -> handleStreamStart(msg)
             "stream.stop" -> handleStreamStop()
-            "input.touch" -> handleTouch(msg)
-            "input.key" -> handleKey(msg)
-            "input.text" -> handleText(msg)
-            "input.command" -> handleCommand(msg)
-            "clipboard.set" -> handleClipboardSet(msg)
+            "stream.keyframe" -> screenCapture.requestKeyFrame()
+            "screen.thumbnail" -> handleThumbnail(msg)
+            "input.touch" -> runCommand(msg) { handleTouch(msg) }
+            "input.touchframe" -> runCommand(msg) { handleTouchFrame(msg) }
+            "input.key" -> runCommand(msg) { handleKey(msg) }
+            "input.text" -> runCommand(msg) { handleText(msg) }
+            "input.command" -> runCommand(msg) { handleCommand(msg) }
+            "clipboard.set" -> runCommand(msg) { handleClipboardSet(msg) }
+            // 现场用户同意
+            "control.pending" -> handleControlPending(msg)
+            "control.cancel" -> consentPrompt?.cancel(msg["sessionId"] as? String)
+            // 会话聊天
+            "chat.message" -> handleChatMessage(msg)
+            "chat.ack" -> chatWindow?.onAck(msg["relayed"] as? Boolean ?: false, msg["code"] as? String)
             // WebRTC 信令消息
             "webrtc.offer", "webrtc.answer", "webrtc.ice", "webrtc.ready" -> {
                 webRTCSignalingHandler?.invoke(type, msg)
@@ -48,79 +93,151 @@ class MessageHandler(
         }
     }
 
+    /**
+     * 执行输入命令，消息携带 requestId 时回复执行结果
+     */
+    private fun runCommand(msg: Map<*, *>, action: () -> Boolean) {
+        val requestId = msg["requestId"] as? String
+        val success = try {
+            action()
+        } catch (e: Exception) {
+            Log.e(TAG, "Command failed", e)
+            false
+        }
+        if (requestId != null) {
+            commandResultSender?.invoke(requestId, success, if (success) null else "invalid or unsupported command")
+        }
+    }
+
     private fun handleStreamStart(msg: Map<*, *>) {
         val mode = msg["mode"] as? String ?: "mjpeg"
+        // 服务端推流配置限制的最大分辨率（0 表示不限制）
+        val maxWidth = (msg["maxWidth"] as? Double)?.toInt() ?: 0
+        val maxHeight = (msg["maxHeight"] as? Double)?.toInt() ?: 0
+        // 控制端指定的推流区域（裁剪、旋转、输出分辨率）
+        val region = StreamRegion.fromMessage(msg, screenCapture.screenWidth, screenCapture.screenHeight)
 
         when (mode) {
             "h264" -> {
                 val bitrate = (msg["bitrate"] as? Double)?.toInt() ?: 2_000_000
                 val fps = (msg["fps"] as? Double)?.toInt() ?: 30
                 Log.d(TAG, "Starting H264 stream: bitrate=$bitrate, fps=$fps")
-                screenCapture.startH264Capture(bitrate, fps)
+                screenCapture.startH264Capture(bitrate, fps, maxWidth, maxHeight, region)
             }
             else -> {
                 // MJPEG 模式（默认）
                 val quality = (msg["quality"] as? Double)?.toInt() ?: 80
                 val maxFps = (msg["maxFps"] as? Double)?.toInt() ?: 30
                 Log.d(TAG, "Starting MJPEG stream: quality=$quality, maxFps=$maxFps")
-                screenCapture.startCapture(quality, maxFps)
+                screenCapture.startCapture(quality, maxFps, maxWidth, maxHeight, region)
             }
         }
     }
 
+    private fun handleControlPending(msg: Map<*, *>) {
+        val sessionId = msg["sessionId"] as? String ?: return
+        val controllerId = msg["controllerId"] as? String
+        val timeoutMs = (msg["timeoutMs"] as? Double)?.toLong() ?: 30_000L
+        val prompt = consentPrompt
+        if (prompt == null) {
+            consentReplySender?.invoke(sessionId, false, "consent not supported")
+            return
+        }
+        Log.d(TAG, "Control pending: session=$sessionId, controller=$controllerId")
+        prompt.show(sessionId, controllerId, timeoutMs) { approved, reason ->
+            consentReplySender?.invoke(sessionId, approved, reason)
+        }
+    }
+
+    private fun handleChatMessage(msg: Map<*, *>) {
+        val text = msg["text"] as? String ?: return
+        val sessionId = msg["sessionId"] as? String
+        Log.d(TAG, "Chat message: session=$sessionId")
+        chatWindow?.showMessage(sessionId, text) { reply ->
+            chatSender?.invoke(reply)
+        }
+    }
+
+    private fun handleThumbnail(msg: Map<*, *>) {
+        val maxWidth = (msg["maxWidth"] as? Double)?.toInt() ?: 320
+        val quality = (msg["quality"] as? Double)?.toInt() ?: 50
+        screenCapture.captureThumbnail(maxWidth, quality)
+    }
+
     private fun handleStreamStop() {
         Log.d(TAG, "Stopping stream")
         screenCapture.stopCapture()
     }
 
-    private fun handleTouch(msg: Map<*, *>) {
-        val action = msg["action"] as? String ?: return
+    private fun handleTouch(msg: Map<*, *>): Boolean {
+        val action = msg["action"] as? String ?: return false
 
         Log.d(TAG, "Touch event: action=$action")
 
         when (action) {
             "tap" -> {
-                val x = (msg["x"] as? Double)?.toInt() ?: return
-                val y = (msg["y"] as? Double)?.toInt() ?: return
+                val x = (msg["x"] as? Double)?.toInt() ?: return false
+                val y = (msg["y"] as? Double)?.toInt() ?: return false
                 Log.d(TAG, "Tap: x=$x, y=$y")
                 inputInjector.injectTap(x, y)
             }
             "longpress" -> {
-                val x = (msg["x"] as? Double)?.toInt() ?: return
-                val y = (msg["y"] as? Double)?.toInt() ?: return
+                val x = (msg["x"] as? Double)?.toInt() ?: return false
+                val y = (msg["y"] as? Double)?.toInt() ?: return false
                 Log.d(TAG, "LongPress: x=$x, y=$y")
                 inputInjector.injectLongPress(x, y)
             }
             "swipe" -> {
-                val startX = (msg["startX"] as? Double)?.toInt() ?: return
-                val startY = (msg["startY"] as? Double)?.toInt() ?: return
-                val endX = (msg["endX"] as? Double)?.toInt() ?: return
-                val endY = (msg["endY"] as? Double)?.toInt() ?: return
+                val startX = (msg["startX"] as? Double)?.toInt() ?: return false
+                val startY = (msg["startY"] as? Double)?.toInt() ?: return false
+                val endX = (msg["endX"] as? Double)?.toInt() ?: return false
+                val endY = (msg["endY"] as? Double)?.toInt() ?: return false
                 val duration = (msg["duration"] as? Double)?.toInt() ?: 300
                 Log.d(TAG, "Swipe: ($startX,$startY) -> ($endX,$endY), duration=$duration")
                 inputInjector.injectSwipe(startX, startY, endX, endY, duration)
             }
             "scroll" -> {
-                val x = (msg["x"] as? Double)?.toInt() ?: return
-                val y = (msg["y"] as? Double)?.toInt() ?: return
+                val x = (msg["x"] as? Double)?.toInt() ?: return false
+                val y = (msg["y"] as? Double)?.toInt() ?: return false
                 val hScroll = (msg["hScroll"] as? Double)?.toFloat() ?: 0f
                 val vScroll = (msg["vScroll"] as? Double)?.toFloat() ?: 0f
                 Log.d(TAG, "Scroll: x=$x, y=$y, hScroll=$hScroll, vScroll=$vScroll")
                 inputInjector.injectScroll(x, y, hScroll, vScroll)
             }
+            else -> return false
         }
+        return true
     }
 
-    private fun handleKey(msg: Map<*, *>) {
-        val keyCode = (msg["keyCode"] as? Double)?.toInt() ?: return
-        val action = msg["action"] as? String ?: return
+    private fun handleTouchFrame(msg: Map<*, *>): Boolean {
+        val action = msg["action"] as? String ?: return false
+        val actionPointer = (msg["actionPointer"] as? Double)?.toInt() ?: 0
+        val pointers = (msg["pointers"] as? List<*>).orEmpty().mapNotNull { item ->
+            val pointer = item as? Map<*, *> ?: return@mapNotNull null
+            TouchPoint(
+                id = (pointer["id"] as? Double)?.toInt() ?: return@mapNotNull null,
+                x = (pointer["x"] as? Double)?.toFloat() ?: return@mapNotNull null,
+                y = (pointer["y"] as? Double)?.toFloat() ?: return@mapNotNull null,
+                // 服务端省略为 0 的压力
+                pressure = (pointer["pressure"] as? Double)?.toFloat() ?: 1f
+            )
+        }
+
+        Log.d(TAG, "Touch frame: action=$action, actionPointer=$actionPointer, pointers=${pointers.size}")
+        return inputInjector.injectTouchFrame(action, actionPointer, pointers)
+    }
+
+    private fun handleKey(msg: Map<*, *>): Boolean {
+        val keyCode = (msg["keyCode"] as? Double)?.toInt() ?: return false
+        val action = msg["action"] as? String ?: return false
 
         Log.d(TAG, "Key event: keyCode=$keyCode, action=$action")
         inputInjector.injectKey(keyCode, action)
+        return true
     }
 
-    private fun handleClipboardSet(msg: Map<*, *>) {
-        val text = msg["text"] as? String ?: return
+    private fun handleClipboardSet(msg: Map<*, *>): Boolean {
+        val text = msg["text"] as? String ?: return false
         val autoPaste = msg["autoPaste"] as? Boolean ?: true
         Log.d(TAG, "Setting clipboard: ${text.take(50)}..., autoPaste=$autoPaste")
 
@@ -131,21 +248,25 @@ class MessageHandler(
             Thread.sleep(150) // 等待剪贴板设置完成
             inputInjector.injectPaste()
         }
+        return true
     }
 
-    private fun handleText(msg: Map<*, *>) {
-        val text = msg["text"] as? String ?: return
+    private fun handleText(msg: Map<*, *>): Boolean {
+        val text = msg["text"] as? String ?: return false
         Log.d(TAG, "Input text: $text")
         inputInjector.injectText(text)
+        return true
     }
 
-    private fun handleCommand(msg: Map<*, *>) {
-        val command = msg["command"] as? String ?: return
+    private fun handleCommand(msg: Map<*, *>): Boolean {
+        val command = msg["command"] as? String ?: return false
         Log.d(TAG, "Command: $command")
 
         when (command) {
             "hide_keyboard" -> inputInjector.hideKeyboard()
+            else -> return false
         }
+        return true
     }
 
     // 隐私模式处理
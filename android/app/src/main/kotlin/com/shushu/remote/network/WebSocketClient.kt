@@ -6,6 +6,7 @@ import android.util.Log
 import com.google.gson.Gson
 import okhttp3.*
 import okio.ByteString
+import java.util.UUID
 import java.util.concurrent.TimeUnit
 import java.util.concurrent.atomic.AtomicBoolean
 
@@ -25,6 +26,11 @@ class WebSocketClient(
         private const val RECONNECT_DELAY = 3000L
         private const val HEARTBEAT_INTERVAL = 15000L // 15秒心跳
         private const val SEND_TIMEOUT = 5000L // 发送超时5秒
+        private const val PROTOCOL_VERSION = 1
+        // 设备支持的能力，服务端据此拒绝设备无法处理的消息
+        private val CAPABILITIES = listOf("h264", "mjpeg", "webrtc", "privacy", "clipboard", "command", "ack", "multitouch", "keyframe", "thumbnail", "region", "consent", "chat")
+        // MessageHandler 支持的 input.command 命令
+        private val COMMANDS = listOf("hide_keyboard")
     }
 
     private val gson = Gson()
@@ -74,7 +80,10 @@ class WebSocketClient(
                     "deviceName" to deviceName,
                     "screenWidth" to screenWidth,
                     "screenHeight" to screenHeight,
-                    "token" to token
+                    "token" to token,
+                    "protocolVersion" to PROTOCOL_VERSION,
+                    "capabilities" to CAPABILITIES,
+                    "commands" to COMMANDS
                 )
                 webSocket.send(gson.toJson(registerMsg))
             }
@@ -180,6 +189,41 @@ class WebSocketClient(
         }
     }
 
+    fun sendCommandResult(requestId: String, success: Boolean, error: String?) {
+        if (isConnected.get()) {
+            val msg = mutableMapOf<String, Any>(
+                "type" to "command.result",
+                "requestId" to requestId,
+                "status" to if (success) "success" else "failure"
+            )
+            error?.let { msg["error"] = it }
+            webSocket?.send(gson.toJson(msg))
+        }
+    }
+
+    fun sendConsent(sessionId: String, approved: Boolean, reason: String?) {
+        if (isConnected.get()) {
+            val msg = mutableMapOf<String, Any>(
+                "type" to "control.consent",
+                "sessionId" to sessionId,
+                "approved" to approved
+            )
+            reason?.let { msg["reason"] = it }
+            webSocket?.send(gson.toJson(msg))
+        }
+    }
+
+    fun sendChat(text: String) {
+        if (isConnected.get()) {
+            val msg = mapOf(
+                "type" to "chat.message",
+                "id" to UUID.randomUUID().toString(),
+                "text" to text
+            )
+            webSocket?.send(gson.toJson(msg))
+        }
+    }
+
     fun sendHeartbeat() {
         if (isConnected.get()) {
             val msg = mapOf("type" to "device.heartbeat")
@@ -17,7 +17,9 @@ import com.shushu.remote.MainActivity
 import com.shushu.remote.RemoteApplication
 import com.shushu.remote.R
 import com.shushu.remote.capture.ScreenCapture
+import com.shushu.remote.chat.ChatWindow
 import com.shushu.remote.clipboard.ClipboardSync
+import com.shushu.remote.consent.ConsentPrompt
 import com.shushu.remote.input.InputInjector
 import com.shushu.remote.network.WebSocketClient
 import com.shushu.remote.network.MessageHandler
@@ -133,7 +135,9 @@ class RemoteService : Service() {
                 inputInjector!!,
                 clipboardSync!!,
                 screenCapture!!,
-                privacyScreenManager
+                privacyScreenManager,
+                ConsentPrompt(this),
+                ChatWindow(this)
             )
 
             // 设置 WebRTC 信令处理
@@ -169,6 +173,21 @@ class RemoteService : Service() {
                 screenCapture?.onFrameDropped()
             }
 
+            // 设置命令回执发送
+            messageHandler?.setCommandResultSender { requestId, success, error ->
+                webSocketClient?.sendCommandResult(requestId, success, error)
+            }
+
+            // 设置同意答复发送
+            messageHandler?.setConsentReplySender { sessionId, approved, reason ->
+                webSocketClient?.sendConsent(sessionId, approved, reason)
+            }
+
+            // 设置聊天回复发送
+            messageHandler?.setChatSender { text ->
+                webSocketClient?.sendChat(text)
+            }
+
             // 设置剪贴板变化回调
             clipboardSync?.setOnClipboardChangeListener { text ->
                 webSocketClient?.sendClipboardUpdate(text)
@@ -204,6 +223,11 @@ class RemoteService : Service() {
             startWebRTCStream(controllerId)
         }
 
+        signalingClient?.onIceServersReceived = { servers ->
+            Log.d(TAG, "Received ${servers.size} ICE servers from server")
+            webRTCClient?.iceServers = servers
+        }
+
         signalingClient?.onAnswerReceived = { sdp ->
             Log.d(TAG, "Received WebRTC answer")
             webRTCClient?.setRemoteAnswer(sdp) { success ->
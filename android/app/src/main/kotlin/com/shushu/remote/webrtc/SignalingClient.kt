@@ -3,6 +3,7 @@ package com.shushu.remote.webrtc
 import android.util.Log
 import com.google.gson.Gson
 import org.webrtc.IceCandidate
+import org.webrtc.PeerConnection
 import org.webrtc.SessionDescription
 
 /**
@@ -29,6 +30,7 @@ class SignalingClient(
     var onAnswerReceived: ((SessionDescription) -> Unit)? = null
     var onIceCandidateReceived: ((IceCandidate) -> Unit)? = null
     var onWebRTCReady: ((String) -> Unit)? = null  // 控制端准备好接收 WebRTC
+    var onIceServersReceived: ((List<PeerConnection.IceServer>) -> Unit)? = null  // 服务器下发的 STUN/TURN 配置
 
     /**
      * 发送 WebRTC 准备就绪消息（设备端发送）
@@ -90,6 +92,27 @@ class SignalingClient(
         Log.d(TAG, "ICE candidate sent to $targetId")
     }
 
+    /**
+     * 解析 webrtc.ready 携带的 iceServers（urls 可以是字符串或数组）
+     */
+    private fun parseIceServers(value: Any?): List<PeerConnection.IceServer>? {
+        val list = value as? List<*> ?: return null
+        val servers = list.mapNotNull { item ->
+            val server = item as? Map<*, *> ?: return@mapNotNull null
+            val urls = when (val u = server["urls"]) {
+                is String -> listOf(u)
+                is List<*> -> u.filterIsInstance<String>()
+                else -> emptyList()
+            }
+            if (urls.isEmpty()) return@mapNotNull null
+            PeerConnection.IceServer.builder(urls)
+                .setUsername(server["username"] as? String ?: "")
+                .setPassword(server["credential"] as? String ?: "")
+                .createIceServer()
+        }
+        return servers.ifEmpty { null }
+    }
+
     /**
      * 处理收到的信令消息
      * @return true 如果消息被处理，false 如果不是信令消息
@@ -102,6 +125,7 @@ class SignalingClient(
                     ?: data["controllerId"] as? String
                     ?: ""
                 Log.d(TAG, "WebRTC ready received from controller: $controllerId")
+                parseIceServers(data["iceServers"])?.let { onIceServersReceived?.invoke(it) }
                 onWebRTCReady?.invoke(controllerId)
                 true
             }
@@ -35,6 +35,9 @@ class WebRTCClient(
 
     private var mediaProjectionPermissionResultData: Intent? = null
 
+    // 服务器下发的 STUN/TURN 配置，为空时使用内置公共 STUN
+    var iceServers: List<PeerConnection.IceServer>? = null
+
     // 回调
     var onIceCandidate: ((IceCandidate) -> Unit)? = null
     var onIceConnectionStateChange: ((PeerConnection.IceConnectionState) -> Unit)? = null
@@ -93,7 +96,7 @@ class WebRTCClient(
             return false
         }
 
-        val rtcConfig = PeerConnection.RTCConfiguration(ICE_SERVERS).apply {
+        val rtcConfig = PeerConnection.RTCConfiguration(iceServers ?: ICE_SERVERS).apply {
             sdpSemantics = PeerConnection.SdpSemantics.UNIFIED_PLAN
             continualGatheringPolicy = PeerConnection.ContinualGatheringPolicy.GATHER_CONTINUALLY
             // 启用 TCP 候选（更稳定）
@@ -0,0 +1,74 @@
+// fakecontroller 模拟控制端：请求控制设备，执行输入脚本并检查屏幕帧是否到达
+// 任一步骤失败时以非零状态退出，可用于部署后的冒烟测试
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"shushu-remote-control/internal/sim"
+)
+
+func main() {
+	server := flag.String("server", "ws://127.0.0.1:9222", "服务端地址")
+	deviceID := flag.String("device", "FAKE_001", "设备ID")
+	token := flag.String("token", "", "控制Token")
+	scriptPath := flag.String("script", "", "输入脚本（每行一条 JSON 消息或 sleep 500ms，为空使用默认脚本）")
+	minFrames := flag.Int64("frames", 30, "至少收到的帧数")
+	timeout := flag.Duration("timeout", 10*time.Second, "每一步的超时时间")
+	subprotocol := flag.String("subprotocol", "", "消息编码子协议（如 shushu.v1.msgpack）")
+	flag.Parse()
+
+	var steps []sim.ScriptStep
+	if *scriptPath != "" {
+		f, err := os.Open(*scriptPath)
+		if err != nil {
+			log.Fatalf("打开脚本失败: %v", err)
+		}
+		steps, err = sim.ParseScript(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("解析脚本失败: %v", err)
+		}
+	}
+
+	controller, err := sim.ConnectController(sim.ControllerConfig{
+		ServerURL:   *server,
+		DeviceID:    *deviceID,
+		Token:       *token,
+		Subprotocol: *subprotocol,
+	})
+	if err != nil {
+		log.Fatalf("连接服务端失败: %v", err)
+	}
+	defer controller.Close()
+
+	granted, err := controller.RequestControl(*timeout)
+	if err != nil {
+		if code := controller.CloseCode(); code != 0 {
+			log.Fatalf("请求控制失败: %v (关闭码 %d)", err, code)
+		}
+		log.Fatalf("请求控制失败: %v", err)
+	}
+	log.Printf("获得控制权: %s (%dx%d, 能力 %v)", granted.DeviceName, granted.ScreenWidth, granted.ScreenHeight, granted.Capabilities)
+
+	if err := controller.WaitFrames(1, *timeout); err != nil {
+		log.Fatalf("未收到屏幕帧: %v", err)
+	}
+	log.Printf("首帧类型: %v", controller.FrameTypes())
+
+	if steps == nil {
+		steps = sim.DefaultScript(granted.ScreenWidth, granted.ScreenHeight)
+	}
+	if err := controller.RunScript(steps, *timeout); err != nil {
+		log.Fatalf("执行脚本失败: %v", err)
+	}
+	log.Printf("脚本执行完成: %d 步", len(steps))
+
+	if err := controller.WaitFrames(*minFrames, *timeout); err != nil {
+		log.Fatalf("屏幕帧不足: %v", err)
+	}
+	log.Printf("检查通过: 收到 %d 帧", controller.Frames())
+}
@@ -0,0 +1,68 @@
+// fakedevice 模拟被控设备，连接服务端后注册、心跳，并在收到 stream.start 后推送合成的 H264 / MJPEG 帧
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"shushu-remote-control/internal/sim"
+)
+
+func main() {
+	server := flag.String("server", "ws://127.0.0.1:9222", "服务端地址")
+	deviceID := flag.String("id", "FAKE_001", "设备ID")
+	name := flag.String("name", "模拟设备", "设备名称")
+	token := flag.String("token", "shushu123", "设备连接Token")
+	width := flag.Int("width", 720, "屏幕宽度")
+	height := flag.Int("height", 1280, "屏幕高度")
+	caps := flag.String("caps", strings.Join(sim.DefaultCapabilities, ","), "上报的能力（逗号分隔）")
+	heartbeat := flag.Duration("heartbeat", 15*time.Second, "心跳间隔")
+	subprotocol := flag.String("subprotocol", "", "消息编码子协议（如 shushu.v1.msgpack）")
+	failInput := flag.Bool("fail-input", false, "输入消息回执 failure")
+	denyConsent := flag.Bool("deny-consent", false, "拒绝需要现场确认的控制请求")
+	flag.Parse()
+
+	device, err := sim.ConnectDevice(sim.DeviceConfig{
+		ServerURL:    *server,
+		DeviceID:     *deviceID,
+		DeviceName:   *name,
+		Token:        *token,
+		ScreenWidth:  *width,
+		ScreenHeight: *height,
+		Capabilities: strings.Split(*caps, ","),
+		Heartbeat:    *heartbeat,
+		Subprotocol:  *subprotocol,
+		FailInput:    *failInput,
+		DenyConsent:  *denyConsent,
+	})
+	if err != nil {
+		log.Fatalf("连接服务端失败: %v", err)
+	}
+	log.Printf("模拟设备已连接: %s (%dx%d)", *deviceID, *width, *height)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stats := time.NewTicker(5 * time.Second)
+	defer stats.Stop()
+
+	for {
+		select {
+		case msg := <-device.Messages():
+			log.Printf("收到消息: %s", msg.Raw)
+		case <-stats.C:
+			if device.Streaming() {
+				log.Printf("已发送 %d 帧", device.FramesSent())
+			}
+		case <-device.Done():
+			log.Fatalf("连接已断开 (关闭码 %d)", device.CloseCode())
+		case <-signals:
+			device.Close()
+			return
+		}
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"shushu-remote-control/internal/handler"
+	"shushu-remote-control/internal/service"
 	"shushu-remote-control/internal/store"
 )
 
@@ -17,12 +24,47 @@ const (
 	defaultPort        = "9222"
 	defaultDeviceToken = "shushu123"
 	defaultWebDir      = "./web/dist"
+	defaultReconnect   = "30s"
+	defaultConsent     = "30s"
+	defaultRetention   = "720h"
+	defaultCommand     = "10s"
+	defaultDeviceGzip  = "false"
+	defaultCtrlGzip    = "true"
+	defaultABR         = "true"
+	defaultMJPEGQ      = "70"
+	defaultMJPEGFPS    = "10"
+	defaultSFU         = "false"
+	defaultTURNTTL     = "1h"
+	defaultThumbnail   = "0"
+	defaultStreamToken = "1h"
 
 	envPort        = "SERVER_PORT"
 	envMySQL       = "MYSQL_DSN"
 	envDeviceToken = "DEVICE_TOKEN"
 	envWebDir      = "WEB_DIR"
 	envAuthToken   = "AUTH_TOKEN"
+	envReconnect   = "RECONNECT_GRACE"
+	envConsent     = "CONSENT_TIMEOUT"
+	envAPIToken    = "API_TOKEN"
+	envRecordDir   = "RECORD_DIR"
+	envRetention   = "RECORD_RETENTION"
+	envCommand     = "COMMAND_TIMEOUT"
+	envDeviceGzip  = "DEVICE_COMPRESSION"
+	envCtrlGzip    = "CONTROLLER_COMPRESSION"
+	envABR         = "ADAPTIVE_BITRATE"
+	envMJPEGQ      = "MJPEG_QUALITY"
+	envMJPEGFPS    = "MJPEG_FPS"
+	envSFU         = "WEBRTC_SFU"
+	envICEServers  = "ICE_SERVERS"
+	envTURNSecret  = "TURN_SECRET"
+	envTURNTTL     = "TURN_TTL"
+	envTURNListen  = "TURN_LISTEN"
+	envTURNIP      = "TURN_PUBLIC_IP"
+	envThumbnail   = "THUMBNAIL_INTERVAL"
+	envTLSCert     = "TLS_CERT"
+	envTLSKey      = "TLS_KEY"
+	envRedirect    = "HTTP_REDIRECT"
+	envStreamToken = "STREAM_TOKEN_TTL"
 )
 
 type stringFlag struct {
@@ -50,17 +92,114 @@ func resolveString(flagValue *stringFlag, envKey, fallback string) string {
 	return fallback
 }
 
+func resolveDuration(flagValue *stringFlag, envKey, fallback string) time.Duration {
+	value := resolveString(flagValue, envKey, fallback)
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("无效的时长参数 %s: %q", envKey, value)
+	}
+	return d
+}
+
+func resolveBool(flagValue *stringFlag, envKey, fallback string) bool {
+	value := resolveString(flagValue, envKey, fallback)
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("无效的开关参数 %s: %q", envKey, value)
+	}
+	return b
+}
+
+func resolveInt(flagValue *stringFlag, envKey, fallback string, min, max int) int {
+	value := resolveString(flagValue, envKey, fallback)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		log.Fatalf("无效的数值参数 %s: %q（范围 %d-%d）", envKey, value, min, max)
+	}
+	return n
+}
+
+// resolveURLs 解析逗号分隔的 STUN/TURN 地址
+func resolveURLs(flagValue *stringFlag, envKey string) []string {
+	var urls []string
+	for _, url := range strings.Split(resolveString(flagValue, envKey, ""), ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "stuns:") &&
+			!strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+			log.Fatalf("无效的 ICE 服务器地址 %s: %q", envKey, url)
+		}
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// randomSecret 生成随机的 TURN 共享密钥
+func randomSecret() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("生成 TURN 共享密钥失败: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
 func main() {
 	// 命令行参数
 	portFlag := &stringFlag{value: defaultPort}
 	mysqlFlag := &stringFlag{value: ""}
 	deviceTokenFlag := &stringFlag{value: defaultDeviceToken}
 	webDirFlag := &stringFlag{value: defaultWebDir}
+	reconnectFlag := &stringFlag{value: defaultReconnect}
+	consentFlag := &stringFlag{value: defaultConsent}
+	apiTokenFlag := &stringFlag{value: ""}
+	recordDirFlag := &stringFlag{value: ""}
+	retentionFlag := &stringFlag{value: defaultRetention}
+	commandFlag := &stringFlag{value: defaultCommand}
+	deviceGzipFlag := &stringFlag{value: defaultDeviceGzip}
+	ctrlGzipFlag := &stringFlag{value: defaultCtrlGzip}
+	abrFlag := &stringFlag{value: defaultABR}
+	mjpegQualityFlag := &stringFlag{value: defaultMJPEGQ}
+	mjpegFPSFlag := &stringFlag{value: defaultMJPEGFPS}
+	sfuFlag := &stringFlag{value: defaultSFU}
+	iceServersFlag := &stringFlag{value: ""}
+	turnSecretFlag := &stringFlag{value: ""}
+	turnTTLFlag := &stringFlag{value: defaultTURNTTL}
+	turnListenFlag := &stringFlag{value: ""}
+	turnIPFlag := &stringFlag{value: ""}
+	thumbnailFlag := &stringFlag{value: defaultThumbnail}
+	tlsCertFlag := &stringFlag{value: ""}
+	tlsKeyFlag := &stringFlag{value: ""}
+	redirectFlag := &stringFlag{value: ""}
+	streamTokenFlag := &stringFlag{value: defaultStreamToken}
 
 	flag.Var(portFlag, "port", "服务端口")
 	flag.Var(mysqlFlag, "mysql", "MySQL 连接字符串")
 	flag.Var(deviceTokenFlag, "device-token", "设备连接Token")
 	flag.Var(webDirFlag, "web", "Web静态文件目录")
+	flag.Var(reconnectFlag, "reconnect-grace", "设备断线后保留会话等待重连的时间（0 关闭）")
+	flag.Var(consentFlag, "consent-timeout", "等待现场用户同意控制的超时时间")
+	flag.Var(apiTokenFlag, "api-token", "管理API Token（为空则关闭管理API）")
+	flag.Var(recordDirFlag, "record-dir", "会话录像目录（为空则不录像）")
+	flag.Var(retentionFlag, "record-retention", "录像保留时长（0 永久保留）")
+	flag.Var(commandFlag, "command-timeout", "等待设备命令回执的超时时间")
+	flag.Var(deviceGzipFlag, "device-compression", "设备端点启用 permessage-deflate 压缩")
+	flag.Var(ctrlGzipFlag, "controller-compression", "控制端点启用 permessage-deflate 压缩")
+	flag.Var(abrFlag, "adaptive-bitrate", "按控制端链路状况自动调整推流码率和帧率")
+	flag.Var(mjpegQualityFlag, "mjpeg-quality", "HTTP MJPEG 观看时设备推流的 JPEG 质量（1-100）")
+	flag.Var(mjpegFPSFlag, "mjpeg-fps", "HTTP MJPEG 观看时设备推流的帧率")
+	flag.Var(sfuFlag, "webrtc-sfu", "WebRTC 经服务端转发（SFU），否则点对点")
+	flag.Var(iceServersFlag, "ice-servers", "下发给 WebRTC 对端的 STUN/TURN 地址（逗号分隔）")
+	flag.Var(turnSecretFlag, "turn-secret", "TURN 共享密钥（REST API 方案），用于生成临时凭据")
+	flag.Var(turnTTLFlag, "turn-ttl", "TURN 临时凭据有效期")
+	flag.Var(turnListenFlag, "turn-listen", "内置 TURN 服务监听地址（为空则不启用），如 :3478")
+	flag.Var(turnIPFlag, "turn-public-ip", "内置 TURN 服务的公网 IP（中继地址）")
+	flag.Var(thumbnailFlag, "thumbnail-interval", "每台在线设备的缩略图刷新间隔（0 关闭）")
+	flag.Var(tlsCertFlag, "tls-cert", "TLS 证书文件（与 -tls-key 同时配置后启用 HTTPS/WSS）")
+	flag.Var(tlsKeyFlag, "tls-key", "TLS 私钥文件")
+	flag.Var(redirectFlag, "http-redirect", "HTTP 重定向到 HTTPS 的监听地址（为空则不启用），如 :80")
+	flag.Var(streamTokenFlag, "stream-token-ttl", "MJPEG / HLS / 缩略图只读观看 Token 的有效期")
 	flag.Parse()
 
 	port := resolveString(portFlag, envPort, defaultPort)
@@ -72,11 +211,43 @@ func main() {
 		}
 	}
 	webDir := resolveString(webDirFlag, envWebDir, defaultWebDir)
+	reconnectGrace := resolveDuration(reconnectFlag, envReconnect, defaultReconnect)
+	consentTimeout := resolveDuration(consentFlag, envConsent, defaultConsent)
+	apiToken := resolveString(apiTokenFlag, envAPIToken, "")
+	recordDir := resolveString(recordDirFlag, envRecordDir, "")
+	recordRetention := resolveDuration(retentionFlag, envRetention, defaultRetention)
+	commandTimeout := resolveDuration(commandFlag, envCommand, defaultCommand)
+	deviceCompression := resolveBool(deviceGzipFlag, envDeviceGzip, defaultDeviceGzip)
+	controllerCompression := resolveBool(ctrlGzipFlag, envCtrlGzip, defaultCtrlGzip)
+	adaptiveBitrate := resolveBool(abrFlag, envABR, defaultABR)
+	mjpegQuality := resolveInt(mjpegQualityFlag, envMJPEGQ, defaultMJPEGQ, 1, 100)
+	mjpegFPS := resolveInt(mjpegFPSFlag, envMJPEGFPS, defaultMJPEGFPS, 1, 60)
+	webrtcSFU := resolveBool(sfuFlag, envSFU, defaultSFU)
+	ice := &service.ICEConfig{
+		URLs:   resolveURLs(iceServersFlag, envICEServers),
+		Secret: resolveString(turnSecretFlag, envTURNSecret, ""),
+		TTL:    resolveDuration(turnTTLFlag, envTURNTTL, defaultTURNTTL),
+	}
+	turnListen := resolveString(turnListenFlag, envTURNListen, "")
+	turnPublicIP := resolveString(turnIPFlag, envTURNIP, "")
+	thumbnailInterval := resolveDuration(thumbnailFlag, envThumbnail, defaultThumbnail)
+	tlsCert := resolveString(tlsCertFlag, envTLSCert, "")
+	tlsKey := resolveString(tlsKeyFlag, envTLSKey, "")
+	httpRedirect := resolveString(redirectFlag, envRedirect, "")
+	streamTokenTTL := resolveDuration(streamTokenFlag, envStreamToken, defaultStreamToken)
 
 	log.Printf("启动服务器...")
 	log.Printf("端口: %s", port)
 	log.Printf("MySQL: configured")
 	log.Printf("Web目录: %s", webDir)
+	log.Printf("重连宽限期: %s", reconnectGrace)
+	log.Printf("WebSocket 压缩: 设备=%t 控制端=%t", deviceCompression, controllerCompression)
+	log.Printf("自适应码率: %t", adaptiveBitrate)
+	log.Printf("WebRTC SFU: %t", webrtcSFU)
+	log.Printf("缩略图刷新间隔: %s", thumbnailInterval)
+	if recordDir != "" {
+		log.Printf("会话录像: %s (保留 %s)", recordDir, recordRetention)
+	}
 
 	if mysqlDSN == "" {
 		log.Fatal("MySQL 连接字符串不能为空")
@@ -86,15 +257,65 @@ func main() {
 		log.Fatal("设备连接Token不能为空")
 	}
 
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatal("TLS 证书和私钥需要同时配置")
+	}
+	if httpRedirect != "" && tlsCert == "" {
+		log.Fatal("HTTP 重定向需要先配置 TLS 证书")
+	}
+
+	if turnListen != "" {
+		if turnPublicIP == "" {
+			log.Fatal("启用内置 TURN 服务需要配置公网 IP")
+		}
+		if ice.Secret == "" {
+			ice.Secret = randomSecret()
+		}
+		turnServer, err := service.StartTURNServer(turnListen, turnPublicIP, ice.Secret)
+		if err != nil {
+			log.Fatalf("内置 TURN 服务启动失败: %v", err)
+		}
+		defer turnServer.Close()
+		ice.URLs = append(ice.URLs, turnServer.URLs...)
+		log.Printf("内置 TURN 服务: %s (公网 %s)", turnListen, turnPublicIP)
+	}
+	if len(ice.URLs) > 0 {
+		log.Printf("ICE 服务器: %v", ice.URLs)
+	}
+
 	deviceStore, err := store.NewDeviceStore(mysqlDSN)
 	if err != nil {
 		log.Fatalf("MySQL 连接失败: %v", err)
 	}
 	defer deviceStore.Close()
 
+	// 观看 Token 以管理 API Token 为签名密钥，未配置管理 API 时不签发
+	var streamTokens *service.StreamTokens
+	if apiToken != "" {
+		streamTokens = service.NewStreamTokens(apiToken, streamTokenTTL)
+	}
+
 	// 创建处理器
-	wsHandler := handler.NewWebSocketHandler(deviceToken, deviceStore)
-	apiHandler := handler.NewAPIHandler()
+	wsHandler := handler.NewWebSocketHandler(deviceToken, deviceStore, handler.Options{
+		ReconnectGrace: reconnectGrace,
+		ConsentTimeout: consentTimeout,
+		CommandTimeout: commandTimeout,
+
+		DeviceCompression:     deviceCompression,
+		ControllerCompression: controllerCompression,
+		AdaptiveBitrate:       adaptiveBitrate,
+		MJPEGQuality:          mjpegQuality,
+		MJPEGFPS:              mjpegFPS,
+		StreamTokens:          streamTokens,
+		SFU:                   webrtcSFU,
+		ICE:                   ice,
+		ThumbnailInterval:     thumbnailInterval,
+
+		RecordDir:       recordDir,
+		RecordRetention: recordRetention,
+	})
+	defer wsHandler.Close()
+	apiHandler := handler.NewAPIHandler(wsHandler, deviceStore)
 
 	// 设置Gin
 	gin.SetMode(gin.ReleaseMode)
@@ -103,7 +324,7 @@ func main() {
 	// CORS中间件
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -115,6 +336,45 @@ func main() {
 	// 公开API（无需认证）
 	r.GET("/api/health", apiHandler.HealthCheck)
 
+	// 管理API（需要 API Token）
+	api := r.Group("/api", handler.RequireAPIToken(apiToken))
+	// 只读观看接口另行鉴权，?token= 只接受按设备签发的观看 Token
+	view := r.Group("/api/devices/:id", handler.RequireStreamToken(apiToken, streamTokens))
+	view.GET("/mjpeg", apiHandler.StreamMJPEG)
+	view.GET("/hls/:file", apiHandler.HLS)
+	view.GET("/thumbnail", apiHandler.GetThumbnail)
+	view.GET("/webrtc", wsHandler.HandleWebRTCViewer)
+	api.GET("/recordings", apiHandler.ListRecordings)
+	api.GET("/recordings/:id", apiHandler.GetRecording)
+	api.GET("/recordings/:id/download", apiHandler.DownloadRecording)
+	api.GET("/recordings/:id/index", apiHandler.RecordingIndex)
+	api.GET("/macros", apiHandler.ListMacros)
+	api.GET("/macros/:name", apiHandler.GetMacro)
+	api.DELETE("/macros/:name", apiHandler.DeleteMacro)
+	api.GET("/stream-profiles", apiHandler.ListStreamProfiles)
+	api.PUT("/stream-profiles/:name", apiHandler.SaveStreamProfile)
+	api.DELETE("/stream-profiles/:name", apiHandler.DeleteStreamProfile)
+	api.GET("/stream-assignments", apiHandler.ListStreamAssignments)
+	api.PUT("/stream-assignments/:scope", apiHandler.SaveStreamAssignment)
+	api.PUT("/stream-assignments/:scope/:target", apiHandler.SaveStreamAssignment)
+	api.DELETE("/stream-assignments/:scope", apiHandler.DeleteStreamAssignment)
+	api.DELETE("/stream-assignments/:scope/:target", apiHandler.DeleteStreamAssignment)
+	api.GET("/bandwidth-quotas", apiHandler.ListBandwidthQuotas)
+	api.PUT("/bandwidth-quotas/:id", apiHandler.SaveBandwidthQuota)
+	api.DELETE("/bandwidth-quotas/:id", apiHandler.DeleteBandwidthQuota)
+	api.POST("/devices/:id/macros/:name/replay", apiHandler.ReplayMacro)
+	api.GET("/devices/:id/commands", apiHandler.ListDeviceCommands)
+	api.POST("/devices/:id/stream-token", apiHandler.IssueStreamToken)
+	api.GET("/devices/:id/bandwidth", apiHandler.DeviceBandwidth)
+	api.GET("/thumbnails/feed", wsHandler.HandleThumbnailFeed)
+	api.POST("/devices/:id/commands", apiHandler.SendCommand)
+	api.POST("/devices/:id/gestures/:action", apiHandler.SendGesture)
+	api.GET("/replays", apiHandler.ListReplays)
+	api.GET("/stats/websocket", apiHandler.WireStats)
+	api.GET("/stats/bandwidth", apiHandler.BandwidthStats)
+	api.GET("/sessions/:id/stats", apiHandler.SessionStats)
+	api.DELETE("/replays/:id", apiHandler.AbortReplay)
+
 	// WebSocket路由（带token验证）
 	r.GET("/ws/device", wsHandler.HandleDevice)
 	r.GET("/ws/controller", wsHandler.HandleController)
@@ -128,11 +388,44 @@ func main() {
 		c.File(filepath.Join(absWebDir, "index.html"))
 	})
 
-	log.Printf("服务器启动成功: http://0.0.0.0:%s", port)
-	log.Printf("设备连接地址: ws://服务器IP:%s/ws/device", port)
-	log.Printf("控制端连接地址: ws://服务器IP:%s/ws/controller", port)
+	if tlsCert == "" {
+		log.Printf("服务器启动成功: http://0.0.0.0:%s", port)
+		log.Printf("设备连接地址: ws://服务器IP:%s/ws/device", port)
+		log.Printf("控制端连接地址: ws://服务器IP:%s/ws/controller", port)
+
+		if err := r.Run(":" + port); err != nil {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+		return
+	}
 
-	if err := r.Run(":" + port); err != nil {
+	certs, err := service.NewCertReloader(tlsCert, tlsKey)
+	if err != nil {
+		log.Fatalf("加载 TLS 证书失败: %v", err)
+	}
+	defer certs.Close()
+	// SIGHUP 重新加载证书，已建立的连接不受影响
+	certs.ReloadOn(syscall.SIGHUP)
+
+	if httpRedirect != "" {
+		go func() {
+			log.Printf("HTTP 重定向到 HTTPS: %s", httpRedirect)
+			if err := http.ListenAndServe(httpRedirect, service.HTTPSRedirect(port)); err != nil {
+				log.Fatalf("HTTP 重定向服务启动失败: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("服务器启动成功: https://0.0.0.0:%s", port)
+	log.Printf("设备连接地址: wss://服务器IP:%s/ws/device", port)
+	log.Printf("控制端连接地址: wss://服务器IP:%s/ws/controller", port)
+
+	server := &http.Server{
+		Addr:      ":" + port,
+		Handler:   r,
+		TLSConfig: certs.TLSConfig(),
+	}
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		log.Fatalf("服务器启动失败: %v", err)
 	}
 }
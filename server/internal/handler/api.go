@@ -1,18 +1,47 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+	"shushu-remote-control/internal/store"
+)
+
+const (
+	mjpegBoundary   = "mjpegframe"
+	hlsPlaylistWait = 5 * time.Second // 第一次请求播放列表时等待首个分片的时间
 )
 
 // APIHandler REST API处理器
 type APIHandler struct {
+	wsHandler *WebSocketHandler
+	deviceMgr *service.DeviceManager
+	recorder  *service.RecordingManager
+	player    *service.MacroPlayer
+	store     *store.DeviceStore
 }
 
 // NewAPIHandler 创建API处理器
-func NewAPIHandler() *APIHandler {
+func NewAPIHandler(wsHandler *WebSocketHandler, deviceStore *store.DeviceStore) *APIHandler {
 	return &APIHandler{
+		wsHandler: wsHandler,
+		deviceMgr: wsHandler.GetDeviceManager(),
+		recorder:  wsHandler.GetRecordingManager(),
+		player:    wsHandler.GetMacroPlayer(),
+		store:     deviceStore,
 	}
 }
 
@@ -22,3 +51,631 @@ func (h *APIHandler) HealthCheck(c *gin.Context) {
 		"status": "ok",
 	})
 }
+
+// StreamMJPEG 以 multipart/x-mixed-replace 输出设备的 MJPEG 画面，可直接用于 <img> 标签
+func (h *APIHandler) StreamMJPEG(c *gin.Context) {
+	deviceID := c.Param("id")
+	viewer, err := h.wsHandler.WatchMJPEG(deviceID)
+	switch {
+	case errors.Is(err, ErrDeviceOffline):
+		c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+		return
+	case errors.Is(err, ErrMJPEGNotSupported):
+		c.JSON(http.StatusConflict, gin.H{"error": "UNSUPPORTED_CAPABILITY", "message": "设备不支持 MJPEG 推流"})
+		return
+	}
+	defer h.wsHandler.UnwatchMJPEG(viewer)
+
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Header("Connection", "close")
+	c.Status(http.StatusOK)
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case jpeg, ok := <-viewer.Frames():
+			if !ok {
+				return // 设备离线
+			}
+			header, err := fmt.Fprintf(c.Writer, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(jpeg))
+			if err != nil {
+				return
+			}
+			if _, err := c.Writer.Write(jpeg); err != nil {
+				return
+			}
+			if _, err := io.WriteString(c.Writer, "\r\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			h.wsHandler.RecordViewerBandwidth(deviceID, int64(header+len(jpeg)+2))
+		}
+	}
+}
+
+// IssueStreamToken 签发设备的只读观看 Token，用于 MJPEG、HLS 和缩略图地址的 ?token= 参数
+func (h *APIHandler) IssueStreamToken(c *gin.Context) {
+	tokens := h.wsHandler.opts.StreamTokens
+	if tokens == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "STREAM_TOKEN_DISABLED", "message": "未启用观看 Token"})
+		return
+	}
+	token, expires := tokens.Issue(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"token": token, "expiresAt": expires})
+}
+
+// GetThumbnail 获取设备的最新缩略图（JPEG，支持 If-Modified-Since）
+func (h *APIHandler) GetThumbnail(c *gin.Context) {
+	jpeg, updatedAt, ok := h.wsHandler.GetThumbnail(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "THUMBNAIL_NOT_FOUND", "message": "暂无该设备的缩略图"})
+		return
+	}
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Cache-Control", "no-cache")
+	http.ServeContent(c.Writer, c.Request, "", updatedAt, bytes.NewReader(jpeg))
+}
+
+// HLS 输出设备的 H264 直播（fMP4 分片），file 为 index.m3u8、init-N.mp4 或 segment-N.m4s
+func (h *APIHandler) HLS(c *gin.Context) {
+	deviceID := c.Param("id")
+	file := c.Param("file")
+
+	switch {
+	case file == "index.m3u8":
+		err := h.wsHandler.WatchHLS(deviceID)
+		switch {
+		case errors.Is(err, ErrDeviceOffline):
+			c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+			return
+		case errors.Is(err, ErrH264NotSupported):
+			c.JSON(http.StatusConflict, gin.H{"error": "UNSUPPORTED_CAPABILITY", "message": "设备不支持 H264 推流"})
+			return
+		}
+		// 分片地址是相对路径，播放器不会自动带上 token，需要写入播放列表
+		query := ""
+		if token := c.Query("token"); token != "" {
+			query = "?token=" + url.QueryEscape(token)
+		}
+		playlist, ok := h.wsHandler.HLSPlaylist(c.Request.Context(), deviceID, query, hlsPlaylistWait)
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "STREAM_NOT_READY", "message": "直播尚未生成分片，请稍后重试"})
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+		h.wsHandler.RecordViewerBandwidth(deviceID, int64(len(playlist)))
+		return
+
+	case strings.HasPrefix(file, "init-") && strings.HasSuffix(file, ".mp4"):
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, "init-"), ".mp4"))
+		if err != nil {
+			break
+		}
+		if data, ok := h.wsHandler.HLSInit(deviceID, id); ok {
+			c.Data(http.StatusOK, "video/mp4", data)
+			h.wsHandler.RecordViewerBandwidth(deviceID, int64(len(data)))
+			return
+		}
+
+	case strings.HasPrefix(file, "segment-") && strings.HasSuffix(file, ".m4s"):
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(file, "segment-"), ".m4s"), 10, 64)
+		if err != nil {
+			break
+		}
+		if data, ok := h.wsHandler.HLSSegment(deviceID, seq); ok {
+			c.Data(http.StatusOK, "video/iso.segment", data)
+			h.wsHandler.RecordViewerBandwidth(deviceID, int64(len(data)))
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "SEGMENT_NOT_FOUND", "message": "分片不存在或已过期"})
+}
+
+// SessionStats 会话统计：自适应码率当前参数、链路指标和调整记录
+func (h *APIHandler) SessionStats(c *gin.Context) {
+	stats, ok := h.wsHandler.GetSessionStats(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SESSION_NOT_FOUND", "message": "会话不存在或未开启自适应码率"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// WireStats WebSocket 发送统计（文本消息压缩比、二进制帧字节数）
+func (h *APIHandler) WireStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.wsHandler.GetWireStats())
+}
+
+// ListRecordings 录像列表，可按 deviceId / sessionId 过滤
+func (h *APIHandler) ListRecordings(c *gin.Context) {
+	if h.recorder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "RECORDING_DISABLED", "message": "未开启会话录像"})
+		return
+	}
+
+	list, err := h.recorder.List(c.Query("deviceId"), c.Query("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recordings": list})
+}
+
+// GetRecording 录像详情
+func (h *APIHandler) GetRecording(c *gin.Context) {
+	info, ok := h.findRecording(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// DownloadRecording 下载录像文件
+func (h *APIHandler) DownloadRecording(c *gin.Context) {
+	info, ok := h.findRecording(c)
+	if !ok {
+		return
+	}
+	c.FileAttachment(h.recorder.FilePath(info), info.ID+"."+info.Format)
+}
+
+// RecordingIndex 录像时间索引（NDJSON，每行一条）
+func (h *APIHandler) RecordingIndex(c *gin.Context) {
+	info, ok := h.findRecording(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.File(h.recorder.IndexPath(info))
+}
+
+func (h *APIHandler) findRecording(c *gin.Context) (*service.RecordingInfo, bool) {
+	info, err := h.recorder.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "RECORDING_NOT_FOUND", "message": "录像不存在"})
+		return nil, false
+	}
+	return info, true
+}
+
+// ListMacros 宏列表（不含事件）
+func (h *APIHandler) ListMacros(c *gin.Context) {
+	list, err := h.store.ListMacros()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"macros": list})
+}
+
+// GetMacro 宏详情
+func (h *APIHandler) GetMacro(c *gin.Context) {
+	macro, err := h.store.GetMacro(c.Param("name"))
+	if err != nil {
+		h.macroError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, macro)
+}
+
+// DeleteMacro 删除宏
+func (h *APIHandler) DeleteMacro(c *gin.Context) {
+	if err := h.store.DeleteMacro(c.Param("name")); err != nil {
+		h.macroError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListStreamProfiles 推流配置列表
+func (h *APIHandler) ListStreamProfiles(c *gin.Context) {
+	list, err := h.store.ListStreamProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": list})
+}
+
+// SaveStreamProfile 新建或更新推流配置，名称取自路径
+func (h *APIHandler) SaveStreamProfile(c *gin.Context) {
+	var profile protocol.StreamProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+		return
+	}
+	profile.Name = c.Param("name")
+	if err := profile.Validate(); err != nil {
+		h.commandError(c, err)
+		return
+	}
+	if err := h.store.SaveStreamProfile(&profile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteStreamProfile 删除推流配置
+func (h *APIHandler) DeleteStreamProfile(c *gin.Context) {
+	if err := h.store.DeleteStreamProfile(c.Param("name")); err != nil {
+		h.streamProfileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListStreamAssignments 推流配置分配列表
+func (h *APIHandler) ListStreamAssignments(c *gin.Context) {
+	list, err := h.store.ListStreamAssignments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"assignments": list})
+}
+
+// streamAssignmentRequest 推流配置分配参数
+type streamAssignmentRequest struct {
+	Profile string   `json:"profile"` // 会话开始时使用的配置
+	Allowed []string `json:"allowed"` // 控制端还可切换的配置
+}
+
+// SaveStreamAssignment 为设备、分组或全局分配推流配置
+// 路径为 /stream-assignments/global、/stream-assignments/group/:target 或 /stream-assignments/device/:target
+func (h *APIHandler) SaveStreamAssignment(c *gin.Context) {
+	scope, targetID, ok := h.streamAssignmentTarget(c)
+	if !ok {
+		return
+	}
+	var req streamAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+		return
+	}
+	if req.Profile == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "profile 不能为空"})
+		return
+	}
+
+	// 引用的配置必须存在
+	profiles, err := h.store.ListStreamProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	exists := make(map[string]bool, len(profiles))
+	for _, profile := range profiles {
+		exists[profile.Name] = true
+	}
+	for _, name := range append([]string{req.Profile}, req.Allowed...) {
+		if !exists[name] {
+			c.JSON(http.StatusNotFound, gin.H{"error": "PROFILE_NOT_FOUND", "message": "推流配置不存在: " + name})
+			return
+		}
+	}
+
+	assignment := model.StreamAssignment{Scope: scope, TargetID: targetID, Profile: req.Profile, Allowed: req.Allowed}
+	if assignment.Allowed == nil {
+		assignment.Allowed = []string{}
+	}
+	if err := h.store.SaveStreamAssignment(&assignment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, assignment)
+}
+
+// DeleteStreamAssignment 取消推流配置分配
+func (h *APIHandler) DeleteStreamAssignment(c *gin.Context) {
+	scope, targetID, ok := h.streamAssignmentTarget(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteStreamAssignment(scope, targetID); err != nil {
+		h.streamProfileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// streamAssignmentTarget 解析分配范围，全局不带目标，设备和分组必须带目标
+func (h *APIHandler) streamAssignmentTarget(c *gin.Context) (scope, targetID string, ok bool) {
+	scope, targetID = c.Param("scope"), c.Param("target")
+	switch {
+	case scope == model.StreamScopeGlobal && targetID == "":
+	case (scope == model.StreamScopeDevice || scope == model.StreamScopeGroup) && targetID != "":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_SCOPE", "message": "分配范围应为 global、group/:id 或 device/:id"})
+		return "", "", false
+	}
+	return scope, targetID, true
+}
+
+func (h *APIHandler) streamProfileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrStreamProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "PROFILE_NOT_FOUND", "message": "推流配置不存在"})
+	case errors.Is(err, store.ErrStreamAssignmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "ASSIGNMENT_NOT_FOUND", "message": "推流配置分配不存在"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+	}
+}
+
+// BandwidthStats 进程启动以来经服务端中转的流量，按设备、控制端、进行中的会话汇总
+func (h *APIHandler) BandwidthStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.wsHandler.GetBandwidthStats())
+}
+
+// DeviceBandwidth 设备的日流量（from / to 为 2006-01-02，默认本月）、本月设备链路流量和配额
+func (h *APIHandler) DeviceBandwidth(c *gin.Context) {
+	deviceID := c.Param("id")
+	now := time.Now()
+	monthStart := service.MonthStart(now)
+	from, okFrom := parseDay(c.Query("from"), monthStart)
+	to, okTo := parseDay(c.Query("to"), now)
+	if !okFrom || !okTo {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "from / to 应为 YYYY-MM-DD"})
+		return
+	}
+
+	days, err := h.store.ListBandwidthUsage(deviceID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	quota, used, err := h.store.DeviceBandwidthQuota(deviceID, monthStart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	if quota == nil {
+		month, err := h.store.ListBandwidthUsage(deviceID, monthStart, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+			return
+		}
+		for _, day := range month {
+			used += day.Device.Total()
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deviceId":   deviceID,
+		"days":       days,
+		"monthBytes": used + h.wsHandler.PendingBandwidth(deviceID, monthStart),
+		"quota":      quota,
+	})
+}
+
+// parseDay 解析 YYYY-MM-DD（服务端本地时区），为空时返回 fallback
+func parseDay(value string, fallback time.Time) (time.Time, bool) {
+	if value == "" {
+		return fallback, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t, err == nil
+}
+
+// ListBandwidthQuotas 流量配额列表
+func (h *APIHandler) ListBandwidthQuotas(c *gin.Context) {
+	list, err := h.store.ListBandwidthQuotas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": list})
+}
+
+// bandwidthQuotaRequest 流量配额参数
+type bandwidthQuotaRequest struct {
+	MonthlyBytes int64  `json:"monthlyBytes"` // 每月设备链路收发合计上限
+	Action       string `json:"action"`       // downgrade / refuse
+	Profile      string `json:"profile"`      // downgrade 时切换到的推流配置
+}
+
+// SaveBandwidthQuota 设置设备的月度流量配额
+func (h *APIHandler) SaveBandwidthQuota(c *gin.Context) {
+	var req bandwidthQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+		return
+	}
+	if req.MonthlyBytes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "monthlyBytes 必须大于 0"})
+		return
+	}
+	switch req.Action {
+	case model.QuotaActionRefuse:
+		req.Profile = ""
+	case model.QuotaActionDowngrade:
+		if req.Profile == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "downgrade 需要指定 profile"})
+			return
+		}
+		profiles, err := h.store.ListStreamProfiles()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+			return
+		}
+		exists := false
+		for _, profile := range profiles {
+			exists = exists || profile.Name == req.Profile
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "PROFILE_NOT_FOUND", "message": "推流配置不存在: " + req.Profile})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "action 应为 downgrade 或 refuse"})
+		return
+	}
+
+	quota := model.BandwidthQuota{DeviceID: c.Param("id"), MonthlyBytes: req.MonthlyBytes, Action: req.Action, Profile: req.Profile}
+	if err := h.store.SaveBandwidthQuota(&quota); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, quota)
+}
+
+// DeleteBandwidthQuota 取消设备的流量配额
+func (h *APIHandler) DeleteBandwidthQuota(c *gin.Context) {
+	if err := h.store.DeleteBandwidthQuota(c.Param("id")); err != nil {
+		if errors.Is(err, store.ErrBandwidthQuotaNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "QUOTA_NOT_FOUND", "message": "流量配额不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// replayRequest 宏回放参数
+type replayRequest struct {
+	Speed float64 `json:"speed"` // 回放倍速，默认 1
+}
+
+// ReplayMacro 在设备上回放宏，设备正被控制时须通过 sessionId 查询参数指定该会话
+func (h *APIHandler) ReplayMacro(c *gin.Context) {
+	req := replayRequest{Speed: 1}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+			return
+		}
+	}
+
+	device := h.deviceMgr.GetOnline(c.Param("id"))
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+		return
+	}
+
+	session, err := h.wsHandler.AuthorizeInjection(device.ID, c.Query("sessionId"))
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+
+	macro, err := h.store.GetMacro(c.Param("name"))
+	if err != nil {
+		h.macroError(c, err)
+		return
+	}
+
+	sessionID := ""
+	if session != nil {
+		sessionID = session.ID
+	}
+	replay, err := h.player.Play(device, sessionID, macro, req.Speed)
+	if errors.Is(err, service.ErrInvalidSpeed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_SPEED", "message": "回放倍速超出范围"})
+		return
+	}
+	if err != nil {
+		// 宏中的命令未通过当前设备的命令校验
+		h.commandError(c, err)
+		return
+	}
+	h.wsHandler.GetAuditLogger().RecordAPI(session, device.ID, service.AuditMacroReplay,
+		fmt.Sprintf("macro=%s speed=%.2f replay=%s", macro.Name, req.Speed, replay.ID))
+	c.JSON(http.StatusOK, replay)
+}
+
+// SendGesture 向设备发送高层手势，请求体为对应手势的参数（如 tap 为 {"x":100,"y":200}）
+// 与 SendCommand 相同，设备正被控制时须通过 sessionId 查询参数指定该会话
+func (h *APIHandler) SendGesture(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 4096))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+		return
+	}
+	gesture, err := protocol.ParseGesture(c.Param("action"), body)
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+	message, _ := json.Marshal(gesture.Touch())
+	h.sendCommand(c, message)
+}
+
+// ListDeviceCommands 设备支持的 input.command 命令
+func (h *APIHandler) ListDeviceCommands(c *gin.Context) {
+	device := h.deviceMgr.GetOnline(c.Param("id"))
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"commands": h.wsHandler.GetCommandRegistry().ListFor(device)})
+}
+
+// ListReplays 进行中的回放
+func (h *APIHandler) ListReplays(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"replays": h.player.List()})
+}
+
+// AbortReplay 中止回放
+func (h *APIHandler) AbortReplay(c *gin.Context) {
+	if !h.player.Abort(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "REPLAY_NOT_FOUND", "message": "回放不存在或已结束"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (h *APIHandler) macroError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrMacroNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "MACRO_NOT_FOUND", "message": "宏不存在"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR", "message": err.Error()})
+}
+
+// SendCommand 向设备发送输入消息并等待执行回执
+// 请求体与 WebSocket 消息相同（input.touch / input.key / input.text / input.command / clipboard.set）
+// 设备正被控制时须通过 sessionId 查询参数指定该会话
+func (h *APIHandler) SendCommand(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, protocol.MaxClipboardLength*2))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": err.Error()})
+		return
+	}
+	h.sendCommand(c, body)
+}
+
+func (h *APIHandler) sendCommand(c *gin.Context, message []byte) {
+	result, err := h.wsHandler.SendCommand(c.Param("id"), c.Query("sessionId"), message)
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+	if result == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// commandError 输入消息被拒绝：设备忙或会话不符返回 409，需现场用户同意返回 403，其余为 400
+func (h *APIHandler) commandError(c *gin.Context, err error) {
+	code, message := protocol.ErrCodeInvalidMessage, err.Error()
+	var ve *protocol.ValidationError
+	if errors.As(err, &ve) {
+		code, message = ve.Code, ve.Message
+	}
+	status := http.StatusBadRequest
+	switch code {
+	case "DEVICE_BUSY", "SESSION_NOT_FOUND":
+		status = http.StatusConflict
+	case "CONSENT_REQUIRED":
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"error": code, "message": message})
+}
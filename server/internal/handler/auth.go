@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shushu-remote-control/internal/service"
+)
+
+// RequireAPIToken 管理API鉴权中间件
+// 支持 Authorization: Bearer <token> 或 ?token=<token>（供无法设置请求头的 WebSocket 客户端使用，如缩略图推送）
+func RequireAPIToken(apiToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API_DISABLED", "message": "未配置 API Token"})
+			return
+		}
+
+		token := c.Query("token")
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "UNAUTHORIZED", "message": "无效的 API Token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireStreamToken 只读观看接口（MJPEG、HLS、缩略图）鉴权中间件
+// 管理 API Token 只能通过 Authorization 请求头传入；?token= 只接受为路径中设备签发的观看 Token，
+// 避免管理 Token 出现在 <img> / <video> 地址、浏览器历史和访问日志中
+func RequireStreamToken(apiToken string, tokens *service.StreamTokens) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API_DISABLED", "message": "未配置 API Token"})
+			return
+		}
+
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(apiToken)) == 1 {
+				c.Next()
+				return
+			}
+		} else if token := c.Query("token"); token != "" && tokens != nil && tokens.Valid(token, c.Param("id"), time.Now()) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "UNAUTHORIZED", "message": "无效的观看 Token"})
+	}
+}
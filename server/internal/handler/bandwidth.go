@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"log"
+	"time"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+)
+
+// flushBandwidth 定期把设备日流量写入数据库，并检查有流量的设备是否超出月度配额，Close 后退出
+func (h *WebSocketHandler) flushBandwidth() {
+	ticker := time.NewTicker(h.opts.BandwidthFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+
+		records := h.bandwidth.Flush()
+		if len(records) == 0 {
+			continue
+		}
+		if err := h.opts.Bandwidth.AddBandwidthUsage(records); err != nil {
+			log.Printf("写入流量统计失败，下次重试: %v", err)
+			h.bandwidth.Restore(records)
+		}
+
+		checked := make(map[string]bool, len(records))
+		for _, record := range records {
+			if checked[record.DeviceID] {
+				continue
+			}
+			checked[record.DeviceID] = true
+			if session := h.sessionMgr.GetByDevice(record.DeviceID); session != nil {
+				h.enforceQuota(session)
+			}
+		}
+	}
+}
+
+// quotaExceeded 设备本月流量（含尚未写入数据库的部分）达到配额时返回配额
+// 未设置配额或查询失败时返回 nil
+func (h *WebSocketHandler) quotaExceeded(deviceID string) *model.BandwidthQuota {
+	if h.opts.Bandwidth == nil {
+		return nil
+	}
+	since := service.MonthStart(time.Now())
+	quota, used, err := h.opts.Bandwidth.DeviceBandwidthQuota(deviceID, since)
+	if err != nil {
+		log.Printf("查询流量配额失败: %s %v", deviceID, err)
+		return nil
+	}
+	if quota == nil || used+h.bandwidth.Pending(deviceID, since) < quota.MonthlyBytes {
+		return nil
+	}
+	return quota
+}
+
+// quotaRefuses 配额用尽后是否拒绝会话，降级配置已被删除时同样拒绝
+func quotaRefuses(quota *model.BandwidthQuota) bool {
+	return quota.Action != model.QuotaActionDowngrade || quota.Downgrade == nil
+}
+
+// enforceQuota 会话进行中设备流量达到配额：结束会话，或切换到配额指定的推流配置
+func (h *WebSocketHandler) enforceQuota(session *model.Session) {
+	quota := h.quotaExceeded(session.DeviceID)
+	if quota == nil {
+		return
+	}
+
+	if quotaRefuses(quota) {
+		if h.sessionMgr.CloseByDevice(session.DeviceID) != session {
+			return
+		}
+		if session.Controller != nil {
+			session.Controller.SendJSON(protocol.ErrorMessage{
+				Type:    protocol.TypeError,
+				Code:    "QUOTA_EXCEEDED",
+				Message: "设备本月流量已用尽，会话已结束",
+			})
+		}
+		if device := h.sessionMgr.Device(session.ID); device != nil {
+			// 有 HTTP 观看者时 endSession 会按观看者重新推流
+			device.SendJSON(protocol.BaseMessage{Type: protocol.TypeStreamStop})
+		}
+		h.endSession(session, "quota exceeded")
+		log.Printf("设备本月流量已用尽，结束会话: %s -> %s", session.ControllerID, session.DeviceID)
+		return
+	}
+
+	if current := h.sessionMgr.StreamProfile(session.ID); current != nil && current.Name == quota.Downgrade.Name &&
+		len(h.sessionMgr.StreamProfiles(session.ID)) == 1 {
+		return
+	}
+	profile := *quota.Downgrade
+	profiles := []protocol.StreamProfile{profile}
+	h.sessionMgr.SetStreamProfile(session.ID, &profile, profiles)
+	h.startStream(session, &profile)
+	if session.Controller != nil {
+		session.Controller.SendJSON(protocol.StreamProfileMessage{
+			Type:     protocol.TypeStreamProfile,
+			Profile:  profile.Name,
+			Reason:   protocol.StreamProfileReasonQuota,
+			Profiles: profiles,
+		})
+	}
+	log.Printf("设备本月流量已用尽，切换推流配置: %s -> %s", session.ID, profile.Name)
+}
+
+// GetBandwidthStats 获取实时流量统计（供API使用）
+func (h *WebSocketHandler) GetBandwidthStats() service.BandwidthSnapshot {
+	return h.bandwidth.Snapshot()
+}
+
+// PendingBandwidth 设备自 since 起尚未写入数据库的设备链路流量（供API使用）
+func (h *WebSocketHandler) PendingBandwidth(deviceID string, since time.Time) int64 {
+	return h.bandwidth.Pending(deviceID, since)
+}
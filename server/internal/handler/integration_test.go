@@ -0,0 +1,1138 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+
+	"shushu-remote-control/internal/handler"
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+	"shushu-remote-control/internal/sim"
+	"shushu-remote-control/internal/store"
+)
+
+const (
+	testDeviceToken = "device-secret"
+	testAPIToken    = "api-secret"
+	testTimeout     = 3 * time.Second
+)
+
+// fakeTokens 内存中的控制 Token 表，替代 MySQL
+type fakeTokens struct {
+	mutex   sync.Mutex
+	tokens  map[string]string // deviceID -> token
+	expired map[string]bool
+	consent map[string]bool // 要求现场用户同意的设备
+}
+
+func (f *fakeTokens) set(deviceID, token string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.tokens[deviceID] = token
+}
+
+func (f *fakeTokens) requireConsent(deviceID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.consent[deviceID] = true
+}
+
+func (f *fakeTokens) ValidateControlToken(deviceID, token string) (*model.Device, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	dbToken, ok := f.tokens[deviceID]
+	if !ok {
+		return nil, store.ErrDeviceNotFound
+	}
+	if token != dbToken {
+		return nil, store.ErrInvalidToken
+	}
+	if f.expired[deviceID] {
+		return nil, store.ErrTokenExpired
+	}
+	return &model.Device{ID: deviceID, Name: deviceID, Online: true, ConsentRequired: f.consent[deviceID]}, nil
+}
+
+type testServer struct {
+	url     string // WebSocket 地址
+	httpURL string // REST 接口地址
+	tokens  *fakeTokens
+}
+
+func newTestServer(t *testing.T, opts handler.Options) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	tokens := &fakeTokens{tokens: map[string]string{}, expired: map[string]bool{}, consent: map[string]bool{}}
+	opts.TokenValidator = tokens
+	if opts.StreamTokens == nil {
+		opts.StreamTokens = service.NewStreamTokens(testAPIToken, time.Minute)
+	}
+	wsHandler := handler.NewWebSocketHandler(testDeviceToken, nil, opts)
+	t.Cleanup(wsHandler.Close)
+
+	r := gin.New()
+	r.GET("/ws/device", wsHandler.HandleDevice)
+	r.GET("/ws/controller", wsHandler.HandleController)
+
+	apiHandler := handler.NewAPIHandler(wsHandler, nil)
+	r.POST("/api/devices/:id/macros/:name/replay", apiHandler.ReplayMacro)
+	r.POST("/api/devices/:id/commands", apiHandler.SendCommand)
+	r.POST("/api/devices/:id/gestures/:action", apiHandler.SendGesture)
+	r.POST("/api/devices/:id/stream-token", handler.RequireAPIToken(testAPIToken), apiHandler.IssueStreamToken)
+	view := r.Group("/api/devices/:id", handler.RequireStreamToken(testAPIToken, opts.StreamTokens))
+	view.GET("/thumbnail", apiHandler.GetThumbnail)
+	view.GET("/webrtc", wsHandler.HandleWebRTCViewer)
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return &testServer{url: "ws" + strings.TrimPrefix(srv.URL, "http"), httpURL: srv.URL, tokens: tokens}
+}
+
+// post 调用 REST 接口，返回状态码和响应中的错误码
+func (s *testServer) post(t *testing.T, path, body string) (int, string) {
+	t.Helper()
+	status, result := s.request(t, http.MethodPost, path, "", body)
+	return status, result["error"]
+}
+
+// request 调用 REST 接口，bearer 非空时作为 Authorization 请求头，返回状态码和响应中的字符串字段
+func (s *testServer) request(t *testing.T, method, path, bearer, body string) (int, map[string]string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.httpURL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := map[string]string{}
+	var fields map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&fields)
+	for k, v := range fields {
+		if str, ok := v.(string); ok {
+			result[k] = str
+		}
+	}
+	return resp.StatusCode, result
+}
+
+func (s *testServer) device(t *testing.T, id string, configure ...func(*sim.DeviceConfig)) *sim.FakeDevice {
+	t.Helper()
+	cfg := sim.DeviceConfig{ServerURL: s.url, DeviceID: id, Token: testDeviceToken}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	d, err := sim.ConnectDevice(cfg)
+	if err != nil {
+		t.Fatalf("设备连接失败: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	s.tokens.set(id, "token-"+id)
+	// 等待注册完成：注册后控制端才能看到设备在线
+	waitFor(t, func() bool {
+		c, err := sim.ConnectController(sim.ControllerConfig{ServerURL: s.url, DeviceID: id, Token: "token-" + id})
+		if err != nil {
+			return false
+		}
+		defer c.Close()
+		select {
+		case <-c.Done():
+			return c.CloseCode() != 4003
+		case <-time.After(50 * time.Millisecond):
+			return true
+		}
+	})
+	return d
+}
+
+func (s *testServer) controller(t *testing.T, deviceID string) *sim.FakeController {
+	t.Helper()
+	c, err := sim.ConnectController(sim.ControllerConfig{ServerURL: s.url, DeviceID: deviceID, Token: "token-" + deviceID})
+	if err != nil {
+		t.Fatalf("控制端连接失败: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待条件超时")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("连接未关闭")
+	}
+}
+
+func TestDeviceAuthFailure(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+
+	d, err := sim.ConnectDevice(sim.DeviceConfig{ServerURL: srv.url, DeviceID: "DEV_AUTH", Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeError)
+	if err != nil {
+		t.Fatalf("未收到错误消息: %v", err)
+	}
+	if msg.Code != "AUTH_FAILED" {
+		t.Fatalf("错误码 = %q, 期望 AUTH_FAILED", msg.Code)
+	}
+	waitClosed(t, d.Done())
+}
+
+func TestControllerAuthFailures(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.device(t, "DEV_A")
+	srv.tokens.set("DEV_OFFLINE", "token-DEV_OFFLINE")
+	srv.tokens.set("DEV_EXPIRED", "token-DEV_EXPIRED")
+	srv.tokens.expired["DEV_EXPIRED"] = true
+
+	cases := []struct {
+		name     string
+		deviceID string
+		token    string
+		code     int
+	}{
+		{"wrong token", "DEV_A", "bad", 4002},
+		{"missing token", "DEV_A", "", 4002},
+		{"unknown device", "DEV_UNKNOWN", "x", 4002},
+		{"expired token", "DEV_EXPIRED", "token-DEV_EXPIRED", 4001},
+		{"device offline", "DEV_OFFLINE", "token-DEV_OFFLINE", 4003},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := sim.ConnectController(sim.ControllerConfig{ServerURL: srv.url, DeviceID: tc.deviceID, Token: tc.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			waitClosed(t, c.Done())
+			if c.CloseCode() != tc.code {
+				t.Fatalf("关闭码 = %d, 期望 %d", c.CloseCode(), tc.code)
+			}
+		})
+	}
+}
+
+func TestControlStreamsFramesAndInput(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	d := srv.device(t, "DEV_STREAM")
+	c := srv.controller(t, "DEV_STREAM")
+
+	granted, err := c.RequestControl(testTimeout)
+	if err != nil {
+		t.Fatalf("请求控制失败: %v", err)
+	}
+	if granted.ScreenWidth != 720 || granted.ScreenHeight != 1280 {
+		t.Fatalf("屏幕尺寸 = %dx%d", granted.ScreenWidth, granted.ScreenHeight)
+	}
+	if _, err := d.WaitMessage(testTimeout, protocol.TypeStreamStart); err != nil {
+		t.Fatalf("设备未收到 stream.start: %v", err)
+	}
+	if err := c.WaitFrames(10, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if types := c.FrameTypes(); types[0] != protocol.BinaryTypeH264Config {
+		t.Fatalf("首帧类型 = %#x, 期望配置帧", types[0])
+	}
+
+	if err := c.RunScript(sim.DefaultScript(granted.ScreenWidth, granted.ScreenHeight), testTimeout); err != nil {
+		t.Fatalf("执行脚本失败: %v", err)
+	}
+	if _, err := d.WaitMessage(testTimeout, protocol.TypeInputText); err != nil {
+		t.Fatalf("设备未收到输入: %v", err)
+	}
+}
+
+func TestInputRejectedByDevice(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.device(t, "DEV_FAIL", func(cfg *sim.DeviceConfig) { cfg.FailInput = true })
+	c := srv.controller(t, "DEV_FAIL")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []sim.ScriptStep{{Message: map[string]interface{}{"type": protocol.TypeInputTouch, "action": "tap", "x": 10, "y": 10}}}
+	if err := c.RunScript(steps, testTimeout); err == nil || !strings.Contains(err.Error(), protocol.CommandStatusFailure) {
+		t.Fatalf("期望执行失败, 实际 %v", err)
+	}
+}
+
+// fakeProfiles 固定的推流配置分配，替代 MySQL
+type fakeProfiles struct {
+	profile  protocol.StreamProfile
+	profiles []protocol.StreamProfile
+}
+
+func (f *fakeProfiles) DeviceStreamProfiles(deviceID string) (*protocol.StreamProfile, []protocol.StreamProfile, error) {
+	profile := f.profile
+	return &profile, f.profiles, nil
+}
+
+func TestStreamProfiles(t *testing.T) {
+	signage := protocol.StreamProfile{Name: "signage", Mode: "h264", Bitrate: 6000000, FPS: 30, MJPEGQuality: 80, MaxWidth: 3840, MaxHeight: 2160}
+	cellular := protocol.StreamProfile{Name: "cellular", Mode: "h264", Bitrate: 500000, FPS: 15, MJPEGQuality: 50, MaxWidth: 960, MaxHeight: 540}
+	srv := newTestServer(t, handler.Options{
+		StreamProfiles: &fakeProfiles{profile: signage, profiles: []protocol.StreamProfile{signage, cellular}},
+	})
+	d := srv.device(t, "DEV_PROFILE")
+	c := srv.controller(t, "DEV_PROFILE")
+
+	granted, err := c.RequestControl(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted.StreamProfile != "signage" || len(granted.StreamProfiles) != 2 {
+		t.Fatalf("control.granted 推流配置 = %q %v", granted.StreamProfile, granted.StreamProfiles)
+	}
+	waitStream := func() protocol.StreamControlMessage {
+		t.Helper()
+		msg, err := d.WaitMessage(testTimeout, protocol.TypeStreamStart)
+		if err != nil {
+			t.Fatalf("设备未收到 stream.start: %v", err)
+		}
+		var params protocol.StreamControlMessage
+		msg.Decode(&params)
+		return params
+	}
+	if params := waitStream(); params.Bitrate != 6000000 || params.FPS != 30 || params.MaxWidth != 3840 {
+		t.Fatalf("初始推流参数 = %+v", params)
+	}
+
+	// 切换到允许的配置
+	c.Send(protocol.StreamProfileMessage{Type: protocol.TypeStreamProfile, Profile: "cellular"})
+	if params := waitStream(); params.Bitrate != 500000 || params.FPS != 15 || params.MaxWidth != 960 {
+		t.Fatalf("切换后推流参数 = %+v", params)
+	}
+	if _, err := c.WaitMessage(testTimeout, protocol.TypeStreamProfile); err != nil {
+		t.Fatalf("控制端未收到切换确认: %v", err)
+	}
+
+	// 手动参数不能超出当前配置
+	c.Send(protocol.StreamControlMessage{Type: protocol.TypeStreamStart, Mode: "h264", Bitrate: 8000000, FPS: 60})
+	if params := waitStream(); params.Bitrate != 500000 || params.FPS != 15 {
+		t.Fatalf("手动推流参数未被限制: %+v", params)
+	}
+
+	c.Send(protocol.StreamProfileMessage{Type: protocol.TypeStreamProfile, Profile: "studio"})
+	msg, err := c.WaitMessage(testTimeout, protocol.TypeError)
+	if err != nil || msg.Code != "PROFILE_NOT_ALLOWED" {
+		t.Fatalf("期望 PROFILE_NOT_ALLOWED, 实际 %+v %v", msg, err)
+	}
+}
+
+// fakeBandwidth 内存中的流量配额和日流量，替代 MySQL
+type fakeBandwidth struct {
+	mutex   sync.Mutex
+	quota   *model.BandwidthQuota
+	used    int64
+	records []model.BandwidthDaily
+}
+
+func (f *fakeBandwidth) set(quota *model.BandwidthQuota, used int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.quota, f.used = quota, used
+}
+
+func (f *fakeBandwidth) AddBandwidthUsage(records []model.BandwidthDaily) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.records = append(f.records, records...)
+	for _, record := range records {
+		f.used += record.Device.Total()
+	}
+	return nil
+}
+
+// deviceBytes 已写入的设备链路流量
+func (f *fakeBandwidth) deviceBytes(deviceID string) int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var total int64
+	for _, record := range f.records {
+		if record.DeviceID == deviceID {
+			total += record.Device.Total()
+		}
+	}
+	return total
+}
+
+func (f *fakeBandwidth) DeviceBandwidthQuota(deviceID string, since time.Time) (*model.BandwidthQuota, int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.quota == nil {
+		return nil, 0, nil
+	}
+	quota := *f.quota
+	return &quota, f.used, nil
+}
+
+func TestBandwidthQuota(t *testing.T) {
+	cellular := protocol.StreamProfile{Name: "cellular", Mode: "h264", Bitrate: 500000, FPS: 15, MJPEGQuality: 50, MaxWidth: 960, MaxHeight: 540}
+	bandwidth := &fakeBandwidth{}
+	srv := newTestServer(t, handler.Options{Bandwidth: bandwidth})
+	d := srv.device(t, "DEV_QUOTA")
+
+	// 配额用尽且设置为拒绝：控制请求被拒绝
+	bandwidth.set(&model.BandwidthQuota{DeviceID: "DEV_QUOTA", MonthlyBytes: 1000, Action: model.QuotaActionRefuse}, 1000)
+	_, err := srv.controller(t, "DEV_QUOTA").RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "QUOTA_EXCEEDED" {
+		t.Fatalf("期望 QUOTA_EXCEEDED, 实际 %v", err)
+	}
+
+	// 配额用尽且设置为降级：会话只能使用降级配置
+	bandwidth.set(&model.BandwidthQuota{DeviceID: "DEV_QUOTA", MonthlyBytes: 1000, Action: model.QuotaActionDowngrade, Profile: "cellular", Downgrade: &cellular}, 1000)
+	granted, err := srv.controller(t, "DEV_QUOTA").RequestControl(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted.StreamProfile != "cellular" || len(granted.StreamProfiles) != 1 {
+		t.Fatalf("control.granted 推流配置 = %q %v", granted.StreamProfile, granted.StreamProfiles)
+	}
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeStreamStart)
+	if err != nil {
+		t.Fatalf("设备未收到 stream.start: %v", err)
+	}
+	var params protocol.StreamControlMessage
+	msg.Decode(&params)
+	if params.Bitrate != 500000 || params.MaxWidth != 960 {
+		t.Fatalf("降级推流参数 = %+v", params)
+	}
+}
+
+func TestBandwidthQuotaEnforced(t *testing.T) {
+	cellular := protocol.StreamProfile{Name: "cellular", Mode: "h264", Bitrate: 500000, FPS: 15, MJPEGQuality: 50, MaxWidth: 960, MaxHeight: 540}
+	bandwidth := &fakeBandwidth{}
+	srv := newTestServer(t, handler.Options{Bandwidth: bandwidth, BandwidthFlushPeriod: 50 * time.Millisecond})
+	srv.device(t, "DEV_QUOTA_LIVE")
+
+	// 会话进行中流量写入后达到配额：切换到降级配置
+	bandwidth.set(&model.BandwidthQuota{DeviceID: "DEV_QUOTA_LIVE", MonthlyBytes: 1 << 40, Action: model.QuotaActionDowngrade, Profile: "cellular", Downgrade: &cellular}, 0)
+	c := srv.controller(t, "DEV_QUOTA_LIVE")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return bandwidth.deviceBytes("DEV_QUOTA_LIVE") > 0 })
+	bandwidth.set(&model.BandwidthQuota{DeviceID: "DEV_QUOTA_LIVE", MonthlyBytes: 1000, Action: model.QuotaActionDowngrade, Profile: "cellular", Downgrade: &cellular}, 1000)
+	msg, err := c.WaitMessage(testTimeout, protocol.TypeStreamProfile)
+	if err != nil {
+		t.Fatalf("控制端未收到 stream.profile: %v", err)
+	}
+	var switched protocol.StreamProfileMessage
+	msg.Decode(&switched)
+	if switched.Profile != "cellular" || switched.Reason != protocol.StreamProfileReasonQuota || len(switched.Profiles) != 1 {
+		t.Fatalf("配额降级消息 = %+v", switched)
+	}
+
+	// 设置为拒绝后结束会话
+	bandwidth.set(&model.BandwidthQuota{DeviceID: "DEV_QUOTA_LIVE", MonthlyBytes: 1000, Action: model.QuotaActionRefuse}, 1000)
+	msg, err = c.WaitMessage(testTimeout, protocol.TypeError)
+	if err != nil || msg.Code != "QUOTA_EXCEEDED" {
+		t.Fatalf("期望 QUOTA_EXCEEDED, 实际 %+v %v", msg, err)
+	}
+}
+
+func TestDeviceBusy(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.device(t, "DEV_BUSY")
+
+	first := srv.controller(t, "DEV_BUSY")
+	if _, err := first.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	second := srv.controller(t, "DEV_BUSY")
+	_, err := second.RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "DEVICE_BUSY" {
+		t.Fatalf("期望 DEVICE_BUSY, 实际 %v", err)
+	}
+
+	// 第一个控制端断开后设备可再次被控制
+	first.Close()
+	waitFor(t, func() bool {
+		c, err := sim.ConnectController(sim.ControllerConfig{ServerURL: srv.url, DeviceID: "DEV_BUSY", Token: "token-DEV_BUSY"})
+		if err != nil {
+			return false
+		}
+		defer c.Close()
+		_, err = c.RequestControl(testTimeout)
+		return err == nil
+	})
+}
+
+// fakeConsent 要求现场用户同意的设备
+type fakeConsent map[string]bool
+
+func (f fakeConsent) DeviceConsentRequired(deviceID string) (bool, error) {
+	return f[deviceID], nil
+}
+
+// controlCancel 等待设备收到 control.cancel 并返回撤回原因
+func controlCancel(t *testing.T, d *sim.FakeDevice) string {
+	t.Helper()
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeControlCancel)
+	if err != nil {
+		t.Fatalf("设备未收到 control.cancel: %v", err)
+	}
+	var cancel protocol.ControlCancelMessage
+	msg.Decode(&cancel)
+	return cancel.Reason
+}
+
+func TestConsentGranted(t *testing.T) {
+	// 未配置 ConsentTimeout 时使用默认超时，不会立即超时
+	srv := newTestServer(t, handler.Options{})
+	srv.tokens.requireConsent("DEV_CONSENT")
+	d := srv.device(t, "DEV_CONSENT")
+	c := srv.controller(t, "DEV_CONSENT")
+
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatalf("现场用户同意后未授予控制: %v", err)
+	}
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeControlPending)
+	if err != nil {
+		t.Fatalf("设备未收到 control.pending: %v", err)
+	}
+	var pending protocol.ControlPendingMessage
+	msg.Decode(&pending)
+	if pending.TimeoutMs != 30000 {
+		t.Fatalf("等待超时 %dms, 期望默认 30000ms", pending.TimeoutMs)
+	}
+	if err := c.WaitFrames(1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsentDenied(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.tokens.requireConsent("DEV_CONSENT")
+	srv.device(t, "DEV_CONSENT", func(cfg *sim.DeviceConfig) { cfg.DenyConsent = true })
+	c := srv.controller(t, "DEV_CONSENT")
+
+	_, err := c.RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "CONSENT_DENIED" {
+		t.Fatalf("控制请求结果 %v, 期望 CONSENT_DENIED", err)
+	}
+
+	// 拒绝后设备空闲，可以再次请求
+	if _, err := c.RequestControl(testTimeout); !errors.As(err, &serverErr) || serverErr.Code != "CONSENT_DENIED" {
+		t.Fatalf("再次请求结果 %v, 期望 CONSENT_DENIED", err)
+	}
+}
+
+func TestConsentTimeout(t *testing.T) {
+	srv := newTestServer(t, handler.Options{ConsentTimeout: 200 * time.Millisecond})
+	srv.tokens.requireConsent("DEV_CONSENT")
+	d := srv.device(t, "DEV_CONSENT", func(cfg *sim.DeviceConfig) { cfg.NoConsent = true })
+	c := srv.controller(t, "DEV_CONSENT")
+
+	_, err := c.RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "CONSENT_TIMEOUT" {
+		t.Fatalf("控制请求结果 %v, 期望 CONSENT_TIMEOUT", err)
+	}
+	if reason := controlCancel(t, d); reason != "timeout" {
+		t.Fatalf("撤回原因 %q, 期望 timeout", reason)
+	}
+
+	// 超时后设备空闲，再次请求重新等待同意
+	if err := c.Send(protocol.ControlRequestMessage{Type: protocol.TypeControlRequest, DeviceID: "DEV_CONSENT"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WaitMessage(testTimeout, protocol.TypeControlPending); err != nil {
+		t.Fatalf("超时后再次请求未进入等待: %v", err)
+	}
+}
+
+func TestConsentControllerLeaves(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.tokens.requireConsent("DEV_CONSENT")
+	d := srv.device(t, "DEV_CONSENT", func(cfg *sim.DeviceConfig) { cfg.NoConsent = true })
+	c := srv.controller(t, "DEV_CONSENT")
+
+	if err := c.Send(protocol.ControlRequestMessage{Type: protocol.TypeControlRequest, DeviceID: "DEV_CONSENT"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.WaitMessage(testTimeout, protocol.TypeControlPending); err != nil {
+		t.Fatalf("设备未收到 control.pending: %v", err)
+	}
+
+	// 等待期间其他控制端请求时设备忙
+	c2 := srv.controller(t, "DEV_CONSENT")
+	_, err := c2.RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "DEVICE_BUSY" {
+		t.Fatalf("等待期间请求结果 %v, 期望 DEVICE_BUSY", err)
+	}
+
+	// 控制端离开后设备撤回请求，其他控制端可以重新请求
+	c.Close()
+	if reason := controlCancel(t, d); reason == "" {
+		t.Fatal("撤回原因为空")
+	}
+	if err := c2.Send(protocol.ControlRequestMessage{Type: protocol.TypeControlRequest, DeviceID: "DEV_CONSENT"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.WaitMessage(testTimeout, protocol.TypeControlPending); err != nil {
+		t.Fatalf("控制端离开后重新请求未进入等待: %v", err)
+	}
+}
+
+func TestChatRelay(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	d := srv.device(t, "DEV_CHAT")
+	c := srv.controller(t, "DEV_CHAT")
+	granted, err := c.RequestControl(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chatRelayed 等待发送方收到 id 对应的回执并确认已转发
+	chatRelayed := func(wait func(time.Duration, ...string) (sim.Message, error), id string) {
+		t.Helper()
+		msg, err := wait(testTimeout, protocol.TypeChatAck)
+		if err != nil {
+			t.Fatalf("未收到 chat.ack: %v", err)
+		}
+		var ack protocol.ChatAckMessage
+		msg.Decode(&ack)
+		if ack.ID != id || !ack.Relayed {
+			t.Fatalf("回执 %+v, 期望 %s 已转发", ack, id)
+		}
+	}
+	// chatReceived 等待接收方收到聊天消息并检查服务端补全的字段
+	chatReceived := func(wait func(time.Duration, ...string) (sim.Message, error), from, text string) {
+		t.Helper()
+		msg, err := wait(testTimeout, protocol.TypeChatMessage)
+		if err != nil {
+			t.Fatalf("未收到 chat.message: %v", err)
+		}
+		var chat protocol.ChatMessage
+		msg.Decode(&chat)
+		if chat.From != from || chat.Text != text || chat.SessionID != granted.SessionID || chat.Timestamp == 0 {
+			t.Fatalf("聊天消息 %+v", chat)
+		}
+	}
+
+	// 控制端 -> 设备
+	if err := c.Send(protocol.ChatMessage{Type: protocol.TypeChatMessage, ID: "c1", Text: "请稍等"}); err != nil {
+		t.Fatal(err)
+	}
+	chatReceived(d.WaitMessage, protocol.ChatFromController, "请稍等")
+	chatRelayed(c.WaitMessage, "c1")
+
+	// 设备 -> 控制端
+	if err := d.Send(protocol.ChatMessage{Type: protocol.TypeChatMessage, ID: "d1", Text: "好的"}); err != nil {
+		t.Fatal(err)
+	}
+	chatReceived(c.WaitMessage, protocol.ChatFromDevice, "好的")
+	chatRelayed(d.WaitMessage, "d1")
+
+	// 会话结束后设备的消息不再转发
+	c.Close()
+	waitFor(t, func() bool {
+		if err := d.Send(protocol.ChatMessage{Type: protocol.TypeChatMessage, ID: "d2", Text: "还在吗"}); err != nil {
+			return false
+		}
+		msg, err := d.WaitMessage(testTimeout, protocol.TypeChatAck)
+		if err != nil {
+			return false
+		}
+		var ack protocol.ChatAckMessage
+		msg.Decode(&ack)
+		return !ack.Relayed && ack.Code == "NO_SESSION"
+	})
+}
+
+func TestReplayMacroRequiresSession(t *testing.T) {
+	srv := newTestServer(t, handler.Options{Consent: fakeConsent{"DEV_CONSENT": true}})
+	srv.device(t, "DEV_REPLAY")
+	srv.device(t, "DEV_CONSENT")
+
+	c := srv.controller(t, "DEV_REPLAY")
+	granted, err := c.RequestControl(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"busy without session", "/api/devices/DEV_REPLAY/macros/m/replay", http.StatusConflict, "DEVICE_BUSY"},
+		{"busy with other session", "/api/devices/DEV_REPLAY/macros/m/replay?sessionId=other", http.StatusConflict, "DEVICE_BUSY"},
+		{"stale session", "/api/devices/DEV_CONSENT/macros/m/replay?sessionId=" + granted.SessionID, http.StatusConflict, "SESSION_NOT_FOUND"},
+		{"consent required", "/api/devices/DEV_CONSENT/macros/m/replay", http.StatusForbidden, "CONSENT_REQUIRED"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, code := srv.post(t, tc.path, "")
+			if status != tc.status || code != tc.code {
+				t.Fatalf("响应 = %d %s, 期望 %d %s", status, code, tc.status, tc.code)
+			}
+		})
+	}
+
+	// 携带会话ID时通过校验（测试环境没有宏存储）
+	status, code := srv.post(t, "/api/devices/DEV_REPLAY/macros/m/replay?sessionId="+granted.SessionID, "")
+	if status == http.StatusConflict || status == http.StatusForbidden {
+		t.Fatalf("会话内回放被拒绝: %d %s", status, code)
+	}
+}
+
+func TestSendCommandRequiresSession(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.device(t, "DEV_CMD")
+	const key = `{"type":"input.key","action":"down","keyCode":4}`
+
+	if status, code := srv.post(t, "/api/devices/DEV_CMD/commands", key); status != http.StatusOK {
+		t.Fatalf("空闲设备发送命令 = %d %s", status, code)
+	}
+
+	c := srv.controller(t, "DEV_CMD")
+	granted, err := c.RequestControl(testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, code := srv.post(t, "/api/devices/DEV_CMD/commands", key); status != http.StatusConflict || code != "DEVICE_BUSY" {
+		t.Fatalf("会话外发送命令 = %d %s, 期望 409 DEVICE_BUSY", status, code)
+	}
+	if status, code := srv.post(t, "/api/devices/DEV_CMD/commands?sessionId="+granted.SessionID, key); status != http.StatusOK {
+		t.Fatalf("会话内发送命令 = %d %s", status, code)
+	}
+
+	const tap = `{"x":100,"y":200}`
+	if status, code := srv.post(t, "/api/devices/DEV_CMD/gestures/tap", tap); status != http.StatusConflict || code != "DEVICE_BUSY" {
+		t.Fatalf("会话外发送手势 = %d %s, 期望 409 DEVICE_BUSY", status, code)
+	}
+	if status, code := srv.post(t, "/api/devices/DEV_CMD/gestures/tap?sessionId="+granted.SessionID, tap); status != http.StatusOK {
+		t.Fatalf("会话内发送手势 = %d %s", status, code)
+	}
+}
+
+func TestDeviceDisconnectWithoutGrace(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	d := srv.device(t, "DEV_DROP")
+	c := srv.controller(t, "DEV_DROP")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Close()
+	if _, err := c.WaitMessage(testTimeout, protocol.TypeDeviceOffline); err != nil {
+		t.Fatalf("控制端未收到 device.offline: %v", err)
+	}
+
+	_, err := c.RequestControl(testTimeout)
+	var serverErr *sim.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "DEVICE_OFFLINE" {
+		t.Fatalf("期望 DEVICE_OFFLINE, 实际 %v", err)
+	}
+}
+
+func TestDeviceReconnectWithinGrace(t *testing.T) {
+	srv := newTestServer(t, handler.Options{ReconnectGrace: 2 * time.Second})
+	d := srv.device(t, "DEV_RECONNECT")
+	c := srv.controller(t, "DEV_RECONNECT")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitFrames(1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Close()
+	msg, err := c.WaitMessage(testTimeout, protocol.TypeSessionState)
+	if err != nil {
+		t.Fatalf("未收到 session.state: %v", err)
+	}
+	var state protocol.SessionStateMessage
+	msg.Decode(&state)
+	if state.State != protocol.SessionStateReconnecting {
+		t.Fatalf("会话状态 = %q, 期望 reconnecting", state.State)
+	}
+
+	// 重连后设备按原参数恢复推流
+	d2, err := sim.ConnectDevice(sim.DeviceConfig{ServerURL: srv.url, DeviceID: "DEV_RECONNECT", Token: testDeviceToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+	msg, err = c.WaitMessage(testTimeout, protocol.TypeSessionState)
+	if err != nil {
+		t.Fatalf("未收到会话恢复: %v", err)
+	}
+	msg.Decode(&state)
+	if state.State != protocol.SessionStateResumed {
+		t.Fatalf("会话状态 = %q, 期望 resumed", state.State)
+	}
+	if _, err := d2.WaitMessage(testTimeout, protocol.TypeStreamStart); err != nil {
+		t.Fatalf("重连设备未收到 stream.start: %v", err)
+	}
+	frames := c.Frames()
+	if err := c.WaitFrames(frames+5, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceReconnectGraceExpires(t *testing.T) {
+	srv := newTestServer(t, handler.Options{ReconnectGrace: 300 * time.Millisecond})
+	d := srv.device(t, "DEV_GRACE")
+	c := srv.controller(t, "DEV_GRACE")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Close()
+	if _, err := c.WaitMessage(testTimeout, protocol.TypeSessionState); err != nil {
+		t.Fatalf("未收到 session.state: %v", err)
+	}
+
+	// 宽限期内未重连：会话关闭并广播设备离线
+	msg, err := c.WaitMessage(testTimeout, protocol.TypeSessionState)
+	if err != nil {
+		t.Fatalf("宽限期结束后未收到 session.state: %v", err)
+	}
+	var state protocol.SessionStateMessage
+	msg.Decode(&state)
+	if state.State != protocol.SessionStateClosed {
+		t.Fatalf("会话状态 = %q, 期望 closed", state.State)
+	}
+	if _, err := c.WaitMessage(testTimeout, protocol.TypeDeviceOffline); err != nil {
+		t.Fatalf("未收到 device.offline: %v", err)
+	}
+
+	// 设备重新上线后不再被原会话占用
+	srv.device(t, "DEV_GRACE")
+	c2 := srv.controller(t, "DEV_GRACE")
+	if _, err := c2.RequestControl(testTimeout); err != nil {
+		t.Fatalf("重连超时后设备仍被占用: %v", err)
+	}
+}
+
+func TestMsgpackDeviceWithJSONController(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	d := srv.device(t, "DEV_MSGPACK", func(cfg *sim.DeviceConfig) { cfg.Subprotocol = protocol.SubprotocolMsgpack })
+	c := srv.controller(t, "DEV_MSGPACK")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitFrames(5, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []sim.ScriptStep{{Message: map[string]interface{}{"type": protocol.TypeInputTouch, "action": "tap", "x": 100, "y": 200}}}
+	if err := c.RunScript(steps, testTimeout); err != nil {
+		t.Fatalf("执行脚本失败: %v", err)
+	}
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeInputTouch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var touch protocol.TouchMessage
+	msg.Decode(&touch)
+	if touch.X != 100 || touch.Y != 200 {
+		t.Fatalf("坐标 = (%v,%v)", touch.X, touch.Y)
+	}
+}
+
+func TestControllerMessageValidation(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	srv.device(t, "DEV_VALIDATE")
+	c := srv.controller(t, "DEV_VALIDATE")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		message string
+		code    string
+	}{
+		{"wrong field type", `{"type":"input.touch","action":"tap","x":"100","y":200}`, protocol.ErrCodeInvalidMessage},
+		{"unknown action", `{"type":"input.touch","action":"pinch","x":100,"y":200}`, protocol.ErrCodeInvalidAction},
+		{"outside screen", `{"type":"input.touch","action":"tap","x":100,"y":99999}`, protocol.ErrCodeInvalidCoord},
+		{"keycode", `{"type":"input.key","action":"down","keyCode":0}`, protocol.ErrCodeInvalidKeyCode},
+		{"text too long", `{"type":"input.text","text":"` + strings.Repeat("a", protocol.MaxTextLength+1) + `"}`, protocol.ErrCodeTextTooLong},
+		{"stream fps", `{"type":"stream.start","mode":"h264","fps":120}`, protocol.ErrCodeInvalidParam},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := c.Send(json.RawMessage(tc.message)); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := c.WaitMessage(testTimeout, protocol.TypeError)
+			if err != nil || msg.Code != tc.code {
+				t.Fatalf("期望 %s, 实际 %+v %v", tc.code, msg, err)
+			}
+		})
+	}
+}
+
+func TestStreamRegion(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+	d := srv.device(t, "DEV_REGION")
+	c := srv.controller(t, "DEV_REGION")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.WaitMessage(testTimeout, protocol.TypeStreamStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// 裁剪超出屏幕被拒绝
+	c.Send(protocol.StreamControlMessage{Type: protocol.TypeStreamStart, Mode: "h264", StreamRegion: protocol.StreamRegion{
+		Crop: &protocol.CropRect{X: 600, Y: 0, Width: 400, Height: 300},
+	}})
+	if msg, err := c.WaitMessage(testTimeout, protocol.TypeError); err != nil || msg.Code != "INVALID_PARAMETER" {
+		t.Fatalf("期望 INVALID_PARAMETER, 实际 %+v %v", msg, err)
+	}
+
+	region := protocol.StreamRegion{Crop: &protocol.CropRect{X: 100, Y: 200, Width: 400, Height: 300}, Rotation: 90, TargetWidth: 600}
+	c.Send(protocol.StreamControlMessage{Type: protocol.TypeStreamStart, Mode: "h264", Bitrate: 1000000, FPS: 30, StreamRegion: region})
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeStreamStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params protocol.StreamControlMessage
+	msg.Decode(&params)
+	if params.Crop == nil || *params.Crop != *region.Crop || params.Rotation != 90 || params.TargetWidth != 600 {
+		t.Fatalf("推流区域 = %+v", params.StreamRegion)
+	}
+
+	// 旋转后的画面为 300x400，坐标转换回设备全屏
+	steps := []sim.ScriptStep{{Message: map[string]interface{}{"type": protocol.TypeInputTouch, "action": "tap", "x": 50, "y": 100}}}
+	if err := c.RunScript(steps, testTimeout); err != nil {
+		t.Fatalf("执行脚本失败: %v", err)
+	}
+	msg, err = d.WaitMessage(testTimeout, protocol.TypeInputTouch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var touch protocol.TouchMessage
+	msg.Decode(&touch)
+	if touch.X != 200 || touch.Y != 450 {
+		t.Fatalf("坐标 = (%v,%v), 期望 (200,450)", touch.X, touch.Y)
+	}
+}
+
+// negotiate 创建 PeerConnection 并完成候选收集后返回本地 SDP（与服务端一样不使用 trickle ICE）
+func negotiate(t *testing.T, pc *webrtc.PeerConnection, remote *protocol.SessionDescription) string {
+	t.Helper()
+	var desc webrtc.SessionDescription
+	var err error
+	if remote == nil {
+		desc, err = pc.CreateOffer(nil)
+	} else {
+		if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: remote.SDP}); err != nil {
+			t.Fatal(err)
+		}
+		desc, err = pc.CreateAnswer(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(desc); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc.LocalDescription().SDP
+}
+
+func TestSFURelaysDeviceTrack(t *testing.T) {
+	srv := newTestServer(t, handler.Options{SFU: true})
+	d := srv.device(t, "DEV_SFU", func(cfg *sim.DeviceConfig) {
+		cfg.Capabilities = append([]string{protocol.CapWebRTC}, sim.DefaultCapabilities...)
+	})
+	c := srv.controller(t, "DEV_SFU")
+	if _, err := c.RequestControl(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端通知设备向其发布
+	msg, err := d.WaitMessage(testTimeout, protocol.TypeWebRTCReady)
+	if err != nil {
+		t.Fatalf("设备未收到 webrtc.ready: %v", err)
+	}
+	var ready protocol.WebRTCMessage
+	msg.Decode(&ready)
+	if ready.FromID != service.SFUPeerID {
+		t.Fatalf("fromId = %q", ready.FromID)
+	}
+
+	publisher, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	d.Send(protocol.WebRTCMessage{
+		Type:     protocol.TypeWebRTCOffer,
+		TargetID: ready.FromID,
+		SDP:      &protocol.SessionDescription{Type: "offer", SDP: negotiate(t, publisher, nil)},
+	})
+	msg, err = d.WaitMessage(testTimeout, protocol.TypeWebRTCAnswer)
+	if err != nil {
+		t.Fatalf("设备未收到 answer: %v", err)
+	}
+	var answer protocol.WebRTCMessage
+	msg.Decode(&answer)
+	if err := publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP.SDP}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(33 * time.Millisecond)
+		defer ticker.Stop()
+		nal := []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				track.WriteSample(media.Sample{Data: nal, Duration: 33 * time.Millisecond})
+			}
+		}
+	}()
+
+	// 设备轨道到达后服务端向控制端发起 offer
+	msg, err = c.WaitMessage(testTimeout, protocol.TypeWebRTCOffer)
+	if err != nil {
+		t.Fatalf("控制端未收到 offer: %v", err)
+	}
+	var offer protocol.WebRTCMessage
+	msg.Decode(&offer)
+	answerSDP, packets := subscribeSFU(t, offer.SDP)
+	c.Send(protocol.WebRTCMessage{
+		Type:     protocol.TypeWebRTCAnswer,
+		TargetID: "DEV_SFU",
+		SDP:      &protocol.SessionDescription{Type: "answer", SDP: answerSDP},
+	})
+	waitFor(t, func() bool { return packets.Load() >= 10 })
+
+	// 只读观看者通过观看 Token 订阅同一个发布端
+	_, issued := srv.request(t, http.MethodPost, "/api/devices/DEV_SFU/stream-token", testAPIToken, "")
+	viewer, _, err := websocket.DefaultDialer.Dial(srv.url+"/api/devices/DEV_SFU/webrtc?token="+issued["token"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+	viewer.SetReadDeadline(time.Now().Add(testTimeout))
+	var viewerOffer protocol.WebRTCMessage
+	if err := viewer.ReadJSON(&viewerOffer); err != nil || viewerOffer.Type != protocol.TypeWebRTCOffer {
+		t.Fatalf("观看者未收到 offer: %v %s", err, viewerOffer.Type)
+	}
+	answerSDP, viewerPackets := subscribeSFU(t, viewerOffer.SDP)
+	viewer.WriteJSON(protocol.WebRTCMessage{
+		Type: protocol.TypeWebRTCAnswer,
+		SDP:  &protocol.SessionDescription{Type: "answer", SDP: answerSDP},
+	})
+	waitFor(t, func() bool { return viewerPackets.Load() >= 10 })
+	if _, err := d.WaitMessage(200*time.Millisecond, protocol.TypeWebRTCReady); err == nil {
+		t.Fatal("设备已发布时观看者订阅不应再次通知设备")
+	}
+}
+
+// subscribeSFU 按服务端的 offer 创建订阅连接，返回 answer 和收到的 RTP 包数
+func subscribeSFU(t *testing.T, offer *protocol.SessionDescription) (string, *atomic.Int64) {
+	t.Helper()
+	subscriber, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { subscriber.Close() })
+	packets := &atomic.Int64{}
+	subscriber.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := remote.ReadRTP(); err != nil {
+				return
+			}
+			packets.Add(1)
+		}
+	})
+	return negotiate(t, subscriber, offer), packets
+}
+
+func TestStreamTokenScope(t *testing.T) {
+	srv := newTestServer(t, handler.Options{})
+
+	status, issued := srv.request(t, http.MethodPost, "/api/devices/DEV_A/stream-token", testAPIToken, "")
+	if status != http.StatusOK || issued["token"] == "" {
+		t.Fatalf("签发观看 Token = %d %v", status, issued)
+	}
+	viewToken := issued["token"]
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		bearer string
+		status int
+		code   string
+	}{
+		// 设备尚未上报缩略图，通过鉴权时返回 404
+		{"admin token in header", http.MethodGet, "/api/devices/DEV_A/thumbnail", testAPIToken, http.StatusNotFound, "THUMBNAIL_NOT_FOUND"},
+		{"view token for device", http.MethodGet, "/api/devices/DEV_A/thumbnail?token=" + viewToken, "", http.StatusNotFound, "THUMBNAIL_NOT_FOUND"},
+		{"admin token in query", http.MethodGet, "/api/devices/DEV_A/thumbnail?token=" + testAPIToken, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"view token for other device", http.MethodGet, "/api/devices/DEV_B/thumbnail?token=" + viewToken, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"view token as bearer", http.MethodGet, "/api/devices/DEV_A/thumbnail", viewToken, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"view token on admin API", http.MethodPost, "/api/devices/DEV_A/stream-token?token=" + viewToken, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, result := srv.request(t, tc.method, tc.path, tc.bearer, "")
+			if status != tc.status || result["error"] != tc.code {
+				t.Fatalf("响应 = %d %s, 期望 %d %s", status, result["error"], tc.status, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"log"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+)
+
+// resolveStreamProfiles 查询设备的推流配置，未分配或查询失败时返回 nil（使用默认参数）
+func (h *WebSocketHandler) resolveStreamProfiles(deviceID string) (*protocol.StreamProfile, []protocol.StreamProfile) {
+	if h.opts.StreamProfiles == nil {
+		return nil, nil
+	}
+	profile, profiles, err := h.opts.StreamProfiles.DeviceStreamProfiles(deviceID)
+	if err != nil {
+		log.Printf("查询推流配置失败，使用默认参数: %s %v", deviceID, err)
+		return nil, nil
+	}
+	return profile, profiles
+}
+
+// startStream 让设备按推流配置开始推流
+// 未分配配置时默认使用 H264 2Mbps/30fps，设备不支持时回退 MJPEG 质量 80/30fps
+func (h *WebSocketHandler) startStream(session *model.Session, profile *protocol.StreamProfile) {
+	device := h.sessionMgr.Device(session.ID)
+
+	var params protocol.StreamControlMessage
+	if profile != nil {
+		params = service.StreamProfileParams(*profile, device)
+	} else {
+		params = protocol.StreamControlMessage{
+			Type:    protocol.TypeStreamStart,
+			Mode:    "h264",
+			Bitrate: 2000000, // 2Mbps
+			FPS:     30,
+		}
+		if !device.HasCapability(protocol.CapH264) && device.HasCapability(protocol.CapMJPEG) {
+			params = protocol.StreamControlMessage{
+				Type:    protocol.TypeStreamStart,
+				Mode:    "mjpeg",
+				Quality: 80,
+				MaxFPS:  30,
+			}
+		}
+	}
+
+	if h.opts.AdaptiveBitrate {
+		if profile != nil {
+			params = h.abr.StartAt(session.ID, params)
+		} else {
+			params = h.abr.Start(session.ID, params.Mode)
+		}
+	}
+	params = h.withRegion(session, params)
+	h.sessionMgr.UpdateStreamParams(session.ID, params)
+	h.frames.Invalidate(device.ID)
+	device.SendJSON(params)
+}
+
+// withRegion 推流参数带上会话的推流区域
+func (h *WebSocketHandler) withRegion(session *model.Session, params protocol.StreamControlMessage) protocol.StreamControlMessage {
+	if region := h.sessionMgr.StreamRegion(session.ID); region != nil {
+		params.StreamRegion = *region
+	}
+	return params
+}
+
+// handleStreamProfile 控制端切换到允许使用的推流配置
+func (h *WebSocketHandler) handleStreamProfile(controller *model.Controller, msg protocol.StreamProfileMessage) {
+	session, device := h.sessionDevice(controller)
+	if device == nil || session.Pending {
+		return
+	}
+
+	profile, ok := h.sessionMgr.AllowedStreamProfile(session.ID, msg.Profile)
+	if !ok {
+		controller.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.TypeError,
+			Code:    "PROFILE_NOT_ALLOWED",
+			Message: "无权使用该推流配置: " + msg.Profile,
+		})
+		return
+	}
+
+	h.sessionMgr.SetStreamProfile(session.ID, profile, nil)
+	h.startStream(session, profile)
+	controller.SendJSON(protocol.StreamProfileMessage{Type: protocol.TypeStreamProfile, Profile: profile.Name})
+	log.Printf("切换推流配置: %s -> %s", session.ID, profile.Name)
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+)
+
+// subscribeSFU 控制端或只读观看者订阅设备的 SFU 转发，设备尚未发布时通知设备向服务端发起 WebRTC
+func (h *WebSocketHandler) subscribeSFU(subscriberID string, send service.SignalFunc, device *model.Device) {
+	if !h.sfu.Subscribe(device.ID, subscriberID, send) {
+		return
+	}
+	device.SendJSON(protocol.WebRTCMessage{
+		Type:       protocol.TypeWebRTCReady,
+		FromID:     service.SFUPeerID,
+		ICEServers: h.opts.ICE.Servers(device.ID),
+	})
+}
+
+// handleSFUSignalingFromDevice 处理设备发给服务端的 WebRTC 信令（SFU 模式）
+func (h *WebSocketHandler) handleSFUSignalingFromDevice(device *model.Device, message []byte) {
+	var msg protocol.WebRTCMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case protocol.TypeWebRTCOffer:
+		if msg.SDP == nil {
+			return
+		}
+		err = h.sfu.Publish(device.ID, *msg.SDP, device.SendJSON)
+	case protocol.TypeWebRTCIce:
+		if msg.Candidate == nil {
+			return
+		}
+		err = h.sfu.AddPublisherCandidate(device.ID, *msg.Candidate)
+	}
+	if err != nil {
+		log.Printf("SFU 处理设备信令失败: %s %s %v", device.ID, msg.Type, err)
+	}
+}
+
+// handleSFUSignalingFromController 处理控制端发给服务端的 WebRTC 信令（SFU 模式）
+func (h *WebSocketHandler) handleSFUSignalingFromController(controller *model.Controller, message []byte) {
+	session, device := h.sessionDevice(controller)
+	if device == nil || session.Pending {
+		log.Printf("WebRTC signaling: no session for controller %s", controller.ID)
+		return
+	}
+
+	var msg protocol.WebRTCMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case protocol.TypeWebRTCReady:
+		h.subscribeSFU(controller.ID, controller.SendJSON, device)
+	case protocol.TypeWebRTCAnswer:
+		if msg.SDP == nil {
+			return
+		}
+		err = h.sfu.Answer(controller.ID, *msg.SDP)
+	case protocol.TypeWebRTCIce:
+		if msg.Candidate == nil {
+			return
+		}
+		err = h.sfu.AddSubscriberCandidate(controller.ID, *msg.Candidate)
+	}
+	if err != nil {
+		log.Printf("SFU 处理控制端信令失败: %s %s %v", controller.ID, msg.Type, err)
+	}
+}
+
+// HandleWebRTCViewer 只读观看者通过 SFU 订阅设备的 WebRTC 画面，支持多人同时观看
+// 连接建立后服务端发送 webrtc.offer，观看者回复 webrtc.answer / webrtc.ice，需要重新协商时发送 webrtc.ready；
+// 观看者不能发送输入，也不占用设备的控制会话
+func (h *WebSocketHandler) HandleWebRTCViewer(c *gin.Context) {
+	if h.sfu == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "SFU_DISABLED", "message": "未开启 WebRTC SFU 模式"})
+		return
+	}
+	device := h.deviceMgr.GetOnline(c.Param("id"))
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "DEVICE_OFFLINE", "message": "设备不在线"})
+		return
+	}
+	if !device.HasCapability(protocol.CapWebRTC) {
+		c.JSON(http.StatusConflict, gin.H{"error": "UNSUPPORTED_CAPABILITY", "message": "设备不支持 WebRTC"})
+		return
+	}
+
+	upgrader := *h.controllerUpgrader
+	upgrader.Subprotocols = nil // 只收发 JSON
+	conn, _, err := upgrade(&upgrader, h.controllerWire, c.Writer, c.Request)
+	if err != nil {
+		log.Printf("WebRTC 观看者WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	viewerID := "viewer-" + uuid.New().String()
+	var writeMutex sync.Mutex
+	send := func(v interface{}) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(v)
+	}
+
+	log.Printf("WebRTC 观看者加入: %s -> %s", viewerID, device.ID)
+	h.subscribeSFU(viewerID, send, device)
+	defer func() {
+		h.sfu.Unsubscribe(viewerID)
+		log.Printf("WebRTC 观看者离开: %s -> %s", viewerID, device.ID)
+	}()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				writeMutex.Lock()
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				err := conn.WriteMessage(websocket.PingMessage, nil)
+				writeMutex.Unlock()
+				if err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg protocol.WebRTCMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case protocol.TypeWebRTCReady:
+			current := h.deviceMgr.GetOnline(device.ID)
+			if current == nil {
+				send(protocol.ErrorMessage{Type: protocol.TypeError, Code: "DEVICE_OFFLINE", Message: "设备不在线"})
+				return
+			}
+			h.subscribeSFU(viewerID, send, current)
+		case protocol.TypeWebRTCAnswer:
+			if msg.SDP != nil {
+				err = h.sfu.Answer(viewerID, *msg.SDP)
+			}
+		case protocol.TypeWebRTCIce:
+			if msg.Candidate != nil {
+				err = h.sfu.AddSubscriberCandidate(viewerID, *msg.Candidate)
+			}
+		}
+		if err != nil {
+			log.Printf("SFU 处理观看者信令失败: %s %s %v", viewerID, msg.Type, err)
+			err = nil
+		}
+	}
+}
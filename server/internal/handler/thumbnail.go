@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// pollThumbnails 定期向在线设备请求缩略图（每轮受并发上限限制）
+func (h *WebSocketHandler) pollThumbnails() {
+	ticker := time.NewTicker(thumbnailPollPeriod)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-h.done:
+			return
+		case now = <-ticker.C:
+		}
+		var deviceIDs []string
+		for _, device := range h.deviceMgr.ListOnline() {
+			if device.HasCapability(protocol.CapThumbnail) {
+				deviceIDs = append(deviceIDs, device.ID)
+			}
+		}
+		for _, deviceID := range h.thumbnails.Due(deviceIDs, now) {
+			if device := h.deviceMgr.GetOnline(deviceID); device != nil {
+				device.SendJSON(protocol.ThumbnailRequestMessage{
+					Type:     protocol.TypeScreenThumbnail,
+					MaxWidth: thumbnailMaxWidth,
+					Quality:  thumbnailQuality,
+				})
+			}
+		}
+	}
+}
+
+// GetThumbnail 获取设备的最新缩略图（供API使用）
+func (h *WebSocketHandler) GetThumbnail(deviceID string) ([]byte, time.Time, bool) {
+	return h.thumbnails.Get(deviceID)
+}
+
+// HandleThumbnailFeed 缩略图墙 WebSocket 推送
+// 连接后先推送所有已缓存的缩略图，之后推送 thumbnail.update / thumbnail.remove（JSON，jpeg 为 base64）
+func (h *WebSocketHandler) HandleThumbnailFeed(c *gin.Context) {
+	upgrader := *h.controllerUpgrader
+	upgrader.Subprotocols = nil // 只推送 JSON
+	conn, _, err := upgrade(&upgrader, h.controllerWire, c.Writer, c.Request)
+	if err != nil {
+		log.Printf("缩略图推送WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	feed, snapshot := h.thumbnails.Subscribe()
+	defer h.thumbnails.Unsubscribe(feed)
+
+	// 读协程只用于处理 pong 和检测连接关闭
+	closed := make(chan struct{})
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(msg protocol.ThumbnailMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(msg) == nil
+	}
+	for _, msg := range snapshot {
+		if !write(msg) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-feed.Messages():
+			if !write(msg) {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
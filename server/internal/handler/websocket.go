@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +25,22 @@ const (
 	pongWait       = 60 * time.Second    // Pong等待时间
 	pingPeriod     = (pongWait * 9) / 10 // Ping间隔（54秒）
 	maxMessageSize = 1024 * 1024         // 最大消息大小 1MB
+
+	defaultCommandTimeout = 10 * time.Second       // 未配置时等待设备回执的超时时间
+	defaultConsentTimeout = 30 * time.Second       // 未配置时等待现场用户同意的超时时间
+	keyframeMaxAge        = 3 * time.Second        // 缓存关键帧超过该时长视为过期（设备 GOP 为 2 秒）
+	frameQueueSize        = 30                     // 控制端发送队列上限（约 1 秒的帧）
+	defaultMJPEGQuality   = 70                     // 未配置时 HTTP MJPEG 观看的 JPEG 质量
+	defaultMJPEGFPS       = 10                     // 未配置时 HTTP MJPEG 观看的帧率
+	hlsIdleTimeout        = 30 * time.Second       // 超过该时长没有请求播放列表视为 HLS 观看者离开
+	hlsTickPeriod         = 500 * time.Millisecond // HLS 分片推进和观看者回收的检查间隔
+	hlsBitrate            = 1000000                // HLS 观看时设备推流的码率
+	hlsFPS                = 25                     // HLS 观看时设备推流的帧率
+	thumbnailPollPeriod   = 1 * time.Second        // 缩略图轮询检查间隔
+	thumbnailMaxWidth     = 320                    // 缩略图最大宽度
+	thumbnailQuality      = 50                     // 缩略图 JPEG 质量
+	thumbnailMaxInFlight  = 8                      // 同时等待回复的缩略图请求上限
+	defaultBandwidthFlush = 1 * time.Minute        // 未配置时流量日汇总写入数据库并检查配额的间隔
 )
 
 const (
@@ -29,37 +49,173 @@ const (
 	closeCodeDeviceOffline = 4003
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 开发阶段允许所有来源
-	},
-	ReadBufferSize:  1024 * 64,  // 64KB 读缓冲区
-	WriteBufferSize: 1024 * 256, // 256KB 写缓冲区（视频帧较大）
+// TokenValidator 校验控制端 Token，返回设备信息（名称、在线状态、是否需要现场同意）
+type TokenValidator interface {
+	ValidateControlToken(deviceID, token string) (*model.Device, error)
+}
+
+// StreamProfileResolver 查询设备的推流配置（设备 > 分组 > 全局）和控制端可切换的配置，未分配时返回 nil
+type StreamProfileResolver interface {
+	DeviceStreamProfiles(deviceID string) (*protocol.StreamProfile, []protocol.StreamProfile, error)
+}
+
+// BandwidthStore 持久化设备的日流量，查询设备的月度配额（含降级配置）和自 since 起已持久化的设备链路流量
+type BandwidthStore interface {
+	AddBandwidthUsage(records []model.BandwidthDaily) error
+	DeviceBandwidthQuota(deviceID string, since time.Time) (*model.BandwidthQuota, int64, error)
+}
+
+// ConsentPolicy 查询设备是否要求现场用户同意后才能控制
+type ConsentPolicy interface {
+	DeviceConsentRequired(deviceID string) (bool, error)
+}
+
+// Options WebSocket处理器可选配置
+type Options struct {
+	ReconnectGrace time.Duration // 设备断线后保留会话等待重连的时间，0 表示立即关闭会话
+	ConsentTimeout time.Duration // 等待现场用户同意的超时时间，0 使用默认值
+	CommandTimeout time.Duration // 等待设备 command.result 回执的超时时间
+
+	DeviceCompression     bool // 设备端点接受 permessage-deflate 协商
+	ControllerCompression bool // 控制端点接受 permessage-deflate 协商
+
+	AdaptiveBitrate bool // 按控制端链路状况自动调整推流码率和帧率
+
+	SFU bool               // WebRTC 由服务端转发（设备发布到服务端，服务端转发给控制端），否则点对点
+	ICE *service.ICEConfig // 下发给 WebRTC 对端的 STUN/TURN 配置，为空时不下发
+
+	MJPEGQuality int // HTTP MJPEG 观看时设备推流的 JPEG 质量
+	MJPEGFPS     int // HTTP MJPEG 观看时设备推流的最大帧率
+
+	StreamTokens *service.StreamTokens // 签发 MJPEG / HLS / 缩略图的只读观看 Token，为空时不签发
+
+	ThumbnailInterval time.Duration // 每台在线设备的缩略图刷新间隔，0 表示不轮询
+
+	RecordDir       string        // 会话录像目录，为空表示不录像
+	RecordRetention time.Duration // 录像保留时长，0 表示永久保留
+
+	TokenValidator TokenValidator        // 控制端 Token 校验，为空时使用 DeviceStore（测试可替换）
+	StreamProfiles StreamProfileResolver // 推流配置查询，为空时使用 DeviceStore（测试可替换）
+	Bandwidth      BandwidthStore        // 流量日汇总和月度配额，为空时使用 DeviceStore（测试可替换）
+	Consent        ConsentPolicy         // REST 接口注入输入时查询设备是否要求现场用户同意，为空时使用 DeviceStore（测试可替换）
+
+	BandwidthFlushPeriod time.Duration // 流量日汇总写入数据库并检查配额的间隔，0 使用默认的 1 分钟（测试可缩短）
 }
 
 // WebSocketHandler WebSocket处理器
 type WebSocketHandler struct {
-	deviceMgr     *service.DeviceManager
-	controllerMgr *service.ControllerManager
-	sessionMgr    *service.SessionManager
-	deviceToken   string // 被控端固定token
-	store         *store.DeviceStore
+	deviceMgr          *service.DeviceManager
+	controllerMgr      *service.ControllerManager
+	sessionMgr         *service.SessionManager
+	consentMgr         *service.ConsentManager
+	audit              *service.AuditLogger
+	recorder           *service.RecordingManager
+	macros             *service.MacroRecorder
+	player             *service.MacroPlayer
+	chat               *service.ChatManager
+	commands           *service.CommandTracker
+	registry           *service.CommandRegistry
+	frames             *service.FrameCache
+	abr                *service.ABRController
+	mjpeg              *service.MJPEGHub
+	hls                *service.HLSManager
+	thumbnails         *service.ThumbnailManager
+	bandwidth          *service.BandwidthMeter
+	sfu                *service.SFU // 未开启 SFU 模式时为空
+	deviceWire         *service.WireStats
+	controllerWire     *service.WireStats
+	deviceUpgrader     *websocket.Upgrader
+	controllerUpgrader *websocket.Upgrader
+	deviceToken        string // 被控端固定token
+	store              *store.DeviceStore
+	opts               Options
+	done               chan struct{} // Close 后关闭，后台协程随之退出
+	closeOnce          sync.Once
 }
 
 // NewWebSocketHandler 创建WebSocket处理器
-func NewWebSocketHandler(deviceToken string, deviceStore *store.DeviceStore) *WebSocketHandler {
-	return &WebSocketHandler{
-		deviceMgr:     service.NewDeviceManager(deviceStore),
-		controllerMgr: service.NewControllerManager(),
-		sessionMgr:    service.NewSessionManager(),
-		deviceToken:   deviceToken,
-		store:         deviceStore,
+func NewWebSocketHandler(deviceToken string, deviceStore *store.DeviceStore, opts Options) *WebSocketHandler {
+	if opts.CommandTimeout <= 0 {
+		opts.CommandTimeout = defaultCommandTimeout
+	}
+	if opts.ConsentTimeout <= 0 {
+		opts.ConsentTimeout = defaultConsentTimeout
+	}
+	if opts.BandwidthFlushPeriod <= 0 {
+		opts.BandwidthFlushPeriod = defaultBandwidthFlush
+	}
+	if opts.MJPEGQuality <= 0 {
+		opts.MJPEGQuality = defaultMJPEGQuality
+	}
+	if opts.MJPEGFPS <= 0 {
+		opts.MJPEGFPS = defaultMJPEGFPS
+	}
+	if opts.TokenValidator == nil && deviceStore != nil {
+		opts.TokenValidator = deviceStore
+	}
+	if opts.StreamProfiles == nil && deviceStore != nil {
+		opts.StreamProfiles = deviceStore
+	}
+	if opts.Bandwidth == nil && deviceStore != nil {
+		opts.Bandwidth = deviceStore
+	}
+	if opts.Consent == nil && deviceStore != nil {
+		opts.Consent = deviceStore
+	}
+	registry := service.NewCommandRegistry()
+	h := &WebSocketHandler{
+		deviceMgr:          service.NewDeviceManager(deviceStore),
+		controllerMgr:      service.NewControllerManager(),
+		sessionMgr:         service.NewSessionManager(),
+		consentMgr:         service.NewConsentManager(),
+		audit:              service.NewAuditLogger(deviceStore),
+		recorder:           service.NewRecordingManager(opts.RecordDir, opts.RecordRetention),
+		macros:             service.NewMacroRecorder(),
+		player:             service.NewMacroPlayer(registry),
+		chat:               service.NewChatManager(),
+		commands:           service.NewCommandTracker(),
+		registry:           registry,
+		frames:             service.NewFrameCache(keyframeMaxAge),
+		abr:                service.NewABRController(),
+		mjpeg:              service.NewMJPEGHub(),
+		hls:                service.NewHLSManager(hlsIdleTimeout),
+		thumbnails:         service.NewThumbnailManager(opts.ThumbnailInterval, thumbnailMaxInFlight),
+		bandwidth:          service.NewBandwidthMeter(),
+		deviceWire:         &service.WireStats{},
+		controllerWire:     &service.WireStats{},
+		deviceUpgrader:     newUpgrader(opts.DeviceCompression),
+		controllerUpgrader: newUpgrader(opts.ControllerCompression),
+		deviceToken:        deviceToken,
+		store:              deviceStore,
+		opts:               opts,
+		done:               make(chan struct{}),
 	}
+	if opts.SFU {
+		sfu, err := service.NewSFU(opts.ICE, h.bandwidth)
+		if err != nil {
+			log.Printf("初始化 WebRTC SFU 失败，使用点对点模式: %v", err)
+		} else {
+			h.sfu = sfu
+		}
+	}
+	go h.runHLS()
+	if opts.ThumbnailInterval > 0 {
+		go h.pollThumbnails()
+	}
+	if opts.Bandwidth != nil {
+		go h.flushBandwidth()
+	}
+	return h
+}
+
+// Close 停止后台协程（HLS 分片推进、缩略图轮询、流量写入），已建立的连接不受影响
+func (h *WebSocketHandler) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
 }
 
 // HandleDevice 处理设备连接
 func (h *WebSocketHandler) HandleDevice(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, meter, err := upgrade(h.deviceUpgrader, h.deviceWire, c.Writer, c.Request)
 	if err != nil {
 		log.Printf("设备WebSocket升级失败: %v", err)
 		return
@@ -73,10 +229,11 @@ func (h *WebSocketHandler) HandleDevice(c *gin.Context) {
 		return nil
 	})
 
-	log.Println("新设备连接")
+	encoding := protocol.EncodingForSubprotocol(conn.Subprotocol())
+	log.Printf("新设备连接 (编码: %s)", encoding)
 
 	// 等待设备注册消息
-	_, message, err := conn.ReadMessage()
+	_, message, err := readMessage(conn, meter, encoding)
 	if err != nil {
 		log.Printf("读取设备注册消息失败: %v", err)
 		conn.Close()
@@ -93,7 +250,8 @@ func (h *WebSocketHandler) HandleDevice(c *gin.Context) {
 	// 验证token
 	if registerMsg.Token != h.deviceToken {
 		log.Printf("设备认证失败: %s", registerMsg.DeviceID)
-		conn.WriteJSON(protocol.ErrorMessage{
+		unregistered := &model.Device{Conn: conn, Encoding: encoding}
+		unregistered.SendJSON(protocol.ErrorMessage{
 			Type:    protocol.TypeError,
 			Code:    "AUTH_FAILED",
 			Message: "认证失败",
@@ -102,52 +260,77 @@ func (h *WebSocketHandler) HandleDevice(c *gin.Context) {
 		return
 	}
 
+	capabilities := registerMsg.Capabilities
+	if registerMsg.ProtocolVersion == 0 && len(capabilities) == 0 {
+		capabilities = protocol.LegacyCapabilities
+	}
+	commands := registerMsg.Commands
+	if len(commands) == 0 && slices.Contains(capabilities, protocol.CapCommand) {
+		commands = protocol.LegacyCommands
+	}
+	if registerMsg.ProtocolVersion > protocol.ProtocolVersion {
+		log.Printf("设备协议版本较新: %s v%d (服务端 v%d)", registerMsg.DeviceID, registerMsg.ProtocolVersion, protocol.ProtocolVersion)
+	}
+
 	device := &model.Device{
-		ID:           registerMsg.DeviceID,
-		Name:         registerMsg.DeviceName,
-		ScreenWidth:  registerMsg.ScreenWidth,
-		ScreenHeight: registerMsg.ScreenHeight,
-		Token:        registerMsg.Token,
-		Conn:         conn,
+		ID:              registerMsg.DeviceID,
+		Name:            registerMsg.DeviceName,
+		ScreenWidth:     registerMsg.ScreenWidth,
+		ScreenHeight:    registerMsg.ScreenHeight,
+		Token:           registerMsg.Token,
+		Conn:            conn,
+		Meter:           meter,
+		Encoding:        encoding,
+		ProtocolVersion: registerMsg.ProtocolVersion,
+		Capabilities:    capabilities,
+		Commands:        commands,
 	}
 
+	meter.account = func(in, out int64) {
+		h.bandwidth.RecordDevice(device.ID, in, out)
+	}
 	h.deviceMgr.Register(device)
 	h.controllerMgr.BroadcastDeviceOnline(device.ID, device.Name)
 
-	log.Printf("设备注册成功: %s (%s)", device.Name, device.ID)
+	log.Printf("设备注册成功: %s (%s) v%d %v", device.Name, device.ID, device.ProtocolVersion, device.Capabilities)
 
 	// 发送注册成功响应
-	conn.WriteJSON(map[string]interface{}{
+	device.SendJSON(map[string]interface{}{
 		"type":    "device.registered",
 		"success": true,
 	})
 
+	// 宽限期内重连，恢复原会话
+	if session := h.sessionMgr.Resume(device); session != nil {
+		h.resumeSession(session)
+	} else {
+		h.startViewerStream(device)
+	}
+
 	// 处理设备消息
-	h.handleDeviceMessages(device)
+	h.handleDeviceMessages(device, meter)
 }
 
 // handleDeviceMessages 处理设备消息循环
-func (h *WebSocketHandler) handleDeviceMessages(device *model.Device) {
+func (h *WebSocketHandler) handleDeviceMessages(device *model.Device, meter *wireMeter) {
+	conn := device.Conn
 	defer func() {
-		device.Conn.Close()
-		h.deviceMgr.Unregister(device.ID)
-		h.sessionMgr.CloseByDevice(device.ID)
-		h.controllerMgr.BroadcastDeviceOffline(device.ID)
-		log.Printf("设备断开: %s", device.ID)
+		conn.Close()
+		h.handleDeviceDisconnect(device)
 	}()
 
 	// 启动 ping 协程
-	go h.pingLoop(device.Conn)
+	go h.pingLoop(conn)
 
 	for {
-		messageType, message, err := device.Conn.ReadMessage()
+		messageType, message, err := readMessage(conn, meter, device.Encoding)
 		if err != nil {
 			log.Printf("读取设备消息失败: %v", err)
 			return
 		}
 
 		// 收到任何消息都重置读取超时
-		device.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 
 		// 二进制消息 - 屏幕帧
 		if messageType == websocket.BinaryMessage {
@@ -171,11 +354,138 @@ func (h *WebSocketHandler) handleDeviceMessages(device *model.Device) {
 			json.Unmarshal(message, &clipMsg)
 			h.handleClipboardFromDevice(device, clipMsg)
 
-		// WebRTC 信令转发（设备 -> 控制端）
+		case protocol.TypeControlConsent:
+			var consentMsg protocol.ControlConsentMessage
+			json.Unmarshal(message, &consentMsg)
+			h.handleConsentReply(device, consentMsg)
+
+		case protocol.TypeCommandResult:
+			var resultMsg protocol.CommandResultMessage
+			if err := json.Unmarshal(message, &resultMsg); err == nil && !h.commands.Resolve(device.ID, resultMsg) {
+				log.Printf("忽略未知请求的回执: %s %s", device.ID, resultMsg.RequestID)
+			}
+
+		case protocol.TypeChatMessage:
+			var chatMsg protocol.ChatMessage
+			json.Unmarshal(message, &chatMsg)
+			h.handleChatFromDevice(device, chatMsg)
+
+		// WebRTC 信令转发（设备 -> 控制端），SFU 模式下由服务端处理
 		case protocol.TypeWebRTCOffer, protocol.TypeWebRTCAnswer, protocol.TypeWebRTCIce, protocol.TypeWebRTCReady:
-			h.forwardWebRTCSignalingToController(device, message)
+			if h.sfu != nil {
+				h.handleSFUSignalingFromDevice(device, message)
+			} else {
+				h.forwardWebRTCSignalingToController(device, message)
+			}
+		}
+	}
+}
+
+// handleDeviceDisconnect 处理设备断开
+// 配置了重连宽限期且设备有活跃会话时，保留会话等待设备重连，超时后再关闭
+func (h *WebSocketHandler) handleDeviceDisconnect(device *model.Device) {
+	if !h.deviceMgr.Unregister(device) {
+		// 设备已通过新连接重新注册，旧连接的断开无需处理
+		log.Printf("设备旧连接断开: %s", device.ID)
+		return
+	}
+	h.commands.FailDevice(device.ID, "设备已断开")
+	h.frames.Clear(device.ID)
+	h.mjpeg.CloseDevice(device.ID)
+	h.hls.CloseDevice(device.ID)
+	h.thumbnails.Remove(device.ID)
+	if h.sfu != nil {
+		h.sfu.CloseDevice(device.ID)
+	}
+
+	if h.opts.ReconnectGrace > 0 {
+		if session := h.sessionMgr.Suspend(device.ID, h.opts.ReconnectGrace); session != nil {
+			log.Printf("设备断开，等待重连: %s (%s)", device.ID, h.opts.ReconnectGrace)
+			h.audit.Record(session, service.AuditSessionReconnecting, "")
+			if session.Controller != nil {
+				session.Controller.SendJSON(protocol.SessionStateMessage{
+					Type:      protocol.TypeSessionState,
+					SessionID: session.ID,
+					State:     protocol.SessionStateReconnecting,
+					GraceMs:   h.opts.ReconnectGrace.Milliseconds(),
+				})
+			}
+			time.AfterFunc(h.opts.ReconnectGrace, func() {
+				h.expireReconnect(device.ID, session.ID)
+			})
+			return
+		}
+	}
+
+	if session := h.sessionMgr.CloseByDevice(device.ID); session != nil {
+		if h.consentMgr.Resolve(session.ID) {
+			// 等待同意期间设备离线
+			h.audit.Record(session, service.AuditConsentCancelled, "device offline")
+			if session.Controller != nil {
+				session.Controller.SendJSON(protocol.ControlDeniedMessage{
+					Type:     protocol.TypeControlDenied,
+					DeviceID: device.ID,
+					Code:     "DEVICE_OFFLINE",
+					Message:  "设备不在线",
+				})
+			}
+		} else {
+			h.endSession(session, "device offline")
 		}
 	}
+	h.controllerMgr.BroadcastDeviceOffline(device.ID)
+	log.Printf("设备断开: %s", device.ID)
+}
+
+// expireReconnect 重连宽限期结束，设备仍未重连则关闭会话并广播离线
+func (h *WebSocketHandler) expireReconnect(deviceID, sessionID string) {
+	if h.deviceMgr.GetOnline(deviceID) != nil {
+		// 已重连
+		return
+	}
+
+	session := h.sessionMgr.ExpireReconnect(sessionID)
+	if session == nil && h.sessionMgr.IsReconnecting(deviceID) {
+		// 重连后再次断开，由新的宽限期结束时处理
+		return
+	}
+	if session != nil {
+		h.endSession(session, "reconnect timeout")
+		if session.Controller != nil {
+			session.Controller.SendJSON(protocol.SessionStateMessage{
+				Type:      protocol.TypeSessionState,
+				SessionID: session.ID,
+				State:     protocol.SessionStateClosed,
+			})
+		}
+	}
+
+	h.controllerMgr.BroadcastDeviceOffline(deviceID)
+	log.Printf("设备断开: %s (重连超时)", deviceID)
+}
+
+// resumeSession 设备重连后恢复会话：按原参数重新推流并通知控制端
+func (h *WebSocketHandler) resumeSession(session *model.Session) {
+	params := h.sessionMgr.StreamParams(session.ID)
+	params.Type = protocol.TypeStreamStart
+	device := h.sessionMgr.Device(session.ID)
+	h.frames.Invalidate(session.DeviceID)
+	device.SendJSON(params)
+
+	if session.Controller != nil {
+		session.Controller.SendJSON(protocol.SessionStateMessage{
+			Type:      protocol.TypeSessionState,
+			SessionID: session.ID,
+			State:     protocol.SessionStateResumed,
+		})
+	}
+
+	if h.sfu != nil && session.Controller != nil && device.HasCapability(protocol.CapWebRTC) {
+		h.subscribeSFU(session.Controller.ID, session.Controller.SendJSON, device)
+	}
+
+	h.audit.Record(session, service.AuditSessionResumed, "")
+	log.Printf("控制会话恢复: %s -> %s", session.ControllerID, session.DeviceID)
 }
 
 // pingLoop 定期发送 ping 保持连接
@@ -193,17 +503,35 @@ func (h *WebSocketHandler) pingLoop(conn *websocket.Conn) {
 
 // handleScreenFrame 处理屏幕帧，转发给控制端
 func (h *WebSocketHandler) handleScreenFrame(device *model.Device, frame []byte) {
+	if len(frame) >= 2 && frame[0] == protocol.BinaryTypeThumbnail {
+		h.thumbnails.Update(device.ID, frame[2:], time.Now())
+		return
+	}
+
+	// 没有会话时也缓存，控制端加入时可立即显示画面
+	h.frames.Update(device.ID, frame)
+	if frameType, _, payload, ok := protocol.ParseFrame(frame); ok && frameType == protocol.BinaryTypeScreenFrame {
+		h.mjpeg.Publish(device.ID, payload)
+	}
+	h.hls.Write(device.ID, frame, time.Now())
+
 	session := h.sessionMgr.GetByDevice(device.ID)
 	if session == nil || session.Controller == nil {
 		// 没有活跃会话，丢弃帧
 		return
 	}
+	if !h.frames.Admitted(device.ID, session.ControllerID) {
+		// 控制端尚未收到缓存帧，实时帧不能排在缓存帧之前
+		return
+	}
 
-	// 转发给控制端
+	// 转发给控制端（入队，拥塞丢帧由发送队列处理并回调 frameObserver）
 	err := session.Controller.SendBinary(frame)
-	if err != nil {
+	if err != nil && !errors.Is(err, model.ErrFrameDropped) {
 		log.Printf("转发帧到控制端失败: %v", err)
 	}
+
+	h.recorder.WriteFrame(device.ID, frame)
 }
 
 // handleClipboardFromDevice 处理来自设备的剪贴板更新
@@ -224,7 +552,7 @@ func (h *WebSocketHandler) HandleController(c *gin.Context) {
 	deviceID := c.Query("deviceId")
 	token := c.Query("token")
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, meter, err := upgrade(h.controllerUpgrader, h.controllerWire, c.Writer, c.Request)
 	if err != nil {
 		log.Printf("控制端WebSocket升级失败: %v", err)
 		return
@@ -235,12 +563,12 @@ func (h *WebSocketHandler) HandleController(c *gin.Context) {
 		return
 	}
 
-	if h.store == nil {
+	if h.opts.TokenValidator == nil {
 		h.closeWithCode(conn, closeCodeInvalidToken, "store not configured")
 		return
 	}
 
-	deviceInfo, err := h.store.ValidateControlToken(deviceID, token)
+	deviceInfo, err := h.opts.TokenValidator.ValidateControlToken(deviceID, token)
 	if err != nil {
 		switch err {
 		case store.ErrTokenExpired:
@@ -270,23 +598,32 @@ func (h *WebSocketHandler) HandleController(c *gin.Context) {
 	controller := &model.Controller{
 		ID:              controllerID,
 		Conn:            conn,
+		Meter:           meter,
+		Encoding:        protocol.EncodingForSubprotocol(conn.Subprotocol()),
 		AllowedDeviceID: deviceID,
 		DisplayName:     deviceInfo.Name,
+		ConsentRequired: deviceInfo.ConsentRequired,
 	}
 
+	meter.account = func(in, out int64) {
+		h.bandwidth.RecordController(controllerID, in, out)
+	}
+	controller.StartFrameWriter(frameQueueSize, frameObserver{h})
 	h.controllerMgr.Register(controller)
-	log.Printf("控制端连接: %s", controllerID)
+	log.Printf("控制端连接: %s (编码: %s)", controllerID, controller.Encoding)
 
 	// 处理控制端消息
-	h.handleControllerMessages(controller)
+	h.handleControllerMessages(controller, meter)
 }
 
 // handleControllerMessages 处理控制端消息循环
-func (h *WebSocketHandler) handleControllerMessages(controller *model.Controller) {
+func (h *WebSocketHandler) handleControllerMessages(controller *model.Controller, meter *wireMeter) {
 	defer func() {
+		controller.StopFrameWriter()
 		controller.Conn.Close()
-		h.sessionMgr.CloseByController(controller.ID)
+		h.releaseControl(controller, "controller disconnected")
 		h.controllerMgr.Unregister(controller.ID)
+		h.bandwidth.RemoveController(controller.ID)
 		log.Printf("控制端断开: %s", controller.ID)
 	}()
 
@@ -294,7 +631,7 @@ func (h *WebSocketHandler) handleControllerMessages(controller *model.Controller
 	go h.pingLoop(controller.Conn)
 
 	for {
-		_, message, err := controller.Conn.ReadMessage()
+		_, message, err := readMessage(controller.Conn, meter, controller.Encoding)
 		if err != nil {
 			log.Printf("读取控制端消息失败: %v", err)
 			return
@@ -306,46 +643,170 @@ func (h *WebSocketHandler) handleControllerMessages(controller *model.Controller
 		var baseMsg protocol.BaseMessage
 		if err := json.Unmarshal(message, &baseMsg); err != nil {
 			log.Printf("解析消息失败: %v", err)
+			h.rejectMessage(controller, &protocol.ValidationError{Code: protocol.ErrCodeInvalidMessage, Message: "消息格式错误"})
+			continue
+		}
+
+		// 设备不支持的功能直接拒绝，不再转发
+		if !h.checkCapability(controller, protocol.RequiredCapability(baseMsg.Type)) {
 			continue
 		}
 
 		switch baseMsg.Type {
 		case protocol.TypeControlRequest:
 			var reqMsg protocol.ControlRequestMessage
-			json.Unmarshal(message, &reqMsg)
-			h.handleControlRequest(controller, reqMsg)
+			if h.decodeMessage(controller, message, &reqMsg) {
+				h.handleControlRequest(controller, reqMsg)
+			}
 
 		case protocol.TypeControlRelease:
-			h.sessionMgr.CloseByController(controller.ID)
+			h.releaseControl(controller, "released")
 
 		case protocol.TypeInputTouch:
-			h.forwardToDevice(controller, message)
+			var touchMsg protocol.TouchMessage
+			if !h.decodeMessage(controller, message, &touchMsg) {
+				continue
+			}
+			width, height := h.screenSize(controller)
+			if !h.validateMessage(controller, touchMsg.Validate(width, height)) || !h.trackRequest(controller, touchMsg.RequestID) {
+				continue
+			}
+			h.handleTouchInput(controller, touchMsg)
+
+		case protocol.TypeInputTouchFrame:
+			var frameMsg protocol.TouchFrameMessage
+			if !h.decodeMessage(controller, message, &frameMsg) {
+				continue
+			}
+			width, height := h.screenSize(controller)
+			if !h.validateMessage(controller, frameMsg.Validate(width, height)) || !h.trackRequest(controller, frameMsg.RequestID) {
+				continue
+			}
+			h.handleTouchFrame(controller, frameMsg)
 
 		case protocol.TypeInputKey:
 			var keyMsg protocol.KeyMessage
-			json.Unmarshal(message, &keyMsg)
+			if !h.decodeMessage(controller, message, &keyMsg) || !h.validateMessage(controller, keyMsg.Validate()) || !h.trackRequest(controller, keyMsg.RequestID) {
+				continue
+			}
 			h.handleKeyInput(controller, keyMsg)
+			h.macros.Record(controller.SessionID, message)
 
 		case protocol.TypeInputText:
 			var textMsg protocol.TextMessage
-			json.Unmarshal(message, &textMsg)
+			if !h.decodeMessage(controller, message, &textMsg) || !h.validateMessage(controller, textMsg.Validate()) || !h.trackRequest(controller, textMsg.RequestID) {
+				continue
+			}
 			h.handleTextInput(controller, textMsg)
+			h.macros.Record(controller.SessionID, message)
+
+		case protocol.TypeInputCommand:
+			var cmdMsg protocol.CommandMessage
+			if !h.decodeMessage(controller, message, &cmdMsg) {
+				continue
+			}
+			if _, device := h.sessionDevice(controller); device != nil {
+				if !h.validateMessage(controller, h.registry.Authorize(device, &cmdMsg, service.PermissionControl)) || !h.trackRequest(controller, cmdMsg.RequestID) {
+					continue
+				}
+				device.SendJSON(cmdMsg)
+				h.macros.Record(controller.SessionID, message)
+			}
+
+		case protocol.TypeCommandList:
+			if _, device := h.sessionDevice(controller); device != nil {
+				controller.SendJSON(protocol.CommandListMessage{
+					Type:     protocol.TypeCommandList,
+					DeviceID: device.ID,
+					Commands: h.registry.ListFor(device),
+				})
+			}
+
+		case protocol.TypeMacroRecordStart, protocol.TypeMacroRecordStop:
+			var macroMsg protocol.MacroRecordMessage
+			if h.decodeMessage(controller, message, &macroMsg) && h.validateMessage(controller, macroMsg.Validate()) {
+				h.handleMacroRecord(controller, macroMsg)
+			}
 
 		case protocol.TypeClipboardSet:
 			var clipMsg protocol.ClipboardMessage
-			json.Unmarshal(message, &clipMsg)
-			h.handleClipboardFromController(controller, clipMsg)
+			if h.decodeMessage(controller, message, &clipMsg) && h.validateMessage(controller, clipMsg.Validate()) && h.trackRequest(controller, clipMsg.RequestID) {
+				h.handleClipboardFromController(controller, clipMsg)
+			}
+
+		case protocol.TypeChatMessage:
+			var chatMsg protocol.ChatMessage
+			if h.decodeMessage(controller, message, &chatMsg) {
+				h.handleChatFromController(controller, chatMsg)
+			}
+
+		case protocol.TypeStreamStart:
+			var streamMsg protocol.StreamControlMessage
+			if !h.decodeMessage(controller, message, &streamMsg) || !h.validateMessage(controller, streamMsg.Validate()) {
+				continue
+			}
+			if !h.checkCapability(controller, protocol.StreamCapability(streamMsg.Mode)) {
+				continue
+			}
+			session, device := h.sessionDevice(controller)
+			if !streamMsg.StreamRegion.IsZero() {
+				if !h.checkCapability(controller, protocol.CapRegion) {
+					continue
+				}
+				if device != nil && !h.validateMessage(controller, streamMsg.StreamRegion.Validate(device.ScreenWidth, device.ScreenHeight)) {
+					continue
+				}
+			}
+			if session != nil {
+				// 推流区域在之后的码率调整和配置切换中保持不变
+				var region *protocol.StreamRegion
+				if !streamMsg.StreamRegion.IsZero() {
+					r := streamMsg.StreamRegion
+					region = &r
+				}
+				h.sessionMgr.SetStreamRegion(session.ID, region)
+			}
+			if session != nil {
+				// 分配了推流配置时，手动参数不能超出当前配置
+				if profile := h.sessionMgr.StreamProfile(session.ID); profile != nil {
+					streamMsg = service.ClampStreamParams(streamMsg, *profile)
+					message, _ = json.Marshal(streamMsg)
+				}
+			}
+			h.sessionMgr.UpdateStreamParams(controller.SessionID, streamMsg)
+			h.abr.Pin(controller.SessionID, streamMsg)
+			if session != nil {
+				h.frames.Invalidate(session.DeviceID)
+			}
+			h.forwardToDevice(controller, message)
+
+		case protocol.TypeStreamProfile:
+			var profileMsg protocol.StreamProfileMessage
+			if h.decodeMessage(controller, message, &profileMsg) && h.validateMessage(controller, profileMsg.Validate()) {
+				h.handleStreamProfile(controller, profileMsg)
+			}
 
-		case protocol.TypeStreamStart, protocol.TypeStreamStop:
+		case protocol.TypeStreamStats:
+			var statsMsg protocol.StreamStatsMessage
+			if !h.decodeMessage(controller, message, &statsMsg) || !h.validateMessage(controller, statsMsg.Validate()) {
+				continue
+			}
+			h.abr.Report(controller.SessionID, statsMsg)
+
+		case protocol.TypeStreamStop, protocol.TypeStreamKeyframe:
 			h.forwardToDevice(controller, message)
 
 		// 隐私模式消息转发
 		case protocol.TypePrivacyEnable, protocol.TypePrivacyDisable, protocol.TypePrivacyToggle:
 			h.forwardToDevice(controller, message)
 
-		// WebRTC 信令转发（控制端 -> 设备）
+		// WebRTC 信令转发（控制端 -> 设备），SFU 模式下由服务端处理
 		case protocol.TypeWebRTCOffer, protocol.TypeWebRTCAnswer, protocol.TypeWebRTCIce, protocol.TypeWebRTCReady:
-			h.forwardWebRTCSignalingToDevice(controller, message)
+			if h.sfu != nil {
+				h.handleSFUSignalingFromController(controller, message)
+			} else {
+				h.forwardWebRTCSignalingToDevice(controller, message)
+			}
 
 		// Ping/Pong 延迟测量
 		case protocol.TypePing:
@@ -353,10 +814,229 @@ func (h *WebSocketHandler) handleControllerMessages(controller *model.Controller
 				"type":      protocol.TypePong,
 				"timestamp": time.Now().UnixMilli(),
 			})
+
+		default:
+			h.rejectMessage(controller, &protocol.ValidationError{
+				Code:    protocol.ErrCodeUnknownType,
+				Message: "未知消息类型: " + baseMsg.Type,
+			})
 		}
 	}
 }
 
+// decodeMessage 按类型解析控制端消息，失败时回复 INVALID_MESSAGE
+func (h *WebSocketHandler) decodeMessage(controller *model.Controller, message []byte, v interface{}) bool {
+	if err := json.Unmarshal(message, v); err != nil {
+		return h.validateMessage(controller, &protocol.ValidationError{
+			Code:    protocol.ErrCodeInvalidMessage,
+			Message: "消息字段格式错误: " + err.Error(),
+		})
+	}
+	return true
+}
+
+// validateMessage 校验未通过时回复错误，返回是否通过
+func (h *WebSocketHandler) validateMessage(controller *model.Controller, err error) bool {
+	if err == nil {
+		return true
+	}
+	h.rejectMessage(controller, err)
+	return false
+}
+
+// rejectMessage 向控制端回复校验错误
+func (h *WebSocketHandler) rejectMessage(controller *model.Controller, err error) {
+	code, message := protocol.ErrCodeInvalidMessage, err.Error()
+	if ve, ok := err.(*protocol.ValidationError); ok {
+		code, message = ve.Code, ve.Message
+	}
+	controller.SendJSON(protocol.ErrorMessage{
+		Type:    protocol.TypeError,
+		Code:    code,
+		Message: message,
+	})
+}
+
+// trackRequest 为携带 requestId 的消息登记回执，回执结果发给控制端
+// 设备不支持回执时直接回复 sent；requestId 重复时回复错误并返回 false
+func (h *WebSocketHandler) trackRequest(controller *model.Controller, requestID string) bool {
+	if requestID == "" {
+		return true
+	}
+	_, device := h.sessionDevice(controller)
+	if device == nil {
+		return true
+	}
+
+	if !device.HasCapability(protocol.CapAck) {
+		controller.SendJSON(protocol.CommandResultMessage{
+			Type:      protocol.TypeCommandResult,
+			RequestID: requestID,
+			Status:    protocol.CommandStatusSent,
+		})
+		return true
+	}
+
+	if !h.commands.Track(device.ID, requestID, h.opts.CommandTimeout, func(result protocol.CommandResultMessage) {
+		controller.SendJSON(result)
+	}) {
+		h.rejectMessage(controller, &protocol.ValidationError{
+			Code:    protocol.ErrCodeDuplicateID,
+			Message: "requestId 重复: " + requestID,
+		})
+		return false
+	}
+	return true
+}
+
+// SendCommand 向设备发送一条输入消息并等待回执（REST 调用）
+// sessionID 为设备当前的控制会话，设备空闲时为空（见 AuthorizeInjection）
+// 设备不在线时返回 nil 结果，消息不合法或无权注入时返回 *protocol.ValidationError
+func (h *WebSocketHandler) SendCommand(deviceID, sessionID string, message []byte) (*protocol.CommandResultMessage, error) {
+	device := h.deviceMgr.GetOnline(deviceID)
+	if device == nil {
+		return nil, nil
+	}
+	session, err := h.AuthorizeInjection(device.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var base protocol.BaseMessage
+	if err := json.Unmarshal(message, &base); err != nil {
+		return nil, &protocol.ValidationError{Code: protocol.ErrCodeInvalidMessage, Message: "消息格式错误"}
+	}
+
+	var typed interface{}
+	switch base.Type {
+	case protocol.TypeInputTouch:
+		var msg protocol.TouchMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = msg.Validate(device.ScreenWidth, device.ScreenHeight), msg
+		}
+	case protocol.TypeInputTouchFrame:
+		var msg protocol.TouchFrameMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = msg.Validate(device.ScreenWidth, device.ScreenHeight), msg
+		}
+	case protocol.TypeInputKey:
+		var msg protocol.KeyMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = msg.Validate(), msg
+		}
+	case protocol.TypeInputText:
+		var msg protocol.TextMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = msg.Validate(), msg
+		}
+	case protocol.TypeClipboardSet:
+		var msg protocol.ClipboardMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = msg.Validate(), msg
+		}
+	case protocol.TypeInputCommand:
+		var msg protocol.CommandMessage
+		if err = json.Unmarshal(message, &msg); err == nil {
+			err, typed = h.registry.Authorize(device, &msg, service.PermissionAdmin), msg
+		}
+	default:
+		return nil, &protocol.ValidationError{Code: protocol.ErrCodeUnknownType, Message: "不支持的命令类型: " + base.Type}
+	}
+	if err != nil {
+		if _, ok := err.(*protocol.ValidationError); !ok {
+			err = &protocol.ValidationError{Code: protocol.ErrCodeInvalidMessage, Message: "消息字段格式错误: " + err.Error()}
+		}
+		return nil, err
+	}
+	if capability := protocol.RequiredCapability(base.Type); capability != "" && !device.HasCapability(capability) {
+		return nil, &protocol.ValidationError{Code: "UNSUPPORTED_CAPABILITY", Message: "设备不支持该功能: " + capability}
+	}
+
+	// 按类型重新序列化（去掉未定义的字段）后附加 requestId
+	var payload map[string]interface{}
+	normalized, _ := json.Marshal(typed)
+	json.Unmarshal(normalized, &payload)
+	requestID := uuid.New().String()
+	payload["requestId"] = requestID
+	data, _ := json.Marshal(payload)
+	h.audit.RecordAPI(session, device.ID, service.AuditAPIInput, fmt.Sprintf("type=%s request=%s", base.Type, requestID))
+
+	if !device.HasCapability(protocol.CapAck) {
+		status, errMsg := protocol.CommandStatusSent, ""
+		if err := device.SendText(data); err != nil {
+			status, errMsg = protocol.CommandStatusFailure, err.Error()
+		}
+		return &protocol.CommandResultMessage{Type: protocol.TypeCommandResult, RequestID: requestID, Status: status, Error: errMsg}, nil
+	}
+
+	done := make(chan protocol.CommandResultMessage, 1)
+	h.commands.Track(device.ID, requestID, h.opts.CommandTimeout, func(result protocol.CommandResultMessage) {
+		done <- result
+	})
+	if err := device.SendText(data); err != nil {
+		h.commands.Resolve(device.ID, protocol.CommandResultMessage{
+			Type:      protocol.TypeCommandResult,
+			RequestID: requestID,
+			Status:    protocol.CommandStatusFailure,
+			Error:     err.Error(),
+		})
+	}
+	result := <-done
+	return &result, nil
+}
+
+// AuthorizeInjection 校验 REST 接口向设备注入输入的权限
+// 设备正被控制（或等待现场用户同意）时须携带该会话ID，否则返回 DEVICE_BUSY；
+// 设备要求现场用户同意时不允许在会话之外注入，返回 CONSENT_REQUIRED。
+// 返回注入所属的会话，设备空闲时为空
+func (h *WebSocketHandler) AuthorizeInjection(deviceID, sessionID string) (*model.Session, error) {
+	session, pending := h.sessionMgr.DeviceHolder(deviceID)
+	if session != nil {
+		if pending || session.ID != sessionID {
+			return nil, &protocol.ValidationError{Code: "DEVICE_BUSY", Message: "设备正在被其他人控制"}
+		}
+		return session, nil
+	}
+	if sessionID != "" {
+		return nil, &protocol.ValidationError{Code: "SESSION_NOT_FOUND", Message: "会话不存在或已结束"}
+	}
+
+	if h.opts.Consent != nil {
+		required, err := h.opts.Consent.DeviceConsentRequired(deviceID)
+		if err != nil {
+			log.Printf("查询设备同意设置失败: %s: %v", deviceID, err)
+			required = true
+		}
+		if required {
+			return nil, &protocol.ValidationError{Code: "CONSENT_REQUIRED", Message: "设备要求现场用户同意，只能在控制会话中操作"}
+		}
+	}
+	return nil, nil
+}
+
+// sessionDevice 控制端当前会话及会话的设备连接，无会话时均为 nil
+// 设备重连会替换会话的设备连接，需通过 SessionManager 读取
+func (h *WebSocketHandler) sessionDevice(controller *model.Controller) (*model.Session, *model.Device) {
+	session := h.sessionMgr.GetByController(controller.ID)
+	if session == nil {
+		return nil, nil
+	}
+	return session, h.sessionMgr.Device(session.ID)
+}
+
+// screenSize 控制端触摸坐标空间的尺寸：设备屏幕尺寸，指定了推流区域时为裁剪旋转后的画面尺寸
+// 无会话时返回 0
+func (h *WebSocketHandler) screenSize(controller *model.Controller) (int, int) {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
+		return 0, 0
+	}
+	if region := h.sessionMgr.StreamRegion(session.ID); region != nil {
+		return service.RegionSize(*region, device.ScreenWidth, device.ScreenHeight)
+	}
+	return device.ScreenWidth, device.ScreenHeight
+}
+
 // handleControlRequest 处理控制请求
 func (h *WebSocketHandler) handleControlRequest(controller *model.Controller, msg protocol.ControlRequestMessage) {
 	deviceID := msg.DeviceID
@@ -382,6 +1062,29 @@ func (h *WebSocketHandler) handleControlRequest(controller *model.Controller, ms
 		return
 	}
 
+	if quota := h.quotaExceeded(device.ID); quota != nil && quotaRefuses(quota) {
+		controller.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.TypeError,
+			Code:    "QUOTA_EXCEEDED",
+			Message: "设备本月流量已用尽",
+		})
+		log.Printf("设备本月流量已用尽，拒绝控制请求: %s -> %s", controller.ID, device.ID)
+		return
+	}
+
+	if controller.ConsentRequired {
+		if !device.HasCapability(protocol.CapConsent) {
+			controller.SendJSON(protocol.ErrorMessage{
+				Type:    protocol.TypeError,
+				Code:    "UNSUPPORTED_CAPABILITY",
+				Message: "设备不支持现场用户确认",
+			})
+			return
+		}
+		h.requestConsent(controller, device)
+		return
+	}
+
 	session := h.sessionMgr.Create(device, controller)
 	if session == nil {
 		controller.SendJSON(protocol.ErrorMessage{
@@ -392,25 +1095,254 @@ func (h *WebSocketHandler) handleControlRequest(controller *model.Controller, ms
 		return
 	}
 
+	h.grantControl(session)
+}
+
+// grantControl 授予控制权：通知控制端并让设备开始推流
+func (h *WebSocketHandler) grantControl(session *model.Session) {
+	controller := session.Controller
+	device := h.sessionMgr.Device(session.ID)
+
+	profile, profiles := h.resolveStreamProfiles(device.ID)
+	if quota := h.quotaExceeded(device.ID); quota != nil && !quotaRefuses(quota) {
+		// 流量配额用尽，会话只能使用配额指定的推流配置
+		profile, profiles = quota.Downgrade, []protocol.StreamProfile{*quota.Downgrade}
+		log.Printf("设备本月流量已用尽，使用推流配置: %s %s", device.ID, profile.Name)
+	}
+	h.sessionMgr.SetStreamProfile(session.ID, profile, profiles)
+	h.bandwidth.StartSession(session.ID, device.ID, controller.ID)
+	// 控制端接管设备，停止 REST 接口发起的回放
+	h.player.AbortDevice(device.ID)
+
 	// 通知控制端
-	controller.SendJSON(protocol.ControlGrantedMessage{
+	granted := protocol.ControlGrantedMessage{
 		Type:         protocol.TypeControlGranted,
 		DeviceID:     device.ID,
 		DeviceName:   h.displayName(controller, device.Name),
 		SessionID:    session.ID,
 		ScreenWidth:  device.ScreenWidth,
 		ScreenHeight: device.ScreenHeight,
+
+		ProtocolVersion: device.ProtocolVersion,
+		Capabilities:    device.Capabilities,
+		Commands:        device.Commands,
+		ICEServers:      h.opts.ICE.Servers(controller.ID),
+		StreamProfiles:  profiles,
+	}
+	if profile != nil {
+		granted.StreamProfile = profile.Name
+	}
+	controller.SendJSON(granted)
+
+	// 通知设备开始推流，之前缓存的帧随之失效；此后收到的配置帧和关键帧先于实时帧发给控制端
+	h.startStream(session, profile)
+	h.primeReceiver(controller, device)
+
+	// SFU 模式下服务端主动向控制端发起 WebRTC。服务端不会停止设备的 WebSocket 推流，
+	// 但 Android 端在 WebRTC 连接建立后自行停止 WebSocket 推流，之后录像和 HTTP 观看不再有新帧
+	if h.sfu != nil && device.HasCapability(protocol.CapWebRTC) {
+		h.subscribeSFU(controller.ID, controller.SendJSON, device)
+	}
+
+	h.recorder.Start(session)
+	h.audit.Record(session, service.AuditSessionStart, "")
+	log.Printf("控制会话建立: %s -> %s", controller.ID, device.ID)
+}
+
+// adaptStream 自适应码率调整后通知设备按新参数推流
+func (h *WebSocketHandler) adaptStream(session *model.Session, params protocol.StreamControlMessage) {
+	log.Printf("自适应码率调整: %s mode=%s bitrate=%d quality=%d fps=%d", session.ID, params.Mode, params.Bitrate, params.Quality, params.FPS+params.MaxFPS)
+	params = h.withRegion(session, params)
+	h.sessionMgr.UpdateStreamParams(session.ID, params)
+	if device := h.sessionMgr.Device(session.ID); device != nil {
+		h.frames.Invalidate(session.DeviceID)
+		device.SendJSON(params)
+	}
+}
+
+// frameObserver 控制端发送队列回调：更新自适应码率统计，丢帧后请求关键帧
+type frameObserver struct {
+	h *WebSocketHandler
+}
+
+func (o frameObserver) FrameSent(c *model.Controller, latency time.Duration) {
+	if session := o.h.sessionMgr.GetByController(c.ID); session != nil {
+		if params, changed := o.h.abr.RecordSent(session.ID, latency); changed {
+			o.h.adaptStream(session, params)
+		}
+	}
+}
+
+func (o frameObserver) FramesDropped(c *model.Controller, count int) {
+	if session := o.h.sessionMgr.GetByController(c.ID); session != nil {
+		if params, changed := o.h.abr.RecordDropped(session.ID, count); changed {
+			o.h.adaptStream(session, params)
+		}
+	}
+}
+
+func (o frameObserver) NeedKeyframe(c *model.Controller) {
+	if _, device := o.h.sessionDevice(c); device != nil {
+		o.h.requestKeyframe(device)
+	}
+}
+
+// primeReceiver 向新的接收方先发送缓存的配置帧和关键帧，之后才转发实时帧；缓存缺失或过期时请求设备输出关键帧
+func (h *WebSocketHandler) primeReceiver(controller *model.Controller, device *model.Device) {
+	stale := h.frames.Prime(device.ID, controller.ID, func(frame []byte) error {
+		err := controller.SendBinary(frame)
+		if err != nil {
+			log.Printf("发送缓存帧失败: %v", err)
+		}
+		return err
 	})
+	if stale {
+		h.requestKeyframe(device)
+	}
+}
 
-	// 通知设备开始推流（默认使用 H264 模式）
-	device.SendJSON(protocol.StreamControlMessage{
-		Type:    protocol.TypeStreamStart,
-		Mode:    "h264",
-		Bitrate: 2000000, // 2Mbps
-		FPS:     30,
+// requestKeyframe 请求设备立即输出关键帧（限制频率）
+func (h *WebSocketHandler) requestKeyframe(device *model.Device) {
+	if !device.HasCapability(protocol.CapKeyframe) || !h.frames.ShouldRequestKeyframe(device.ID) {
+		return
+	}
+	device.SendJSON(protocol.BaseMessage{Type: protocol.TypeStreamKeyframe})
+}
+
+// requestConsent 向设备发起同意请求，控制端进入等待状态
+func (h *WebSocketHandler) requestConsent(controller *model.Controller, device *model.Device) {
+	session := h.sessionMgr.CreatePending(device, controller)
+	if session == nil {
+		controller.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.TypeError,
+			Code:    "DEVICE_BUSY",
+			Message: "设备正在被其他人控制",
+		})
+		return
+	}
+
+	h.player.AbortDevice(device.ID)
+	h.consentMgr.Begin(session.ID, h.opts.ConsentTimeout, func() {
+		h.handleConsentTimeout(session)
 	})
+	h.audit.Record(session, service.AuditConsentRequested, "")
 
-	log.Printf("控制会话建立: %s -> %s", controller.ID, device.ID)
+	pending := protocol.ControlPendingMessage{
+		Type:         protocol.TypeControlPending,
+		SessionID:    session.ID,
+		DeviceID:     device.ID,
+		ControllerID: controller.ID,
+		TimeoutMs:    h.opts.ConsentTimeout.Milliseconds(),
+	}
+	device.SendJSON(pending)
+	controller.SendJSON(pending)
+
+	log.Printf("等待现场用户同意: %s -> %s", controller.ID, device.ID)
+}
+
+// handleConsentReply 处理设备端的同意/拒绝答复
+func (h *WebSocketHandler) handleConsentReply(device *model.Device, msg protocol.ControlConsentMessage) {
+	session := h.sessionMgr.Get(msg.SessionID)
+	if session == nil || session.DeviceID != device.ID || !session.Pending {
+		return
+	}
+	if !h.consentMgr.Resolve(session.ID) {
+		// 已超时或已取消
+		return
+	}
+
+	if msg.Approved {
+		if session = h.sessionMgr.Activate(session.ID); session != nil {
+			h.audit.Record(session, service.AuditConsentGranted, msg.Reason)
+			h.grantControl(session)
+		}
+		return
+	}
+
+	h.sessionMgr.Close(session.ID)
+	h.audit.Record(session, service.AuditConsentDenied, msg.Reason)
+	if session.Controller != nil {
+		session.Controller.SendJSON(protocol.ControlDeniedMessage{
+			Type:     protocol.TypeControlDenied,
+			DeviceID: device.ID,
+			Code:     "CONSENT_DENIED",
+			Message:  "现场用户拒绝了控制请求",
+		})
+	}
+	log.Printf("现场用户拒绝控制: %s -> %s", session.ControllerID, device.ID)
+}
+
+// handleConsentTimeout 等待同意超时
+func (h *WebSocketHandler) handleConsentTimeout(session *model.Session) {
+	h.sessionMgr.Close(session.ID)
+	h.audit.Record(session, service.AuditConsentTimeout, "")
+
+	h.sessionMgr.Device(session.ID).SendJSON(protocol.ControlCancelMessage{
+		Type:      protocol.TypeControlCancel,
+		SessionID: session.ID,
+		Reason:    "timeout",
+	})
+	if session.Controller != nil {
+		session.Controller.SendJSON(protocol.ControlDeniedMessage{
+			Type:     protocol.TypeControlDenied,
+			DeviceID: session.DeviceID,
+			Code:     "CONSENT_TIMEOUT",
+			Message:  "现场用户未在规定时间内响应",
+		})
+	}
+	log.Printf("等待现场用户同意超时: %s -> %s", session.ControllerID, session.DeviceID)
+}
+
+// releaseControl 控制端释放控制或断开时结束会话
+func (h *WebSocketHandler) releaseControl(controller *model.Controller, reason string) {
+	session := h.sessionMgr.CloseByController(controller.ID)
+	if session == nil {
+		return
+	}
+
+	if h.consentMgr.Resolve(session.ID) {
+		// 等待同意期间控制端离开，通知设备撤回请求
+		h.audit.Record(session, service.AuditConsentCancelled, reason)
+		h.sessionMgr.Device(session.ID).SendJSON(protocol.ControlCancelMessage{
+			Type:      protocol.TypeControlCancel,
+			SessionID: session.ID,
+			Reason:    reason,
+		})
+		return
+	}
+
+	h.endSession(session, reason)
+}
+
+// endSession 会话结束后的收尾：记录审计并结束录像
+func (h *WebSocketHandler) endSession(session *model.Session, reason string) {
+	h.recorder.Stop(session.ID)
+	h.abr.Stop(session.ID)
+	if h.sfu != nil {
+		h.sfu.Unsubscribe(session.ControllerID)
+	}
+	h.macros.Discard(session.ID)
+	h.player.AbortDevice(session.DeviceID)
+	h.frames.Release(session.DeviceID, session.ControllerID)
+	h.frames.Invalidate(session.DeviceID)
+	if transcript := h.chat.Take(session.ID); len(transcript) > 0 {
+		// 聊天内容写入独立的聊天记录表，审计只记录条数
+		if err := h.store.InsertChatTranscript(session.ID, session.DeviceID, transcript); err != nil {
+			log.Printf("写入聊天记录失败: session=%s: %v", session.ID, err)
+		}
+		h.audit.Record(session, service.AuditChatTranscript, fmt.Sprintf("messages=%d", len(transcript)))
+	}
+	if usage, ok := h.bandwidth.EndSession(session.ID); ok {
+		if data, err := json.Marshal(usage); err == nil {
+			h.audit.Record(session, service.AuditSessionBandwidth, string(data))
+		}
+	}
+	h.audit.Record(session, service.AuditSessionEnd, reason)
+
+	// 仍有 HTTP 观看者时恢复推流
+	if device := h.sessionMgr.Device(session.ID); device != nil {
+		h.startViewerStream(device)
+	}
 }
 
 func (h *WebSocketHandler) closeWithCode(conn *websocket.Conn, code int, reason string) {
@@ -426,60 +1358,201 @@ func (h *WebSocketHandler) displayName(controller *model.Controller, fallback st
 	return fallback
 }
 
+// handleMacroRecord 开始/停止录制输入宏
+func (h *WebSocketHandler) handleMacroRecord(controller *model.Controller, msg protocol.MacroRecordMessage) {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
+		return
+	}
+
+	if msg.Type == protocol.TypeMacroRecordStart {
+		if err := h.macros.Start(session.ID, device, msg.Name); err != nil {
+			controller.SendJSON(protocol.ErrorMessage{
+				Type:    protocol.TypeError,
+				Code:    "MACRO_RECORDING",
+				Message: "正在录制其他宏",
+			})
+		}
+		return
+	}
+
+	macro, err := h.macros.Stop(session.ID)
+	if err != nil {
+		controller.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.TypeError,
+			Code:    "MACRO_NOT_RECORDING",
+			Message: "没有正在录制的宏",
+		})
+		return
+	}
+	if err := h.store.SaveMacro(macro); err != nil {
+		log.Printf("保存宏失败: %v", err)
+		controller.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.TypeError,
+			Code:    "MACRO_SAVE_FAILED",
+			Message: "保存宏失败",
+		})
+		return
+	}
+
+	controller.SendJSON(protocol.MacroSavedMessage{
+		Type:   protocol.TypeMacroSaved,
+		Name:   macro.Name,
+		Events: len(macro.Events),
+	})
+	log.Printf("宏已保存: %s (%d 个事件)", macro.Name, len(macro.Events))
+}
+
+// handleChatFromController 转发控制端聊天消息到设备
+func (h *WebSocketHandler) handleChatFromController(controller *model.Controller, msg protocol.ChatMessage) {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
+		h.ackChat(controller.SendJSON, msg.ID, "NO_SESSION")
+		return
+	}
+	msg.From = protocol.ChatFromController
+	h.relayChat(session, msg, device.SendJSON, controller.SendJSON)
+}
+
+// handleChatFromDevice 转发设备聊天消息到控制端
+func (h *WebSocketHandler) handleChatFromDevice(device *model.Device, msg protocol.ChatMessage) {
+	session := h.sessionMgr.GetByDevice(device.ID)
+	if session == nil || session.Controller == nil {
+		h.ackChat(device.SendJSON, msg.ID, "NO_SESSION")
+		return
+	}
+	msg.From = protocol.ChatFromDevice
+	h.relayChat(session, msg, session.Controller.SendJSON, device.SendJSON)
+}
+
+// relayChat 补全消息字段后转发给对端，并向发送方回执是否已转发
+func (h *WebSocketHandler) relayChat(session *model.Session, msg protocol.ChatMessage, deliver, reply func(interface{}) error) {
+	if err := msg.Validate(); err != nil {
+		h.ackChat(reply, msg.ID, err.(*protocol.ValidationError).Code)
+		return
+	}
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	msg.Type = protocol.TypeChatMessage
+	msg.SessionID = session.ID
+	msg.Timestamp = time.Now().UnixMilli()
+
+	if err := deliver(msg); err != nil {
+		log.Printf("聊天消息发送失败: session=%s from=%s: %v", session.ID, msg.From, err)
+		h.ackChat(reply, msg.ID, "PEER_UNAVAILABLE")
+		return
+	}
+	h.chat.Append(msg)
+	h.ackChat(reply, msg.ID, "")
+}
+
+// ackChat 发送聊天回执，code 为空表示已写入对端连接
+// 服务端不等待对端确认，回执不代表对端已显示消息
+func (h *WebSocketHandler) ackChat(reply func(interface{}) error, id, code string) {
+	reply(protocol.ChatAckMessage{
+		Type:      protocol.TypeChatAck,
+		ID:        id,
+		Relayed:   code == "",
+		Code:      code,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// checkCapability 检查控制端当前会话的设备是否具备指定能力，不具备时回复错误
+func (h *WebSocketHandler) checkCapability(controller *model.Controller, capability string) bool {
+	if capability == "" {
+		return true
+	}
+	_, device := h.sessionDevice(controller)
+	if device == nil || device.HasCapability(capability) {
+		return true
+	}
+	controller.SendJSON(protocol.ErrorMessage{
+		Type:    protocol.TypeError,
+		Code:    "UNSUPPORTED_CAPABILITY",
+		Message: "设备不支持该功能: " + capability,
+	})
+	return false
+}
+
 // handleTouchInput 处理触摸输入
 func (h *WebSocketHandler) handleTouchInput(controller *model.Controller, msg protocol.TouchMessage) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
 		return
 	}
 
-	// 转发给设备
-	session.Device.SendJSON(msg)
+	if region := h.sessionMgr.StreamRegion(session.ID); region != nil {
+		msg = service.RemapTouch(msg, *region, device.ScreenWidth, device.ScreenHeight)
+	}
+	// 按类型重新序列化后转发，未定义的字段不会到达设备
+	device.SendJSON(msg)
+	if data, err := json.Marshal(msg); err == nil {
+		h.macros.Record(session.ID, data)
+	}
+}
+
+// handleTouchFrame 处理多点触控帧
+func (h *WebSocketHandler) handleTouchFrame(controller *model.Controller, msg protocol.TouchFrameMessage) {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
+		return
+	}
+
+	if region := h.sessionMgr.StreamRegion(session.ID); region != nil {
+		msg = service.RemapTouchFrame(msg, *region, device.ScreenWidth, device.ScreenHeight)
+	}
+	device.SendJSON(msg)
+	if data, err := json.Marshal(msg); err == nil {
+		h.macros.Record(session.ID, data)
+	}
 }
 
 // handleKeyInput 处理按键输入
 func (h *WebSocketHandler) handleKeyInput(controller *model.Controller, msg protocol.KeyMessage) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	_, device := h.sessionDevice(controller)
+	if device == nil {
 		return
 	}
 
-	session.Device.SendJSON(msg)
+	device.SendJSON(msg)
 }
 
 // handleTextInput 处理文本输入
 func (h *WebSocketHandler) handleTextInput(controller *model.Controller, msg protocol.TextMessage) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	_, device := h.sessionDevice(controller)
+	if device == nil {
 		return
 	}
 
-	session.Device.SendJSON(msg)
+	device.SendJSON(msg)
 }
 
 // handleClipboardFromController 处理来自控制端的剪贴板设置
 func (h *WebSocketHandler) handleClipboardFromController(controller *model.Controller, msg protocol.ClipboardMessage) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	_, device := h.sessionDevice(controller)
+	if device == nil {
 		return
 	}
 
-	session.Device.SendJSON(protocol.ClipboardMessage{
-		Type: protocol.TypeClipboardSet,
-		Text: msg.Text,
+	device.SendJSON(protocol.ClipboardMessage{
+		Type:      protocol.TypeClipboardSet,
+		RequestID: msg.RequestID,
+		Text:      msg.Text,
 	})
 }
 
 // forwardToDevice 转发消息给设备
 func (h *WebSocketHandler) forwardToDevice(controller *model.Controller, message []byte) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	_, device := h.sessionDevice(controller)
+	if device == nil {
 		log.Printf("forwardToDevice: no session for controller %s", controller.ID)
 		return
 	}
 
 	// 使用线程安全的发送方法
-	err := session.Device.SendText(message)
+	err := device.SendText(message)
 	if err != nil {
 		log.Printf("forwardToDevice error: %v", err)
 	}
@@ -487,8 +1560,8 @@ func (h *WebSocketHandler) forwardToDevice(controller *model.Controller, message
 
 // forwardWebRTCSignalingToDevice 转发 WebRTC 信令给设备
 func (h *WebSocketHandler) forwardWebRTCSignalingToDevice(controller *model.Controller, message []byte) {
-	session := h.sessionMgr.GetByController(controller.ID)
-	if session == nil || session.Device == nil {
+	session, device := h.sessionDevice(controller)
+	if device == nil {
 		log.Printf("WebRTC signaling: no session for controller %s", controller.ID)
 		return
 	}
@@ -497,14 +1570,19 @@ func (h *WebSocketHandler) forwardWebRTCSignalingToDevice(controller *model.Cont
 	var msg map[string]interface{}
 	if err := json.Unmarshal(message, &msg); err == nil {
 		msg["fromId"] = controller.ID
+		if msg["type"] == protocol.TypeWebRTCReady {
+			if servers := h.opts.ICE.Servers(session.DeviceID); servers != nil {
+				msg["iceServers"] = servers
+			}
+		}
 		if newMsg, err := json.Marshal(msg); err == nil {
-			session.Device.Conn.WriteMessage(websocket.TextMessage, newMsg)
-			log.Printf("WebRTC signaling forwarded to device: %s", session.Device.ID)
+			device.SendText(newMsg)
+			log.Printf("WebRTC signaling forwarded to device: %s", device.ID)
 			return
 		}
 	}
 
-	session.Device.Conn.WriteMessage(websocket.TextMessage, message)
+	device.SendText(message)
 }
 
 // forwardWebRTCSignalingToController 转发 WebRTC 信令给控制端
@@ -519,17 +1597,205 @@ func (h *WebSocketHandler) forwardWebRTCSignalingToController(device *model.Devi
 	var msg map[string]interface{}
 	if err := json.Unmarshal(message, &msg); err == nil {
 		msg["fromId"] = device.ID
+		if msg["type"] == protocol.TypeWebRTCReady {
+			if servers := h.opts.ICE.Servers(session.ControllerID); servers != nil {
+				msg["iceServers"] = servers
+			}
+		}
 		if newMsg, err := json.Marshal(msg); err == nil {
-			session.Controller.Conn.WriteMessage(websocket.TextMessage, newMsg)
+			session.Controller.SendText(newMsg)
 			log.Printf("WebRTC signaling forwarded to controller: %s", session.Controller.ID)
 			return
 		}
 	}
 
-	session.Controller.Conn.WriteMessage(websocket.TextMessage, message)
+	session.Controller.SendText(message)
+}
+
+// GetCommandRegistry 获取命令注册表（用于注册扩展命令）
+func (h *WebSocketHandler) GetCommandRegistry() *service.CommandRegistry {
+	return h.registry
+}
+
+// 订阅 HTTP MJPEG 流的错误
+var (
+	ErrDeviceOffline     = errors.New("device offline")
+	ErrMJPEGNotSupported = errors.New("device does not support mjpeg")
+	ErrH264NotSupported  = errors.New("device does not support h264")
+)
+
+// WatchMJPEG 订阅设备的 MJPEG 帧（供API使用）
+// 设备没有控制会话时，第一个观看者会让设备按配置的质量和帧率开始 MJPEG 推流
+func (h *WebSocketHandler) WatchMJPEG(deviceID string) (*service.MJPEGViewer, error) {
+	device := h.deviceMgr.GetOnline(deviceID)
+	if device == nil {
+		return nil, ErrDeviceOffline
+	}
+	if !device.HasCapability(protocol.CapMJPEG) {
+		return nil, ErrMJPEGNotSupported
+	}
+	viewer, first := h.mjpeg.Subscribe(deviceID)
+	if first {
+		h.startViewerStream(device)
+	}
+	log.Printf("MJPEG 观看者加入: %s (共 %d)", deviceID, h.mjpeg.Count(deviceID))
+	return viewer, nil
+}
+
+// UnwatchMJPEG 取消订阅，最后一个观看者离开且设备没有控制会话时停止推流
+func (h *WebSocketHandler) UnwatchMJPEG(viewer *service.MJPEGViewer) {
+	if !h.mjpeg.Unsubscribe(viewer) {
+		return
+	}
+	log.Printf("MJPEG 观看者全部离开: %s", viewer.DeviceID)
+	h.viewerLeft(viewer.DeviceID)
+}
+
+// WatchHLS 标记设备的 HLS 直播正在被观看（供API使用，每次请求播放列表时调用）
+// 设备没有控制会话和 MJPEG 观看者时，第一次请求会让设备开始 H264 推流
+func (h *WebSocketHandler) WatchHLS(deviceID string) error {
+	device := h.deviceMgr.GetOnline(deviceID)
+	if device == nil {
+		return ErrDeviceOffline
+	}
+	if !device.HasCapability(protocol.CapH264) {
+		return ErrH264NotSupported
+	}
+	if !h.hls.Watch(deviceID) {
+		return nil
+	}
+
+	log.Printf("HLS 直播开始: %s", deviceID)
+	// 推流中途加入时先写入缓存的配置帧和关键帧
+	frames, stale := h.frames.Snapshot(deviceID)
+	now := time.Now()
+	for _, frame := range frames {
+		h.hls.Write(deviceID, frame, now)
+	}
+	h.startViewerStream(device)
+	if stale {
+		h.requestKeyframe(device)
+	}
+	return nil
+}
+
+// runHLS 定期回收没有观看者的 HLS 直播，并按分片进度向设备请求关键帧（分片只在关键帧处切分）
+func (h *WebSocketHandler) runHLS() {
+	ticker := time.NewTicker(hlsTickPeriod)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-h.done:
+			return
+		case now = <-ticker.C:
+		}
+		for _, deviceID := range h.hls.Expire(now) {
+			log.Printf("HLS 直播结束: %s", deviceID)
+			h.viewerLeft(deviceID)
+		}
+		for _, deviceID := range h.hls.Tick(now) {
+			if device := h.deviceMgr.GetOnline(deviceID); device != nil {
+				h.requestKeyframe(device)
+			}
+		}
+	}
+}
+
+// viewerLeft 某类 HTTP 观看者全部离开后，切换到其余观看者的推流方式，都没有时停止推流
+func (h *WebSocketHandler) viewerLeft(deviceID string) {
+	device := h.deviceMgr.GetOnline(deviceID)
+	if device == nil || h.sessionMgr.GetByDevice(deviceID) != nil {
+		return
+	}
+	if h.mjpeg.Count(deviceID) == 0 && !h.hls.Watching(deviceID) {
+		device.SendJSON(protocol.BaseMessage{Type: protocol.TypeStreamStop})
+		return
+	}
+	h.startViewerStream(device)
+}
+
+// startViewerStream 设备没有控制会话时按 HTTP 观看者开始推流
+// MJPEG 观看者优先（MJPEG 帧不能封装为 HLS），否则为 HLS 观看者推 H264
+func (h *WebSocketHandler) startViewerStream(device *model.Device) {
+	if h.sessionMgr.GetByDevice(device.ID) != nil {
+		return
+	}
+	if h.mjpeg.Count(device.ID) > 0 || h.hls.Watching(device.ID) {
+		h.frames.Invalidate(device.ID)
+	}
+	switch {
+	case h.mjpeg.Count(device.ID) > 0:
+		device.SendJSON(protocol.StreamControlMessage{
+			Type:    protocol.TypeStreamStart,
+			Mode:    "mjpeg",
+			Quality: h.opts.MJPEGQuality,
+			MaxFPS:  h.opts.MJPEGFPS,
+		})
+	case h.hls.Watching(device.ID):
+		device.SendJSON(protocol.StreamControlMessage{
+			Type:    protocol.TypeStreamStart,
+			Mode:    "h264",
+			Bitrate: hlsBitrate,
+			FPS:     hlsFPS,
+		})
+	}
+}
+
+// HLSPlaylist 获取设备的 HLS 播放列表，wait 内等待第一个分片生成（供API使用）
+func (h *WebSocketHandler) HLSPlaylist(ctx context.Context, deviceID, query string, wait time.Duration) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if !h.hls.WaitReady(ctx, deviceID) {
+		return "", false
+	}
+	return h.hls.Playlist(deviceID, query)
+}
+
+// HLSInit 获取设备 HLS 直播的初始化分片（供API使用）
+func (h *WebSocketHandler) HLSInit(deviceID string, id int) ([]byte, bool) {
+	return h.hls.Init(deviceID, id)
+}
+
+// HLSSegment 获取设备 HLS 直播的媒体分片（供API使用）
+func (h *WebSocketHandler) HLSSegment(deviceID string, seq uint64) ([]byte, bool) {
+	return h.hls.Segment(deviceID, seq)
+}
+
+// RecordViewerBandwidth 记录 HTTP 观看者（MJPEG / HLS）从服务端读取的字节数（供API使用）
+func (h *WebSocketHandler) RecordViewerBandwidth(deviceID string, out int64) {
+	h.bandwidth.RecordViewer(deviceID, out)
+}
+
+// GetSessionStats 获取会话的自适应码率状态（供API使用）
+func (h *WebSocketHandler) GetSessionStats(sessionID string) (service.ABRStats, bool) {
+	return h.abr.Stats(sessionID)
+}
+
+// GetWireStats 获取各端点的发送统计（供API使用）
+func (h *WebSocketHandler) GetWireStats() map[string]service.WireStatsSnapshot {
+	return map[string]service.WireStatsSnapshot{
+		"device":     h.deviceWire.Snapshot(h.opts.DeviceCompression),
+		"controller": h.controllerWire.Snapshot(h.opts.ControllerCompression),
+	}
 }
 
 // GetDeviceManager 获取设备管理器（供API使用）
 func (h *WebSocketHandler) GetDeviceManager() *service.DeviceManager {
 	return h.deviceMgr
 }
+
+// GetAuditLogger 获取会话审计记录器（供API使用）
+func (h *WebSocketHandler) GetAuditLogger() *service.AuditLogger {
+	return h.audit
+}
+
+// GetMacroPlayer 获取宏回放器（供API使用）
+func (h *WebSocketHandler) GetMacroPlayer() *service.MacroPlayer {
+	return h.player
+}
+
+// GetRecordingManager 获取录像管理器（供API使用，未开启录像时为 nil）
+func (h *WebSocketHandler) GetRecordingManager() *service.RecordingManager {
+	return h.recorder
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
+	"shushu-remote-control/internal/service"
+)
+
+// newUpgrader 创建 WebSocket 升级器，compression 控制是否接受 permessage-deflate 协商
+func newUpgrader(compression bool) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true // 开发阶段允许所有来源
+		},
+		ReadBufferSize:    1024 * 64,  // 64KB 读缓冲区
+		WriteBufferSize:   1024 * 256, // 256KB 写缓冲区（视频帧较大）
+		EnableCompression: compression,
+		Subprotocols:      protocol.Subprotocols,
+	}
+}
+
+// meteredConn 统计实际写入底层连接的字节数
+type meteredConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// meteredWriter 包装 ResponseWriter，Hijack 时返回 meteredConn
+type meteredWriter struct {
+	http.ResponseWriter
+	conn *meteredConn
+}
+
+func (w *meteredWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &meteredConn{Conn: conn}
+	return w.conn, brw, nil
+}
+
+// wireMeter 实现 model.WireMeter，把连接的写出字节计入端点统计
+// 设备注册或控制端认证后设置 account，收发字节同时计入流量统计
+type wireMeter struct {
+	conn    *meteredConn
+	stats   *service.WireStats
+	account func(in, out int64)
+}
+
+func (m *wireMeter) BytesWritten() int64 {
+	return m.conn.written.Load()
+}
+
+func (m *wireMeter) Record(message bool, payload, wire int64) {
+	if m.account != nil {
+		m.account(0, wire)
+	}
+	if !message {
+		m.stats.RecordBinary(payload)
+		return
+	}
+	m.stats.RecordText(payload, wire)
+}
+
+// received 记录收到的消息字节数（不含帧头）
+func (m *wireMeter) received(n int) {
+	if m != nil && m.account != nil {
+		m.account(int64(n), 0)
+	}
+}
+
+// readMessage 读取一条消息，MessagePack 编码的协议消息转换为 JSON 文本消息
+// 返回的二进制消息均为屏幕帧
+func readMessage(conn *websocket.Conn, meter *wireMeter, encoding string) (int, []byte, error) {
+	messageType, data, err := conn.ReadMessage()
+	if err == nil {
+		meter.received(len(data))
+	}
+	if err != nil || messageType != websocket.BinaryMessage || encoding != protocol.EncodingMsgpack || !protocol.IsMsgpackMessage(data) {
+		return messageType, data, err
+	}
+	decoded, err := protocol.MsgpackToJSON(data)
+	if err != nil {
+		// 交由调用方按格式错误处理
+		return websocket.TextMessage, data, nil
+	}
+	return websocket.TextMessage, decoded, nil
+}
+
+// upgrade 升级连接并返回对应端点的发送统计器
+func upgrade(upgrader *websocket.Upgrader, stats *service.WireStats, w http.ResponseWriter, r *http.Request) (*websocket.Conn, *wireMeter, error) {
+	mw := &meteredWriter{ResponseWriter: w}
+	conn, err := upgrader.Upgrade(mw, r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, &wireMeter{conn: mw.conn, stats: stats}, nil
+}
@@ -0,0 +1,294 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Timescale fMP4 视频轨时间刻度（90kHz）
+const Timescale = 90000
+
+// 样本标志（trun sample_flags）
+const (
+	sampleFlagsKey    = 0x02000000 // sample_depends_on=2（不依赖其他帧）
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+)
+
+var errMissingParameterSets = errors.New("missing sps/pps")
+
+// Sample 一帧视频样本（AVCC 格式，4 字节长度前缀）
+type Sample struct {
+	Data     []byte
+	Duration uint32
+	Key      bool
+}
+
+// Fragment 一个 fMP4 分片（moof + mdat）
+type Fragment struct {
+	Data     []byte
+	BaseTime uint64 // 分片起始解码时间（Timescale）
+	Duration uint64 // 分片时长（Timescale）
+	Key      bool   // 分片是否以关键帧开始
+	Samples  int
+}
+
+// Muxer 将 Annex-B H264 帧封装为 fMP4
+// 分片在关键帧处切分；MaxFragment 大于 0 时分片时长超过该值也会切分
+type Muxer struct {
+	MaxFragment time.Duration
+
+	sps, pps      []byte
+	width, height int
+
+	seq      uint32
+	start    time.Time
+	nextTime uint64 // 下一个分片的起始解码时间
+	pending  []Sample
+	pendDur  uint64
+	cur      *Sample
+	curAt    time.Time
+}
+
+// NewMuxer 使用配置帧（SPS/PPS）创建封装器
+func NewMuxer(config []byte) (*Muxer, error) {
+	sps, pps := ExtractParameterSets(config)
+	if sps == nil || pps == nil {
+		return nil, errMissingParameterSets
+	}
+	width, height, err := ParseSPS(sps)
+	if err != nil {
+		return nil, err
+	}
+	return &Muxer{
+		sps:    sps,
+		pps:    pps,
+		width:  width,
+		height: height,
+	}, nil
+}
+
+// Size 视频宽高
+func (m *Muxer) Size() (int, int) {
+	return m.width, m.height
+}
+
+// SameConfig 配置帧中的参数集是否与当前一致
+func (m *Muxer) SameConfig(config []byte) bool {
+	sps, pps := ExtractParameterSets(config)
+	return string(sps) == string(m.sps) && string(pps) == string(m.pps)
+}
+
+// Init 返回初始化分片（ftyp + moov）
+func (m *Muxer) Init() []byte {
+	return InitSegment(m.sps, m.pps, m.width, m.height)
+}
+
+// Push 写入一帧 Annex-B 数据，凑满一个分片时返回该分片
+func (m *Muxer) Push(frame []byte, key bool, at time.Time) *Fragment {
+	data := annexBToAVCC(frame)
+	if len(data) == 0 {
+		return nil
+	}
+	if m.start.IsZero() {
+		if !key {
+			// 分片流必须从关键帧开始
+			return nil
+		}
+		m.start = at
+	}
+
+	var out *Fragment
+	if m.cur != nil {
+		m.cur.Duration = durationTicks(at.Sub(m.curAt))
+		m.pending = append(m.pending, *m.cur)
+		m.pendDur += uint64(m.cur.Duration)
+
+		if key || (m.MaxFragment > 0 && m.pendDur >= uint64(m.MaxFragment.Seconds()*Timescale)) {
+			out = m.flush()
+		}
+	}
+
+	m.cur = &Sample{Data: data, Key: key}
+	m.curAt = at
+	return out
+}
+
+// Flush 输出所有已缓存的样本（结束录制时调用）
+func (m *Muxer) Flush() *Fragment {
+	if m.cur != nil {
+		// 最后一帧时长未知，沿用上一帧时长
+		m.cur.Duration = durationTicks(time.Second / 30)
+		if n := len(m.pending); n > 0 {
+			m.cur.Duration = m.pending[n-1].Duration
+		}
+		m.pending = append(m.pending, *m.cur)
+		m.pendDur += uint64(m.cur.Duration)
+		m.cur = nil
+	}
+	return m.flush()
+}
+
+func (m *Muxer) flush() *Fragment {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	m.seq++
+	frag := &Fragment{
+		Data:     BuildFragment(m.seq, m.nextTime, m.pending),
+		BaseTime: m.nextTime,
+		Duration: m.pendDur,
+		Key:      m.pending[0].Key,
+		Samples:  len(m.pending),
+	}
+	m.nextTime += m.pendDur
+	m.pending = nil
+	m.pendDur = 0
+	return frag
+}
+
+func durationTicks(d time.Duration) uint32 {
+	ticks := d * Timescale / time.Second
+	if ticks < 1 {
+		ticks = 1
+	}
+	return uint32(ticks)
+}
+
+// annexBToAVCC 转换为长度前缀格式，参数集和 AUD 放在 avcC 中，不写入样本
+func annexBToAVCC(frame []byte) []byte {
+	var out []byte
+	for _, nal := range SplitNALUnits(frame) {
+		switch NALType(nal) {
+		case NALTypeSPS, NALTypePPS, NALTypeAUD:
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nal)))
+		out = append(out, nal...)
+	}
+	return out
+}
+
+// InitSegment 生成 fMP4 初始化分片
+func InitSegment(sps, pps []byte, width, height int) []byte {
+	ftyp := box("ftyp", []byte("iso5"), u32(512), []byte("iso5iso6mp41avc1"))
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(Timescale), u32(0), // creation, modification, timescale, duration
+		u32(0x00010000), u16(0x0100), make([]byte, 10), // rate, volume, reserved
+		matrix(), make([]byte, 24), u32(2), // pre_defined, next_track_ID
+	)
+
+	tkhd := fullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(1), u32(0), u32(0), // creation, modification, track_ID, reserved, duration
+		make([]byte, 8), u16(0), u16(0), u16(0), u16(0), // reserved, layer, alternate_group, volume, reserved
+		matrix(), u32(uint32(width)<<16), u32(uint32(height)<<16),
+	)
+
+	mdhd := fullBox("mdhd", 0, 0, u32(0), u32(0), u32(Timescale), u32(0), u16(0x55c4), u16(0))
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	avcC := box("avcC",
+		[]byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1},
+		u16(uint16(len(sps))), sps,
+		[]byte{1}, u16(uint16(len(pps))), pps,
+	)
+	avc1 := box("avc1",
+		make([]byte, 6), u16(1), // reserved, data_reference_index
+		make([]byte, 16), u16(uint16(width)), u16(uint16(height)),
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1), // 分辨率 72dpi, reserved, frame_count
+		make([]byte, 32), u16(0x0018), u16(0xffff), // compressorname, depth, pre_defined
+		avcC,
+	)
+
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), avc1),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)),
+	)
+	minf := box("minf",
+		fullBox("vmhd", 0, 1, u16(0), make([]byte, 6)),
+		box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1))),
+		stbl,
+	)
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, minf))
+	mvex := box("mvex", fullBox("trex", 0, 0, u32(1), u32(1), u32(0), u32(0), u32(0)))
+
+	return append(ftyp, box("moov", mvhd, trak, mvex)...)
+}
+
+// BuildFragment 生成 moof + mdat
+func BuildFragment(seq uint32, baseTime uint64, samples []Sample) []byte {
+	moof := buildMoof(seq, baseTime, samples, 0)
+	// data_offset 指向 mdat 负载（moof 之后 + 8 字节 mdat 头）
+	moof = buildMoof(seq, baseTime, samples, uint32(len(moof)+8))
+
+	payload := make([][]byte, len(samples))
+	for i, s := range samples {
+		payload[i] = s.Data
+	}
+	return append(moof, box("mdat", payload...)...)
+}
+
+func buildMoof(seq uint32, baseTime uint64, samples []Sample, dataOffset uint32) []byte {
+	entries := make([]byte, 0, len(samples)*12)
+	for _, s := range samples {
+		flags := uint32(sampleFlagsNonKey)
+		if s.Key {
+			flags = sampleFlagsKey
+		}
+		entries = append(entries, u32(s.Duration)...)
+		entries = append(entries, u32(uint32(len(s.Data)))...)
+		entries = append(entries, u32(flags)...)
+	}
+
+	return box("moof",
+		fullBox("mfhd", 0, 0, u32(seq)),
+		box("traf",
+			fullBox("tfhd", 0, 0x020000, u32(1)), // default-base-is-moof
+			fullBox("tfdt", 1, 0, u64(baseTime)),
+			fullBox("trun", 0, 0x000701, u32(uint32(len(samples))), u32(dataOffset), entries),
+		),
+	)
+}
+
+func box(name string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, u32(uint32(size))...)
+	out = append(out, name...)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func fullBox(name string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(name, append([][]byte{header}, parts...)...)
+}
+
+func matrix() []byte {
+	var out []byte
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
@@ -0,0 +1,235 @@
+package media
+
+import (
+	"errors"
+)
+
+// H264 NAL 单元类型
+const (
+	NALTypeSlice = 1
+	NALTypeIDR   = 5
+	NALTypeSEI   = 6
+	NALTypeSPS   = 7
+	NALTypePPS   = 8
+	NALTypeAUD   = 9
+)
+
+var errInvalidSPS = errors.New("invalid sps")
+
+// SplitNALUnits 将 Annex-B 格式数据（00 00 01 / 00 00 00 01 起始码）拆分为 NAL 单元
+func SplitNALUnits(data []byte) [][]byte {
+	var units [][]byte
+	start := -1
+	i := 0
+	for i+2 < len(data) {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				units = appendNAL(units, data[start:i])
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 {
+		units = appendNAL(units, data[start:])
+	} else if len(data) > 0 {
+		// 没有起始码，视为单个 NAL
+		units = append(units, data)
+	}
+	return units
+}
+
+// appendNAL 去掉四字节起始码遗留的前导 0 后追加
+func appendNAL(units [][]byte, nal []byte) [][]byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	if len(nal) > 0 {
+		units = append(units, nal)
+	}
+	return units
+}
+
+// NALType 返回 NAL 单元类型
+func NALType(nal []byte) byte {
+	if len(nal) == 0 {
+		return 0
+	}
+	return nal[0] & 0x1f
+}
+
+// ExtractParameterSets 从配置帧中提取 SPS 和 PPS
+func ExtractParameterSets(data []byte) (sps, pps []byte) {
+	for _, nal := range SplitNALUnits(data) {
+		switch NALType(nal) {
+		case NALTypeSPS:
+			sps = nal
+		case NALTypePPS:
+			pps = nal
+		}
+	}
+	return sps, pps
+}
+
+// ParseSPS 解析 SPS 得到视频宽高
+func ParseSPS(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errInvalidSPS
+	}
+	r := &bitReader{data: removeEmulationPrevention(sps[1:])}
+
+	profileIdc := r.u(8)
+	r.u(16) // constraint flags + level_idc
+	r.ue()  // seq_parameter_set_id
+
+	chromaFormatIdc := uint(1)
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc = r.ue()
+		if chromaFormatIdc == 3 {
+			r.u(1) // separate_colour_plane_flag
+		}
+		r.ue()           // bit_depth_luma_minus8
+		r.ue()           // bit_depth_chroma_minus8
+		r.u(1)           // qpprime_y_zero_transform_bypass_flag
+		if r.u(1) == 1 { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormatIdc == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.u(1) == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+
+	r.ue()          // log2_max_frame_num_minus4
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.u(1) // delta_pic_order_always_zero_flag
+		r.se() // offset_for_non_ref_pic
+		r.se() // offset_for_top_to_bottom_field
+		n := r.ue()
+		for i := uint(0); i < n && !r.failed; i++ {
+			r.se()
+		}
+	}
+	r.ue() // max_num_ref_frames
+	r.u(1) // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := int(r.u(1))
+	if frameMbsOnly == 0 {
+		r.u(1) // mb_adaptive_frame_field_flag
+	}
+	r.u(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if r.u(1) == 1 {
+		cropLeft = int(r.ue())
+		cropRight = int(r.ue())
+		cropTop = int(r.ue())
+		cropBottom = int(r.ue())
+	}
+	if r.failed {
+		return 0, 0, errInvalidSPS
+	}
+
+	cropUnitX, cropUnitY := 1, 2-frameMbsOnly
+	switch chromaFormatIdc {
+	case 1:
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropUnitX = 2
+	}
+
+	width = widthMbs*16 - (cropLeft+cropRight)*cropUnitX
+	height = (2-frameMbsOnly)*heightMapUnits*16 - (cropTop+cropBottom)*cropUnitY
+	if width <= 0 || height <= 0 {
+		return 0, 0, errInvalidSPS
+	}
+	return width, height, nil
+}
+
+func skipScalingList(r *bitReader, size int) {
+	last, next := 8, 8
+	for j := 0; j < size && !r.failed; j++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// removeEmulationPrevention 去除 00 00 03 中的防竞争字节
+func removeEmulationPrevention(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// bitReader 按位读取 RBSP，越界时置 failed
+type bitReader struct {
+	data   []byte
+	pos    int
+	failed bool
+}
+
+func (r *bitReader) u(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.failed = true
+			return 0
+		}
+		bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+		v = v<<1 | uint(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.u(1) == 0 {
+		if r.failed || zeros > 31 {
+			r.failed = true
+			return 0
+		}
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + r.u(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const frameWriteWait = 5 * time.Second // 队列写协程的单帧写超时
+
+// FrameObserver 帧发送队列的回调，在写协程或入队方调用，实现需并发安全
+type FrameObserver interface {
+	FrameSent(c *Controller, latency time.Duration) // 帧已写出，latency 含排队时间
+	FramesDropped(c *Controller, count int)         // 因拥塞丢弃的帧数
+	NeedKeyframe(c *Controller)                     // 丢帧后需要关键帧重新同步
+}
+
+type queuedFrame struct {
+	data []byte
+	at   time.Time
+}
+
+// frameQueue 控制端有界发送队列
+// 拥塞时 H264 按 GOP 丢弃：从丢弃点到下一个关键帧之间的帧全部丢弃，不会出现孤立缺失的 P 帧；
+// 配置帧不丢弃；MJPEG 每帧独立，丢弃最旧的帧
+type frameQueue struct {
+	frames   []queuedFrame
+	limit    int
+	waitKey  bool // 已丢弃 H264 帧，等待下一个关键帧
+	closed   bool
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	observer FrameObserver
+}
+
+// StartFrameWriter 启用有界发送队列和独立写协程，之后 SendBinary 只入队不阻塞
+func (c *Controller) StartFrameWriter(limit int, observer FrameObserver) {
+	q := &frameQueue{limit: limit, observer: observer}
+	q.cond = sync.NewCond(&q.mutex)
+	c.frames = q
+	go c.writeFrames(q)
+}
+
+// StopFrameWriter 关闭发送队列，写协程退出
+func (c *Controller) StopFrameWriter() {
+	if q := c.frames; q != nil {
+		q.mutex.Lock()
+		q.closed = true
+		q.frames = nil
+		q.mutex.Unlock()
+		q.cond.Broadcast()
+	}
+}
+
+// push 入队，返回本帧是否被丢弃
+func (q *frameQueue) push(c *Controller, data []byte) bool {
+	frameType, flags, _, ok := protocol.ParseFrame(data)
+	if !ok {
+		return true
+	}
+
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return true
+	}
+
+	dropped, resync := 0, false
+	accept := true
+	switch {
+	case frameType == protocol.BinaryTypeH264Config:
+		// 配置帧很小且丢失后无法解码，始终入队（允许超出上限）
+
+	case frameType == protocol.BinaryTypeH264Frame && flags&protocol.FrameFlagKeyFrame != 0:
+		if len(q.frames) >= q.limit {
+			// 新 GOP 开始，队列中的旧帧已无意义
+			dropped += q.dropMedia()
+		}
+		q.waitKey = false
+
+	case frameType == protocol.BinaryTypeH264Frame:
+		if q.waitKey {
+			accept = false
+		} else if len(q.frames) >= q.limit {
+			// 丢弃本帧及之后直到下一个关键帧的所有帧
+			accept = false
+			q.waitKey = true
+			resync = true
+		}
+
+	default:
+		if len(q.frames) >= q.limit {
+			dropped += q.dropOldest()
+		}
+	}
+
+	if accept {
+		q.frames = append(q.frames, queuedFrame{data: data, at: time.Now()})
+		q.cond.Signal()
+	} else {
+		dropped++
+	}
+	q.mutex.Unlock()
+
+	if q.observer != nil {
+		if dropped > 0 {
+			q.observer.FramesDropped(c, dropped)
+		}
+		if resync {
+			q.observer.NeedKeyframe(c)
+		}
+	}
+	return !accept
+}
+
+// dropMedia 丢弃队列中除配置帧外的所有帧
+func (q *frameQueue) dropMedia() int {
+	kept := q.frames[:0]
+	dropped := 0
+	for _, f := range q.frames {
+		if frameType, _, _, _ := protocol.ParseFrame(f.data); frameType == protocol.BinaryTypeH264Config {
+			kept = append(kept, f)
+			continue
+		}
+		dropped++
+	}
+	q.frames = kept
+	return dropped
+}
+
+// dropOldest 丢弃最旧的非配置帧
+func (q *frameQueue) dropOldest() int {
+	for i, f := range q.frames {
+		if frameType, _, _, _ := protocol.ParseFrame(f.data); frameType != protocol.BinaryTypeH264Config {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			return 1
+		}
+	}
+	return 0
+}
+
+func (q *frameQueue) pop() (queuedFrame, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.frames) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return queuedFrame{}, false
+	}
+	f := q.frames[0]
+	q.frames[0] = queuedFrame{}
+	q.frames = q.frames[1:]
+	return f, true
+}
+
+// writeFrames 写协程：依次写出队列中的帧，写失败后关闭队列（读循环会随连接关闭退出）
+func (c *Controller) writeFrames(q *frameQueue) {
+	for {
+		f, ok := q.pop()
+		if !ok {
+			return
+		}
+		c.ConnMutex.Lock()
+		c.Conn.SetWriteDeadline(time.Now().Add(frameWriteWait))
+		err := writeMessage(c.Conn, c.Meter, c.Encoding, websocket.BinaryMessage, f.data)
+		c.ConnMutex.Unlock()
+		if err != nil {
+			c.StopFrameWriter()
+			return
+		}
+		if q.observer != nil {
+			q.observer.FrameSent(c, time.Since(f.at))
+		}
+	}
+}
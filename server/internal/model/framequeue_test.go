@@ -0,0 +1,86 @@
+package model
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// countingObserver 统计丢帧数和关键帧请求次数
+type countingObserver struct {
+	dropped int
+	resyncs int
+	sent    int
+}
+
+func (o *countingObserver) FrameSent(c *Controller, latency time.Duration) { o.sent++ }
+func (o *countingObserver) FramesDropped(c *Controller, count int)         { o.dropped += count }
+func (o *countingObserver) NeedKeyframe(c *Controller)                     { o.resyncs++ }
+
+// 测试帧的负载只有一个字节的编号，用于检查队列中剩下哪些帧
+func config(n byte) []byte { return []byte{protocol.BinaryTypeH264Config, 0, n} }
+func key(n byte) []byte    { return []byte{protocol.BinaryTypeH264Frame, protocol.FrameFlagKeyFrame, n} }
+func delta(n byte) []byte  { return []byte{protocol.BinaryTypeH264Frame, 0, n} }
+func mjpeg(n byte) []byte  { return []byte{protocol.BinaryTypeScreenFrame, 0, n} }
+func jpeg(n byte) []byte   { return []byte{0xff, 0xd8, n} }
+
+func TestFrameQueuePush(t *testing.T) {
+	cases := []struct {
+		name     string
+		frames   [][]byte
+		queued   []byte // 队列中剩余帧的编号
+		rejected int    // push 返回 true 的帧数
+		dropped  int    // 上报的丢帧数（含已入队后被丢弃的帧）
+		resyncs  int
+	}{
+		{"under limit", [][]byte{config(1), key(2), delta(3)}, []byte{1, 2, 3}, 0, 0, 0},
+		{"keyframe under limit keeps queue", [][]byte{key(1), delta(2), key(3)}, []byte{1, 2, 3}, 0, 0, 0},
+		{"delta overflow drops rest of GOP", [][]byte{key(1), delta(2), delta(3), delta(4), delta(5), key(6)}, []byte{6}, 2, 5, 1},
+		{"keyframe flush keeps config frames", [][]byte{config(1), key(2), delta(3), delta(4), config(5), key(6)}, []byte{1, 5, 6}, 1, 3, 1},
+		{"config frame beyond limit", [][]byte{key(1), delta(2), delta(3), config(4)}, []byte{1, 2, 3, 4}, 0, 0, 0},
+		{"delta after keyframe resync", [][]byte{key(1), delta(2), delta(3), delta(4), key(5), delta(6)}, []byte{5, 6}, 1, 4, 1},
+		{"mjpeg drops oldest", [][]byte{mjpeg(1), mjpeg(2), mjpeg(3), mjpeg(4)}, []byte{2, 3, 4}, 0, 1, 0},
+		{"mjpeg skips config frames", [][]byte{config(1), mjpeg(2), mjpeg(3), mjpeg(4)}, []byte{1, 3, 4}, 0, 1, 0},
+		{"raw jpeg drops oldest", [][]byte{jpeg(1), jpeg(2), jpeg(3), jpeg(4), jpeg(5)}, []byte{3, 4, 5}, 0, 2, 0},
+		{"malformed frame", [][]byte{{protocol.BinaryTypeH264Frame}, key(2)}, []byte{2}, 1, 0, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			observer := &countingObserver{}
+			q := &frameQueue{limit: 3, observer: observer}
+			q.cond = sync.NewCond(&q.mutex)
+
+			rejected := 0
+			for _, f := range tc.frames {
+				if q.push(nil, f) {
+					rejected++
+				}
+			}
+
+			var queued []byte
+			for _, f := range q.frames {
+				queued = append(queued, f.data[len(f.data)-1])
+			}
+			if string(queued) != string(tc.queued) {
+				t.Fatalf("队列 %v, 期望 %v", queued, tc.queued)
+			}
+			if rejected != tc.rejected || observer.dropped != tc.dropped || observer.resyncs != tc.resyncs {
+				t.Fatalf("rejected=%d dropped=%d resyncs=%d, 期望 %d %d %d",
+					rejected, observer.dropped, observer.resyncs, tc.rejected, tc.dropped, tc.resyncs)
+			}
+		})
+	}
+}
+
+func TestFrameQueueClosed(t *testing.T) {
+	q := &frameQueue{limit: 3, closed: true}
+	q.cond = sync.NewCond(&q.mutex)
+	if !q.push(nil, key(1)) || len(q.frames) != 0 {
+		t.Fatal("关闭后的队列不应接收帧")
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("关闭后的队列 pop 应立即返回")
+	}
+}
@@ -1,14 +1,32 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
 )
 
 const writeWait = 1 * time.Second // 写超时（缩短以避免阻塞）
 
+// ErrNotConnected 连接已断开（设备重连宽限期内会出现）
+var ErrNotConnected = errors.New("connection closed")
+
+// ErrFrameDropped 连接正忙，帧被丢弃（不影响连接）
+var ErrFrameDropped = errors.New("frame dropped")
+
+// WireMeter 统计连接发送的消息字节数和实际写出字节数（压缩后）
+// message 为 true 表示协议消息（参与压缩），否则为屏幕帧
+type WireMeter interface {
+	BytesWritten() int64
+	Record(message bool, payload, wire int64)
+}
+
 // Device 设备实体
 type Device struct {
 	ID           string
@@ -18,8 +36,16 @@ type Device struct {
 	Token        string
 	Conn         *websocket.Conn
 	ConnMutex    sync.Mutex
+	Meter        WireMeter // 发送统计，可为空
+	Encoding     string    // 协议消息编码（protocol.EncodingJSON / EncodingMsgpack）
 	LastSeen     time.Time
 	Online       bool
+
+	ConsentRequired bool // 控制前需现场用户同意
+
+	ProtocolVersion int      // 设备协议版本（0 表示旧版设备）
+	Capabilities    []string // 设备支持的能力
+	Commands        []string // 设备支持的 input.command 命令
 }
 
 // Controller 控制端实体
@@ -27,10 +53,15 @@ type Controller struct {
 	ID              string
 	Conn            *websocket.Conn
 	ConnMutex       sync.Mutex
-	SessionID       string // 当前控制的会话ID
-	DeviceID        string // 当前控制的设备ID
-	AllowedDeviceID string // Token允许控制的设备ID
-	DisplayName     string // 展示用设备名称（别名优先）
+	Meter           WireMeter // 发送统计，可为空
+	Encoding        string    // 协议消息编码（protocol.EncodingJSON / EncodingMsgpack）
+	SessionID       string    // 当前控制的会话ID
+	DeviceID        string    // 当前控制的设备ID
+	AllowedDeviceID string    // Token允许控制的设备ID
+	DisplayName     string    // 展示用设备名称（别名优先）
+	ConsentRequired bool      // 设备要求现场用户同意后才授予控制
+
+	frames *frameQueue // 屏幕帧发送队列（StartFrameWriter 启用）
 }
 
 // Session 控制会话
@@ -42,30 +73,125 @@ type Session struct {
 	Controller   *Controller
 	CreatedAt    time.Time
 	Active       bool
+	Pending      bool // 等待现场用户同意，尚未授予控制
+
+	Reconnecting      bool                          // 设备断线，等待重连
+	ReconnectDeadline time.Time                     // 重连宽限期截止时间
+	StreamParams      protocol.StreamControlMessage // 最近一次推流参数，重连后按此恢复推流
+
+	StreamProfile  *protocol.StreamProfile  // 当前推流配置，未分配时为空
+	StreamProfiles []protocol.StreamProfile // 控制端可切换的推流配置
+	StreamRegion   *protocol.StreamRegion   // 控制端指定的推流区域（裁剪/旋转/输出分辨率），未指定时为空
+}
+
+// Macro 录制的输入宏
+type Macro struct {
+	Name         string       `json:"name"`
+	ScreenWidth  int          `json:"screenWidth"`  // 录制时设备屏幕宽度（回放时按比例缩放坐标）
+	ScreenHeight int          `json:"screenHeight"` // 录制时设备屏幕高度
+	Events       []MacroEvent `json:"events"`
+	CreatedAt    time.Time    `json:"createdAt"`
+}
+
+// 推流配置分配范围，优先级 设备 > 分组 > 全局
+const (
+	StreamScopeDevice = "device"
+	StreamScopeGroup  = "group"
+	StreamScopeGlobal = "global"
+)
+
+// StreamAssignment 推流配置分配
+type StreamAssignment struct {
+	Scope     string    `json:"scope"`
+	TargetID  string    `json:"targetId,omitempty"` // 设备ID或分组ID（rc_devices.group_id），全局为空
+	Profile   string    `json:"profile"`            // 会话开始时使用的配置
+	Allowed   []string  `json:"allowed"`            // 控制端还可切换的配置
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// 月度流量配额用尽后的处理方式
+const (
+	QuotaActionDowngrade = "downgrade" // 会话切换到配额指定的推流配置
+	QuotaActionRefuse    = "refuse"    // 拒绝新会话并结束进行中的会话
+)
+
+// BandwidthUsage 以服务端为视角的收发字节数
+type BandwidthUsage struct {
+	BytesIn  int64 `json:"bytesIn"`  // 服务端收到的字节数
+	BytesOut int64 `json:"bytesOut"` // 服务端发出的字节数
+}
+
+// Total 收发合计
+func (u BandwidthUsage) Total() int64 {
+	return u.BytesIn + u.BytesOut
+}
+
+// BandwidthDaily 设备一天内经服务端中转的流量
+type BandwidthDaily struct {
+	DeviceID   string         `json:"deviceId"`
+	Day        string         `json:"day"`        // 2006-01-02（服务端本地时区）
+	Device     BandwidthUsage `json:"device"`     // 设备链路
+	Controller BandwidthUsage `json:"controller"` // 控制该设备的控制端和 HTTP 观看者链路
+}
+
+// BandwidthQuota 设备的月度流量配额，按设备链路收发合计（自然月）
+type BandwidthQuota struct {
+	DeviceID     string    `json:"deviceId"`
+	MonthlyBytes int64     `json:"monthlyBytes"`
+	Action       string    `json:"action"`            // downgrade / refuse
+	Profile      string    `json:"profile,omitempty"` // downgrade 时切换到的推流配置
+	UpdatedAt    time.Time `json:"updatedAt"`
+
+	Downgrade *protocol.StreamProfile `json:"-"` // Profile 对应的配置，查询设备配额时填充
+}
+
+// MacroEvent 宏中的一条输入消息
+type MacroEvent struct {
+	OffsetMs int64           `json:"offsetMs"` // 相对录制开始的时间
+	Message  json.RawMessage `json:"message"`  // input.touch / input.key / input.text 原始消息
+}
+
+// SupportsCommand 设备是否支持指定的 input.command 命令
+func (d *Device) SupportsCommand(command string) bool {
+	return slices.Contains(d.Commands, command)
+}
+
+// HasCapability 设备是否具备指定能力
+func (d *Device) HasCapability(capability string) bool {
+	return slices.Contains(d.Capabilities, capability)
 }
 
 // SendJSON 线程安全地发送JSON消息
 func (d *Device) SendJSON(v interface{}) error {
 	d.ConnMutex.Lock()
 	defer d.ConnMutex.Unlock()
+	if d.Conn == nil {
+		return ErrNotConnected
+	}
 	d.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return d.Conn.WriteJSON(v)
+	return writeJSON(d.Conn, d.Meter, d.Encoding, v)
 }
 
 // SendText 线程安全地发送文本消息
 func (d *Device) SendText(data []byte) error {
 	d.ConnMutex.Lock()
 	defer d.ConnMutex.Unlock()
+	if d.Conn == nil {
+		return ErrNotConnected
+	}
 	d.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return d.Conn.WriteMessage(websocket.TextMessage, data)
+	return writeMessage(d.Conn, d.Meter, d.Encoding, websocket.TextMessage, data)
 }
 
 // SendBinary 线程安全地发送二进制消息
 func (d *Device) SendBinary(data []byte) error {
 	d.ConnMutex.Lock()
 	defer d.ConnMutex.Unlock()
+	if d.Conn == nil {
+		return ErrNotConnected
+	}
 	d.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return d.Conn.WriteMessage(websocket.BinaryMessage, data)
+	return writeMessage(d.Conn, d.Meter, d.Encoding, websocket.BinaryMessage, data)
 }
 
 // SendJSON 线程安全地发送JSON消息
@@ -73,18 +199,78 @@ func (c *Controller) SendJSON(v interface{}) error {
 	c.ConnMutex.Lock()
 	defer c.ConnMutex.Unlock()
 	c.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	return c.Conn.WriteJSON(v)
+	return writeJSON(c.Conn, c.Meter, c.Encoding, v)
 }
 
-// SendBinary 线程安全地发送二进制消息（非阻塞，超时丢帧）
+// SendText 线程安全地发送文本消息
+func (c *Controller) SendText(data []byte) error {
+	c.ConnMutex.Lock()
+	defer c.ConnMutex.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return writeMessage(c.Conn, c.Meter, c.Encoding, websocket.TextMessage, data)
+}
+
+// SendBinary 线程安全地发送屏幕帧（非阻塞）
+// 启用发送队列后只入队，拥塞时按 GOP 丢帧；否则连接忙时直接丢弃本帧
 func (c *Controller) SendBinary(data []byte) error {
+	if c.frames != nil {
+		if c.frames.push(c, data) {
+			return ErrFrameDropped
+		}
+		return nil
+	}
+
 	// 尝试获取锁，如果获取不到就丢弃这一帧
 	locked := c.ConnMutex.TryLock()
 	if !locked {
-		return nil // 丢弃帧，避免阻塞
+		return ErrFrameDropped // 丢弃帧，避免阻塞
 	}
 	defer c.ConnMutex.Unlock()
 
 	c.Conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)) // 100ms 超时
-	return c.Conn.WriteMessage(websocket.BinaryMessage, data)
+	return writeMessage(c.Conn, c.Meter, c.Encoding, websocket.BinaryMessage, data)
+}
+
+// writeJSON 序列化后按协议消息发送
+// MessagePack 连接直接按结构体编码，不经过 JSON 中转
+func writeJSON(conn *websocket.Conn, meter WireMeter, encoding string, v interface{}) error {
+	if encoding == protocol.EncodingMsgpack {
+		data, err := protocol.MarshalMsgpack(v)
+		if err != nil {
+			return err
+		}
+		return writeRaw(conn, meter, true, websocket.BinaryMessage, data)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeRaw(conn, meter, true, websocket.TextMessage, data)
+}
+
+// writeMessage 发送消息并记录统计
+// 文本消息为 JSON 协议消息，MessagePack 连接转换后以二进制消息发送；二进制帧（视频）不参与压缩
+// 调用方需持有 ConnMutex，因此前后写出字节差即为本条消息的实际大小
+func writeMessage(conn *websocket.Conn, meter WireMeter, encoding string, messageType int, data []byte) error {
+	message := messageType == websocket.TextMessage
+	if message && encoding == protocol.EncodingMsgpack {
+		encoded, err := protocol.JSONToMsgpack(data)
+		if err != nil {
+			return err
+		}
+		data, messageType = encoded, websocket.BinaryMessage
+	}
+	return writeRaw(conn, meter, message, messageType, data)
+}
+
+// writeRaw 写出已编码的消息，message 表示协议消息（启用压缩并按协议消息统计）
+func writeRaw(conn *websocket.Conn, meter WireMeter, message bool, messageType int, data []byte) error {
+	conn.EnableWriteCompression(message)
+	if meter == nil {
+		return conn.WriteMessage(messageType, data)
+	}
+	before := meter.BytesWritten()
+	err := conn.WriteMessage(messageType, data)
+	meter.Record(message, int64(len(data)), meter.BytesWritten()-before)
+	return err
 }
@@ -0,0 +1,58 @@
+package protocol
+
+// ProtocolVersion 当前协议版本
+// 设备注册时未携带版本号视为版本 0（旧版客户端），按 LegacyCapabilities 处理
+const ProtocolVersion = 1
+
+// 设备能力
+const (
+	CapH264       = "h264"       // H264 推流
+	CapMJPEG      = "mjpeg"      // MJPEG 推流
+	CapWebRTC     = "webrtc"     // WebRTC 推流
+	CapPrivacy    = "privacy"    // 隐私屏
+	CapClipboard  = "clipboard"  // 剪贴板同步
+	CapCommand    = "command"    // input.command 设备命令
+	CapChat       = "chat"       // 聊天消息
+	CapConsent    = "consent"    // 现场用户同意控制
+	CapAck        = "ack"        // 对携带 requestId 的消息回复 command.result
+	CapMultiTouch = "multitouch" // input.touchframe 多点触控
+	CapKeyframe   = "keyframe"   // 响应 stream.keyframe
+	CapThumbnail  = "thumbnail"  // 响应 screen.thumbnail
+	CapRegion     = "region"     // stream.start 裁剪、旋转和输出分辨率
+)
+
+// LegacyCapabilities 未上报能力的旧版设备默认具备的能力
+var LegacyCapabilities = []string{CapH264, CapMJPEG, CapWebRTC, CapPrivacy, CapClipboard, CapCommand}
+
+// LegacyCommands 具备 command 能力但未上报命令列表的设备默认支持的命令
+var LegacyCommands = []string{"hide_keyboard"}
+
+// RequiredCapability 返回控制端消息转发到设备所需的能力，无要求时返回空字符串
+// stream.start 需要的能力取决于推流模式，见 StreamCapability
+func RequiredCapability(msgType string) string {
+	switch msgType {
+	case TypeClipboardSet:
+		return CapClipboard
+	case TypePrivacyEnable, TypePrivacyDisable, TypePrivacyToggle:
+		return CapPrivacy
+	case TypeWebRTCOffer, TypeWebRTCAnswer, TypeWebRTCIce, TypeWebRTCReady:
+		return CapWebRTC
+	case TypeChatMessage:
+		return CapChat
+	case TypeInputCommand:
+		return CapCommand
+	case TypeInputTouchFrame:
+		return CapMultiTouch
+	case TypeStreamKeyframe:
+		return CapKeyframe
+	}
+	return ""
+}
+
+// StreamCapability 返回推流模式所需的能力（未指定模式时设备按 MJPEG 处理）
+func StreamCapability(mode string) string {
+	if mode == "h264" {
+		return CapH264
+	}
+	return CapMJPEG
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 消息编码，通过 WebSocket 子协议（Sec-WebSocket-Protocol）协商
+// 未协商子协议的连接使用 JSON 文本消息
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+
+	SubprotocolJSON    = "shushu.v1.json"
+	SubprotocolMsgpack = "shushu.v1.msgpack"
+)
+
+// Subprotocols 服务端支持的子协议，按优先级排列
+var Subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+// ErrInvalidEncoding 消息无法按协商的编码解析
+var ErrInvalidEncoding = errors.New("invalid message encoding")
+
+// EncodingForSubprotocol 根据协商结果返回消息编码
+func EncodingForSubprotocol(subprotocol string) string {
+	if subprotocol == SubprotocolMsgpack {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+// IsMsgpackMessage 二进制消息是否为 MessagePack 编码的协议消息
+// 协议消息顶层为 map（0x80-0x8f / 0xde / 0xdf），与屏幕帧类型字节（0x01-0x03、JPEG 的 0xff）不冲突
+func IsMsgpackMessage(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	b := data[0]
+	return b&0xf0 == 0x80 || b == 0xde || b == 0xdf
+}
+
+// MarshalMsgpack 将协议消息结构体直接编码为 MessagePack
+// 字段名和 omitempty 沿用 json 标签，与 JSON 编码的字段一致
+func MarshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+	encoder.UseCompactFloats(true)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JSONToMsgpack 将 JSON 协议消息转换为 MessagePack，整数保持整数编码
+// 只用于原样转发的 JSON 文本（如控制端发给设备的消息），服务端生成的消息使用 MarshalMsgpack
+func JSONToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v map[string]interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return MarshalMsgpack(normalizeNumbers(v))
+}
+
+// MsgpackToJSON 将 MessagePack 协议消息转换为 JSON，服务端内部统一按 JSON 处理
+func MsgpackToJSON(data []byte) ([]byte, error) {
+	if !IsMsgpackMessage(data) {
+		return nil, ErrInvalidEncoding
+	}
+	var v map[string]interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeNumbers(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeNumbers(item)
+		}
+		return val
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n
+		}
+		f, _ := val.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	mid := "0"
+	index := uint16(0)
+	cases := []struct {
+		name string
+		msg  interface{}
+	}{
+		{"swipe from origin", TouchMessage{Type: TypeInputTouch, Action: TouchActionSwipe, StartX: 0, StartY: 0, EndX: 300, EndY: 800.5, Duration: 200}},
+		{"tap at origin", TouchMessage{Action: TouchActionTap}},
+		{"stream start with region", StreamControlMessage{Type: TypeStreamStart, Mode: "h264", Bitrate: 2000000, FPS: 30,
+			StreamRegion: StreamRegion{Crop: &CropRect{X: 0, Y: 100, Width: 540, Height: 960}, Rotation: 90, TargetWidth: 1280}}},
+		{"stream start full screen", StreamControlMessage{Type: TypeStreamStart, Mode: "mjpeg", Quality: 80, MaxFPS: 15}},
+		{"control granted", ControlGrantedMessage{Type: TypeControlGranted, DeviceID: "d1", SessionID: "s1", ScreenWidth: 1080, ScreenHeight: 2400,
+			ProtocolVersion: 1, Capabilities: []string{"h264", "ack"}, ICEServers: []ICEServer{{URLs: []string{"stun:example.com:3478"}}}}},
+		{"touch frame", TouchFrameMessage{Type: TypeInputTouchFrame, Action: TouchFrameDown, ActionPointer: 1,
+			Pointers: []TouchPointer{{ID: 0, X: 10, Y: 20}, {ID: 1, X: 30.25, Y: 40, Pressure: 0.5}}}},
+		{"command with args", CommandMessage{Type: TypeInputCommand, RequestID: "r1", Command: "hide_keyboard", Args: map[string]interface{}{"n": 3, "s": "x", "b": true}}},
+		{"ice candidate", ICECandidateMessage{SDPMid: &mid, SDPMLineIndex: &index, Candidate: "candidate:1 1 udp 1 10.0.0.1 5000 typ host"}},
+		{"error", ErrorMessage{Type: TypeError, Code: "DEVICE_BUSY", Message: "设备正在被其他人控制"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData, err := json.Marshal(tc.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := decodeMap(t, jsonData)
+
+			// 结构体直接编码与 JSON 编码的字段一致
+			packed, err := MarshalMsgpack(tc.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsMsgpackMessage(packed) {
+				t.Fatalf("编码结果不是 map: %x", packed[:1])
+			}
+			decoded, err := MsgpackToJSON(packed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeMap(t, decoded); !reflect.DeepEqual(got, want) {
+				t.Fatalf("MarshalMsgpack 往返 = %v, 期望 %v", got, want)
+			}
+
+			// 原样转发的 JSON 文本经转换后同样一致
+			converted, err := JSONToMsgpack(jsonData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err = MsgpackToJSON(converted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeMap(t, decoded); !reflect.DeepEqual(got, want) {
+				t.Fatalf("JSONToMsgpack 往返 = %v, 期望 %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMsgpackRejectsFrames(t *testing.T) {
+	for _, frame := range [][]byte{{BinaryTypeScreenFrame, 0}, {BinaryTypeH264Frame, 1}, {0xff, 0xd8}, {}} {
+		if IsMsgpackMessage(frame) {
+			t.Fatalf("屏幕帧 %x 被识别为协议消息", frame)
+		}
+		if _, err := MsgpackToJSON(frame); err == nil {
+			t.Fatalf("屏幕帧 %x 不应解码为协议消息", frame)
+		}
+	}
+}
+
+func decodeMap(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("解析 %s: %v", data, err)
+	}
+	return v
+}
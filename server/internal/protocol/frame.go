@@ -0,0 +1,17 @@
+package protocol
+
+// ParseFrame 解析设备推送的二进制帧 [type][flags][payload]
+// 旧版设备在 MJPEG 模式下直接发送 JPEG 数据（FF D8 开头），按带关键帧标志的 MJPEG 帧处理
+func ParseFrame(frame []byte) (frameType, flags byte, payload []byte, ok bool) {
+	if len(frame) >= 2 && frame[0] == 0xff && frame[1] == 0xd8 {
+		return BinaryTypeScreenFrame, FrameFlagKeyFrame, frame, true
+	}
+	if len(frame) < 2 {
+		return 0, 0, nil, false
+	}
+	frameType, flags, payload = frame[0], frame[1], frame[2:]
+	if frameType == BinaryTypeScreenFrame {
+		flags |= FrameFlagKeyFrame
+	}
+	return frameType, flags, payload, true
+}
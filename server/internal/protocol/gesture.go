@@ -0,0 +1,162 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// 触摸帧动作（input.touchframe）
+const (
+	TouchFrameDown   = "down"   // 新增触点
+	TouchFrameMove   = "move"   // 触点移动
+	TouchFrameUp     = "up"     // 触点抬起
+	TouchFrameCancel = "cancel" // 取消整个手势
+)
+
+// MaxTouchPointers 触摸帧最多触点数
+const MaxTouchPointers = 10
+
+// Gesture 高层手势，转换为 input.touch 消息发送到设备
+type Gesture interface {
+	Touch() TouchMessage
+}
+
+// TapGesture 点击
+type TapGesture struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// LongPressGesture 长按
+type LongPressGesture struct {
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Duration int     `json:"duration,omitempty"` // 毫秒，设备默认 800
+}
+
+// SwipeGesture 滑动
+type SwipeGesture struct {
+	StartX   float64 `json:"startX"`
+	StartY   float64 `json:"startY"`
+	EndX     float64 `json:"endX"`
+	EndY     float64 `json:"endY"`
+	Duration int     `json:"duration,omitempty"` // 毫秒，设备默认 300
+}
+
+// ScrollGesture 滚动
+type ScrollGesture struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	HScroll float64 `json:"hScroll"`
+	VScroll float64 `json:"vScroll"`
+}
+
+// Touch 转换为 input.touch 消息
+func (g TapGesture) Touch() TouchMessage {
+	return TouchMessage{Type: TypeInputTouch, Action: TouchActionTap, X: g.X, Y: g.Y}
+}
+
+// Touch 转换为 input.touch 消息
+func (g LongPressGesture) Touch() TouchMessage {
+	return TouchMessage{Type: TypeInputTouch, Action: TouchActionLongPress, X: g.X, Y: g.Y, Duration: g.Duration}
+}
+
+// Touch 转换为 input.touch 消息
+func (g SwipeGesture) Touch() TouchMessage {
+	return TouchMessage{
+		Type:     TypeInputTouch,
+		Action:   TouchActionSwipe,
+		StartX:   g.StartX,
+		StartY:   g.StartY,
+		EndX:     g.EndX,
+		EndY:     g.EndY,
+		Duration: g.Duration,
+	}
+}
+
+// Touch 转换为 input.touch 消息
+func (g ScrollGesture) Touch() TouchMessage {
+	return TouchMessage{Type: TypeInputTouch, Action: TouchActionScroll, X: g.X, Y: g.Y, HScroll: g.HScroll, VScroll: g.VScroll}
+}
+
+// ParseGesture 按动作名解析手势参数
+func ParseGesture(action string, data []byte) (Gesture, error) {
+	var g Gesture
+	var err error
+	switch action {
+	case TouchActionTap:
+		var v TapGesture
+		err = json.Unmarshal(data, &v)
+		g = v
+	case TouchActionLongPress:
+		var v LongPressGesture
+		err = json.Unmarshal(data, &v)
+		g = v
+	case TouchActionSwipe:
+		var v SwipeGesture
+		err = json.Unmarshal(data, &v)
+		g = v
+	case TouchActionScroll:
+		var v ScrollGesture
+		err = json.Unmarshal(data, &v)
+		g = v
+	default:
+		return nil, invalid(ErrCodeInvalidAction, "不支持的手势: %q", action)
+	}
+	if err != nil {
+		return nil, invalid(ErrCodeInvalidMessage, "手势参数格式错误: %v", err)
+	}
+	return g, nil
+}
+
+// TouchPointer 触摸帧中的一个触点
+type TouchPointer struct {
+	ID       int     `json:"id"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Pressure float64 `json:"pressure,omitempty"` // 0-1，缺省按 1 处理
+}
+
+// TouchFrameMessage 多点触控帧（需设备具备 multitouch 能力）
+// 每帧携带当前所有触点；down/up 时 ActionPointer 指明新增或抬起的触点
+type TouchFrameMessage struct {
+	Type          string         `json:"type"`
+	SessionID     string         `json:"sessionId,omitempty"`
+	RequestID     string         `json:"requestId,omitempty"`
+	Action        string         `json:"action"`
+	ActionPointer int            `json:"actionPointer"`
+	Pointers      []TouchPointer `json:"pointers"`
+}
+
+// Validate 校验触摸帧，width/height 为设备屏幕尺寸（未知时传 0 跳过坐标范围检查）
+func (m *TouchFrameMessage) Validate(width, height int) error {
+	switch m.Action {
+	case TouchFrameDown, TouchFrameMove, TouchFrameUp:
+		if len(m.Pointers) == 0 {
+			return invalid(ErrCodeInvalidParam, "触摸帧没有触点")
+		}
+	case TouchFrameCancel:
+	default:
+		return invalid(ErrCodeInvalidAction, "不支持的触摸帧动作: %q", m.Action)
+	}
+	if len(m.Pointers) > MaxTouchPointers {
+		return invalid(ErrCodeInvalidParam, "触点数超过 %d", MaxTouchPointers)
+	}
+
+	seen := make(map[int]bool, len(m.Pointers))
+	for _, p := range m.Pointers {
+		if p.ID < 0 || p.ID >= MaxTouchPointers || seen[p.ID] {
+			return invalid(ErrCodeInvalidParam, "触点 ID 无效或重复: %d", p.ID)
+		}
+		seen[p.ID] = true
+		if err := validatePoint(width, height, p.X, p.Y); err != nil {
+			return err
+		}
+		if !inRange(p.Pressure, 0, 1) {
+			return invalid(ErrCodeInvalidParam, "触点压力超出范围 0-1")
+		}
+	}
+	if (m.Action == TouchFrameDown || m.Action == TouchFrameUp) && !seen[m.ActionPointer] {
+		return invalid(ErrCodeInvalidParam, "actionPointer %d 不在触点列表中", m.ActionPointer)
+	}
+	return nil
+}
@@ -1,5 +1,11 @@
 package protocol
 
+import (
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
 // 消息类型常量
 const (
 	// 设备消息
@@ -7,17 +13,29 @@ const (
 	TypeDeviceHeartbeat = "device.heartbeat"
 	TypeScreenFrame     = "screen.frame" // 二进制消息用 0x01 标识
 	TypeClipboardUpdate = "clipboard.update"
+	TypeControlConsent  = "control.consent" // 现场用户对控制请求的答复
+	TypeCommandResult   = "command.result"  // 设备对携带 requestId 消息的执行回执（服务端转发给发起方）
 
 	// 控制端消息
-	TypeControlRequest = "control.request"
-	TypeControlRelease = "control.release"
-	TypeInputTouch     = "input.touch"
-	TypeInputKey       = "input.key"
-	TypeInputText      = "input.text"
-	TypeClipboardSet   = "clipboard.set"
-	TypeStreamStart    = "stream.start"
-	TypeStreamStop     = "stream.stop"
-	TypePing           = "ping"
+	TypeControlRequest  = "control.request"
+	TypeControlRelease  = "control.release"
+	TypeInputTouch      = "input.touch"
+	TypeInputKey        = "input.key"
+	TypeInputText       = "input.text"
+	TypeInputTouchFrame = "input.touchframe" // 多点触控帧
+	TypeInputCommand    = "input.command"    // 设备命令（如 hide_keyboard），命令需在服务端注册
+	TypeCommandList     = "command.list"     // 查询设备支持的命令（服务端回复同类型消息）
+	TypeClipboardSet    = "clipboard.set"
+	TypeStreamStart     = "stream.start"
+	TypeStreamStop      = "stream.stop"
+	TypeStreamKeyframe  = "stream.keyframe"  // 请求设备立即输出关键帧（帧缓存过期时服务端也会发送）
+	TypeStreamStats     = "stream.stats"     // 控制端上报播放统计，用于自适应码率
+	TypeScreenThumbnail = "screen.thumbnail" // 服务端请求设备截取一张缩略图（二进制 0x04 回复）
+	TypeStreamProfile   = "stream.profile"   // 控制端切换推流配置（服务端回复同类型消息告知当前配置）
+	TypePing            = "ping"
+
+	TypeMacroRecordStart = "macro.record.start" // 开始录制输入宏
+	TypeMacroRecordStop  = "macro.record.stop"  // 停止录制并保存
 
 	// 服务端消息
 	TypeControlGranted = "control.granted"
@@ -27,6 +45,18 @@ const (
 	TypeDeviceOffline  = "device.offline"
 	TypeError          = "error"
 	TypePong           = "pong"
+	TypeSessionState   = "session.state"
+	TypeControlPending = "control.pending" // 等待现场用户同意（同时发给设备和控制端）
+	TypeControlCancel  = "control.cancel"  // 取消等待中的控制请求（发给设备）
+	TypeMacroSaved     = "macro.saved"
+	TypeChatAck        = "chat.ack" // 聊天消息转发回执（发给发送方）
+
+	// 缩略图墙推送（/api/thumbnails/feed）
+	TypeThumbnailUpdate = "thumbnail.update"
+	TypeThumbnailRemove = "thumbnail.remove" // 设备离线
+
+	// 聊天消息（控制端与设备双向）
+	TypeChatMessage = "chat.message"
 
 	// WebRTC 信令消息
 	TypeWebRTCOffer  = "webrtc.offer"
@@ -40,9 +70,43 @@ const (
 	TypePrivacyToggle  = "privacy.toggle"
 )
 
-// 二进制消息类型
+// 会话状态（session.state）
+const (
+	SessionStateReconnecting = "reconnecting" // 设备断线，会话保留等待重连
+	SessionStateResumed      = "resumed"      // 设备已重连，会话恢复
+	SessionStateClosed       = "closed"       // 宽限期内未重连，会话关闭
+)
+
+// 命令回执状态（command.result）
 const (
-	BinaryTypeScreenFrame byte = 0x01
+	CommandStatusSuccess = "success" // 设备执行成功
+	CommandStatusFailure = "failure" // 设备执行失败或设备离线
+	CommandStatusTimeout = "timeout" // 设备未在超时时间内回执
+	CommandStatusSent    = "sent"    // 设备不支持回执，仅确认已发送
+)
+
+// 服务端主动切换推流配置的原因（stream.profile）
+const (
+	StreamProfileReasonQuota = "quota" // 设备本月流量配额用尽
+)
+
+// 聊天消息发送方
+const (
+	ChatFromController = "controller"
+	ChatFromDevice     = "device"
+)
+
+// 二进制消息类型，帧格式: [type][flags][payload]
+const (
+	BinaryTypeScreenFrame byte = 0x01 // MJPEG 帧
+	BinaryTypeH264Frame   byte = 0x02 // H264 帧（Annex-B）
+	BinaryTypeH264Config  byte = 0x03 // H264 配置帧（SPS/PPS）
+	BinaryTypeThumbnail   byte = 0x04 // 缩略图（JPEG，回复 screen.thumbnail）
+)
+
+// 二进制帧标志位
+const (
+	FrameFlagKeyFrame byte = 0x01 // 关键帧
 )
 
 // BaseMessage 基础消息结构
@@ -58,6 +122,10 @@ type DeviceRegisterMessage struct {
 	ScreenWidth  int    `json:"screenWidth"`
 	ScreenHeight int    `json:"screenHeight"`
 	Token        string `json:"token"`
+
+	ProtocolVersion int      `json:"protocolVersion,omitempty"` // 协议版本，旧版设备不携带
+	Capabilities    []string `json:"capabilities,omitempty"`    // 设备支持的能力
+	Commands        []string `json:"commands,omitempty"`        // 设备支持的 input.command 命令
 }
 
 // DeviceInfo 设备信息
@@ -83,22 +151,120 @@ type ControlGrantedMessage struct {
 	SessionID    string `json:"sessionId"`
 	ScreenWidth  int    `json:"screenWidth"`
 	ScreenHeight int    `json:"screenHeight"`
+
+	ProtocolVersion int      `json:"protocolVersion"` // 设备协议版本
+	Capabilities    []string `json:"capabilities"`    // 设备支持的能力
+	Commands        []string `json:"commands"`        // 设备支持的 input.command 命令
+
+	ICEServers []ICEServer `json:"iceServers,omitempty"` // WebRTC STUN/TURN 配置（TURN 凭据有时效）
+
+	StreamProfile  string          `json:"streamProfile,omitempty"`  // 当前推流配置，未分配时为空
+	StreamProfiles []StreamProfile `json:"streamProfiles,omitempty"` // 控制端可切换的推流配置
+}
+
+// ControlPendingMessage 控制请求等待现场用户同意
+type ControlPendingMessage struct {
+	Type         string `json:"type"`
+	SessionID    string `json:"sessionId"`
+	DeviceID     string `json:"deviceId"`
+	ControllerID string `json:"controllerId,omitempty"`
+	TimeoutMs    int64  `json:"timeoutMs"`
+}
+
+// ControlConsentMessage 设备端对控制请求的答复
+type ControlConsentMessage struct {
+	Type      string `json:"type"`
+	SessionID string `json:"sessionId"`
+	Approved  bool   `json:"approved"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ControlCancelMessage 取消等待中的控制请求
+type ControlCancelMessage struct {
+	Type      string `json:"type"`
+	SessionID string `json:"sessionId"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ControlDeniedMessage 控制请求被拒绝
+type ControlDeniedMessage struct {
+	Type     string `json:"type"`
+	DeviceID string `json:"deviceId"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
 }
 
 // TouchMessage 触摸消息
 type TouchMessage struct {
 	Type      string  `json:"type"`
 	SessionID string  `json:"sessionId,omitempty"`
-	Action    string  `json:"action"` // down, move, up
+	RequestID string  `json:"requestId,omitempty"`
+	Action    string  `json:"action"` // tap, longpress, swipe, scroll
 	X         float64 `json:"x"`
 	Y         float64 `json:"y"`
 	PointerID int     `json:"pointerId"`
+
+	StartX   float64 `json:"startX,omitempty"` // swipe 起点
+	StartY   float64 `json:"startY,omitempty"`
+	EndX     float64 `json:"endX,omitempty"` // swipe 终点
+	EndY     float64 `json:"endY,omitempty"`
+	Duration int     `json:"duration,omitempty"` // swipe / longpress 时长（毫秒）
+	HScroll  float64 `json:"hScroll,omitempty"`  // scroll 水平幅度
+	VScroll  float64 `json:"vScroll,omitempty"`  // scroll 垂直幅度
+}
+
+// MarshalJSON 只输出当前动作使用的字段
+// 坐标为 0 时也必须输出，设备端缺少字段会丢弃该事件
+func (m TouchMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.fields())
+}
+
+// EncodeMsgpack MessagePack 编码输出与 MarshalJSON 相同的字段
+func (m TouchMessage) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(m.fields())
+}
+
+// fields 当前动作使用的字段
+func (m TouchMessage) fields() map[string]interface{} {
+	out := map[string]interface{}{
+		"type":      m.Type,
+		"action":    m.Action,
+		"pointerId": m.PointerID,
+	}
+	if m.Type == "" {
+		out["type"] = TypeInputTouch
+	}
+	if m.SessionID != "" {
+		out["sessionId"] = m.SessionID
+	}
+	if m.RequestID != "" {
+		out["requestId"] = m.RequestID
+	}
+
+	switch m.Action {
+	case TouchActionSwipe:
+		out["startX"], out["startY"] = m.StartX, m.StartY
+		out["endX"], out["endY"] = m.EndX, m.EndY
+		if m.Duration > 0 {
+			out["duration"] = m.Duration
+		}
+	case TouchActionScroll:
+		out["x"], out["y"] = m.X, m.Y
+		out["hScroll"], out["vScroll"] = m.HScroll, m.VScroll
+	default:
+		out["x"], out["y"] = m.X, m.Y
+		if m.Duration > 0 {
+			out["duration"] = m.Duration
+		}
+	}
+	return out
 }
 
 // KeyMessage 按键消息
 type KeyMessage struct {
 	Type      string `json:"type"`
 	SessionID string `json:"sessionId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
 	KeyCode   int    `json:"keyCode"`
 	Action    string `json:"action"` // down, up
 }
@@ -107,6 +273,7 @@ type KeyMessage struct {
 type TextMessage struct {
 	Type      string `json:"type"`
 	SessionID string `json:"sessionId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
 	Text      string `json:"text"`
 }
 
@@ -114,6 +281,7 @@ type TextMessage struct {
 type ClipboardMessage struct {
 	Type      string `json:"type"`
 	SessionID string `json:"sessionId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
 	Text      string `json:"text"`
 	AutoPaste bool   `json:"autoPaste,omitempty"`
 }
@@ -126,6 +294,128 @@ type StreamControlMessage struct {
 	MaxFPS  int    `json:"maxFps,omitempty"`  // 最大帧率 (MJPEG模式)
 	Bitrate int    `json:"bitrate,omitempty"` // 码率 (H264模式)
 	FPS     int    `json:"fps,omitempty"`     // 帧率 (H264模式)
+
+	MaxWidth  int `json:"maxWidth,omitempty"`  // 最大采集宽度，超过时等比缩小
+	MaxHeight int `json:"maxHeight,omitempty"` // 最大采集高度
+
+	StreamRegion // 推流区域（需设备具备 region 能力），为空表示全屏
+}
+
+// CropRect 裁剪矩形，设备全屏像素坐标
+type CropRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// StreamRegion 推流区域：设备先裁剪，再顺时针旋转，最后缩放到输出分辨率（仍受 maxWidth / maxHeight 限制）
+// 控制端的触摸坐标以裁剪并旋转后的画面像素为准（与输出分辨率无关），服务端转换为设备全屏坐标后转发
+type StreamRegion struct {
+	Crop         *CropRect `json:"crop,omitempty"`         // 为空表示全屏
+	Rotation     int       `json:"rotation,omitempty"`     // 顺时针旋转角度 0 / 90 / 180 / 270
+	TargetWidth  int       `json:"targetWidth,omitempty"`  // 输出分辨率，只给一边时按比例计算另一边
+	TargetHeight int       `json:"targetHeight,omitempty"` // 两边都给时等比缩放到该尺寸以内
+}
+
+// IsZero 是否为全屏、不旋转、不指定输出分辨率
+func (r StreamRegion) IsZero() bool {
+	return r.Crop == nil && r.Rotation == 0 && r.TargetWidth == 0 && r.TargetHeight == 0
+}
+
+// StreamProfile 命名的推流配置，按设备、分组或全局默认分配
+type StreamProfile struct {
+	Name         string `json:"name"`
+	Mode         string `json:"mode"`         // 首选推流模式: "h264" | "mjpeg"（设备不支持时回退另一种）
+	Bitrate      int    `json:"bitrate"`      // H264 码率
+	FPS          int    `json:"fps"`          // 帧率（两种模式共用）
+	MJPEGQuality int    `json:"mjpegQuality"` // MJPEG JPEG 质量
+	MaxWidth     int    `json:"maxWidth"`     // 最大采集宽度，0 表示不限制
+	MaxHeight    int    `json:"maxHeight"`    // 最大采集高度，0 表示不限制
+}
+
+// StreamProfileMessage 切换推流配置（stream.profile）
+// 服务端主动切换时带上原因和新的可切换列表
+type StreamProfileMessage struct {
+	Type     string          `json:"type"`
+	Profile  string          `json:"profile"`
+	Reason   string          `json:"reason,omitempty"`   // StreamProfileReason*
+	Profiles []StreamProfile `json:"profiles,omitempty"` // 服务端切换后控制端可切换的配置
+}
+
+// ThumbnailRequestMessage 请求设备截取缩略图（screen.thumbnail）
+type ThumbnailRequestMessage struct {
+	Type     string `json:"type"`
+	MaxWidth int    `json:"maxWidth"` // 缩略图最大宽度（等比缩放）
+	Quality  int    `json:"quality"`  // JPEG 质量 1-100
+}
+
+// ThumbnailMessage 缩略图墙推送消息（thumbnail.update / thumbnail.remove）
+type ThumbnailMessage struct {
+	Type      string `json:"type"`
+	DeviceID  string `json:"deviceId"`
+	UpdatedAt int64  `json:"updatedAt,omitempty"` // 毫秒时间戳
+	JPEG      []byte `json:"jpeg,omitempty"`      // JSON 中为 base64
+}
+
+// WebRTCMessage WebRTC 信令消息（webrtc.offer / answer / ice / ready）
+type WebRTCMessage struct {
+	Type      string               `json:"type"`
+	TargetID  string               `json:"targetId,omitempty"`
+	FromID    string               `json:"fromId,omitempty"`
+	SDP       *SessionDescription  `json:"sdp,omitempty"`
+	Candidate *ICECandidateMessage `json:"candidate,omitempty"`
+
+	ICEServers []ICEServer `json:"iceServers,omitempty"` // 仅 webrtc.ready 携带
+}
+
+// ICEServer STUN/TURN 服务器（与浏览器 RTCIceServer 字段一致）
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// SessionDescription SDP（type 为 "offer" 或 "answer"）
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// ICECandidateMessage ICE 候选
+type ICECandidateMessage struct {
+	SDPMid        *string `json:"sdpMid"`
+	SDPMLineIndex *uint16 `json:"sdpMLineIndex"`
+	Candidate     string  `json:"candidate"`
+}
+
+// StreamStatsMessage 控制端播放统计（stream.stats）
+type StreamStatsMessage struct {
+	Type      string  `json:"type"`
+	FPS       float64 `json:"fps"`       // 实际渲染帧率
+	LatencyMs int     `json:"latencyMs"` // 端到端延迟估计（毫秒）
+	Dropped   int     `json:"dropped"`   // 上报周期内解码丢弃的帧数
+}
+
+// SessionStateMessage 会话状态通知
+type SessionStateMessage struct {
+	Type      string `json:"type"`
+	SessionID string `json:"sessionId"`
+	State     string `json:"state"`             // reconnecting, resumed, closed
+	GraceMs   int64  `json:"graceMs,omitempty"` // 重连宽限期（毫秒，仅 reconnecting）
+}
+
+// MacroRecordMessage 宏录制控制消息
+type MacroRecordMessage struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// MacroSavedMessage 宏保存结果
+type MacroSavedMessage struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Events int    `json:"events"`
 }
 
 // ErrorMessage 错误消息
@@ -140,3 +430,66 @@ type DeviceListMessage struct {
 	Type    string       `json:"type"`
 	Devices []DeviceInfo `json:"devices"`
 }
+
+// ChatMessage 聊天消息
+// 发送方只需填写 text，可选 id 用于匹配回执；其余字段由服务端填写后转发
+type ChatMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	From      string `json:"from,omitempty"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// ChatAckMessage 聊天消息回执
+type ChatAckMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Relayed   bool   `json:"relayed"` // 已写入对端连接（不代表对端已显示）
+	Code      string `json:"code,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// CommandResultMessage 命令执行回执
+// 设备回复 success/failure，服务端超时或设备离线时生成 timeout/failure
+type CommandResultMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CommandMessage 设备命令消息
+type CommandMessage struct {
+	Type      string                 `json:"type"`
+	SessionID string                 `json:"sessionId,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	Command   string                 `json:"command"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// CommandArg 命令参数定义
+type CommandArg struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // string, number, bool
+	Required bool     `json:"required,omitempty"`
+	Min      *float64 `json:"min,omitempty"`  // number 最小值
+	Max      *float64 `json:"max,omitempty"`  // number 最大值 / string 最大长度
+	Enum     []string `json:"enum,omitempty"` // string 可选值
+}
+
+// CommandInfo 命令说明（command.list 返回）
+type CommandInfo struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Args        []CommandArg `json:"args,omitempty"`
+	Permission  string       `json:"permission"`
+}
+
+// CommandListMessage 设备支持的命令列表
+type CommandListMessage struct {
+	Type     string        `json:"type"`
+	DeviceID string        `json:"deviceId"`
+	Commands []CommandInfo `json:"commands"`
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// 消息校验限制
+const (
+	MaxTextLength      = 5000      // input.text 最大字符数
+	MaxClipboardLength = 64 * 1024 // clipboard.set 最大字节数
+	MaxChatLength      = 2000      // chat.message 最大字符数
+	MaxMacroNameLength = 128       // 宏名称最大字节数
+	MaxKeyCode         = 400       // Android KeyEvent 键码上限
+	MaxGestureDuration = 10000     // 手势最长持续时间（毫秒）
+	MaxScrollAmount    = 100       // 单次滚动最大幅度
+	MaxStreamFPS       = 60
+	MaxStreamBitrate   = 20000000 // 20Mbps
+	MaxStreamDimension = 7680     // 最大采集宽高（8K）
+	MaxProfileName     = 64       // 推流配置名称最大字节数
+)
+
+// 校验错误码
+const (
+	ErrCodeInvalidMessage = "INVALID_MESSAGE"      // JSON 格式错误或字段类型不符
+	ErrCodeUnknownType    = "UNKNOWN_MESSAGE_TYPE" // 未知消息类型
+	ErrCodeInvalidAction  = "INVALID_ACTION"       // action 不在允许的取值内
+	ErrCodeInvalidCoord   = "INVALID_COORDINATE"   // 坐标超出屏幕范围
+	ErrCodeInvalidKeyCode = "INVALID_KEYCODE"      // 键码超出范围
+	ErrCodeInvalidText    = "INVALID_TEXT"         // 文本为空
+	ErrCodeTextTooLong    = "TEXT_TOO_LONG"        // 文本超长
+	ErrCodeInvalidParam   = "INVALID_PARAMETER"    // 其他参数超出范围
+	ErrCodeDuplicateID    = "DUPLICATE_REQUEST_ID" // requestId 与等待中的请求重复
+)
+
+// 触摸动作（与 Android MessageHandler 支持的动作一致）
+const (
+	TouchActionTap       = "tap"
+	TouchActionLongPress = "longpress"
+	TouchActionSwipe     = "swipe"
+	TouchActionScroll    = "scroll"
+)
+
+// 按键动作
+const (
+	KeyActionDown = "down"
+	KeyActionUp   = "up"
+)
+
+// ValidationError 消息校验错误，Code 作为 ErrorMessage.Code 回复给发送方
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func invalid(code, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate 校验触摸消息，width/height 为设备屏幕尺寸（未知时传 0 跳过坐标范围检查）
+func (m *TouchMessage) Validate(width, height int) error {
+	switch m.Action {
+	case TouchActionTap:
+		return validatePoint(width, height, m.X, m.Y)
+	case TouchActionLongPress:
+		if err := validatePoint(width, height, m.X, m.Y); err != nil {
+			return err
+		}
+		if m.Duration < 0 || m.Duration > MaxGestureDuration {
+			return invalid(ErrCodeInvalidParam, "duration 超出范围 0-%d", MaxGestureDuration)
+		}
+		return nil
+	case TouchActionSwipe:
+		if err := validatePoint(width, height, m.StartX, m.StartY); err != nil {
+			return err
+		}
+		if err := validatePoint(width, height, m.EndX, m.EndY); err != nil {
+			return err
+		}
+		if m.Duration < 0 || m.Duration > MaxGestureDuration {
+			return invalid(ErrCodeInvalidParam, "duration 超出范围 0-%d", MaxGestureDuration)
+		}
+		return nil
+	case TouchActionScroll:
+		if err := validatePoint(width, height, m.X, m.Y); err != nil {
+			return err
+		}
+		if !inRange(m.HScroll, -MaxScrollAmount, MaxScrollAmount) || !inRange(m.VScroll, -MaxScrollAmount, MaxScrollAmount) {
+			return invalid(ErrCodeInvalidParam, "滚动幅度超出范围 ±%d", MaxScrollAmount)
+		}
+		return nil
+	}
+	return invalid(ErrCodeInvalidAction, "不支持的触摸动作: %q", m.Action)
+}
+
+// Validate 校验按键消息
+func (m *KeyMessage) Validate() error {
+	if m.Action != KeyActionDown && m.Action != KeyActionUp {
+		return invalid(ErrCodeInvalidAction, "不支持的按键动作: %q", m.Action)
+	}
+	if m.KeyCode <= 0 || m.KeyCode > MaxKeyCode {
+		return invalid(ErrCodeInvalidKeyCode, "键码超出范围 1-%d", MaxKeyCode)
+	}
+	return nil
+}
+
+// Validate 校验文本输入消息
+func (m *TextMessage) Validate() error {
+	return validateText(m.Text, MaxTextLength)
+}
+
+// Validate 校验剪贴板消息
+func (m *ClipboardMessage) Validate() error {
+	if len(m.Text) > MaxClipboardLength {
+		return invalid(ErrCodeTextTooLong, "剪贴板内容超过 %d 字节", MaxClipboardLength)
+	}
+	return nil
+}
+
+// Validate 校验聊天消息
+func (m *ChatMessage) Validate() error {
+	return validateText(m.Text, MaxChatLength)
+}
+
+// Validate 校验宏录制消息
+func (m *MacroRecordMessage) Validate() error {
+	if m.Type == TypeMacroRecordStart && (m.Name == "" || len(m.Name) > MaxMacroNameLength) {
+		return invalid(ErrCodeInvalidParam, "宏名称为空或超过 %d 字节", MaxMacroNameLength)
+	}
+	return nil
+}
+
+// Validate 校验推流参数
+func (m *StreamControlMessage) Validate() error {
+	switch m.Mode {
+	case "", "h264", "mjpeg":
+	default:
+		return invalid(ErrCodeInvalidParam, "不支持的推流模式: %q", m.Mode)
+	}
+	if m.Quality < 0 || m.Quality > 100 {
+		return invalid(ErrCodeInvalidParam, "quality 超出范围 1-100")
+	}
+	if m.MaxFPS < 0 || m.MaxFPS > MaxStreamFPS || m.FPS < 0 || m.FPS > MaxStreamFPS {
+		return invalid(ErrCodeInvalidParam, "帧率超出范围 1-%d", MaxStreamFPS)
+	}
+	if m.Bitrate < 0 || m.Bitrate > MaxStreamBitrate {
+		return invalid(ErrCodeInvalidParam, "码率超出范围 0-%d", MaxStreamBitrate)
+	}
+	if m.MaxWidth < 0 || m.MaxWidth > MaxStreamDimension || m.MaxHeight < 0 || m.MaxHeight > MaxStreamDimension {
+		return invalid(ErrCodeInvalidParam, "分辨率超出范围 0-%d", MaxStreamDimension)
+	}
+	return m.StreamRegion.Validate(0, 0)
+}
+
+// Validate 校验推流区域，width/height 为设备屏幕尺寸（未知时传 0 跳过裁剪范围检查）
+func (r *StreamRegion) Validate(width, height int) error {
+	switch r.Rotation {
+	case 0, 90, 180, 270:
+	default:
+		return invalid(ErrCodeInvalidParam, "rotation 应为 0、90、180 或 270")
+	}
+	if r.TargetWidth < 0 || r.TargetWidth > MaxStreamDimension || r.TargetHeight < 0 || r.TargetHeight > MaxStreamDimension {
+		return invalid(ErrCodeInvalidParam, "输出分辨率超出范围 0-%d", MaxStreamDimension)
+	}
+	if c := r.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 {
+			return invalid(ErrCodeInvalidParam, "裁剪区域无效")
+		}
+		if width > 0 && height > 0 && (c.X+c.Width > width || c.Y+c.Height > height) {
+			return invalid(ErrCodeInvalidParam, "裁剪区域超出屏幕范围 %dx%d", width, height)
+		}
+	}
+	return nil
+}
+
+// Validate 校验推流配置
+func (p *StreamProfile) Validate() error {
+	if p.Name == "" || len(p.Name) > MaxProfileName {
+		return invalid(ErrCodeInvalidParam, "配置名称为空或超过 %d 字节", MaxProfileName)
+	}
+	if p.Mode != "h264" && p.Mode != "mjpeg" {
+		return invalid(ErrCodeInvalidParam, "不支持的推流模式: %q", p.Mode)
+	}
+	if p.Bitrate <= 0 || p.Bitrate > MaxStreamBitrate {
+		return invalid(ErrCodeInvalidParam, "码率超出范围 1-%d", MaxStreamBitrate)
+	}
+	if p.FPS <= 0 || p.FPS > MaxStreamFPS {
+		return invalid(ErrCodeInvalidParam, "帧率超出范围 1-%d", MaxStreamFPS)
+	}
+	if p.MJPEGQuality <= 0 || p.MJPEGQuality > 100 {
+		return invalid(ErrCodeInvalidParam, "mjpegQuality 超出范围 1-100")
+	}
+	if p.MaxWidth < 0 || p.MaxWidth > MaxStreamDimension || p.MaxHeight < 0 || p.MaxHeight > MaxStreamDimension {
+		return invalid(ErrCodeInvalidParam, "分辨率超出范围 0-%d", MaxStreamDimension)
+	}
+	return nil
+}
+
+// Validate 校验推流配置切换消息
+func (m *StreamProfileMessage) Validate() error {
+	if m.Profile == "" || len(m.Profile) > MaxProfileName {
+		return invalid(ErrCodeInvalidParam, "配置名称为空或超过 %d 字节", MaxProfileName)
+	}
+	return nil
+}
+
+func validatePoint(width, height int, x, y float64) error {
+	if !inRange(x, 0, float64(width)) || !inRange(y, 0, float64(height)) {
+		return invalid(ErrCodeInvalidCoord, "坐标 (%.0f, %.0f) 超出屏幕范围 %dx%d", x, y, width, height)
+	}
+	return nil
+}
+
+func validateText(text string, max int) error {
+	if text == "" {
+		return invalid(ErrCodeInvalidText, "文本不能为空")
+	}
+	if utf8.RuneCountInString(text) > max {
+		return invalid(ErrCodeTextTooLong, "文本超过 %d 字符", max)
+	}
+	return nil
+}
+
+// inRange 检查取值范围，max 不大于 0 时只检查下限（屏幕尺寸未知）
+func inRange(v, min, max float64) bool {
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < min {
+		return false
+	}
+	return max <= 0 || v <= max
+}
+
+// Validate 校验播放统计
+func (m *StreamStatsMessage) Validate() error {
+	if m.FPS < 0 || m.FPS > MaxStreamFPS*2 {
+		return invalid(ErrCodeInvalidParam, "帧率超出范围 0-%d", MaxStreamFPS*2)
+	}
+	if m.LatencyMs < 0 || m.Dropped < 0 {
+		return invalid(ErrCodeInvalidParam, "统计值不能为负数")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// 设备屏幕 1080x1920
+const (
+	testScreenWidth  = 1080
+	testScreenHeight = 1920
+)
+
+// validationCase 一条校验用例，code 为空表示应通过校验
+type validationCase struct {
+	name string
+	err  error
+	code string
+}
+
+func checkValidation(t *testing.T, cases []validationCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.code == "" {
+				if tc.err != nil {
+					t.Fatalf("校验失败: %v", tc.err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(tc.err, &ve) {
+				t.Fatalf("错误 %v, 期望 %s", tc.err, tc.code)
+			}
+			if ve.Code != tc.code {
+				t.Fatalf("错误码 %s, 期望 %s (%s)", ve.Code, tc.code, ve.Message)
+			}
+		})
+	}
+}
+
+func TestTouchMessageValidate(t *testing.T) {
+	touch := func(m TouchMessage) error { return m.Validate(testScreenWidth, testScreenHeight) }
+	// 屏幕尺寸未知时只检查坐标下限
+	unknownScreen := func(m TouchMessage) error { return m.Validate(0, 0) }
+	checkValidation(t, []validationCase{
+		{"tap", touch(TouchMessage{Action: TouchActionTap, X: 100, Y: 200}), ""},
+		{"tap at edge", touch(TouchMessage{Action: TouchActionTap, X: testScreenWidth, Y: testScreenHeight}), ""},
+		{"tap outside width", touch(TouchMessage{Action: TouchActionTap, X: testScreenWidth + 1, Y: 200}), ErrCodeInvalidCoord},
+		{"tap negative", touch(TouchMessage{Action: TouchActionTap, X: -1, Y: 200}), ErrCodeInvalidCoord},
+		{"tap NaN", touch(TouchMessage{Action: TouchActionTap, X: math.NaN(), Y: 200}), ErrCodeInvalidCoord},
+		{"tap Inf", touch(TouchMessage{Action: TouchActionTap, X: 100, Y: math.Inf(1)}), ErrCodeInvalidCoord},
+		{"tap unknown screen", unknownScreen(TouchMessage{Action: TouchActionTap, X: 5000, Y: 5000}), ""},
+		{"tap unknown screen negative", unknownScreen(TouchMessage{Action: TouchActionTap, X: -1, Y: 0}), ErrCodeInvalidCoord},
+		{"longpress", touch(TouchMessage{Action: TouchActionLongPress, X: 100, Y: 200, Duration: 800}), ""},
+		{"longpress too long", touch(TouchMessage{Action: TouchActionLongPress, X: 100, Y: 200, Duration: MaxGestureDuration + 1}), ErrCodeInvalidParam},
+		{"swipe", touch(TouchMessage{Action: TouchActionSwipe, StartX: 100, StartY: 200, EndX: 500, EndY: 200, Duration: 300}), ""},
+		{"swipe end outside", touch(TouchMessage{Action: TouchActionSwipe, StartX: 100, StartY: 200, EndX: 500, EndY: testScreenHeight + 1}), ErrCodeInvalidCoord},
+		{"swipe negative duration", touch(TouchMessage{Action: TouchActionSwipe, StartX: 100, StartY: 200, EndX: 500, EndY: 200, Duration: -1}), ErrCodeInvalidParam},
+		{"scroll", touch(TouchMessage{Action: TouchActionScroll, X: 100, Y: 200, VScroll: -MaxScrollAmount}), ""},
+		{"scroll too far", touch(TouchMessage{Action: TouchActionScroll, X: 100, Y: 200, HScroll: MaxScrollAmount + 1}), ErrCodeInvalidParam},
+		{"unknown action", touch(TouchMessage{Action: "pinch", X: 100, Y: 200}), ErrCodeInvalidAction},
+		{"empty action", touch(TouchMessage{X: 100, Y: 200}), ErrCodeInvalidAction},
+	})
+}
+
+func TestTouchFrameMessageValidate(t *testing.T) {
+	frame := func(m TouchFrameMessage) error {
+		return m.Validate(testScreenWidth, testScreenHeight)
+	}
+	tooMany := make([]TouchPointer, MaxTouchPointers+1)
+	for i := range tooMany {
+		tooMany[i] = TouchPointer{ID: i, X: 10, Y: 10}
+	}
+	checkValidation(t, []validationCase{
+		{"down", frame(TouchFrameMessage{Action: TouchFrameDown, Pointers: []TouchPointer{{ID: 0, X: 10, Y: 20}}}), ""},
+		{"move two pointers", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: []TouchPointer{{ID: 0, X: 10, Y: 20}, {ID: 1, X: 30, Y: 40, Pressure: 0.5}}}), ""},
+		{"cancel without pointers", frame(TouchFrameMessage{Action: TouchFrameCancel}), ""},
+		{"down without pointers", frame(TouchFrameMessage{Action: TouchFrameDown}), ErrCodeInvalidParam},
+		{"unknown action", frame(TouchFrameMessage{Action: "tap", Pointers: []TouchPointer{{ID: 0}}}), ErrCodeInvalidAction},
+		{"too many pointers", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: tooMany}), ErrCodeInvalidParam},
+		{"duplicate id", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: []TouchPointer{{ID: 1}, {ID: 1}}}), ErrCodeInvalidParam},
+		{"id out of range", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: []TouchPointer{{ID: MaxTouchPointers}}}), ErrCodeInvalidParam},
+		{"pointer outside", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: []TouchPointer{{ID: 0, X: -5, Y: 20}}}), ErrCodeInvalidCoord},
+		{"pressure too high", frame(TouchFrameMessage{Action: TouchFrameMove, Pointers: []TouchPointer{{ID: 0, X: 10, Y: 20, Pressure: 1.5}}}), ErrCodeInvalidParam},
+	})
+}
+
+func TestKeyMessageValidate(t *testing.T) {
+	key := func(m KeyMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"down", key(KeyMessage{Action: KeyActionDown, KeyCode: 4}), ""},
+		{"up max keycode", key(KeyMessage{Action: KeyActionUp, KeyCode: MaxKeyCode}), ""},
+		{"unknown action", key(KeyMessage{Action: "press", KeyCode: 4}), ErrCodeInvalidAction},
+		{"zero keycode", key(KeyMessage{Action: KeyActionDown}), ErrCodeInvalidKeyCode},
+		{"keycode too large", key(KeyMessage{Action: KeyActionDown, KeyCode: MaxKeyCode + 1}), ErrCodeInvalidKeyCode},
+	})
+}
+
+func TestTextValidate(t *testing.T) {
+	text := func(m TextMessage) error { return m.Validate() }
+	chat := func(m ChatMessage) error { return m.Validate() }
+	clipboard := func(m ClipboardMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"text", text(TextMessage{Text: "hello"}), ""},
+		// 长度按字符计算，多字节字符不会提前超限
+		{"text max runes", text(TextMessage{Text: strings.Repeat("中", MaxTextLength)}), ""},
+		{"text empty", text(TextMessage{}), ErrCodeInvalidText},
+		{"text too long", text(TextMessage{Text: strings.Repeat("a", MaxTextLength+1)}), ErrCodeTextTooLong},
+		{"chat", chat(ChatMessage{Text: "请稍等"}), ""},
+		{"chat empty", chat(ChatMessage{}), ErrCodeInvalidText},
+		{"chat too long", chat(ChatMessage{Text: strings.Repeat("中", MaxChatLength+1)}), ErrCodeTextTooLong},
+		// 剪贴板按字节计算，允许清空
+		{"clipboard empty", clipboard(ClipboardMessage{}), ""},
+		{"clipboard max bytes", clipboard(ClipboardMessage{Text: strings.Repeat("a", MaxClipboardLength)}), ""},
+		{"clipboard too long", clipboard(ClipboardMessage{Text: strings.Repeat("中", MaxClipboardLength/3+1)}), ErrCodeTextTooLong},
+	})
+}
+
+func TestMacroRecordMessageValidate(t *testing.T) {
+	macro := func(m MacroRecordMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"start", macro(MacroRecordMessage{Type: TypeMacroRecordStart, Name: "kiosk-reset"}), ""},
+		{"stop without name", macro(MacroRecordMessage{Type: TypeMacroRecordStop}), ""},
+		{"start without name", macro(MacroRecordMessage{Type: TypeMacroRecordStart}), ErrCodeInvalidParam},
+		{"name too long", macro(MacroRecordMessage{Type: TypeMacroRecordStart, Name: strings.Repeat("m", MaxMacroNameLength+1)}), ErrCodeInvalidParam},
+	})
+}
+
+func TestStreamControlMessageValidate(t *testing.T) {
+	stream := func(m StreamControlMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"h264", stream(StreamControlMessage{Mode: "h264", Bitrate: 2000000, FPS: 30}), ""},
+		{"mjpeg", stream(StreamControlMessage{Mode: "mjpeg", Quality: 80, MaxFPS: 30, MaxWidth: 1280}), ""},
+		{"default mode", stream(StreamControlMessage{}), ""},
+		{"unknown mode", stream(StreamControlMessage{Mode: "vp8"}), ErrCodeInvalidParam},
+		{"quality too high", stream(StreamControlMessage{Mode: "mjpeg", Quality: 101}), ErrCodeInvalidParam},
+		{"fps too high", stream(StreamControlMessage{Mode: "h264", FPS: MaxStreamFPS + 1}), ErrCodeInvalidParam},
+		{"negative max fps", stream(StreamControlMessage{Mode: "mjpeg", MaxFPS: -1}), ErrCodeInvalidParam},
+		{"bitrate too high", stream(StreamControlMessage{Mode: "h264", Bitrate: MaxStreamBitrate + 1}), ErrCodeInvalidParam},
+		{"width too large", stream(StreamControlMessage{Mode: "h264", MaxWidth: MaxStreamDimension + 1}), ErrCodeInvalidParam},
+		{"invalid region", stream(StreamControlMessage{Mode: "h264", StreamRegion: StreamRegion{Rotation: 45}}), ErrCodeInvalidParam},
+	})
+}
+
+func TestStreamRegionValidate(t *testing.T) {
+	region := func(r StreamRegion) error { return r.Validate(testScreenWidth, testScreenHeight) }
+	unknownScreen := func(r StreamRegion) error { return r.Validate(0, 0) }
+	checkValidation(t, []validationCase{
+		{"full screen", region(StreamRegion{}), ""},
+		{"crop rotate", region(StreamRegion{Crop: &CropRect{X: 100, Y: 200, Width: 400, Height: 300}, Rotation: 270, TargetWidth: 600}), ""},
+		{"crop whole screen", region(StreamRegion{Crop: &CropRect{Width: testScreenWidth, Height: testScreenHeight}}), ""},
+		{"rotation 45", region(StreamRegion{Rotation: 45}), ErrCodeInvalidParam},
+		{"target too large", region(StreamRegion{TargetHeight: MaxStreamDimension + 1}), ErrCodeInvalidParam},
+		{"empty crop", region(StreamRegion{Crop: &CropRect{X: 100, Y: 200}}), ErrCodeInvalidParam},
+		{"negative crop", region(StreamRegion{Crop: &CropRect{X: -1, Y: 0, Width: 100, Height: 100}}), ErrCodeInvalidParam},
+		{"crop outside", region(StreamRegion{Crop: &CropRect{X: 700, Y: 0, Width: 400, Height: 300}}), ErrCodeInvalidParam},
+		// 屏幕尺寸未知时不检查裁剪是否超出屏幕
+		{"crop unknown screen", unknownScreen(StreamRegion{Crop: &CropRect{X: 700, Y: 0, Width: 400, Height: 300}}), ""},
+	})
+}
+
+func TestStreamProfileValidate(t *testing.T) {
+	valid := StreamProfile{Name: "hd", Mode: "h264", Bitrate: 2000000, FPS: 30, MJPEGQuality: 80}
+	profile := func(modify func(*StreamProfile)) error {
+		p := valid
+		modify(&p)
+		return p.Validate()
+	}
+	change := func(m StreamProfileMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"valid", profile(func(p *StreamProfile) {}), ""},
+		{"empty name", profile(func(p *StreamProfile) { p.Name = "" }), ErrCodeInvalidParam},
+		{"name too long", profile(func(p *StreamProfile) { p.Name = strings.Repeat("p", MaxProfileName+1) }), ErrCodeInvalidParam},
+		{"default mode", profile(func(p *StreamProfile) { p.Mode = "" }), ErrCodeInvalidParam},
+		{"zero bitrate", profile(func(p *StreamProfile) { p.Bitrate = 0 }), ErrCodeInvalidParam},
+		{"fps too high", profile(func(p *StreamProfile) { p.FPS = MaxStreamFPS + 1 }), ErrCodeInvalidParam},
+		{"zero quality", profile(func(p *StreamProfile) { p.MJPEGQuality = 0 }), ErrCodeInvalidParam},
+		{"negative height", profile(func(p *StreamProfile) { p.MaxHeight = -1 }), ErrCodeInvalidParam},
+		{"switch", change(StreamProfileMessage{Profile: "hd"}), ""},
+		{"switch without name", change(StreamProfileMessage{}), ErrCodeInvalidParam},
+	})
+}
+
+func TestStreamStatsMessageValidate(t *testing.T) {
+	stats := func(m StreamStatsMessage) error { return m.Validate() }
+	checkValidation(t, []validationCase{
+		{"valid", stats(StreamStatsMessage{FPS: 29.5, LatencyMs: 120, Dropped: 2}), ""},
+		{"fps too high", stats(StreamStatsMessage{FPS: MaxStreamFPS*2 + 1}), ErrCodeInvalidParam},
+		{"negative latency", stats(StreamStatsMessage{LatencyMs: -1}), ErrCodeInvalidParam},
+		{"negative dropped", stats(StreamStatsMessage{Dropped: -1}), ErrCodeInvalidParam},
+	})
+}
+
+func TestParseGesture(t *testing.T) {
+	parse := func(action, data string) error {
+		_, err := ParseGesture(action, []byte(data))
+		return err
+	}
+	checkValidation(t, []validationCase{
+		{"tap", parse(TouchActionTap, `{"x":100,"y":200}`), ""},
+		{"swipe", parse(TouchActionSwipe, `{"startX":1,"startY":2,"endX":3,"endY":4}`), ""},
+		{"malformed json", parse(TouchActionTap, `{"x":`), ErrCodeInvalidMessage},
+		{"wrong field type", parse(TouchActionScroll, `{"x":"100","y":200}`), ErrCodeInvalidMessage},
+		{"unknown gesture", parse("pinch", `{}`), ErrCodeInvalidAction},
+	})
+
+	g, err := ParseGesture(TouchActionLongPress, []byte(`{"x":10,"y":20,"duration":900}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Touch(); got != (TouchMessage{Type: TypeInputTouch, Action: TouchActionLongPress, X: 10, Y: 20, Duration: 900}) {
+		t.Fatalf("手势转换结果 %+v", got)
+	}
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const (
+	abrWindow        = 2 * time.Second  // 统计窗口
+	abrUpWindows     = 5                // 连续健康窗口数达到后才升档
+	abrUpHoldoff     = 10 * time.Second // 降档后至少等待该时长才允许升档
+	abrDownCooldown  = 4 * time.Second  // 降档后等待新参数生效、积压帧发完再评估是否继续降档
+	abrReportMaxAge  = 5 * time.Second  // 控制端上报的统计超过该时长不再参考
+	abrMaxDecisions  = 50               // 每个会话保留的决策记录数
+	abrDropCongested = 0.10             // 丢帧率超过该值视为拥塞
+	abrDropHealthy   = 0.02             // 丢帧率低于该值视为健康
+
+	abrLatencyCongested = 50 * time.Millisecond // 平均发送耗时超过该值视为拥塞
+	abrLatencyHealthy   = 15 * time.Millisecond
+	abrReportLatencyMax = 500 // 控制端上报延迟（毫秒）超过该值视为拥塞
+)
+
+// abrLevel 码率档位
+type abrLevel struct {
+	Bitrate int // H264 码率
+	Quality int // MJPEG 质量
+	FPS     int
+}
+
+// H264 与 MJPEG 档位，从高到低；默认档位与原固定参数一致（2Mbps / 30fps、质量 80 / 30fps）
+var (
+	h264Ladder = []abrLevel{
+		{Bitrate: 4000000, FPS: 30},
+		{Bitrate: 2000000, FPS: 30},
+		{Bitrate: 1200000, FPS: 25},
+		{Bitrate: 800000, FPS: 20},
+		{Bitrate: 500000, FPS: 15},
+		{Bitrate: 300000, FPS: 10},
+	}
+	mjpegLadder = []abrLevel{
+		{Quality: 80, FPS: 30},
+		{Quality: 70, FPS: 24},
+		{Quality: 60, FPS: 18},
+		{Quality: 50, FPS: 12},
+		{Quality: 40, FPS: 8},
+	}
+)
+
+// ABRDecision 一次码率调整决策
+type ABRDecision struct {
+	Time    time.Time                     `json:"time"`
+	Action  string                        `json:"action"` // start / down / up / manual / profile
+	Reason  string                        `json:"reason"`
+	Params  protocol.StreamControlMessage `json:"params"`
+	Metrics ABRMetrics                    `json:"metrics"`
+}
+
+// ABRMetrics 一个统计窗口内的指标
+type ABRMetrics struct {
+	Sent         int     `json:"sent"`
+	Dropped      int     `json:"dropped"`
+	DropRate     float64 `json:"dropRate"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"` // 帧平均排队加写出耗时
+	ReportFPS    float64 `json:"reportFps,omitempty"`
+	ReportLatMs  int     `json:"reportLatencyMs,omitempty"`
+
+	// 控制端窗口内累计上报的渲染帧数和解码丢弃帧数，按实际收到的帧归一化，静止画面不会被误判为拥塞
+	ReportRendered int     `json:"reportRendered,omitempty"`
+	ReportDropped  int     `json:"reportDropped,omitempty"`
+	ReportDropRate float64 `json:"reportDropRate,omitempty"`
+}
+
+// ABRStats 会话的自适应码率状态
+type ABRStats struct {
+	SessionID string                        `json:"sessionId"`
+	Enabled   bool                          `json:"enabled"` // 控制端手动指定推流参数后停止自动调整
+	Level     int                           `json:"level"`
+	Params    protocol.StreamControlMessage `json:"params"`
+	Window    ABRMetrics                    `json:"window"` // 当前窗口（未结束）
+	Last      ABRMetrics                    `json:"last"`   // 上一个完整窗口
+	Report    *protocol.StreamStatsMessage  `json:"report,omitempty"`
+	Decisions []ABRDecision                 `json:"decisions"`
+}
+
+type abrSession struct {
+	mode        string
+	ladder      []abrLevel
+	maxWidth    int // 推流配置限制的分辨率，随参数一起下发
+	maxHeight   int
+	level       int
+	enabled     bool
+	windowStart time.Time
+	sent        int
+	dropped     int
+	latency     time.Duration
+	last        ABRMetrics
+	report      *protocol.StreamStatsMessage
+	reportAt    time.Time
+	rendered    int // 窗口内控制端上报的渲染帧数
+	decodeDrops int // 窗口内控制端上报的解码丢弃帧数
+	goodWindows int
+	lastDown    time.Time
+	decisions   []ABRDecision
+}
+
+// ABRController 按控制端链路状况调整设备推流参数
+// 依据：帧排队加写出耗时、发送队列拥塞丢弃的帧数、控制端上报的延迟和解码丢帧
+// 不比较控制端帧率与目标帧率：画面静止时设备本就少发帧
+type ABRController struct {
+	sessions map[string]*abrSession
+	mutex    sync.Mutex
+}
+
+// NewABRController 创建自适应码率控制器
+func NewABRController() *ABRController {
+	return &ABRController{sessions: make(map[string]*abrSession)}
+}
+
+// Start 开始跟踪会话，返回初始推流参数（mode 为 h264 或 mjpeg）
+func (a *ABRController) Start(sessionID, mode string) protocol.StreamControlMessage {
+	ladder, level := h264Ladder, 1
+	if mode != "h264" {
+		ladder, level = mjpegLadder, 0
+	}
+	s := &abrSession{mode: mode, ladder: ladder, level: level, enabled: true, windowStart: time.Now()}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.sessions[sessionID] = s
+	params := s.params()
+	s.record("start", "会话开始", params, ABRMetrics{})
+	return params
+}
+
+// StartAt 按推流配置开始跟踪会话：配置参数为最高档，向下沿用默认档位中更低的档位
+// 会话已在跟踪时（控制端切换配置）保留决策记录
+func (a *ABRController) StartAt(sessionID string, params protocol.StreamControlMessage) protocol.StreamControlMessage {
+	top, base := abrLevel{Bitrate: params.Bitrate, FPS: params.FPS}, h264Ladder
+	if params.Mode != "h264" {
+		top, base = abrLevel{Quality: params.Quality, FPS: params.MaxFPS}, mjpegLadder
+	}
+	ladder := []abrLevel{top}
+	for _, level := range base {
+		if (params.Mode == "h264" && level.Bitrate < top.Bitrate) || (params.Mode != "h264" && level.Quality < top.Quality) {
+			level.FPS = min(level.FPS, top.FPS)
+			ladder = append(ladder, level)
+		}
+	}
+	s := &abrSession{
+		mode:        params.Mode,
+		ladder:      ladder,
+		maxWidth:    params.MaxWidth,
+		maxHeight:   params.MaxHeight,
+		enabled:     true,
+		windowStart: time.Now(),
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	action, reason := "start", "会话开始"
+	if old := a.sessions[sessionID]; old != nil {
+		s.decisions = old.decisions
+		action, reason = "profile", "切换推流配置"
+	}
+	a.sessions[sessionID] = s
+	params = s.params()
+	s.record(action, reason, params, ABRMetrics{})
+	return params
+}
+
+// Stop 停止跟踪会话
+func (a *ABRController) Stop(sessionID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.sessions, sessionID)
+}
+
+// Pin 控制端手动指定推流参数后停止自动调整
+func (a *ABRController) Pin(sessionID string, params protocol.StreamControlMessage) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if s := a.sessions[sessionID]; s != nil {
+		s.enabled = false
+		s.record("manual", "控制端手动设置推流参数", params, s.last)
+	}
+}
+
+// Report 记录控制端上报的播放统计（约每秒一次，fps 即该周期渲染的帧数）
+func (a *ABRController) Report(sessionID string, stats protocol.StreamStatsMessage) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if s := a.sessions[sessionID]; s != nil {
+		s.report = &stats
+		s.reportAt = time.Now()
+		s.rendered += int(stats.FPS + 0.5)
+		s.decodeDrops += max(stats.Dropped, 0)
+	}
+}
+
+// RecordSent 记录一帧写出及其耗时（含排队时间），窗口结束时评估链路
+// 需要调整时返回新的推流参数
+func (a *ABRController) RecordSent(sessionID string, latency time.Duration) (protocol.StreamControlMessage, bool) {
+	return a.record(sessionID, latency, 0)
+}
+
+// RecordDropped 记录因拥塞丢弃的帧数
+func (a *ABRController) RecordDropped(sessionID string, count int) (protocol.StreamControlMessage, bool) {
+	return a.record(sessionID, 0, count)
+}
+
+func (a *ABRController) record(sessionID string, latency time.Duration, dropped int) (protocol.StreamControlMessage, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	s := a.sessions[sessionID]
+	if s == nil {
+		return protocol.StreamControlMessage{}, false
+	}
+	if dropped > 0 {
+		s.dropped += dropped
+	} else {
+		s.sent++
+		s.latency += latency
+	}
+	if time.Since(s.windowStart) < abrWindow {
+		return protocol.StreamControlMessage{}, false
+	}
+	return s.evaluate()
+}
+
+// Stats 获取会话的自适应码率状态
+func (a *ABRController) Stats(sessionID string) (ABRStats, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	s := a.sessions[sessionID]
+	if s == nil {
+		return ABRStats{}, false
+	}
+	stats := ABRStats{
+		SessionID: sessionID,
+		Enabled:   s.enabled,
+		Level:     s.level,
+		Params:    s.params(),
+		Window:    s.metrics(),
+		Last:      s.last,
+		Decisions: append([]ABRDecision(nil), s.decisions...),
+	}
+	if s.report != nil {
+		report := *s.report
+		stats.Report = &report
+	}
+	return stats, true
+}
+
+// evaluate 结束当前窗口并决定是否换档
+// 拥塞时降档，距上次降档不足 abrDownCooldown 时先等待新参数生效；
+// 连续多个健康窗口且距上次降档足够久才升档，避免来回振荡
+func (s *abrSession) evaluate() (protocol.StreamControlMessage, bool) {
+	m := s.metrics()
+	s.last = m
+	s.windowStart = time.Now()
+	s.sent, s.dropped, s.latency = 0, 0, 0
+	s.rendered, s.decodeDrops = 0, 0
+
+	if !s.enabled {
+		return protocol.StreamControlMessage{}, false
+	}
+
+	if reason := s.congested(m); reason != "" {
+		s.goodWindows = 0
+		if s.level == len(s.ladder)-1 || time.Since(s.lastDown) < abrDownCooldown {
+			return protocol.StreamControlMessage{}, false
+		}
+		s.level++
+		s.lastDown = time.Now()
+		params := s.params()
+		s.record("down", reason, params, m)
+		return params, true
+	}
+
+	if !s.healthy(m) {
+		s.goodWindows = 0
+		return protocol.StreamControlMessage{}, false
+	}
+	s.goodWindows++
+	if s.goodWindows < abrUpWindows || s.level == 0 || time.Since(s.lastDown) < abrUpHoldoff {
+		return protocol.StreamControlMessage{}, false
+	}
+	s.goodWindows = 0
+	s.level--
+	params := s.params()
+	s.record("up", fmt.Sprintf("连续 %d 个窗口链路良好", abrUpWindows), params, m)
+	return params, true
+}
+
+func (s *abrSession) congested(m ABRMetrics) string {
+	switch {
+	case m.DropRate > abrDropCongested:
+		return fmt.Sprintf("丢帧率 %.0f%%", m.DropRate*100)
+	case m.AvgLatencyMs > float64(abrLatencyCongested.Milliseconds()):
+		return fmt.Sprintf("发送耗时 %.0fms", m.AvgLatencyMs)
+	case m.ReportLatMs > abrReportLatencyMax:
+		return fmt.Sprintf("控制端延迟 %dms", m.ReportLatMs)
+	case m.ReportDropRate > abrDropCongested:
+		return fmt.Sprintf("控制端解码丢帧率 %.0f%%", m.ReportDropRate*100)
+	}
+	return ""
+}
+
+func (s *abrSession) healthy(m ABRMetrics) bool {
+	return m.Sent > 0 &&
+		m.DropRate <= abrDropHealthy &&
+		m.AvgLatencyMs <= float64(abrLatencyHealthy.Milliseconds()) &&
+		m.ReportDropRate <= abrDropHealthy
+}
+
+func (s *abrSession) metrics() ABRMetrics {
+	m := ABRMetrics{Sent: s.sent, Dropped: s.dropped}
+	if total := s.sent + s.dropped; total > 0 {
+		m.DropRate = float64(s.dropped) / float64(total)
+	}
+	if s.sent > 0 {
+		m.AvgLatencyMs = float64(s.latency.Microseconds()) / float64(s.sent) / 1000
+	}
+	if s.report != nil && time.Since(s.reportAt) < abrReportMaxAge {
+		m.ReportFPS = s.report.FPS
+		m.ReportLatMs = s.report.LatencyMs
+	}
+	m.ReportRendered, m.ReportDropped = s.rendered, s.decodeDrops
+	if total := s.rendered + s.decodeDrops; total > 0 {
+		m.ReportDropRate = float64(s.decodeDrops) / float64(total)
+	}
+	return m
+}
+
+func (s *abrSession) params() protocol.StreamControlMessage {
+	level := s.ladder[s.level]
+	if s.mode == "h264" {
+		return protocol.StreamControlMessage{Type: protocol.TypeStreamStart, Mode: "h264", Bitrate: level.Bitrate, FPS: level.FPS, MaxWidth: s.maxWidth, MaxHeight: s.maxHeight}
+	}
+	return protocol.StreamControlMessage{Type: protocol.TypeStreamStart, Mode: "mjpeg", Quality: level.Quality, MaxFPS: level.FPS, MaxWidth: s.maxWidth, MaxHeight: s.maxHeight}
+}
+
+func (s *abrSession) record(action, reason string, params protocol.StreamControlMessage, m ABRMetrics) {
+	s.decisions = append(s.decisions, ABRDecision{Time: time.Now(), Action: action, Reason: reason, Params: params, Metrics: m})
+	if len(s.decisions) > abrMaxDecisions {
+		s.decisions = s.decisions[len(s.decisions)-abrMaxDecisions:]
+	}
+}
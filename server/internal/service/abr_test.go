@@ -0,0 +1,156 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// windowSession 构造一个统计窗口已结束的 H264 会话，初始为默认档位 2M/30
+func windowSession(configure func(s *abrSession)) *abrSession {
+	s := &abrSession{mode: "h264", ladder: h264Ladder, level: 1, enabled: true}
+	configure(s)
+	return s
+}
+
+// sendFrames 窗口内写出 n 帧，每帧耗时 latency
+func sendFrames(s *abrSession, n int, latency time.Duration) {
+	s.sent += n
+	s.latency += time.Duration(n) * latency
+}
+
+func TestABREvaluate(t *testing.T) {
+	cases := []struct {
+		name      string
+		configure func(s *abrSession)
+		changed   bool
+		level     int
+	}{
+		{"static screen sends nothing", func(s *abrSession) {}, false, 1},
+		{"static screen renders few frames", func(s *abrSession) {
+			sendFrames(s, 2, time.Millisecond)
+			s.report = &protocol.StreamStatsMessage{FPS: 1, LatencyMs: 40}
+			s.reportAt = time.Now()
+			s.rendered = 2
+		}, false, 1},
+		{"send queue drops", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.dropped = 10
+		}, true, 2},
+		{"slow writes", func(s *abrSession) {
+			sendFrames(s, 40, 80*time.Millisecond)
+		}, true, 2},
+		{"controller latency", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.report = &protocol.StreamStatsMessage{FPS: 20, LatencyMs: 800}
+			s.reportAt = time.Now()
+		}, true, 2},
+		{"stale controller report", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.report = &protocol.StreamStatsMessage{FPS: 20, LatencyMs: 800}
+			s.reportAt = time.Now().Add(-abrReportMaxAge)
+		}, false, 1},
+		{"controller decode drops", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.rendered, s.decodeDrops = 30, 10
+		}, true, 2},
+		{"cool-down after down-step", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.dropped = 10
+			s.lastDown = time.Now().Add(-time.Second)
+		}, false, 1},
+		{"floor", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.dropped = 10
+			s.level = len(h264Ladder) - 1
+		}, false, len(h264Ladder) - 1},
+		{"healthy window below threshold", func(s *abrSession) {
+			sendFrames(s, 60, time.Millisecond)
+		}, false, 1},
+		{"healthy windows step up", func(s *abrSession) {
+			sendFrames(s, 60, time.Millisecond)
+			s.goodWindows = abrUpWindows - 1
+		}, true, 0},
+		{"healthy windows within up hold-off", func(s *abrSession) {
+			sendFrames(s, 60, time.Millisecond)
+			s.goodWindows = abrUpWindows - 1
+			s.lastDown = time.Now().Add(-abrDownCooldown)
+		}, false, 1},
+		{"pinned", func(s *abrSession) {
+			sendFrames(s, 40, time.Millisecond)
+			s.dropped = 10
+			s.enabled = false
+		}, false, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := windowSession(tc.configure)
+			params, changed := s.evaluate()
+			if changed != tc.changed || s.level != tc.level {
+				t.Fatalf("changed=%v level=%d, 期望 changed=%v level=%d", changed, s.level, tc.changed, tc.level)
+			}
+			if changed && params.Bitrate != h264Ladder[tc.level].Bitrate {
+				t.Fatalf("下发码率 %d, 期望 %d", params.Bitrate, h264Ladder[tc.level].Bitrate)
+			}
+			if s.sent != 0 || s.dropped != 0 || s.rendered != 0 || s.decodeDrops != 0 {
+				t.Fatal("评估后应重置窗口计数")
+			}
+		})
+	}
+}
+
+func TestABRReportAccumulates(t *testing.T) {
+	a := NewABRController()
+	a.Start("s1", "h264")
+	a.Report("s1", protocol.StreamStatsMessage{FPS: 24.6, Dropped: 3})
+	a.Report("s1", protocol.StreamStatsMessage{FPS: 12, Dropped: 1})
+
+	stats, _ := a.Stats("s1")
+	if stats.Window.ReportRendered != 37 || stats.Window.ReportDropped != 4 {
+		t.Fatalf("窗口统计 rendered=%d dropped=%d, 期望 37 和 4", stats.Window.ReportRendered, stats.Window.ReportDropped)
+	}
+	if rate := stats.Window.ReportDropRate; rate < 0.097 || rate > 0.098 {
+		t.Fatalf("解码丢帧率 %.3f", rate)
+	}
+}
+
+func TestABRStartAtLadder(t *testing.T) {
+	cases := []struct {
+		name   string
+		params protocol.StreamControlMessage
+		want   []abrLevel
+	}{
+		{"h264 profile", protocol.StreamControlMessage{Mode: "h264", Bitrate: 1500000, FPS: 20}, []abrLevel{
+			{Bitrate: 1500000, FPS: 20},
+			{Bitrate: 1200000, FPS: 20},
+			{Bitrate: 800000, FPS: 20},
+			{Bitrate: 500000, FPS: 15},
+			{Bitrate: 300000, FPS: 10},
+		}},
+		{"mjpeg profile", protocol.StreamControlMessage{Mode: "mjpeg", Quality: 65, MaxFPS: 15}, []abrLevel{
+			{Quality: 65, FPS: 15},
+			{Quality: 60, FPS: 15},
+			{Quality: 50, FPS: 12},
+			{Quality: 40, FPS: 8},
+		}},
+		{"below default ladder", protocol.StreamControlMessage{Mode: "h264", Bitrate: 200000, FPS: 10}, []abrLevel{
+			{Bitrate: 200000, FPS: 10},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewABRController()
+			a.StartAt("s1", tc.params)
+			ladder := a.sessions["s1"].ladder
+			if len(ladder) != len(tc.want) {
+				t.Fatalf("档位 %v, 期望 %v", ladder, tc.want)
+			}
+			for i := range ladder {
+				if ladder[i] != tc.want[i] {
+					t.Fatalf("档位 %v, 期望 %v", ladder, tc.want)
+				}
+			}
+		})
+	}
+}
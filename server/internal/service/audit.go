@@ -0,0 +1,73 @@
+package service
+
+import (
+	"log"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/store"
+)
+
+// 会话审计事件
+const (
+	AuditSessionStart        = "session.start"
+	AuditSessionEnd          = "session.end"
+	AuditSessionReconnecting = "session.reconnecting"
+	AuditSessionResumed      = "session.resumed"
+	AuditConsentRequested    = "consent.requested"
+	AuditConsentGranted      = "consent.granted"
+	AuditConsentDenied       = "consent.denied"
+	AuditConsentTimeout      = "consent.timeout"
+	AuditConsentCancelled    = "consent.cancelled"
+	AuditChatTranscript      = "chat.transcript"
+	AuditSessionBandwidth    = "session.bandwidth" // 会话期间两端链路的流量（JSON）
+	AuditMacroReplay         = "macro.replay"      // REST 接口回放宏
+	AuditAPIInput            = "api.input"         // REST 接口发送的输入消息
+)
+
+// AuditAPIController 审计记录中 REST 接口注入输入时的控制端ID
+const AuditAPIController = "api"
+
+// AuditLogger 会话审计记录器
+type AuditLogger struct {
+	store *store.DeviceStore
+}
+
+// NewAuditLogger 创建会话审计记录器
+func NewAuditLogger(deviceStore *store.DeviceStore) *AuditLogger {
+	return &AuditLogger{
+		store: deviceStore,
+	}
+}
+
+// Record 记录一条会话审计事件
+func (a *AuditLogger) Record(session *model.Session, event, detail string) {
+	if session == nil {
+		return
+	}
+
+	log.Printf("会话审计: session=%s device=%s controller=%s event=%s %s",
+		session.ID, session.DeviceID, session.ControllerID, event, detail)
+
+	if a.store != nil {
+		if err := a.store.InsertSessionAudit(session.ID, session.DeviceID, session.ControllerID, event, detail); err != nil {
+			log.Printf("写入会话审计失败: %v", err)
+		}
+	}
+}
+
+// RecordAPI 记录 REST 接口向设备注入输入的审计事件，session 为空时不关联会话
+func (a *AuditLogger) RecordAPI(session *model.Session, deviceID, event, detail string) {
+	sessionID := ""
+	if session != nil {
+		sessionID = session.ID
+	}
+
+	log.Printf("会话审计: session=%s device=%s controller=%s event=%s %s",
+		sessionID, deviceID, AuditAPIController, event, detail)
+
+	if a.store != nil {
+		if err := a.store.InsertSessionAudit(sessionID, deviceID, AuditAPIController, event, detail); err != nil {
+			log.Printf("写入会话审计失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/model"
+)
+
+const bandwidthDayLayout = "2006-01-02"
+
+// SessionBandwidth 会话两端链路的流量
+type SessionBandwidth struct {
+	DeviceID     string               `json:"deviceId"`
+	ControllerID string               `json:"controllerId"`
+	Device       model.BandwidthUsage `json:"device"`
+	Controller   model.BandwidthUsage `json:"controller"`
+}
+
+// BandwidthSnapshot 进程启动以来的实时流量统计
+type BandwidthSnapshot struct {
+	Devices     map[string]model.BandwidthUsage `json:"devices"`
+	Controllers map[string]model.BandwidthUsage `json:"controllers"`
+	Viewers     map[string]model.BandwidthUsage `json:"viewers"` // 设备ID -> HTTP 观看者（MJPEG / HLS）的输出
+	Sessions    map[string]SessionBandwidth     `json:"sessions"`
+}
+
+// bandwidthKey 待持久化的设备日流量
+type bandwidthKey struct {
+	deviceID string
+	day      string
+}
+
+// BandwidthMeter 统计经服务端中转的流量（WebSocket、SFU 转发和 HTTP 观看），按设备、控制端、会话汇总，
+// 并按设备和日期累计尚未写入数据库的流量
+// 控制端的流量在会话期间同时计入所控制的设备和会话，HTTP 观看者的输出计入所观看设备的控制端链路
+type BandwidthMeter struct {
+	devices      map[string]*model.BandwidthUsage
+	controllers  map[string]*model.BandwidthUsage
+	viewers      map[string]*model.BandwidthUsage
+	sessions     map[string]*SessionBandwidth
+	byDevice     map[string]*SessionBandwidth // 设备ID -> 当前会话
+	byController map[string]*SessionBandwidth // 控制端ID -> 当前会话
+	pending      map[bandwidthKey]*model.BandwidthDaily
+	mutex        sync.Mutex
+}
+
+// NewBandwidthMeter 创建流量统计
+func NewBandwidthMeter() *BandwidthMeter {
+	return &BandwidthMeter{
+		devices:      make(map[string]*model.BandwidthUsage),
+		controllers:  make(map[string]*model.BandwidthUsage),
+		viewers:      make(map[string]*model.BandwidthUsage),
+		sessions:     make(map[string]*SessionBandwidth),
+		byDevice:     make(map[string]*SessionBandwidth),
+		byController: make(map[string]*SessionBandwidth),
+		pending:      make(map[bandwidthKey]*model.BandwidthDaily),
+	}
+}
+
+// StartSession 会话开始，之后两端的流量计入该会话
+func (m *BandwidthMeter) StartSession(sessionID, deviceID, controllerID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session := &SessionBandwidth{DeviceID: deviceID, ControllerID: controllerID}
+	m.sessions[sessionID] = session
+	m.byDevice[deviceID] = session
+	m.byController[controllerID] = session
+}
+
+// EndSession 会话结束，返回会话期间的流量
+func (m *BandwidthMeter) EndSession(sessionID string) (SessionBandwidth, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session := m.sessions[sessionID]
+	if session == nil {
+		return SessionBandwidth{}, false
+	}
+	delete(m.sessions, sessionID)
+	if m.byDevice[session.DeviceID] == session {
+		delete(m.byDevice, session.DeviceID)
+	}
+	if m.byController[session.ControllerID] == session {
+		delete(m.byController, session.ControllerID)
+	}
+	return *session, true
+}
+
+// RecordDevice 记录设备链路的流量
+func (m *BandwidthMeter) RecordDevice(deviceID string, in, out int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	addUsage(m.usage(m.devices, deviceID), in, out)
+	daily := m.daily(deviceID, time.Now())
+	addUsage(&daily.Device, in, out)
+	if session := m.byDevice[deviceID]; session != nil {
+		addUsage(&session.Device, in, out)
+	}
+}
+
+// RecordController 记录控制端链路的流量，会话期间同时计入所控制的设备
+func (m *BandwidthMeter) RecordController(controllerID string, in, out int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	addUsage(m.usage(m.controllers, controllerID), in, out)
+	if session := m.byController[controllerID]; session != nil {
+		addUsage(&session.Controller, in, out)
+		daily := m.daily(session.DeviceID, time.Now())
+		addUsage(&daily.Controller, in, out)
+	}
+}
+
+// RecordViewer 记录 HTTP 观看者（MJPEG / HLS）从服务端读取的字节数，按日计入所观看设备的控制端链路
+func (m *BandwidthMeter) RecordViewer(deviceID string, out int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	addUsage(m.usage(m.viewers, deviceID), 0, out)
+	daily := m.daily(deviceID, time.Now())
+	addUsage(&daily.Controller, 0, out)
+}
+
+// RemoveController 控制端断开后丢弃其实时统计
+func (m *BandwidthMeter) RemoveController(controllerID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.controllers, controllerID)
+}
+
+// Pending 设备自 since 所在日期起尚未写入数据库的设备链路流量
+func (m *BandwidthMeter) Pending(deviceID string, since time.Time) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	from := since.Format(bandwidthDayLayout)
+	var total int64
+	for key, daily := range m.pending {
+		if key.deviceID == deviceID && key.day >= from {
+			total += daily.Device.Total()
+		}
+	}
+	return total
+}
+
+// Flush 取出待持久化的设备日流量并清空
+func (m *BandwidthMeter) Flush() []model.BandwidthDaily {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	records := make([]model.BandwidthDaily, 0, len(m.pending))
+	for _, daily := range m.pending {
+		records = append(records, *daily)
+	}
+	m.pending = make(map[bandwidthKey]*model.BandwidthDaily)
+	return records
+}
+
+// Restore 写入数据库失败时放回待持久化的流量，下次 Flush 一并写入
+func (m *BandwidthMeter) Restore(records []model.BandwidthDaily) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, record := range records {
+		daily := m.pending[bandwidthKey{record.DeviceID, record.Day}]
+		if daily == nil {
+			daily = &model.BandwidthDaily{DeviceID: record.DeviceID, Day: record.Day}
+			m.pending[bandwidthKey{record.DeviceID, record.Day}] = daily
+		}
+		addUsage(&daily.Device, record.Device.BytesIn, record.Device.BytesOut)
+		addUsage(&daily.Controller, record.Controller.BytesIn, record.Controller.BytesOut)
+	}
+}
+
+// Snapshot 读取实时统计
+func (m *BandwidthMeter) Snapshot() BandwidthSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snap := BandwidthSnapshot{
+		Devices:     make(map[string]model.BandwidthUsage, len(m.devices)),
+		Controllers: make(map[string]model.BandwidthUsage, len(m.controllers)),
+		Viewers:     make(map[string]model.BandwidthUsage, len(m.viewers)),
+		Sessions:    make(map[string]SessionBandwidth, len(m.sessions)),
+	}
+	for id, usage := range m.devices {
+		snap.Devices[id] = *usage
+	}
+	for id, usage := range m.controllers {
+		snap.Controllers[id] = *usage
+	}
+	for id, usage := range m.viewers {
+		snap.Viewers[id] = *usage
+	}
+	for id, session := range m.sessions {
+		snap.Sessions[id] = *session
+	}
+	return snap
+}
+
+func (m *BandwidthMeter) usage(list map[string]*model.BandwidthUsage, id string) *model.BandwidthUsage {
+	usage := list[id]
+	if usage == nil {
+		usage = &model.BandwidthUsage{}
+		list[id] = usage
+	}
+	return usage
+}
+
+func (m *BandwidthMeter) daily(deviceID string, now time.Time) *model.BandwidthDaily {
+	key := bandwidthKey{deviceID, now.Format(bandwidthDayLayout)}
+	daily := m.pending[key]
+	if daily == nil {
+		daily = &model.BandwidthDaily{DeviceID: deviceID, Day: key.day}
+		m.pending[key] = daily
+	}
+	return daily
+}
+
+func addUsage(usage *model.BandwidthUsage, in, out int64) {
+	usage.BytesIn += in
+	usage.BytesOut += out
+}
+
+// MonthStart 返回 t 所在自然月的第一天零点（配额按自然月统计）
+func MonthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
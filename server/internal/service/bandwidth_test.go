@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/model"
+)
+
+// dailyOf 按设备ID查找 Flush 取出的日流量
+func dailyOf(records []model.BandwidthDaily, deviceID string) (model.BandwidthDaily, bool) {
+	for _, record := range records {
+		if record.DeviceID == deviceID {
+			return record, true
+		}
+	}
+	return model.BandwidthDaily{}, false
+}
+
+func TestBandwidthMeterSession(t *testing.T) {
+	m := NewBandwidthMeter()
+
+	// 会话之外的控制端流量不计入任何设备
+	m.RecordController("c1", 10, 20)
+	m.StartSession("s1", "d1", "c1")
+	m.RecordDevice("d1", 100, 200)
+	m.RecordController("c1", 30, 40)
+	m.RecordViewer("d1", 500)
+
+	usage, ok := m.EndSession("s1")
+	if !ok {
+		t.Fatal("会话统计不存在")
+	}
+	if usage.Device != (model.BandwidthUsage{BytesIn: 100, BytesOut: 200}) || usage.Controller != (model.BandwidthUsage{BytesIn: 30, BytesOut: 40}) {
+		t.Fatalf("会话流量 %+v", usage)
+	}
+	if _, ok := m.EndSession("s1"); ok {
+		t.Fatal("会话重复结束")
+	}
+
+	// 会话结束后控制端的流量不再计入设备
+	m.RecordController("c1", 1, 1)
+
+	snap := m.Snapshot()
+	if snap.Controllers["c1"] != (model.BandwidthUsage{BytesIn: 41, BytesOut: 61}) || snap.Viewers["d1"].BytesOut != 500 {
+		t.Fatalf("实时统计 %+v", snap)
+	}
+
+	daily, ok := dailyOf(m.Flush(), "d1")
+	if !ok {
+		t.Fatal("没有待持久化的设备流量")
+	}
+	if daily.Device != (model.BandwidthUsage{BytesIn: 100, BytesOut: 200}) || daily.Controller != (model.BandwidthUsage{BytesIn: 30, BytesOut: 540}) {
+		t.Fatalf("日流量 %+v", daily)
+	}
+}
+
+func TestBandwidthMeterFlushRestore(t *testing.T) {
+	m := NewBandwidthMeter()
+	m.RecordDevice("d1", 100, 200)
+
+	now := time.Now()
+	if pending := m.Pending("d1", MonthStart(now)); pending != 300 {
+		t.Fatalf("待持久化流量 %d, 期望 300", pending)
+	}
+	if pending := m.Pending("d1", now.AddDate(0, 0, 1)); pending != 0 {
+		t.Fatalf("since 之前的流量不应计入: %d", pending)
+	}
+
+	records := m.Flush()
+	if len(records) != 1 || m.Pending("d1", MonthStart(now)) != 0 || len(m.Flush()) != 0 {
+		t.Fatalf("Flush 后应清空: %+v", records)
+	}
+
+	// 写入失败放回后与新流量合并
+	m.Restore(records)
+	m.RecordDevice("d1", 1, 2)
+	daily, ok := dailyOf(m.Flush(), "d1")
+	if !ok || daily.Device != (model.BandwidthUsage{BytesIn: 101, BytesOut: 202}) {
+		t.Fatalf("放回后日流量 %+v", daily)
+	}
+}
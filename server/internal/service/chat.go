@@ -0,0 +1,43 @@
+package service
+
+import (
+	"sync"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const maxChatTranscript = 1000 // 单个会话最多保留的聊天记录数
+
+// ChatManager 按会话保存聊天记录，会话结束时写入聊天记录表
+type ChatManager struct {
+	transcripts map[string][]protocol.ChatMessage // sessionID -> 聊天记录
+	mutex       sync.Mutex
+}
+
+// NewChatManager 创建聊天记录管理器
+func NewChatManager() *ChatManager {
+	return &ChatManager{
+		transcripts: make(map[string][]protocol.ChatMessage),
+	}
+}
+
+// Append 追加一条已转发的聊天消息
+func (cm *ChatManager) Append(msg protocol.ChatMessage) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if len(cm.transcripts[msg.SessionID]) >= maxChatTranscript {
+		return
+	}
+	cm.transcripts[msg.SessionID] = append(cm.transcripts[msg.SessionID], msg)
+}
+
+// Take 取出并清除会话的聊天记录
+func (cm *ChatManager) Take(sessionID string) []protocol.ChatMessage {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	transcript := cm.transcripts[sessionID]
+	delete(cm.transcripts, sessionID)
+	return transcript
+}
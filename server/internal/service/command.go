@@ -0,0 +1,101 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// CommandTracker 跟踪等待设备回执的请求（command.result）
+type CommandTracker struct {
+	pending map[string]*pendingCommand // deviceID/requestID -> 等待中的请求
+	mutex   sync.Mutex
+}
+
+type pendingCommand struct {
+	deviceID string
+	timer    *time.Timer
+	done     func(protocol.CommandResultMessage)
+}
+
+// NewCommandTracker 创建请求回执跟踪器
+func NewCommandTracker() *CommandTracker {
+	return &CommandTracker{
+		pending: make(map[string]*pendingCommand),
+	}
+}
+
+// Track 登记等待回执的请求，超时后以 timeout 状态回调 done
+// 同一设备上 requestID 重复时返回 false
+func (ct *CommandTracker) Track(deviceID, requestID string, timeout time.Duration, done func(protocol.CommandResultMessage)) bool {
+	key := deviceID + "/" + requestID
+
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	if _, ok := ct.pending[key]; ok {
+		return false
+	}
+	ct.pending[key] = &pendingCommand{
+		deviceID: deviceID,
+		done:     done,
+		timer: time.AfterFunc(timeout, func() {
+			if cmd := ct.take(key); cmd != nil {
+				cmd.done(protocol.CommandResultMessage{
+					Type:      protocol.TypeCommandResult,
+					RequestID: requestID,
+					Status:    protocol.CommandStatusTimeout,
+					Error:     "设备未在规定时间内回执",
+				})
+			}
+		}),
+	}
+	return true
+}
+
+// Resolve 设备回执到达，回调发起方；没有对应请求时返回 false
+func (ct *CommandTracker) Resolve(deviceID string, result protocol.CommandResultMessage) bool {
+	cmd := ct.take(deviceID + "/" + result.RequestID)
+	if cmd == nil {
+		return false
+	}
+	cmd.timer.Stop()
+	cmd.done(result)
+	return true
+}
+
+// FailDevice 设备断开时以 failure 状态结束该设备所有等待中的请求
+func (ct *CommandTracker) FailDevice(deviceID, reason string) {
+	ct.mutex.Lock()
+	failed := make(map[string]*pendingCommand)
+	for key, cmd := range ct.pending {
+		if cmd.deviceID == deviceID {
+			failed[key] = cmd
+			delete(ct.pending, key)
+		}
+	}
+	ct.mutex.Unlock()
+
+	for key, cmd := range failed {
+		cmd.timer.Stop()
+		cmd.done(protocol.CommandResultMessage{
+			Type:      protocol.TypeCommandResult,
+			RequestID: key[len(deviceID)+1:],
+			Status:    protocol.CommandStatusFailure,
+			Error:     reason,
+		})
+	}
+}
+
+func (ct *CommandTracker) take(key string) *pendingCommand {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	cmd, ok := ct.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(ct.pending, key)
+	return cmd
+}
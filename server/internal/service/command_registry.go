@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+// 命令执行权限
+const (
+	PermissionControl = "control" // 持有控制会话的控制端和管理 API 均可执行
+	PermissionAdmin   = "admin"   // 仅管理 API 可执行
+)
+
+// 命令参数类型
+const (
+	ArgTypeString = "string"
+	ArgTypeNumber = "number"
+	ArgTypeBool   = "bool"
+)
+
+// 命令校验错误码
+const (
+	ErrCodeUnknownCommand     = "UNKNOWN_COMMAND"     // 服务端未注册该命令
+	ErrCodeUnsupportedCommand = "UNSUPPORTED_COMMAND" // 设备不支持该命令
+	ErrCodePermissionDenied   = "PERMISSION_DENIED"   // 调用方权限不足
+)
+
+// CommandDef 已注册的设备命令
+type CommandDef struct {
+	protocol.CommandInfo
+}
+
+// CommandRegistry input.command 命令注册表
+// 只有注册过且设备上报支持的命令才会转发到设备
+type CommandRegistry struct {
+	commands map[string]CommandDef
+	mutex    sync.RWMutex
+}
+
+// NewCommandRegistry 创建命令注册表并注册内置命令
+func NewCommandRegistry() *CommandRegistry {
+	r := &CommandRegistry{
+		commands: make(map[string]CommandDef),
+	}
+	r.Register(CommandDef{protocol.CommandInfo{
+		Name:        "hide_keyboard",
+		Description: "隐藏软键盘",
+		Permission:  PermissionControl,
+	}})
+	return r
+}
+
+// Register 注册或替换命令
+func (r *CommandRegistry) Register(def CommandDef) {
+	if def.Permission == "" {
+		def.Permission = PermissionControl
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands[def.Name] = def
+}
+
+// ListFor 列出设备支持且已注册的命令
+func (r *CommandRegistry) ListFor(device *model.Device) []protocol.CommandInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]protocol.CommandInfo, 0)
+	for _, name := range device.Commands {
+		if def, ok := r.commands[name]; ok {
+			list = append(list, def.CommandInfo)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Authorize 校验命令是否已注册、设备是否支持、调用方权限及参数
+func (r *CommandRegistry) Authorize(device *model.Device, msg *protocol.CommandMessage, permission string) error {
+	r.mutex.RLock()
+	def, ok := r.commands[msg.Command]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return &protocol.ValidationError{Code: ErrCodeUnknownCommand, Message: "未知命令: " + msg.Command}
+	}
+	if !device.SupportsCommand(msg.Command) {
+		return &protocol.ValidationError{Code: ErrCodeUnsupportedCommand, Message: "设备不支持命令: " + msg.Command}
+	}
+	if def.Permission == PermissionAdmin && permission != PermissionAdmin {
+		return &protocol.ValidationError{Code: ErrCodePermissionDenied, Message: "命令仅允许通过管理 API 执行: " + msg.Command}
+	}
+	return validateCommandArgs(def.Args, msg.Args)
+}
+
+func validateCommandArgs(defs []protocol.CommandArg, args map[string]interface{}) error {
+	known := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		known[def.Name] = true
+		value, ok := args[def.Name]
+		if !ok {
+			if def.Required {
+				return invalidArg("缺少参数 %s", def.Name)
+			}
+			continue
+		}
+		if err := validateCommandArg(def, value); err != nil {
+			return err
+		}
+	}
+	for name := range args {
+		if !known[name] {
+			return invalidArg("未知参数 %s", name)
+		}
+	}
+	return nil
+}
+
+func validateCommandArg(def protocol.CommandArg, value interface{}) error {
+	switch def.Type {
+	case ArgTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return invalidArg("参数 %s 应为字符串", def.Name)
+		}
+		if def.Max != nil && float64(utf8.RuneCountInString(s)) > *def.Max {
+			return invalidArg("参数 %s 超过 %.0f 字符", def.Name, *def.Max)
+		}
+		if len(def.Enum) > 0 && !slices.Contains(def.Enum, s) {
+			return invalidArg("参数 %s 取值无效: %q", def.Name, s)
+		}
+	case ArgTypeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return invalidArg("参数 %s 应为数字", def.Name)
+		}
+		if (def.Min != nil && n < *def.Min) || (def.Max != nil && n > *def.Max) {
+			return invalidArg("参数 %s 超出范围", def.Name)
+		}
+	case ArgTypeBool:
+		if _, ok := value.(bool); !ok {
+			return invalidArg("参数 %s 应为布尔值", def.Name)
+		}
+	}
+	return nil
+}
+
+func invalidArg(format string, args ...interface{}) error {
+	return &protocol.ValidationError{Code: protocol.ErrCodeInvalidParam, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// testRegistry 内置命令之外注册一条仅管理 API 可执行的带参数命令
+func testRegistry() *CommandRegistry {
+	r := NewCommandRegistry()
+	r.Register(CommandDef{protocol.CommandInfo{
+		Name:       "set_volume",
+		Permission: PermissionAdmin,
+		Args: []protocol.CommandArg{
+			{Name: "level", Type: ArgTypeNumber, Required: true, Min: floatPtr(0), Max: floatPtr(15)},
+			{Name: "stream", Type: ArgTypeString, Enum: []string{"music", "ring"}},
+			{Name: "label", Type: ArgTypeString, Max: floatPtr(4)},
+			{Name: "mute", Type: ArgTypeBool},
+		},
+	}})
+	return r
+}
+
+func TestCommandRegistryAuthorize(t *testing.T) {
+	r := testRegistry()
+	device := &model.Device{ID: "d1", Commands: []string{"hide_keyboard", "set_volume", "reboot"}}
+	cases := []struct {
+		name       string
+		command    string
+		args       map[string]interface{}
+		permission string
+		code       string // 为空表示应通过
+	}{
+		{"builtin from controller", "hide_keyboard", nil, PermissionControl, ""},
+		{"builtin from admin", "hide_keyboard", nil, PermissionAdmin, ""},
+		{"admin command from admin", "set_volume", map[string]interface{}{"level": 7.0}, PermissionAdmin, ""},
+		{"admin command from controller", "set_volume", map[string]interface{}{"level": 7.0}, PermissionControl, ErrCodePermissionDenied},
+		// 设备声明了但服务端未注册的命令不转发
+		{"unregistered", "reboot", nil, PermissionAdmin, ErrCodeUnknownCommand},
+		{"all args", "set_volume", map[string]interface{}{"level": 15.0, "stream": "ring", "label": "高音量", "mute": false}, PermissionAdmin, ""},
+		{"missing required", "set_volume", map[string]interface{}{"stream": "music"}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"unknown arg", "set_volume", map[string]interface{}{"level": 1.0, "force": true}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"args for command without args", "hide_keyboard", map[string]interface{}{"now": true}, PermissionControl, protocol.ErrCodeInvalidParam},
+		{"number below min", "set_volume", map[string]interface{}{"level": -1.0}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"number above max", "set_volume", map[string]interface{}{"level": 16.0}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"number as string", "set_volume", map[string]interface{}{"level": "7"}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"enum mismatch", "set_volume", map[string]interface{}{"level": 1.0, "stream": "alarm"}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"string as number", "set_volume", map[string]interface{}{"level": 1.0, "stream": 1.0}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		// 字符串长度按字符计算
+		{"string too long", "set_volume", map[string]interface{}{"level": 1.0, "label": "12345"}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+		{"bool as string", "set_volume", map[string]interface{}{"level": 1.0, "mute": "true"}, PermissionAdmin, protocol.ErrCodeInvalidParam},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := r.Authorize(device, &protocol.CommandMessage{Command: tc.command, Args: tc.args}, tc.permission)
+			if tc.code == "" {
+				if err != nil {
+					t.Fatalf("校验失败: %v", err)
+				}
+				return
+			}
+			var ve *protocol.ValidationError
+			if !errors.As(err, &ve) || ve.Code != tc.code {
+				t.Fatalf("校验结果 %v, 期望 %s", err, tc.code)
+			}
+		})
+	}
+}
+
+func TestCommandRegistryUnsupportedDevice(t *testing.T) {
+	r := testRegistry()
+	device := &model.Device{ID: "d1", Commands: []string{"hide_keyboard"}}
+
+	// 已注册但设备未声明的命令
+	err := r.Authorize(device, &protocol.CommandMessage{Command: "set_volume", Args: map[string]interface{}{"level": 1.0}}, PermissionAdmin)
+	var ve *protocol.ValidationError
+	if !errors.As(err, &ve) || ve.Code != ErrCodeUnsupportedCommand {
+		t.Fatalf("校验结果 %v, 期望 %s", err, ErrCodeUnsupportedCommand)
+	}
+
+	// 命令列表只包含设备声明且已注册的命令
+	list := r.ListFor(&model.Device{Commands: []string{"set_volume", "reboot", "hide_keyboard"}})
+	if len(list) != 2 || list[0].Name != "hide_keyboard" || list[1].Name != "set_volume" {
+		t.Fatalf("命令列表 %+v", list)
+	}
+}
+
+func TestCommandRegistryDefaultPermission(t *testing.T) {
+	r := NewCommandRegistry()
+	r.Register(CommandDef{protocol.CommandInfo{Name: "lock_screen"}})
+	device := &model.Device{Commands: []string{"lock_screen"}}
+
+	// 未指定权限的命令控制端也可执行
+	if err := r.Authorize(device, &protocol.CommandMessage{Command: "lock_screen"}, PermissionControl); err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if list := r.ListFor(device); len(list) != 1 || list[0].Permission != PermissionControl {
+		t.Fatalf("命令列表 %+v", list)
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// ConsentManager 管理等待现场用户同意的控制请求
+type ConsentManager struct {
+	pending map[string]*time.Timer // sessionID -> 超时定时器
+	mutex   sync.Mutex
+}
+
+// NewConsentManager 创建同意请求管理器
+func NewConsentManager() *ConsentManager {
+	return &ConsentManager{
+		pending: make(map[string]*time.Timer),
+	}
+}
+
+// Begin 登记等待同意的会话，超时未答复时调用 onTimeout
+func (cm *ConsentManager) Begin(sessionID string, timeout time.Duration, onTimeout func()) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if timer, ok := cm.pending[sessionID]; ok {
+		timer.Stop()
+	}
+	cm.pending[sessionID] = time.AfterFunc(timeout, func() {
+		if cm.Resolve(sessionID) {
+			onTimeout()
+		}
+	})
+}
+
+// Resolve 结束等待，返回该会话此前是否仍在等待中
+// 答复、超时和取消三者只有一个能成功结束等待
+func (cm *ConsentManager) Resolve(sessionID string) bool {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	timer, ok := cm.pending[sessionID]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(cm.pending, sessionID)
+	return true
+}
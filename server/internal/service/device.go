@@ -45,20 +45,27 @@ func (dm *DeviceManager) Register(device *model.Device) {
 }
 
 // Unregister 注销设备
-func (dm *DeviceManager) Unregister(deviceID string) {
+// 设备已用新连接重新注册时不做处理并返回 false，避免旧连接的断开覆盖新连接
+func (dm *DeviceManager) Unregister(device *model.Device) bool {
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
 
-	if device, ok := dm.devices[deviceID]; ok {
-		device.Online = false
-		device.Conn = nil
+	current, ok := dm.devices[device.ID]
+	if ok && current != device {
+		return false
 	}
 
+	device.Online = false
+	device.ConnMutex.Lock()
+	device.Conn = nil
+	device.ConnMutex.Unlock()
+
 	if dm.store != nil {
-		if err := dm.store.SetOnline(deviceID, false); err != nil {
+		if err := dm.store.SetOnline(device.ID, false); err != nil {
 			log.Printf("更新设备离线状态失败: %v", err)
 		}
 	}
+	return true
 }
 
 // Get 获取设备
@@ -80,6 +87,20 @@ func (dm *DeviceManager) GetOnline(deviceID string) *model.Device {
 	return nil
 }
 
+// ListOnline 获取所有在线设备
+func (dm *DeviceManager) ListOnline() []*model.Device {
+	dm.mutex.RLock()
+	defer dm.mutex.RUnlock()
+
+	list := make([]*model.Device, 0, len(dm.devices))
+	for _, device := range dm.devices {
+		if device.Online {
+			list = append(list, device)
+		}
+	}
+	return list
+}
+
 // List 获取所有设备列表
 func (dm *DeviceManager) List() []protocol.DeviceInfo {
 	dm.mutex.RLock()
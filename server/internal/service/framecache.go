@@ -0,0 +1,165 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const keyframeRequestInterval = time.Second // 同一设备 stream.keyframe 请求最小间隔
+
+// FrameCache 按设备缓存最新的 H264 配置帧和关键帧（MJPEG 缓存最新一帧）
+// 新的接收方加入时先发送缓存帧，无需等待下一个 IDR 即可开始渲染
+// 接收方在 Prime 之后才放行实时帧，保证缓存帧排在实时帧之前
+type FrameCache struct {
+	devices   map[string]*cachedFrames
+	receivers map[string]map[string]bool // deviceID -> 已收到缓存帧的接收方
+	maxAge    time.Duration              // 关键帧超过该时长视为过期
+	mutex     sync.Mutex
+}
+
+type cachedFrames struct {
+	config      []byte // 完整二进制帧（含帧头）
+	keyframe    []byte
+	keyAt       time.Time
+	lastRequest time.Time
+}
+
+// NewFrameCache 创建帧缓存
+func NewFrameCache(maxAge time.Duration) *FrameCache {
+	return &FrameCache{
+		devices:   make(map[string]*cachedFrames),
+		receivers: make(map[string]map[string]bool),
+		maxAge:    maxAge,
+	}
+}
+
+// Update 记录设备推送的帧
+// 帧数据直接引用，不复制：WebSocket 每次读取都会分配新的缓冲区
+func (fc *FrameCache) Update(deviceID string, frame []byte) {
+	frameType, flags, _, ok := protocol.ParseFrame(frame)
+	if !ok {
+		return
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	cache := fc.devices[deviceID]
+	if cache == nil {
+		cache = &cachedFrames{}
+		fc.devices[deviceID] = cache
+	}
+
+	switch frameType {
+	case protocol.BinaryTypeH264Config:
+		// 编码参数变化后旧关键帧无法解码
+		cache.config = frame
+		cache.keyframe = nil
+	case protocol.BinaryTypeH264Frame:
+		if flags&protocol.FrameFlagKeyFrame != 0 && cache.config != nil {
+			cache.keyframe = frame
+			cache.keyAt = time.Now()
+		}
+	case protocol.BinaryTypeScreenFrame:
+		cache.config = nil
+		cache.keyframe = frame
+		cache.keyAt = time.Now()
+	}
+}
+
+// Snapshot 返回新接收方需要先收到的帧（配置帧 + 关键帧），stale 表示缓存缺失或已过期
+func (fc *FrameCache) Snapshot(deviceID string) (frames [][]byte, stale bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.snapshotLocked(deviceID)
+}
+
+// Prime 把缓存帧交给新的接收方（send 只应入队，不应阻塞），之后 Admitted 放行该接收方的实时帧
+// 返回 stale 表示缓存缺失或已过期，应请求设备输出关键帧
+func (fc *FrameCache) Prime(deviceID, receiverID string, send func(frame []byte) error) (stale bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	frames, stale := fc.snapshotLocked(deviceID)
+	for _, frame := range frames {
+		if err := send(frame); err != nil {
+			break
+		}
+	}
+	if fc.receivers[deviceID] == nil {
+		fc.receivers[deviceID] = make(map[string]bool)
+	}
+	fc.receivers[deviceID][receiverID] = true
+	return stale
+}
+
+// Admitted 接收方是否已收到缓存帧；Prime 之前到达的实时帧应丢弃，否则会排在缓存帧之前
+func (fc *FrameCache) Admitted(deviceID, receiverID string) bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.receivers[deviceID][receiverID]
+}
+
+// Release 接收方离开（会话结束），下次加入时需重新 Prime
+func (fc *FrameCache) Release(deviceID, receiverID string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	delete(fc.receivers[deviceID], receiverID)
+	if len(fc.receivers[deviceID]) == 0 {
+		delete(fc.receivers, deviceID)
+	}
+}
+
+// Invalidate 丢弃缓存的帧：推流参数变化（stream.start / stream.stop）或会话结束后，缓存帧与之后的画面不再匹配
+func (fc *FrameCache) Invalidate(deviceID string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if cache := fc.devices[deviceID]; cache != nil {
+		cache.config = nil
+		cache.keyframe = nil
+	}
+}
+
+func (fc *FrameCache) snapshotLocked(deviceID string) (frames [][]byte, stale bool) {
+	cache := fc.devices[deviceID]
+	if cache == nil || cache.keyframe == nil {
+		return nil, true
+	}
+	if cache.config != nil {
+		frames = append(frames, cache.config)
+	}
+	frames = append(frames, cache.keyframe)
+	return frames, time.Since(cache.keyAt) > fc.maxAge
+}
+
+// ShouldRequestKeyframe 是否应向设备发送 stream.keyframe（限制请求频率）
+func (fc *FrameCache) ShouldRequestKeyframe(deviceID string) bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	cache := fc.devices[deviceID]
+	if cache == nil {
+		cache = &cachedFrames{}
+		fc.devices[deviceID] = cache
+	}
+	if time.Since(cache.lastRequest) < keyframeRequestInterval {
+		return false
+	}
+	cache.lastRequest = time.Now()
+	return true
+}
+
+// Clear 设备断开后清除缓存（设备重连后编码器会重新输出配置帧）
+// 已放行的接收方保留：重连宽限期内会话仍然有效
+func (fc *FrameCache) Clear(deviceID string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	delete(fc.devices, deviceID)
+}
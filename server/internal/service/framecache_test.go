@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+func TestFrameCachePrime(t *testing.T) {
+	config := []byte{protocol.BinaryTypeH264Config, 0, 1}
+	key := []byte{protocol.BinaryTypeH264Frame, protocol.FrameFlagKeyFrame, 2}
+	delta := []byte{protocol.BinaryTypeH264Frame, 0, 3}
+
+	fc := NewFrameCache(time.Minute)
+	fc.Update("d1", config)
+	fc.Update("d1", key)
+	fc.Update("d1", delta)
+
+	if fc.Admitted("d1", "c1") {
+		t.Fatal("Prime 之前不应放行实时帧")
+	}
+	var sent [][]byte
+	stale := fc.Prime("d1", "c1", func(frame []byte) error {
+		sent = append(sent, frame)
+		return nil
+	})
+	if stale || len(sent) != 2 || !bytes.Equal(sent[0], config) || !bytes.Equal(sent[1], key) {
+		t.Fatalf("Prime 发送 %x stale=%v, 期望配置帧和关键帧", sent, stale)
+	}
+	if !fc.Admitted("d1", "c1") || fc.Admitted("d1", "c2") {
+		t.Fatal("只有 Prime 过的接收方应被放行")
+	}
+
+	// 设备断线重连期间接收方保持放行
+	fc.Clear("d1")
+	if !fc.Admitted("d1", "c1") {
+		t.Fatal("Clear 不应移除接收方")
+	}
+	fc.Release("d1", "c1")
+	if fc.Admitted("d1", "c1") {
+		t.Fatal("Release 后接收方应重新 Prime")
+	}
+}
+
+func TestFrameCacheInvalidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"h264", [][]byte{{protocol.BinaryTypeH264Config, 0, 1}, {protocol.BinaryTypeH264Frame, protocol.FrameFlagKeyFrame, 2}}},
+		{"mjpeg", [][]byte{{protocol.BinaryTypeScreenFrame, 0, 0xff, 0xd8}}},
+		{"raw jpeg", [][]byte{{0xff, 0xd8, 0xff, 0xe0}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fc := NewFrameCache(time.Minute)
+			for _, frame := range tc.frames {
+				fc.Update("d1", frame)
+			}
+			if frames, stale := fc.Snapshot("d1"); stale || len(frames) != len(tc.frames) {
+				t.Fatalf("Snapshot = %d 帧 stale=%v", len(frames), stale)
+			}
+
+			// 推流参数变化后旧帧不再发给新的接收方
+			fc.Invalidate("d1")
+			if frames, stale := fc.Snapshot("d1"); !stale || len(frames) != 0 {
+				t.Fatalf("Invalidate 后 Snapshot = %d 帧 stale=%v", len(frames), stale)
+			}
+		})
+	}
+}
+
+func TestFrameCacheConfigChangeDropsKeyframe(t *testing.T) {
+	fc := NewFrameCache(time.Minute)
+	fc.Update("d1", []byte{protocol.BinaryTypeH264Config, 0, 1})
+	fc.Update("d1", []byte{protocol.BinaryTypeH264Frame, protocol.FrameFlagKeyFrame, 2})
+	fc.Update("d1", []byte{protocol.BinaryTypeH264Config, 0, 9})
+
+	if frames, stale := fc.Snapshot("d1"); !stale || len(frames) != 0 {
+		t.Fatalf("新配置帧之后旧关键帧仍被缓存: %d 帧 stale=%v", len(frames), stale)
+	}
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/media"
+	"shushu-remote-control/internal/protocol"
+)
+
+const (
+	// 目标分片时长：分片只在关键帧处切分，当前分片超过该时长时向设备请求关键帧；
+	// 画面静止（设备不再发帧）超过该时长时结束当前分片，之后等待下一个关键帧
+	hlsSegmentDuration = 2 * time.Second
+	hlsWindow          = 6 // 播放列表保留的分片数量
+)
+
+// hlsSegment 内存中的一个 fMP4 分片
+type hlsSegment struct {
+	seq      uint64
+	init     int // 所属初始化分片编号
+	data     []byte
+	duration float64 // 秒
+}
+
+// hlsStream 单个设备的 HLS 直播流
+type hlsStream struct {
+	lastAccess time.Time
+	ready      chan struct{} // 第一个分片生成后关闭
+
+	muxer        *media.Muxer
+	segmentStart time.Time // 当前分片第一帧（关键帧）的时间，零值表示等待关键帧开始新分片
+	lastFrame    time.Time
+	keyRequested time.Time // 上次请求关键帧的时间
+	initID       int
+	inits        map[int][]byte
+	segments     []hlsSegment
+	nextSeq      uint64
+	discontinued uint64 // 已移出窗口的编码参数切换次数（EXT-X-DISCONTINUITY-SEQUENCE）
+}
+
+// HLSManager 将设备的 H264 帧封装为 fMP4 分片并生成 HLS 直播播放列表
+// 只有被观看（最近请求过播放列表）的设备才会封装，分片保存在内存中并滚动淘汰
+type HLSManager struct {
+	streams map[string]*hlsStream
+	idle    time.Duration
+	mutex   sync.Mutex
+}
+
+// NewHLSManager 创建 HLS 管理器，idle 内没有请求播放列表的流会被 Expire 回收
+func NewHLSManager(idle time.Duration) *HLSManager {
+	return &HLSManager{
+		streams: make(map[string]*hlsStream),
+		idle:    idle,
+	}
+}
+
+// Watch 标记设备正在被观看，first 表示新建了该设备的流
+func (m *HLSManager) Watch(deviceID string) (first bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stream := m.streams[deviceID]
+	if stream == nil {
+		stream = &hlsStream{
+			ready:        make(chan struct{}),
+			inits:        make(map[int][]byte),
+			keyRequested: time.Now(), // 开始观看时 WatchHLS 已按缓存情况请求关键帧
+		}
+		m.streams[deviceID] = stream
+		first = true
+	}
+	stream.lastAccess = time.Now()
+	return first
+}
+
+// Watching 设备是否有 HLS 观看者
+func (m *HLSManager) Watching(deviceID string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.streams[deviceID] != nil
+}
+
+// WaitReady 等待设备的第一个分片，超时或流被回收时返回 false
+func (m *HLSManager) WaitReady(ctx context.Context, deviceID string) bool {
+	m.mutex.Lock()
+	stream := m.streams[deviceID]
+	m.mutex.Unlock()
+	if stream == nil {
+		return false
+	}
+
+	select {
+	case <-stream.ready:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Write 写入一帧屏幕数据，未被观看的设备直接忽略
+func (m *HLSManager) Write(deviceID string, frame []byte, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stream := m.streams[deviceID]
+	if stream == nil {
+		return
+	}
+
+	frameType, flags, payload, ok := protocol.ParseFrame(frame)
+	if !ok {
+		return
+	}
+	switch frameType {
+	case protocol.BinaryTypeH264Config:
+		if stream.muxer != nil && stream.muxer.SameConfig(payload) {
+			return
+		}
+		muxer, err := media.NewMuxer(payload)
+		if err != nil {
+			return
+		}
+		if stream.muxer != nil {
+			if frag := stream.muxer.Flush(); frag != nil {
+				stream.append(frag)
+			}
+		}
+		stream.muxer = muxer
+		stream.segmentStart = time.Time{}
+		stream.initID++
+		stream.inits[stream.initID] = muxer.Init()
+
+	case protocol.BinaryTypeH264Frame:
+		if stream.muxer == nil {
+			return
+		}
+		key := flags&protocol.FrameFlagKeyFrame != 0
+		if !key && stream.segmentStart.IsZero() {
+			return
+		}
+		if frag := stream.muxer.Push(payload, key, at); frag != nil {
+			stream.append(frag)
+		}
+		if key {
+			stream.segmentStart = at
+		}
+		stream.lastFrame = at
+	}
+}
+
+// Tick 推进各设备的分片，返回需要请求关键帧的设备（每个设备每个目标分片时长最多一次）
+// 当前分片超过目标时长，或还在等待分片开始的关键帧时请求关键帧；
+// 画面静止超过目标时长时直接结束当前分片，使静止画面也能生成分片
+func (m *HLSManager) Tick(now time.Time) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var due []string
+	for deviceID, stream := range m.streams {
+		start := stream.segmentStart
+		if !start.IsZero() && now.Sub(stream.lastFrame) >= hlsSegmentDuration {
+			if frag := stream.muxer.Flush(); frag != nil {
+				stream.append(frag)
+			}
+			stream.segmentStart = time.Time{}
+			start = time.Time{}
+		}
+		if (start.IsZero() || now.Sub(start) >= hlsSegmentDuration) && now.Sub(stream.keyRequested) >= hlsSegmentDuration {
+			stream.keyRequested = now
+			due = append(due, deviceID)
+		}
+	}
+	return due
+}
+
+// append 加入新分片并淘汰超出窗口的分片和不再引用的初始化分片
+func (s *hlsStream) append(frag *media.Fragment) {
+	s.segments = append(s.segments, hlsSegment{
+		seq:      s.nextSeq,
+		init:     s.initID,
+		data:     frag.Data,
+		duration: float64(frag.Duration) / media.Timescale,
+	})
+	s.nextSeq++
+	if len(s.segments) == 1 && s.nextSeq == 1 {
+		close(s.ready)
+	}
+
+	for len(s.segments) > hlsWindow {
+		removed := s.segments[0]
+		s.segments = s.segments[1:]
+		if s.segments[0].init != removed.init {
+			s.discontinued++
+			delete(s.inits, removed.init)
+		}
+	}
+}
+
+// Playlist 生成设备的直播播放列表，query 会附加到分片地址（用于传递 token）
+func (m *HLSManager) Playlist(deviceID, query string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stream := m.streams[deviceID]
+	if stream == nil || len(stream.segments) == 0 {
+		return "", false
+	}
+	stream.lastAccess = time.Now()
+
+	target := 1.0
+	for _, seg := range stream.segments {
+		target = math.Max(target, math.Ceil(seg.duration))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", stream.segments[0].seq)
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", stream.discontinued)
+	initID := 0
+	for _, seg := range stream.segments {
+		if seg.init != initID {
+			if initID != 0 {
+				b.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
+			initID = seg.init
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"init-%d.mp4%s\"\n", initID, query)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", seg.duration)
+		fmt.Fprintf(&b, "segment-%d.m4s%s\n", seg.seq, query)
+	}
+	return b.String(), true
+}
+
+// Init 获取初始化分片
+func (m *HLSManager) Init(deviceID string, id int) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stream := m.streams[deviceID]
+	if stream == nil {
+		return nil, false
+	}
+	data, ok := stream.inits[id]
+	return data, ok
+}
+
+// Segment 获取媒体分片（已移出窗口的分片返回 false）
+func (m *HLSManager) Segment(deviceID string, seq uint64) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stream := m.streams[deviceID]
+	if stream == nil {
+		return nil, false
+	}
+	for _, seg := range stream.segments {
+		if seg.seq == seq {
+			return seg.data, true
+		}
+	}
+	return nil, false
+}
+
+// Expire 回收长时间没有请求播放列表的流，返回被回收的设备ID
+func (m *HLSManager) Expire(now time.Time) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var expired []string
+	for deviceID, stream := range m.streams {
+		if now.Sub(stream.lastAccess) > m.idle {
+			delete(m.streams, deviceID)
+			expired = append(expired, deviceID)
+		}
+	}
+	return expired
+}
+
+// CloseDevice 设备离线时丢弃其流
+func (m *HLSManager) CloseDevice(deviceID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.streams, deviceID)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// 合成的 H264 Baseline 参数集（320x240），与模拟设备一致
+var hlsTestConfig = append([]byte{protocol.BinaryTypeH264Config, 0},
+	0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4,
+	0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80)
+
+func hlsTestFrame(key bool) []byte {
+	flags, header := byte(0), byte(0x41)
+	if key {
+		flags, header = protocol.FrameFlagKeyFrame, 0x65
+	}
+	return []byte{protocol.BinaryTypeH264Frame, flags, 0, 0, 0, 1, header, 0x88, 0x5a, 0x5a}
+}
+
+func TestHLSSegmentsStartAtKeyframes(t *testing.T) {
+	m := NewHLSManager(time.Minute)
+	m.Watch("d1")
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	m.Write("d1", hlsTestConfig, at(0))
+	m.Write("d1", hlsTestFrame(false), at(0)) // 关键帧之前的 P 帧丢弃
+	m.Write("d1", hlsTestFrame(true), at(40))
+	for ms := 80; ms <= 3000; ms += 40 {
+		m.Write("d1", hlsTestFrame(false), at(ms))
+	}
+	if _, ok := m.Playlist("d1", ""); ok {
+		t.Fatal("没有新的关键帧时不应按时长切分")
+	}
+	if due := m.Tick(at(3000)); len(due) != 1 || due[0] != "d1" {
+		t.Fatalf("分片超过目标时长应请求关键帧: %v", due)
+	}
+	if due := m.Tick(at(3500)); len(due) != 0 {
+		t.Fatalf("目标分片时长内只请求一次关键帧: %v", due)
+	}
+
+	m.Write("d1", hlsTestFrame(true), at(3040))
+	playlist, ok := m.Playlist("d1", "")
+	if !ok || strings.Count(playlist, "#EXTINF:") != 1 || !strings.Contains(playlist, "#EXTINF:3.000,") {
+		t.Fatalf("关键帧处应切分出 3 秒的分片:\n%s", playlist)
+	}
+}
+
+func TestHLSStaticScreenFlushes(t *testing.T) {
+	m := NewHLSManager(time.Minute)
+	m.Watch("d1")
+	start := time.Now()
+
+	// 画面静止：只有观看开始时写入的缓存关键帧
+	m.Write("d1", hlsTestConfig, start)
+	m.Write("d1", hlsTestFrame(true), start)
+	m.Tick(start.Add(hlsSegmentDuration))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !m.WaitReady(ctx, "d1") {
+		t.Fatal("静止画面超过目标时长应结束当前分片")
+	}
+
+	// 结束分片后的 P 帧缺少参考帧，等待下一个关键帧
+	m.Write("d1", hlsTestFrame(false), start.Add(hlsSegmentDuration+time.Second))
+	if m.streams["d1"].muxer.Flush() != nil {
+		t.Fatal("分片结束后关键帧之前的 P 帧不应封装")
+	}
+}
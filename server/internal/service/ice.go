@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// ICEConfig 下发给 WebRTC 对端的 STUN/TURN 配置
+// TURN 凭据按 TURN REST API 共享密钥方案生成：username 为 "过期时间戳:用户"，
+// credential 为 base64(HMAC-SHA1(secret, username))，coturn 的 use-auth-secret 和内置 TURN 服务均可校验
+type ICEConfig struct {
+	URLs   []string      // stun: / stuns: / turn: / turns: 地址
+	Secret string        // TURN 共享密钥，为空时 TURN 地址不带凭据
+	TTL    time.Duration // TURN 凭据有效期
+}
+
+// Servers 生成 peerID 使用的 ICE 服务器列表，未配置时返回空
+func (c *ICEConfig) Servers(peerID string) []protocol.ICEServer {
+	if c == nil || len(c.URLs) == 0 {
+		return nil
+	}
+
+	var stun, turn []string
+	for _, url := range c.URLs {
+		if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+			turn = append(turn, url)
+		} else {
+			stun = append(stun, url)
+		}
+	}
+
+	var servers []protocol.ICEServer
+	if len(stun) > 0 {
+		servers = append(servers, protocol.ICEServer{URLs: stun})
+	}
+	if len(turn) > 0 {
+		server := protocol.ICEServer{URLs: turn}
+		if c.Secret != "" {
+			server.Username, server.Credential = TURNCredentials(c.Secret, peerID, time.Now().Add(c.TTL))
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// TURNCredentials 生成在 expires 之前有效的 TURN 凭据
+func TURNCredentials(secret, user string, expires time.Time) (username, credential string) {
+	username = strconv.FormatInt(expires.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+	return username, turnPassword(secret, username)
+}
+
+// turnPassword 按共享密钥计算 username 对应的密码
+func turnPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// turnUsernameValid 检查 username 中的过期时间戳
+func turnUsernameValid(username string, now time.Time) bool {
+	expires, _, _ := strings.Cut(username, ":")
+	t, err := strconv.ParseInt(expires, 10, 64)
+	return err == nil && t >= now.Unix()
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+const (
+	maxMacroEvents = 10000 // 单个宏最多录制的输入事件数
+	maxReplaySpeed = 16.0
+)
+
+var (
+	ErrMacroRecording    = errors.New("macro recording already in progress")
+	ErrMacroNotRecording = errors.New("no macro recording in progress")
+	ErrInvalidSpeed      = errors.New("invalid replay speed")
+)
+
+// 回放时按屏幕比例缩放的坐标字段
+var (
+	macroXFields = []string{"x", "startX", "endX"}
+	macroYFields = []string{"y", "startY", "endY"}
+)
+
+// MacroRecorder 按会话录制输入事件
+type MacroRecorder struct {
+	recordings map[string]*macroRecording // sessionID -> 录制中的宏
+	mutex      sync.Mutex
+}
+
+type macroRecording struct {
+	macro   *model.Macro
+	started time.Time
+}
+
+// NewMacroRecorder 创建宏录制器
+func NewMacroRecorder() *MacroRecorder {
+	return &MacroRecorder{
+		recordings: make(map[string]*macroRecording),
+	}
+}
+
+// Start 开始为会话录制宏，记录设备当前的屏幕尺寸
+func (mr *MacroRecorder) Start(sessionID string, device *model.Device, name string) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if _, ok := mr.recordings[sessionID]; ok {
+		return ErrMacroRecording
+	}
+	mr.recordings[sessionID] = &macroRecording{
+		macro: &model.Macro{
+			Name:         name,
+			ScreenWidth:  device.ScreenWidth,
+			ScreenHeight: device.ScreenHeight,
+			Events:       make([]model.MacroEvent, 0),
+		},
+		started: time.Now(),
+	}
+	return nil
+}
+
+// Record 记录一条输入消息（会话未在录制时忽略）
+// requestId 只对本次发送有效，录制时去掉，回放时设备不会回执过期的请求
+func (mr *MacroRecorder) Record(sessionID string, message []byte) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	rec, ok := mr.recordings[sessionID]
+	if !ok || len(rec.macro.Events) >= maxMacroEvents {
+		return
+	}
+	rec.macro.Events = append(rec.macro.Events, model.MacroEvent{
+		OffsetMs: time.Since(rec.started).Milliseconds(),
+		Message:  withoutRequestID(message),
+	})
+}
+
+// withoutRequestID 返回去掉 requestId 字段的消息副本
+func withoutRequestID(message []byte) json.RawMessage {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(message, &msg); err == nil {
+		if _, ok := msg["requestId"]; ok {
+			delete(msg, "requestId")
+			if stripped, err := json.Marshal(msg); err == nil {
+				return stripped
+			}
+		}
+	}
+	return append(json.RawMessage(nil), message...)
+}
+
+// Stop 停止录制并返回录制结果
+func (mr *MacroRecorder) Stop(sessionID string) (*model.Macro, error) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	rec, ok := mr.recordings[sessionID]
+	if !ok {
+		return nil, ErrMacroNotRecording
+	}
+	delete(mr.recordings, sessionID)
+	rec.macro.CreatedAt = time.Now()
+	return rec.macro, nil
+}
+
+// Discard 丢弃会话中未保存的录制
+func (mr *MacroRecorder) Discard(sessionID string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	delete(mr.recordings, sessionID)
+}
+
+// ReplayInfo 回放任务信息
+type ReplayInfo struct {
+	ID        string    `json:"id"`
+	Macro     string    `json:"macro"`
+	DeviceID  string    `json:"deviceId"`
+	SessionID string    `json:"sessionId,omitempty"` // 回放所属的控制会话，设备空闲时回放为空
+	Speed     float64   `json:"speed"`
+	Events    int       `json:"events"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// MacroPlayer 宏回放器
+type MacroPlayer struct {
+	registry *CommandRegistry // 回放前按管理 API 权限重新校验宏中的命令
+	replays  map[string]*macroReplay
+	mutex    sync.Mutex
+}
+
+type macroReplay struct {
+	info   ReplayInfo
+	cancel context.CancelFunc
+}
+
+// NewMacroPlayer 创建宏回放器
+func NewMacroPlayer(registry *CommandRegistry) *MacroPlayer {
+	return &MacroPlayer{
+		registry: registry,
+		replays:  make(map[string]*macroReplay),
+	}
+}
+
+// Play 在设备上回放宏，speed 为回放倍速；设备屏幕尺寸与录制时不同时按比例缩放坐标
+// sessionID 为回放所属的控制会话，设备空闲时为空
+// 宏可能录制自其他设备，其中的命令须通过回放设备的命令校验，否则返回 *protocol.ValidationError
+func (mp *MacroPlayer) Play(device *model.Device, sessionID string, macro *model.Macro, speed float64) (*ReplayInfo, error) {
+	if speed <= 0 || speed > maxReplaySpeed {
+		return nil, ErrInvalidSpeed
+	}
+	if err := mp.authorize(device, macro); err != nil {
+		return nil, err
+	}
+
+	scaleX, scaleY := 1.0, 1.0
+	if macro.ScreenWidth > 0 && macro.ScreenHeight > 0 && device.ScreenWidth > 0 && device.ScreenHeight > 0 {
+		scaleX = float64(device.ScreenWidth) / float64(macro.ScreenWidth)
+		scaleY = float64(device.ScreenHeight) / float64(macro.ScreenHeight)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	replay := &macroReplay{
+		info: ReplayInfo{
+			ID:        uuid.New().String(),
+			Macro:     macro.Name,
+			DeviceID:  device.ID,
+			SessionID: sessionID,
+			Speed:     speed,
+			Events:    len(macro.Events),
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	mp.mutex.Lock()
+	mp.replays[replay.info.ID] = replay
+	mp.mutex.Unlock()
+
+	go mp.run(ctx, replay, device, macro, scaleX, scaleY)
+
+	info := replay.info
+	return &info, nil
+}
+
+// authorize 校验宏中的 input.command 事件是否允许在设备上执行
+func (mp *MacroPlayer) authorize(device *model.Device, macro *model.Macro) error {
+	for _, event := range macro.Events {
+		var base protocol.BaseMessage
+		if err := json.Unmarshal(event.Message, &base); err != nil || base.Type != protocol.TypeInputCommand {
+			continue
+		}
+		var msg protocol.CommandMessage
+		if err := json.Unmarshal(event.Message, &msg); err != nil {
+			return &protocol.ValidationError{Code: protocol.ErrCodeInvalidMessage, Message: "宏中的命令格式错误"}
+		}
+		if err := mp.registry.Authorize(device, &msg, PermissionAdmin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Abort 中止回放
+func (mp *MacroPlayer) Abort(replayID string) bool {
+	mp.mutex.Lock()
+	replay, ok := mp.replays[replayID]
+	mp.mutex.Unlock()
+
+	if ok {
+		replay.cancel()
+	}
+	return ok
+}
+
+// AbortDevice 中止设备上进行中的全部回放（控制会话开始或结束时调用）
+func (mp *MacroPlayer) AbortDevice(deviceID string) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	for _, replay := range mp.replays {
+		if replay.info.DeviceID == deviceID {
+			replay.cancel()
+		}
+	}
+}
+
+// List 列出进行中的回放
+func (mp *MacroPlayer) List() []ReplayInfo {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	list := make([]ReplayInfo, 0, len(mp.replays))
+	for _, replay := range mp.replays {
+		list = append(list, replay.info)
+	}
+	return list
+}
+
+func (mp *MacroPlayer) run(ctx context.Context, replay *macroReplay, device *model.Device, macro *model.Macro, scaleX, scaleY float64) {
+	defer func() {
+		replay.cancel()
+		mp.mutex.Lock()
+		delete(mp.replays, replay.info.ID)
+		mp.mutex.Unlock()
+	}()
+
+	log.Printf("开始回放宏: %s -> %s (x%.2f)", macro.Name, device.ID, replay.info.Speed)
+
+	start := time.Now()
+	for i, event := range macro.Events {
+		due := time.Duration(float64(event.OffsetMs)/replay.info.Speed) * time.Millisecond
+		if wait := due - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Printf("宏回放已中止: %s -> %s (%d/%d)", macro.Name, device.ID, i, len(macro.Events))
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			log.Printf("宏回放已中止: %s -> %s (%d/%d)", macro.Name, device.ID, i, len(macro.Events))
+			return
+		}
+
+		if err := device.SendText(scaleMacroEvent(event.Message, scaleX, scaleY)); err != nil {
+			log.Printf("宏回放发送失败: %s -> %s: %v", macro.Name, device.ID, err)
+			return
+		}
+	}
+
+	log.Printf("宏回放完成: %s -> %s", macro.Name, device.ID)
+}
+
+// scaleMacroEvent 按比例缩放触摸坐标
+func scaleMacroEvent(message []byte, scaleX, scaleY float64) []byte {
+	if scaleX == 1 && scaleY == 1 {
+		return message
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return message
+	}
+	scale := func(fields []string, factor float64) {
+		for _, field := range fields {
+			if v, ok := msg[field].(float64); ok {
+				msg[field] = v * factor
+			}
+		}
+	}
+	scale(macroXFields, scaleX)
+	scale(macroYFields, scaleY)
+
+	// input.touchframe 的触点坐标
+	if pointers, ok := msg["pointers"].([]interface{}); ok {
+		for _, p := range pointers {
+			if pointer, ok := p.(map[string]interface{}); ok {
+				if v, ok := pointer["x"].(float64); ok {
+					pointer["x"] = v * scaleX
+				}
+				if v, ok := pointer["y"].(float64); ok {
+					pointer["y"] = v * scaleY
+				}
+			}
+		}
+	}
+
+	scaled, err := json.Marshal(msg)
+	if err != nil {
+		return message
+	}
+	return scaled
+}
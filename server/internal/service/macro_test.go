@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+func TestMacroRecordStripsRequestID(t *testing.T) {
+	mr := NewMacroRecorder()
+	device := &model.Device{ID: "d1", ScreenWidth: 1080, ScreenHeight: 1920}
+	if err := mr.Start("s1", device, "m"); err != nil {
+		t.Fatal(err)
+	}
+	mr.Record("s1", []byte(`{"type":"input.key","action":"down","keyCode":4,"requestId":"r1"}`))
+	mr.Record("s1", []byte(`{"type":"input.text","text":"hi"}`))
+
+	macro, err := mr.Stop("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(macro.Events) != 2 || macro.ScreenWidth != 1080 || macro.ScreenHeight != 1920 {
+		t.Fatalf("录制结果 %+v", macro)
+	}
+	for _, event := range macro.Events {
+		var msg map[string]interface{}
+		if err := json.Unmarshal(event.Message, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg["requestId"]; ok {
+			t.Fatalf("录制的事件带有 requestId: %s", event.Message)
+		}
+	}
+	var key protocol.KeyMessage
+	json.Unmarshal(macro.Events[0].Message, &key)
+	if key.Type != protocol.TypeInputKey || key.KeyCode != 4 {
+		t.Fatalf("去掉 requestId 后事件内容改变: %s", macro.Events[0].Message)
+	}
+}
+
+func TestMacroPlayAuthorizesCommands(t *testing.T) {
+	registry := NewCommandRegistry()
+	registry.Register(CommandDef{protocol.CommandInfo{Name: "reboot", Permission: PermissionAdmin}})
+	mp := NewMacroPlayer(registry)
+
+	macro := &model.Macro{Name: "m", Events: []model.MacroEvent{
+		{Message: json.RawMessage(`{"type":"input.key","action":"down","keyCode":4}`)},
+		{OffsetMs: 10, Message: json.RawMessage(`{"type":"input.command","command":"reboot"}`)},
+	}}
+	cases := []struct {
+		name     string
+		commands []string
+		code     string
+	}{
+		{"unsupported", []string{"hide_keyboard"}, ErrCodeUnsupportedCommand},
+		{"admin command", []string{"reboot"}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 设备未连接，回放发送第一个事件即结束
+			device := &model.Device{ID: "d1", Commands: tc.commands}
+			_, err := mp.Play(device, "", macro, 1)
+			var ve *protocol.ValidationError
+			switch {
+			case tc.code == "" && err != nil:
+				t.Fatalf("回放被拒绝: %v", err)
+			case tc.code != "" && (!errors.As(err, &ve) || ve.Code != tc.code):
+				t.Fatalf("回放结果 %v, 期望 %s", err, tc.code)
+			}
+		})
+	}
+
+	if _, err := mp.Play(&model.Device{ID: "d1"}, "", macro, 0); !errors.Is(err, ErrInvalidSpeed) {
+		t.Fatalf("倍速 0 的结果 %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import "sync"
+
+const mjpegViewerBuffer = 2 // 每个观看者缓冲的帧数，满时丢弃最旧的帧
+
+// MJPEGViewer HTTP MJPEG 观看者
+type MJPEGViewer struct {
+	DeviceID string
+	frames   chan []byte
+}
+
+// Frames 接收 JPEG 帧，设备离线时关闭
+func (v *MJPEGViewer) Frames() <-chan []byte {
+	return v.frames
+}
+
+// MJPEGHub 按设备分发 MJPEG 帧给 HTTP 观看者
+type MJPEGHub struct {
+	viewers map[string]map[*MJPEGViewer]struct{}
+	mutex   sync.RWMutex
+}
+
+// NewMJPEGHub 创建 MJPEG 分发器
+func NewMJPEGHub() *MJPEGHub {
+	return &MJPEGHub{viewers: make(map[string]map[*MJPEGViewer]struct{})}
+}
+
+// Subscribe 添加观看者，first 表示该设备的第一个观看者
+func (hub *MJPEGHub) Subscribe(deviceID string) (viewer *MJPEGViewer, first bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	viewer = &MJPEGViewer{DeviceID: deviceID, frames: make(chan []byte, mjpegViewerBuffer)}
+	set := hub.viewers[deviceID]
+	if set == nil {
+		set = make(map[*MJPEGViewer]struct{})
+		hub.viewers[deviceID] = set
+	}
+	set[viewer] = struct{}{}
+	return viewer, len(set) == 1
+}
+
+// Unsubscribe 移除观看者，last 表示该设备已没有观看者
+func (hub *MJPEGHub) Unsubscribe(viewer *MJPEGViewer) (last bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	set := hub.viewers[viewer.DeviceID]
+	if _, ok := set[viewer]; !ok {
+		return false
+	}
+	delete(set, viewer)
+	if len(set) == 0 {
+		delete(hub.viewers, viewer.DeviceID)
+		return true
+	}
+	return false
+}
+
+// Count 设备的观看者数量
+func (hub *MJPEGHub) Count(deviceID string) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.viewers[deviceID])
+}
+
+// Publish 分发一帧 JPEG，观看者处理不过来时丢弃其最旧的帧
+func (hub *MJPEGHub) Publish(deviceID string, jpeg []byte) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	for viewer := range hub.viewers[deviceID] {
+		select {
+		case viewer.frames <- jpeg:
+			continue
+		default:
+		}
+		select {
+		case <-viewer.frames:
+		default:
+		}
+		select {
+		case viewer.frames <- jpeg:
+		default:
+		}
+	}
+}
+
+// CloseDevice 设备离线时结束所有观看者
+func (hub *MJPEGHub) CloseDevice(deviceID string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for viewer := range hub.viewers[deviceID] {
+		close(viewer.frames)
+	}
+	delete(hub.viewers, deviceID)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+// StreamProfileParams 按推流配置生成 stream.start 参数，设备不支持首选模式时回退另一种模式
+func StreamProfileParams(profile protocol.StreamProfile, device *model.Device) protocol.StreamControlMessage {
+	mode := profile.Mode
+	if !device.HasCapability(protocol.StreamCapability(mode)) {
+		if mode == "h264" && device.HasCapability(protocol.CapMJPEG) {
+			mode = "mjpeg"
+		} else if mode == "mjpeg" && device.HasCapability(protocol.CapH264) {
+			mode = "h264"
+		}
+	}
+
+	params := protocol.StreamControlMessage{
+		Type:      protocol.TypeStreamStart,
+		Mode:      mode,
+		MaxWidth:  profile.MaxWidth,
+		MaxHeight: profile.MaxHeight,
+	}
+	if mode == "h264" {
+		params.Bitrate, params.FPS = profile.Bitrate, profile.FPS
+	} else {
+		params.Quality, params.MaxFPS = profile.MJPEGQuality, profile.FPS
+	}
+	return params
+}
+
+// ClampStreamParams 将控制端指定的推流参数限制在推流配置范围内（码率、帧率、质量不超过配置，分辨率沿用配置）
+func ClampStreamParams(params protocol.StreamControlMessage, profile protocol.StreamProfile) protocol.StreamControlMessage {
+	if params.Bitrate == 0 || params.Bitrate > profile.Bitrate {
+		params.Bitrate = profile.Bitrate
+	}
+	if params.FPS == 0 || params.FPS > profile.FPS {
+		params.FPS = profile.FPS
+	}
+	if params.MaxFPS == 0 || params.MaxFPS > profile.FPS {
+		params.MaxFPS = profile.FPS
+	}
+	if params.Quality == 0 || params.Quality > profile.MJPEGQuality {
+		params.Quality = profile.MJPEGQuality
+	}
+	params.MaxWidth, params.MaxHeight = profile.MaxWidth, profile.MaxHeight
+	return params
+}
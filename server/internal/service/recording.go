@@ -0,0 +1,471 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/media"
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+// 录像格式
+const (
+	RecordingFormatMP4   = "mp4"   // H264 帧封装为 fragmented MP4
+	RecordingFormatMJPEG = "mjpeg" // JPEG 帧顺序拼接
+)
+
+const (
+	recordingMaxFragment   = 2 * time.Second // 录像分片最长时长
+	recordingInfoPeriod    = 5 * time.Second // 录制中更新元数据（时长、帧数、大小）的间隔
+	recordingCleanupPeriod = time.Hour
+)
+
+var (
+	ErrRecordingNotFound = errors.New("recording not found")
+
+	validRecordingID = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+)
+
+// RecordingInfo 录像元数据（与录像文件同目录保存为 <id>.json）
+type RecordingInfo struct {
+	ID           string    `json:"id"`
+	SessionID    string    `json:"sessionId"`
+	DeviceID     string    `json:"deviceId"`
+	ControllerID string    `json:"controllerId"`
+	Format       string    `json:"format"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	EndedAt      time.Time `json:"endedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	Frames       int       `json:"frames"`
+	Bytes        int64     `json:"bytes"`
+	Recording    bool      `json:"recording"` // 是否仍在录制
+}
+
+// RecordingIndexEntry 录像时间索引（<id>.idx，每行一条 JSON）
+// MP4 每个分片一条，MJPEG 每帧一条
+type RecordingIndexEntry struct {
+	TimeMs int64 `json:"t"`
+	Offset int64 `json:"offset"`
+	Size   int   `json:"size"`
+	Key    bool  `json:"key,omitempty"`
+}
+
+// RecordingManager 会话录像管理器
+type RecordingManager struct {
+	dir       string
+	retention time.Duration
+	active    map[string]*sessionRecording // deviceID -> 录像
+	mutex     sync.Mutex
+}
+
+// sessionRecording 一个会话的录像，推流模式或编码参数变化时切换到新的录像文件
+type sessionRecording struct {
+	mutex        sync.Mutex
+	dir          string
+	sessionID    string
+	deviceID     string
+	controllerID string
+	parts        int
+	part         *recordingPart
+	config       []byte // 最近的 H264 配置帧
+}
+
+type recordingPart struct {
+	info    RecordingInfo
+	file    *os.File
+	index   *os.File
+	offset  int64
+	started time.Time
+	saved   time.Time // 最近一次写入元数据的时间
+	muxer   *media.Muxer
+}
+
+// NewRecordingManager 创建录像管理器，dir 为空表示不录像（返回 nil）
+func NewRecordingManager(dir string, retention time.Duration) *RecordingManager {
+	if dir == "" {
+		return nil
+	}
+	rm := &RecordingManager{
+		dir:       dir,
+		retention: retention,
+		active:    make(map[string]*sessionRecording),
+	}
+	rm.recoverOrphans()
+	if retention > 0 {
+		go rm.cleanupLoop()
+	}
+	return rm
+}
+
+// Start 开始录制会话；会话恢复时继续写入原录像
+func (rm *RecordingManager) Start(session *model.Session) {
+	if rm == nil || session == nil {
+		return
+	}
+	if err := os.MkdirAll(rm.dir, 0o755); err != nil {
+		log.Printf("创建录像目录失败: %v", err)
+		return
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if rec, ok := rm.active[session.DeviceID]; ok {
+		if rec.sessionID == session.ID {
+			return
+		}
+		rec.close()
+	}
+	rm.active[session.DeviceID] = &sessionRecording{
+		dir:          rm.dir,
+		sessionID:    session.ID,
+		deviceID:     session.DeviceID,
+		controllerID: session.ControllerID,
+	}
+	log.Printf("开始录像: session=%s device=%s", session.ID, session.DeviceID)
+}
+
+// Stop 结束会话录像
+func (rm *RecordingManager) Stop(sessionID string) {
+	if rm == nil {
+		return
+	}
+
+	rm.mutex.Lock()
+	var rec *sessionRecording
+	for deviceID, r := range rm.active {
+		if r.sessionID == sessionID {
+			rec = r
+			delete(rm.active, deviceID)
+			break
+		}
+	}
+	rm.mutex.Unlock()
+
+	if rec != nil {
+		rec.close()
+		log.Printf("结束录像: session=%s", sessionID)
+	}
+}
+
+// WriteFrame 写入设备推送的屏幕帧（[type][flags][payload]，或旧版设备 MJPEG 模式的原始 JPEG）
+func (rm *RecordingManager) WriteFrame(deviceID string, frame []byte) {
+	if rm == nil || len(frame) < 2 {
+		return
+	}
+
+	rm.mutex.Lock()
+	rec := rm.active[deviceID]
+	rm.mutex.Unlock()
+
+	if rec != nil {
+		rec.write(frame, time.Now())
+	}
+}
+
+// List 列出录像，deviceID/sessionID 为空表示不过滤，按开始时间倒序
+func (rm *RecordingManager) List(deviceID, sessionID string) ([]RecordingInfo, error) {
+	if rm == nil {
+		return nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(rm.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]RecordingInfo, 0, len(files))
+	for _, file := range files {
+		info, err := readRecordingInfo(file)
+		if err != nil {
+			continue
+		}
+		if deviceID != "" && info.DeviceID != deviceID {
+			continue
+		}
+		if sessionID != "" && info.SessionID != sessionID {
+			continue
+		}
+		list = append(list, *info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+	return list, nil
+}
+
+// Get 获取录像元数据
+func (rm *RecordingManager) Get(id string) (*RecordingInfo, error) {
+	if rm == nil || !validRecordingID.MatchString(id) {
+		return nil, ErrRecordingNotFound
+	}
+	info, err := readRecordingInfo(filepath.Join(rm.dir, id+".json"))
+	if err != nil {
+		return nil, ErrRecordingNotFound
+	}
+	return info, nil
+}
+
+// FilePath 录像文件路径
+func (rm *RecordingManager) FilePath(info *RecordingInfo) string {
+	return filepath.Join(rm.dir, info.ID+"."+info.Format)
+}
+
+// IndexPath 录像时间索引文件路径
+func (rm *RecordingManager) IndexPath(info *RecordingInfo) string {
+	return filepath.Join(rm.dir, info.ID+".idx")
+}
+
+// cleanupLoop 定期删除超过保留期限的录像
+func (rm *RecordingManager) cleanupLoop() {
+	ticker := time.NewTicker(recordingCleanupPeriod)
+	defer ticker.Stop()
+
+	for {
+		rm.cleanup()
+		<-ticker.C
+	}
+}
+
+// recoverOrphans 启动时结束上次进程退出前仍在录制的录像，结束时间取录像文件的最后修改时间
+// 否则这些录像一直处于录制状态，不会被过期清理
+func (rm *RecordingManager) recoverOrphans() {
+	list, err := rm.List("", "")
+	if err != nil {
+		log.Printf("扫描录像目录失败: %v", err)
+		return
+	}
+	for _, info := range list {
+		if !info.Recording {
+			continue
+		}
+		info.Recording = false
+		info.EndedAt = info.StartedAt.Add(time.Duration(info.DurationMs) * time.Millisecond)
+		if stat, err := os.Stat(rm.FilePath(&info)); err == nil {
+			info.Bytes = stat.Size()
+			if stat.ModTime().After(info.EndedAt) {
+				info.EndedAt = stat.ModTime()
+			}
+		}
+		if err := writeRecordingInfo(filepath.Join(rm.dir, info.ID+".json"), &info); err != nil {
+			log.Printf("写入录像元数据失败: %v", err)
+			continue
+		}
+		log.Printf("结束未正常关闭的录像: %s", info.ID)
+	}
+}
+
+func (rm *RecordingManager) cleanup() {
+	list, err := rm.List("", "")
+	if err != nil {
+		log.Printf("扫描录像目录失败: %v", err)
+		return
+	}
+
+	deadline := time.Now().Add(-rm.retention)
+	for _, info := range list {
+		if info.Recording || info.EndedAt.IsZero() || info.EndedAt.After(deadline) {
+			continue
+		}
+		for _, path := range []string{rm.FilePath(&info), rm.IndexPath(&info), filepath.Join(rm.dir, info.ID+".json")} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("删除过期录像失败: %v", err)
+			}
+		}
+		log.Printf("删除过期录像: %s", info.ID)
+	}
+}
+
+func (r *sessionRecording) write(frame []byte, at time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	frameType, flags, payload, ok := protocol.ParseFrame(frame)
+	if !ok {
+		return
+	}
+
+	switch frameType {
+	case protocol.BinaryTypeH264Config:
+		if r.part != nil && r.part.muxer != nil && r.part.muxer.SameConfig(payload) {
+			return
+		}
+		// 编码参数变化，等下一个关键帧写入新文件
+		r.closePart()
+		r.config = append([]byte(nil), payload...)
+
+	case protocol.BinaryTypeH264Frame:
+		key := flags&protocol.FrameFlagKeyFrame != 0
+		if r.part == nil || r.part.muxer == nil {
+			if r.config == nil || !key {
+				return
+			}
+			r.closePart()
+			if err := r.openPart(RecordingFormatMP4, at); err != nil {
+				log.Printf("创建录像文件失败: %v", err)
+				r.config = nil
+				return
+			}
+		}
+		if frag := r.part.muxer.Push(payload, key, at); frag != nil {
+			r.writeFragment(frag)
+		}
+
+	case protocol.BinaryTypeScreenFrame:
+		if r.part == nil || r.part.info.Format != RecordingFormatMJPEG {
+			r.closePart()
+			if err := r.openPart(RecordingFormatMJPEG, at); err != nil {
+				log.Printf("创建录像文件失败: %v", err)
+				return
+			}
+		}
+		r.writeChunk(payload, at.Sub(r.part.started).Milliseconds(), true)
+		r.part.info.Frames++
+		r.part.info.DurationMs = at.Sub(r.part.started).Milliseconds()
+	}
+
+	if r.part != nil && at.Sub(r.part.saved) >= recordingInfoPeriod {
+		r.saveInfo()
+	}
+}
+
+func (r *sessionRecording) openPart(format string, at time.Time) error {
+	r.parts++
+	id := fmt.Sprintf("%s-%d", r.sessionID, r.parts)
+
+	part := &recordingPart{
+		info: RecordingInfo{
+			ID:           id,
+			SessionID:    r.sessionID,
+			DeviceID:     r.deviceID,
+			ControllerID: r.controllerID,
+			Format:       format,
+			StartedAt:    at,
+			Recording:    true,
+		},
+		started: at,
+	}
+
+	if format == RecordingFormatMP4 {
+		muxer, err := media.NewMuxer(r.config)
+		if err != nil {
+			return err
+		}
+		muxer.MaxFragment = recordingMaxFragment
+		part.muxer = muxer
+		part.info.Width, part.info.Height = muxer.Size()
+	}
+
+	var err error
+	if part.file, err = os.Create(filepath.Join(r.dir, id+"."+format)); err != nil {
+		return err
+	}
+	if part.index, err = os.Create(filepath.Join(r.dir, id+".idx")); err != nil {
+		part.file.Close()
+		return err
+	}
+	r.part = part
+
+	if part.muxer != nil {
+		r.writeChunk(part.muxer.Init(), -1, false)
+	}
+	r.saveInfo()
+	return nil
+}
+
+func (r *sessionRecording) writeFragment(frag *media.Fragment) {
+	timeMs := int64(frag.BaseTime * 1000 / media.Timescale)
+	r.writeChunk(frag.Data, timeMs, frag.Key)
+	r.part.info.Frames += frag.Samples
+	r.part.info.DurationMs = int64((frag.BaseTime + frag.Duration) * 1000 / media.Timescale)
+}
+
+// writeChunk 写入录像数据，timeMs >= 0 时同时写入时间索引
+func (r *sessionRecording) writeChunk(data []byte, timeMs int64, key bool) {
+	part := r.part
+	if _, err := part.file.Write(data); err != nil {
+		log.Printf("写入录像失败: %v", err)
+		return
+	}
+	if timeMs >= 0 {
+		entry, _ := json.Marshal(RecordingIndexEntry{
+			TimeMs: timeMs,
+			Offset: part.offset,
+			Size:   len(data),
+			Key:    key,
+		})
+		part.index.Write(append(entry, '\n'))
+	}
+	part.offset += int64(len(data))
+	part.info.Bytes = part.offset
+}
+
+func (r *sessionRecording) closePart() {
+	part := r.part
+	if part == nil {
+		return
+	}
+	if part.muxer != nil {
+		if frag := part.muxer.Flush(); frag != nil {
+			r.writeFragment(frag)
+		}
+	}
+	part.file.Close()
+	part.index.Close()
+
+	part.info.Recording = false
+	part.info.EndedAt = time.Now()
+	r.saveInfo()
+	r.part = nil
+}
+
+func (r *sessionRecording) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closePart()
+}
+
+func (r *sessionRecording) saveInfo() {
+	r.part.saved = time.Now()
+	if err := writeRecordingInfo(filepath.Join(r.dir, r.part.info.ID+".json"), &r.part.info); err != nil {
+		log.Printf("写入录像元数据失败: %v", err)
+	}
+}
+
+// writeRecordingInfo 先写临时文件再改名，避免列表读到写了一半的元数据
+func writeRecordingInfo(path string, info *RecordingInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readRecordingInfo(path string) (*RecordingInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info RecordingInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
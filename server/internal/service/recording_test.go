@@ -0,0 +1,59 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+func TestRecordingRawJPEG(t *testing.T) {
+	dir := t.TempDir()
+	rm := NewRecordingManager(dir, 0)
+	session := &model.Session{ID: "s1", DeviceID: "d1", ControllerID: "c1"}
+	rm.Start(session)
+
+	// Android MJPEG 模式直接发送 JPEG 数据，带帧头的 MJPEG 帧同样写入
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 1, 2, 3}
+	rm.WriteFrame("d1", jpeg)
+	rm.WriteFrame("d1", append([]byte{protocol.BinaryTypeScreenFrame, 0}, jpeg...))
+	rm.Stop("s1")
+
+	info, err := rm.Get("s1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != RecordingFormatMJPEG || info.Frames != 2 || info.Bytes != int64(2*len(jpeg)) || info.Recording {
+		t.Fatalf("录像元数据 = %+v", info)
+	}
+	data, _ := os.ReadFile(rm.FilePath(info))
+	if string(data) != string(jpeg)+string(jpeg) {
+		t.Fatalf("录像内容 = %x", data)
+	}
+}
+
+func TestRecordingRecoverOrphans(t *testing.T) {
+	dir := t.TempDir()
+	started := time.Now().Add(-time.Hour)
+	orphan := RecordingInfo{ID: "s1-1", SessionID: "s1", DeviceID: "d1", Format: RecordingFormatMJPEG, StartedAt: started, DurationMs: 1000, Recording: true}
+	if err := writeRecordingInfo(filepath.Join(dir, "s1-1.json"), &orphan); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s1-1.mjpeg"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := started.Add(10 * time.Minute)
+	os.Chtimes(filepath.Join(dir, "s1-1.mjpeg"), modTime, modTime)
+
+	rm := NewRecordingManager(dir, 0)
+	info, err := rm.Get("s1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Recording || !info.EndedAt.Equal(modTime) || info.Bytes != 100 {
+		t.Fatalf("恢复后的录像元数据 = %+v", info)
+	}
+}
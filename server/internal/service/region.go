@@ -0,0 +1,70 @@
+package service
+
+import "shushu-remote-control/internal/protocol"
+
+// RegionSize 推流区域裁剪并旋转后的画面尺寸（控制端触摸坐标空间），width/height 为设备屏幕尺寸
+func RegionSize(region protocol.StreamRegion, width, height int) (int, int) {
+	w, h := width, height
+	if region.Crop != nil {
+		w, h = region.Crop.Width, region.Crop.Height
+	}
+	if region.Rotation == 90 || region.Rotation == 270 {
+		return h, w
+	}
+	return w, h
+}
+
+// RegionToScreen 把推流区域画面中的坐标转换为设备全屏坐标
+func RegionToScreen(region protocol.StreamRegion, width, height int, x, y float64) (float64, float64) {
+	var ox, oy float64
+	w, h := float64(width), float64(height)
+	if c := region.Crop; c != nil {
+		ox, oy = float64(c.X), float64(c.Y)
+		w, h = float64(c.Width), float64(c.Height)
+	}
+	// 逆时针转回裁剪区域内的坐标
+	switch region.Rotation {
+	case 90:
+		x, y = y, h-x
+	case 180:
+		x, y = w-x, h-y
+	case 270:
+		x, y = w-y, x
+	}
+	return ox + x, oy + y
+}
+
+// regionToScreenVector 把推流区域画面中的方向（滚动幅度）转换为设备屏幕方向
+func regionToScreenVector(region protocol.StreamRegion, dx, dy float64) (float64, float64) {
+	switch region.Rotation {
+	case 90:
+		return dy, -dx
+	case 180:
+		return -dx, -dy
+	case 270:
+		return -dy, dx
+	}
+	return dx, dy
+}
+
+// RemapTouch 把控制端按推流区域给出的触摸坐标转换为设备全屏坐标
+func RemapTouch(msg protocol.TouchMessage, region protocol.StreamRegion, width, height int) protocol.TouchMessage {
+	msg.X, msg.Y = RegionToScreen(region, width, height, msg.X, msg.Y)
+	if msg.Action == protocol.TouchActionSwipe {
+		msg.StartX, msg.StartY = RegionToScreen(region, width, height, msg.StartX, msg.StartY)
+		msg.EndX, msg.EndY = RegionToScreen(region, width, height, msg.EndX, msg.EndY)
+	}
+	msg.HScroll, msg.VScroll = regionToScreenVector(region, msg.HScroll, msg.VScroll)
+	return msg
+}
+
+// RemapTouchFrame 把控制端按推流区域给出的多点触控坐标转换为设备全屏坐标
+func RemapTouchFrame(msg protocol.TouchFrameMessage, region protocol.StreamRegion, width, height int) protocol.TouchFrameMessage {
+	pointers := make([]protocol.TouchPointer, len(msg.Pointers))
+	for i, p := range msg.Pointers {
+		p.X, p.Y = RegionToScreen(region, width, height, p.X, p.Y)
+		pointers[i] = p
+	}
+	msg.Pointers = pointers
+	return msg
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// 设备屏幕 1080x1920，裁剪区域从 (100,200) 起 400x300
+const (
+	regionScreenWidth  = 1080
+	regionScreenHeight = 1920
+)
+
+func cropRegion(rotation int) protocol.StreamRegion {
+	return protocol.StreamRegion{Crop: &protocol.CropRect{X: 100, Y: 200, Width: 400, Height: 300}, Rotation: rotation}
+}
+
+func TestRegionSize(t *testing.T) {
+	cases := []struct {
+		name          string
+		region        protocol.StreamRegion
+		width, height int
+	}{
+		{"full screen", protocol.StreamRegion{}, 1080, 1920},
+		{"full screen 90", protocol.StreamRegion{Rotation: 90}, 1920, 1080},
+		{"crop 0", cropRegion(0), 400, 300},
+		{"crop 90", cropRegion(90), 300, 400},
+		{"crop 180", cropRegion(180), 400, 300},
+		{"crop 270", cropRegion(270), 300, 400},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, h := RegionSize(tc.region, regionScreenWidth, regionScreenHeight)
+			if w != tc.width || h != tc.height {
+				t.Fatalf("画面尺寸 %dx%d, 期望 %dx%d", w, h, tc.width, tc.height)
+			}
+		})
+	}
+}
+
+func TestRegionToScreen(t *testing.T) {
+	cases := []struct {
+		name   string
+		region protocol.StreamRegion
+		x, y   float64 // 推流画面坐标
+		sx, sy float64 // 期望的设备全屏坐标
+	}{
+		{"full screen", protocol.StreamRegion{}, 10, 20, 10, 20},
+		{"full screen 90", protocol.StreamRegion{Rotation: 90}, 10, 20, 20, 1910},
+		{"crop 0", cropRegion(0), 10, 20, 110, 220},
+		{"crop 90", cropRegion(90), 10, 20, 120, 490},
+		{"crop 180", cropRegion(180), 10, 20, 490, 480},
+		{"crop 270", cropRegion(270), 10, 20, 480, 210},
+		// 旋转后画面的左上角对应裁剪区域中转到左上角的那个角
+		{"crop 90 origin", cropRegion(90), 0, 0, 100, 500},
+		{"crop 180 origin", cropRegion(180), 0, 0, 500, 500},
+		{"crop 270 origin", cropRegion(270), 0, 0, 500, 200},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sx, sy := RegionToScreen(tc.region, regionScreenWidth, regionScreenHeight, tc.x, tc.y)
+			if sx != tc.sx || sy != tc.sy {
+				t.Fatalf("全屏坐标 (%v,%v), 期望 (%v,%v)", sx, sy, tc.sx, tc.sy)
+			}
+		})
+	}
+}
+
+func TestRemapTouch(t *testing.T) {
+	swipe := protocol.TouchMessage{Action: protocol.TouchActionSwipe, StartX: 10, StartY: 20, EndX: 50, EndY: 20, Duration: 200}
+	scroll := protocol.TouchMessage{Action: protocol.TouchActionScroll, X: 10, Y: 20, HScroll: 1, VScroll: -2}
+	cases := []struct {
+		name     string
+		rotation int
+		msg      protocol.TouchMessage
+		want     protocol.TouchMessage
+	}{
+		{"swipe 0", 0, swipe, protocol.TouchMessage{Action: protocol.TouchActionSwipe, X: 100, Y: 200, StartX: 110, StartY: 220, EndX: 150, EndY: 220, Duration: 200}},
+		// 画面中向右滑动，顺时针旋转 90 度后是设备屏幕上向上滑动
+		{"swipe 90", 90, swipe, protocol.TouchMessage{Action: protocol.TouchActionSwipe, X: 100, Y: 500, StartX: 120, StartY: 490, EndX: 120, EndY: 450, Duration: 200}},
+		{"swipe 180", 180, swipe, protocol.TouchMessage{Action: protocol.TouchActionSwipe, X: 500, Y: 500, StartX: 490, StartY: 480, EndX: 450, EndY: 480, Duration: 200}},
+		{"swipe 270", 270, swipe, protocol.TouchMessage{Action: protocol.TouchActionSwipe, X: 500, Y: 200, StartX: 480, StartY: 210, EndX: 480, EndY: 250, Duration: 200}},
+		{"scroll 0", 0, scroll, protocol.TouchMessage{Action: protocol.TouchActionScroll, X: 110, Y: 220, HScroll: 1, VScroll: -2}},
+		{"scroll 90", 90, scroll, protocol.TouchMessage{Action: protocol.TouchActionScroll, X: 120, Y: 490, HScroll: -2, VScroll: -1}},
+		{"scroll 180", 180, scroll, protocol.TouchMessage{Action: protocol.TouchActionScroll, X: 490, Y: 480, HScroll: -1, VScroll: 2}},
+		{"scroll 270", 270, scroll, protocol.TouchMessage{Action: protocol.TouchActionScroll, X: 480, Y: 210, HScroll: 2, VScroll: 1}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := RemapTouch(tc.msg, cropRegion(tc.rotation), regionScreenWidth, regionScreenHeight)
+			if got != tc.want {
+				t.Fatalf("转换结果 %+v, 期望 %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemapTouchFrame(t *testing.T) {
+	msg := protocol.TouchFrameMessage{Pointers: []protocol.TouchPointer{{ID: 0, X: 10, Y: 20}, {ID: 1, X: 0, Y: 0}}}
+	got := RemapTouchFrame(msg, cropRegion(90), regionScreenWidth, regionScreenHeight)
+	if got.Pointers[0].X != 120 || got.Pointers[0].Y != 490 || got.Pointers[1].X != 100 || got.Pointers[1].Y != 500 {
+		t.Fatalf("多点触控坐标 %+v", got.Pointers)
+	}
+	if msg.Pointers[0].X != 10 {
+		t.Fatal("不应修改原消息的触点")
+	}
+}
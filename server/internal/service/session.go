@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 
 	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
 )
 
 // SessionManager 会话管理器
@@ -28,6 +29,15 @@ func NewSessionManager() *SessionManager {
 
 // Create 创建控制会话
 func (sm *SessionManager) Create(device *model.Device, controller *model.Controller) *model.Session {
+	return sm.create(device, controller, false)
+}
+
+// CreatePending 创建等待现场用户同意的会话，设备在等待期间视为忙碌
+func (sm *SessionManager) CreatePending(device *model.Device, controller *model.Controller) *model.Session {
+	return sm.create(device, controller, true)
+}
+
+func (sm *SessionManager) create(device *model.Device, controller *model.Controller, pending bool) *model.Session {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
@@ -47,6 +57,7 @@ func (sm *SessionManager) Create(device *model.Device, controller *model.Control
 		Controller:   controller,
 		CreatedAt:    time.Now(),
 		Active:       true,
+		Pending:      pending,
 	}
 
 	sm.sessions[sessionID] = session
@@ -73,7 +84,7 @@ func (sm *SessionManager) GetByDevice(deviceID string) *model.Session {
 	defer sm.mutex.RUnlock()
 
 	if sessionID, ok := sm.deviceSessions[deviceID]; ok {
-		if session, ok := sm.sessions[sessionID]; ok && session.Active {
+		if session, ok := sm.sessions[sessionID]; ok && session.Active && !session.Pending {
 			return session
 		}
 	}
@@ -86,64 +97,279 @@ func (sm *SessionManager) GetByController(controllerID string) *model.Session {
 	defer sm.mutex.RUnlock()
 
 	if sessionID, ok := sm.controllerSessions[controllerID]; ok {
-		if session, ok := sm.sessions[sessionID]; ok && session.Active {
+		if session, ok := sm.sessions[sessionID]; ok && session.Active && !session.Pending {
 			return session
 		}
 	}
 	return nil
 }
 
+// DeviceHolder 设备当前的会话（含等待同意和等待重连的会话），第二个返回值表示是否仍在等待同意
+func (sm *SessionManager) DeviceHolder(deviceID string) (*model.Session, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if sessionID, ok := sm.deviceSessions[deviceID]; ok {
+		if session, ok := sm.sessions[sessionID]; ok && session.Active {
+			return session, session.Pending
+		}
+	}
+	return nil, false
+}
+
+// Activate 现场用户同意后激活等待中的会话
+func (sm *SessionManager) Activate(sessionID string) *model.Session {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if session, ok := sm.sessions[sessionID]; ok && session.Active && session.Pending {
+		session.Pending = false
+		return session
+	}
+	return nil
+}
+
 // Close 关闭会话
 func (sm *SessionManager) Close(sessionID string) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
 	if session, ok := sm.sessions[sessionID]; ok {
-		session.Active = false
-		delete(sm.deviceSessions, session.DeviceID)
-		delete(sm.controllerSessions, session.ControllerID)
+		sm.closeLocked(session)
+	}
+}
 
-		if session.Controller != nil {
-			session.Controller.SessionID = ""
-			session.Controller.DeviceID = ""
-		}
+// Suspend 设备断线时挂起会话，在 grace 时间内保留会话等待设备重连
+func (sm *SessionManager) Suspend(deviceID string, grace time.Duration) *model.Session {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sessionID, ok := sm.deviceSessions[deviceID]
+	if !ok {
+		return nil
 	}
+	session, ok := sm.sessions[sessionID]
+	if !ok || !session.Active || session.Pending {
+		return nil
+	}
+
+	session.Reconnecting = true
+	session.ReconnectDeadline = time.Now().Add(grace)
+	return session
 }
 
-// CloseByDevice 通过设备ID关闭会话
-func (sm *SessionManager) CloseByDevice(deviceID string) {
+// Resume 设备重新注册后将会话重新绑定到新的设备连接
+func (sm *SessionManager) Resume(device *model.Device) *model.Session {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
+	sessionID, ok := sm.deviceSessions[device.ID]
+	if !ok {
+		return nil
+	}
+	session, ok := sm.sessions[sessionID]
+	if !ok || !session.Active {
+		return nil
+	}
+
+	session.Device = device
+	session.Reconnecting = false
+	session.ReconnectDeadline = time.Time{}
+	return session
+}
+
+// Device 读取会话当前的设备连接，设备重连后返回新的连接
+func (sm *SessionManager) Device(sessionID string) *model.Device {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		return session.Device
+	}
+	return nil
+}
+
+// ExpireReconnect 重连宽限期结束时调用，设备仍未重连则关闭会话并返回该会话
+func (sm *SessionManager) ExpireReconnect(sessionID string) *model.Session {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	session, ok := sm.sessions[sessionID]
+	if !ok || !session.Active || !session.Reconnecting {
+		return nil
+	}
+	// 设备重连后再次断开会刷新截止时间，以最新的为准
+	if time.Now().Before(session.ReconnectDeadline) {
+		return nil
+	}
+
+	sm.closeLocked(session)
+	return session
+}
+
+// IsReconnecting 设备是否处于重连宽限期内
+func (sm *SessionManager) IsReconnecting(deviceID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
 	if sessionID, ok := sm.deviceSessions[deviceID]; ok {
-		if session, ok := sm.sessions[sessionID]; ok {
-			session.Active = false
-			delete(sm.deviceSessions, session.DeviceID)
-			delete(sm.controllerSessions, session.ControllerID)
+		if session, ok := sm.sessions[sessionID]; ok && session.Active {
+			return session.Reconnecting
+		}
+	}
+	return false
+}
+
+// UpdateStreamParams 记录会话最近一次推流参数
+func (sm *SessionManager) UpdateStreamParams(sessionID string, params protocol.StreamControlMessage) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		session.StreamParams = params
+	}
+}
 
-			if session.Controller != nil {
-				session.Controller.SessionID = ""
-				session.Controller.DeviceID = ""
+// StreamParams 读取会话最近一次推流参数的副本
+func (sm *SessionManager) StreamParams(sessionID string) protocol.StreamControlMessage {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		return session.StreamParams
+	}
+	return protocol.StreamControlMessage{}
+}
+
+// SetStreamRegion 记录会话的推流区域（保存副本），nil 表示推全屏
+func (sm *SessionManager) SetStreamRegion(sessionID string, region *protocol.StreamRegion) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		session.StreamRegion = copyStreamRegion(region)
+	}
+}
+
+// StreamRegion 读取会话推流区域的副本，推全屏时返回 nil
+func (sm *SessionManager) StreamRegion(sessionID string) *protocol.StreamRegion {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		return copyStreamRegion(session.StreamRegion)
+	}
+	return nil
+}
+
+func copyStreamRegion(region *protocol.StreamRegion) *protocol.StreamRegion {
+	if region == nil {
+		return nil
+	}
+	r := *region
+	if region.Crop != nil {
+		crop := *region.Crop
+		r.Crop = &crop
+	}
+	return &r
+}
+
+// SetStreamProfile 记录会话当前推流配置和可切换的配置（保存副本）
+func (sm *SessionManager) SetStreamProfile(sessionID string, profile *protocol.StreamProfile, profiles []protocol.StreamProfile) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		session.StreamProfile = copyStreamProfile(profile)
+		if profiles != nil {
+			session.StreamProfiles = append([]protocol.StreamProfile(nil), profiles...)
+		}
+	}
+}
+
+// StreamProfile 读取会话当前推流配置的副本，未分配时返回 nil
+func (sm *SessionManager) StreamProfile(sessionID string) *protocol.StreamProfile {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		return copyStreamProfile(session.StreamProfile)
+	}
+	return nil
+}
+
+// StreamProfiles 读取会话可切换的推流配置的副本
+func (sm *SessionManager) StreamProfiles(sessionID string) []protocol.StreamProfile {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		return append([]protocol.StreamProfile(nil), session.StreamProfiles...)
+	}
+	return nil
+}
+
+// AllowedStreamProfile 在会话可切换的推流配置中按名称查找，返回副本
+func (sm *SessionManager) AllowedStreamProfile(sessionID, name string) (*protocol.StreamProfile, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if session, ok := sm.sessions[sessionID]; ok {
+		for _, profile := range session.StreamProfiles {
+			if profile.Name == name {
+				return &profile, true
 			}
 		}
 	}
+	return nil, false
 }
 
-// CloseByController 通过控制端ID关闭会话
-func (sm *SessionManager) CloseByController(controllerID string) {
+func copyStreamProfile(profile *protocol.StreamProfile) *protocol.StreamProfile {
+	if profile == nil {
+		return nil
+	}
+	p := *profile
+	return &p
+}
+
+// CloseByDevice 通过设备ID关闭会话，返回被关闭的会话
+func (sm *SessionManager) CloseByDevice(deviceID string) *model.Session {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	if sessionID, ok := sm.controllerSessions[controllerID]; ok {
+	if sessionID, ok := sm.deviceSessions[deviceID]; ok {
 		if session, ok := sm.sessions[sessionID]; ok {
-			session.Active = false
-			delete(sm.deviceSessions, session.DeviceID)
-			delete(sm.controllerSessions, session.ControllerID)
+			sm.closeLocked(session)
+			return session
+		}
+	}
+	return nil
+}
 
-			if session.Controller != nil {
-				session.Controller.SessionID = ""
-				session.Controller.DeviceID = ""
-			}
+// CloseByController 通过控制端ID关闭会话，返回被关闭的会话
+func (sm *SessionManager) CloseByController(controllerID string) *model.Session {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sessionID, ok := sm.controllerSessions[controllerID]; ok {
+		if session, ok := sm.sessions[sessionID]; ok {
+			sm.closeLocked(session)
+			return session
 		}
 	}
+	return nil
+}
+
+// closeLocked 关闭会话并清理索引，调用方需持有写锁
+func (sm *SessionManager) closeLocked(session *model.Session) {
+	session.Active = false
+	session.Pending = false
+	session.Reconnecting = false
+	delete(sm.deviceSessions, session.DeviceID)
+	delete(sm.controllerSessions, session.ControllerID)
+
+	if session.Controller != nil {
+		session.Controller.SessionID = ""
+		session.Controller.DeviceID = ""
+	}
 }
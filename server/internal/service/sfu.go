@@ -0,0 +1,454 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// SFUPeerID 服务端作为 WebRTC 对端时使用的 ID（信令消息的 fromId / targetId）
+const SFUPeerID = "sfu"
+
+const (
+	sfuKeyframeInterval = time.Second // 向设备请求关键帧（PLI）的最小间隔
+	sfuBandwidthPeriod  = time.Second // 转发流量计入流量统计的间隔
+)
+
+// ErrNoPeerConnection 没有对应的 PeerConnection（设备未发布或控制端未订阅）
+var ErrNoPeerConnection = errors.New("no peer connection")
+
+// SignalFunc 发送信令消息给对端
+type SignalFunc func(v interface{}) error
+
+// sfuSubscriber 一个接收转发画面的控制端
+type sfuSubscriber struct {
+	id   string
+	pc   *webrtc.PeerConnection // 设备轨道到达后创建
+	send SignalFunc
+}
+
+// sfuRoom 一个设备的发布端和订阅者
+type sfuRoom struct {
+	deviceID    string
+	publisher   *webrtc.PeerConnection
+	track       *webrtc.TrackLocalStaticRTP // 转发给订阅者的轨道，发布端重连且编码相同时沿用
+	ssrc        webrtc.SSRC
+	lastPLI     time.Time
+	subscribers map[string]*sfuSubscriber
+}
+
+// SFU 服务端 WebRTC 转发：设备向服务端发布一次，服务端把 RTP 转发给一个或多个控制端
+// 信令沿用 webrtc.offer / answer / ice / ready 消息，服务端 SDP 一次性携带全部候选（不发送 webrtc.ice）
+type SFU struct {
+	api         *webrtc.API
+	ice         *ICEConfig
+	bandwidth   *BandwidthMeter // 可为空
+	rooms       map[string]*sfuRoom
+	subscribers map[string]*sfuRoom // 订阅者ID -> 所在房间
+	mutex       sync.Mutex
+}
+
+// NewSFU 创建 SFU，ice 为服务端连接使用的 STUN/TURN 配置（可为空），转发的 RTP 计入 bandwidth（可为空）
+func NewSFU(ice *ICEConfig, bandwidth *BandwidthMeter) (*SFU, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, interceptors); err != nil {
+		return nil, err
+	}
+	return &SFU{
+		api:         webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithInterceptorRegistry(interceptors)),
+		ice:         ice,
+		bandwidth:   bandwidth,
+		rooms:       make(map[string]*sfuRoom),
+		subscribers: make(map[string]*sfuRoom),
+	}, nil
+}
+
+// newPeerConnection 按 ICE 配置创建 PeerConnection
+func (s *SFU) newPeerConnection() (*webrtc.PeerConnection, error) {
+	var config webrtc.Configuration
+	for _, server := range s.ice.Servers(SFUPeerID) {
+		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+	return s.api.NewPeerConnection(config)
+}
+
+// room 获取或创建设备的房间（调用方需持有锁）
+func (s *SFU) room(deviceID string) *sfuRoom {
+	room := s.rooms[deviceID]
+	if room == nil {
+		room = &sfuRoom{deviceID: deviceID, subscribers: make(map[string]*sfuSubscriber)}
+		s.rooms[deviceID] = room
+	}
+	return room
+}
+
+// Subscribe 控制端订阅设备画面，设备轨道已就绪时立即发送 offer
+// needPublish 为 true 表示设备尚未发布，调用方需通知设备开始推流
+func (s *SFU) Subscribe(deviceID, subscriberID string, send SignalFunc) (needPublish bool) {
+	s.mutex.Lock()
+	if oldRoom := s.subscribers[subscriberID]; oldRoom != nil {
+		if oldRoom.deviceID != deviceID {
+			s.removeSubscriber(oldRoom, subscriberID)
+		} else if old := oldRoom.subscribers[subscriberID]; old.pc != nil {
+			// 同一设备重新订阅，替换连接但保留发布端
+			go old.pc.Close()
+		}
+	}
+	room := s.room(deviceID)
+	sub := &sfuSubscriber{id: subscriberID, send: send}
+	room.subscribers[subscriberID] = sub
+	s.subscribers[subscriberID] = room
+	track := room.track
+	needPublish = room.publisher == nil
+	s.mutex.Unlock()
+
+	if track != nil {
+		go s.offer(room, sub, track)
+	}
+	return needPublish
+}
+
+// Publish 处理设备的 offer，异步回复 answer
+func (s *SFU) Publish(deviceID string, offer protocol.SessionDescription, send SignalFunc) error {
+	pc, err := s.newPeerConnection()
+	if err != nil {
+		return err
+	}
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.forward(deviceID, pc, remote)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("SFU 设备连接状态: %s %s", deviceID, state)
+		if state == webrtc.PeerConnectionStateFailed {
+			s.closePublisher(deviceID, pc)
+		}
+	})
+
+	s.mutex.Lock()
+	room := s.room(deviceID)
+	old := room.publisher
+	room.publisher = pc
+	s.mutex.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		s.closePublisher(deviceID, pc)
+		return err
+	}
+
+	go func() {
+		answer, err := pc.CreateAnswer(nil)
+		if err == nil {
+			gathered := webrtc.GatheringCompletePromise(pc)
+			if err = pc.SetLocalDescription(answer); err == nil {
+				<-gathered
+				err = send(protocol.WebRTCMessage{
+					Type:   protocol.TypeWebRTCAnswer,
+					FromID: SFUPeerID,
+					SDP:    &protocol.SessionDescription{Type: "answer", SDP: pc.LocalDescription().SDP},
+				})
+			}
+		}
+		if err != nil {
+			log.Printf("SFU 回复设备 answer 失败: %s %v", deviceID, err)
+			s.closePublisher(deviceID, pc)
+		}
+	}()
+	return nil
+}
+
+// forward 把设备轨道的 RTP 转发给所有订阅者
+func (s *SFU) forward(deviceID string, pc *webrtc.PeerConnection, remote *webrtc.TrackRemote) {
+	if remote.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	s.mutex.Lock()
+	room := s.rooms[deviceID]
+	if room == nil || room.publisher != pc {
+		s.mutex.Unlock()
+		return
+	}
+	codec := remote.Codec().RTPCodecCapability
+	var pending []*sfuSubscriber
+	if room.track == nil || room.track.Codec().MimeType != codec.MimeType {
+		track, err := webrtc.NewTrackLocalStaticRTP(codec, "video", "shushu-"+deviceID)
+		if err != nil {
+			s.mutex.Unlock()
+			log.Printf("SFU 创建转发轨道失败: %v", err)
+			return
+		}
+		room.track = track
+		// 编码变化，所有订阅者需要重新协商
+		for _, sub := range room.subscribers {
+			if sub.pc != nil {
+				go sub.pc.Close()
+				sub.pc = nil
+			}
+			pending = append(pending, sub)
+		}
+	} else {
+		for _, sub := range room.subscribers {
+			if sub.pc == nil {
+				pending = append(pending, sub)
+			}
+		}
+	}
+	room.ssrc = remote.SSRC()
+	room.lastPLI = time.Time{}
+	track := room.track
+	s.mutex.Unlock()
+
+	log.Printf("SFU 设备开始发布: %s %s", deviceID, codec.MimeType)
+	for _, sub := range pending {
+		go s.offer(room, sub, track)
+	}
+	s.RequestKeyframe(deviceID)
+
+	var (
+		relayed    int64
+		lastReport = time.Now()
+	)
+	defer func() { s.reportRelay(deviceID, relayed) }()
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		if err := track.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+		relayed += int64(packet.MarshalSize())
+		if now := time.Now(); now.Sub(lastReport) >= sfuBandwidthPeriod {
+			s.reportRelay(deviceID, relayed)
+			relayed, lastReport = 0, now
+		}
+	}
+}
+
+// reportRelay 把设备发布的 RTP 字节计入设备链路，并按已连接的订阅者计入控制端链路
+func (s *SFU) reportRelay(deviceID string, bytes int64) {
+	if s.bandwidth == nil || bytes == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	var subscribers []string
+	if room := s.rooms[deviceID]; room != nil {
+		for id, sub := range room.subscribers {
+			if sub.pc != nil {
+				subscribers = append(subscribers, id)
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	s.bandwidth.RecordDevice(deviceID, bytes, 0)
+	for _, id := range subscribers {
+		s.bandwidth.RecordController(id, 0, bytes)
+	}
+}
+
+// offer 为订阅者创建 PeerConnection 并发送 offer
+func (s *SFU) offer(room *sfuRoom, sub *sfuSubscriber, track *webrtc.TrackLocalStaticRTP) {
+	pc, err := s.newPeerConnection()
+	if err != nil {
+		log.Printf("SFU 创建订阅连接失败: %v", err)
+		return
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		log.Printf("SFU 添加转发轨道失败: %v", err)
+		return
+	}
+	go s.readRTCP(room.deviceID, sender)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("SFU 控制端连接状态: %s %s", sub.id, state)
+		if state == webrtc.PeerConnectionStateConnected {
+			s.RequestKeyframe(room.deviceID)
+		}
+	})
+
+	s.mutex.Lock()
+	if room.subscribers[sub.id] != sub || sub.pc != nil {
+		// 已取消订阅或已有连接
+		s.mutex.Unlock()
+		pc.Close()
+		return
+	}
+	sub.pc = pc
+	s.mutex.Unlock()
+
+	offer, err := pc.CreateOffer(nil)
+	if err == nil {
+		gathered := webrtc.GatheringCompletePromise(pc)
+		if err = pc.SetLocalDescription(offer); err == nil {
+			<-gathered
+			err = sub.send(protocol.WebRTCMessage{
+				Type:   protocol.TypeWebRTCOffer,
+				FromID: room.deviceID,
+				SDP:    &protocol.SessionDescription{Type: "offer", SDP: pc.LocalDescription().SDP},
+			})
+		}
+	}
+	if err != nil {
+		log.Printf("SFU 发送 offer 失败: %s %v", sub.id, err)
+	}
+}
+
+// readRTCP 读取订阅者的 RTCP，收到 PLI/FIR 时向设备请求关键帧
+func (s *SFU) readRTCP(deviceID string, sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				s.RequestKeyframe(deviceID)
+			}
+		}
+	}
+}
+
+// RequestKeyframe 向设备发送 PLI 请求关键帧（限频）
+func (s *SFU) RequestKeyframe(deviceID string) {
+	s.mutex.Lock()
+	room := s.rooms[deviceID]
+	if room == nil || room.publisher == nil || room.ssrc == 0 || time.Since(room.lastPLI) < sfuKeyframeInterval {
+		s.mutex.Unlock()
+		return
+	}
+	room.lastPLI = time.Now()
+	publisher, ssrc := room.publisher, room.ssrc
+	s.mutex.Unlock()
+
+	publisher.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}})
+}
+
+// Answer 处理控制端的 answer
+func (s *SFU) Answer(subscriberID string, answer protocol.SessionDescription) error {
+	s.mutex.Lock()
+	var pc *webrtc.PeerConnection
+	if room := s.subscribers[subscriberID]; room != nil {
+		pc = room.subscribers[subscriberID].pc
+	}
+	s.mutex.Unlock()
+	if pc == nil {
+		return ErrNoPeerConnection
+	}
+	return pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP})
+}
+
+// AddPublisherCandidate 添加设备的 ICE 候选
+func (s *SFU) AddPublisherCandidate(deviceID string, candidate protocol.ICECandidateMessage) error {
+	s.mutex.Lock()
+	var pc *webrtc.PeerConnection
+	if room := s.rooms[deviceID]; room != nil {
+		pc = room.publisher
+	}
+	s.mutex.Unlock()
+	return addCandidate(pc, candidate)
+}
+
+// AddSubscriberCandidate 添加控制端的 ICE 候选
+func (s *SFU) AddSubscriberCandidate(subscriberID string, candidate protocol.ICECandidateMessage) error {
+	s.mutex.Lock()
+	var pc *webrtc.PeerConnection
+	if room := s.subscribers[subscriberID]; room != nil {
+		pc = room.subscribers[subscriberID].pc
+	}
+	s.mutex.Unlock()
+	return addCandidate(pc, candidate)
+}
+
+func addCandidate(pc *webrtc.PeerConnection, candidate protocol.ICECandidateMessage) error {
+	if pc == nil {
+		return ErrNoPeerConnection
+	}
+	if candidate.Candidate == "" {
+		return nil // 候选收集结束
+	}
+	return pc.AddICECandidate(webrtc.ICECandidateInit{
+		Candidate:     candidate.Candidate,
+		SDPMid:        candidate.SDPMid,
+		SDPMLineIndex: candidate.SDPMLineIndex,
+	})
+}
+
+// Unsubscribe 控制端取消订阅，设备没有订阅者时关闭发布连接
+func (s *SFU) Unsubscribe(subscriberID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if room := s.subscribers[subscriberID]; room != nil {
+		s.removeSubscriber(room, subscriberID)
+	}
+}
+
+// removeSubscriber 移除订阅者（调用方需持有锁）
+func (s *SFU) removeSubscriber(room *sfuRoom, subscriberID string) {
+	sub := room.subscribers[subscriberID]
+	delete(room.subscribers, subscriberID)
+	delete(s.subscribers, subscriberID)
+	if sub != nil && sub.pc != nil {
+		go sub.pc.Close()
+	}
+	if len(room.subscribers) == 0 {
+		log.Printf("SFU 设备没有订阅者，停止转发: %s", room.deviceID)
+		s.closeRoom(room)
+	}
+}
+
+// closePublisher 发布连接失败时关闭（订阅者保留，等待设备重新发布）
+func (s *SFU) closePublisher(deviceID string, pc *webrtc.PeerConnection) {
+	s.mutex.Lock()
+	if room := s.rooms[deviceID]; room != nil && room.publisher == pc {
+		room.publisher = nil
+		room.ssrc = 0
+	}
+	s.mutex.Unlock()
+	pc.Close()
+}
+
+// CloseDevice 设备离线时关闭发布连接和所有订阅连接
+func (s *SFU) CloseDevice(deviceID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if room := s.rooms[deviceID]; room != nil {
+		s.closeRoom(room)
+	}
+}
+
+// closeRoom 关闭房间内所有连接（调用方需持有锁）
+func (s *SFU) closeRoom(room *sfuRoom) {
+	for id, sub := range room.subscribers {
+		delete(s.subscribers, id)
+		if sub.pc != nil {
+			go sub.pc.Close()
+		}
+	}
+	if room.publisher != nil {
+		go room.publisher.Close()
+	}
+	delete(s.rooms, room.deviceID)
+}
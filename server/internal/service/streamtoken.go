@@ -0,0 +1,49 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamTokens 签发和校验只读观看 Token，只能用于指定设备的 MJPEG、HLS 和缩略图
+// Token 为 "过期时间戳.base64url(HMAC-SHA256(secret, "stream:设备ID:过期时间戳"))"，
+// 无需服务端保存状态，更换密钥（管理 API Token）后已签发的 Token 全部失效
+type StreamTokens struct {
+	secret []byte
+	TTL    time.Duration
+}
+
+// NewStreamTokens 创建观看 Token 签发器
+func NewStreamTokens(secret string, ttl time.Duration) *StreamTokens {
+	return &StreamTokens{secret: []byte(secret), TTL: ttl}
+}
+
+// Issue 签发设备的观看 Token
+func (t *StreamTokens) Issue(deviceID string) (string, time.Time) {
+	expires := time.Now().Add(t.TTL).Truncate(time.Second)
+	stamp := strconv.FormatInt(expires.Unix(), 10)
+	return stamp + "." + t.sign(deviceID, stamp), expires
+}
+
+// Valid 校验 Token 是否为该设备签发且未过期
+func (t *StreamTokens) Valid(token, deviceID string, now time.Time) bool {
+	stamp, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil || expires < now.Unix() {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(t.sign(deviceID, stamp)))
+}
+
+func (t *StreamTokens) sign(deviceID, stamp string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte("stream:" + deviceID + ":" + stamp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamTokensValid(t *testing.T) {
+	tokens := NewStreamTokens("secret", time.Minute)
+	token, expires := tokens.Issue("d1")
+	now := time.Now()
+
+	cases := []struct {
+		name     string
+		token    string
+		deviceID string
+		secret   string
+		now      time.Time
+		valid    bool
+	}{
+		{"issued device", token, "d1", "secret", now, true},
+		{"other device", token, "d2", "secret", now, false},
+		{"expired", token, "d1", "secret", expires.Add(time.Second), false},
+		{"rotated secret", token, "d1", "other", now, false},
+		{"extended expiry", "9999999999" + token[len(token)-44:], "d1", "secret", now, false},
+		{"malformed", "garbage", "d1", "secret", now, false},
+		{"empty", "", "d1", "secret", now, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NewStreamTokens(tc.secret, time.Minute).Valid(tc.token, tc.deviceID, tc.now); got != tc.valid {
+				t.Fatalf("Valid = %v, 期望 %v", got, tc.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const (
+	thumbnailRequestTimeout = 5 * time.Second // 设备超过该时长未回复视为请求失败，可重新请求
+	thumbnailFeedBuffer     = 64              // 每个订阅者缓冲的推送数量，满时丢弃
+)
+
+// thumbnailEntry 单个设备的缩略图
+type thumbnailEntry struct {
+	jpeg        []byte
+	updatedAt   time.Time
+	requestedAt time.Time
+	pending     bool // 已请求、等待设备回复
+}
+
+// ThumbnailFeed 缩略图墙订阅者
+type ThumbnailFeed struct {
+	messages chan protocol.ThumbnailMessage
+}
+
+// Messages 接收缩略图更新和设备离线通知
+func (f *ThumbnailFeed) Messages() <-chan protocol.ThumbnailMessage {
+	return f.messages
+}
+
+// ThumbnailManager 缓存每台设备的最新缩略图并推送给订阅者
+// 按 interval 轮询设备，同时等待回复的请求数不超过 maxInFlight，避免整个设备群同时截图
+type ThumbnailManager struct {
+	interval    time.Duration
+	maxInFlight int
+	entries     map[string]*thumbnailEntry
+	feeds       map[*ThumbnailFeed]struct{}
+	mutex       sync.Mutex
+}
+
+// NewThumbnailManager 创建缩略图管理器
+func NewThumbnailManager(interval time.Duration, maxInFlight int) *ThumbnailManager {
+	return &ThumbnailManager{
+		interval:    interval,
+		maxInFlight: maxInFlight,
+		entries:     make(map[string]*thumbnailEntry),
+		feeds:       make(map[*ThumbnailFeed]struct{}),
+	}
+}
+
+// Due 从 deviceIDs 中选出本轮需要请求缩略图的设备并标记为等待回复
+// 最久未请求的设备优先，超过并发上限的设备留到下一轮
+func (m *ThumbnailManager) Due(deviceIDs []string, now time.Time) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	inFlight := 0
+	var due []string
+	for _, deviceID := range deviceIDs {
+		entry := m.entries[deviceID]
+		if entry == nil {
+			entry = &thumbnailEntry{}
+			m.entries[deviceID] = entry
+		}
+		if entry.pending && now.Sub(entry.requestedAt) < thumbnailRequestTimeout {
+			inFlight++
+			continue
+		}
+		entry.pending = false
+		if now.Sub(entry.requestedAt) >= m.interval {
+			due = append(due, deviceID)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return m.entries[due[i]].requestedAt.Before(m.entries[due[j]].requestedAt)
+	})
+	if limit := m.maxInFlight - inFlight; len(due) > limit {
+		if limit < 0 {
+			limit = 0
+		}
+		due = due[:limit]
+	}
+	for _, deviceID := range due {
+		m.entries[deviceID].requestedAt = now
+		m.entries[deviceID].pending = true
+	}
+	return due
+}
+
+// Update 保存设备回复的缩略图并推送给订阅者
+func (m *ThumbnailManager) Update(deviceID string, jpeg []byte, now time.Time) {
+	data := make([]byte, len(jpeg))
+	copy(data, jpeg)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry := m.entries[deviceID]
+	if entry == nil {
+		entry = &thumbnailEntry{}
+		m.entries[deviceID] = entry
+	}
+	entry.jpeg = data
+	entry.updatedAt = now
+	entry.pending = false
+
+	m.publish(protocol.ThumbnailMessage{
+		Type:      protocol.TypeThumbnailUpdate,
+		DeviceID:  deviceID,
+		UpdatedAt: now.UnixMilli(),
+		JPEG:      data,
+	})
+}
+
+// Get 获取设备的最新缩略图
+func (m *ThumbnailManager) Get(deviceID string) ([]byte, time.Time, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry := m.entries[deviceID]
+	if entry == nil || entry.jpeg == nil {
+		return nil, time.Time{}, false
+	}
+	return entry.jpeg, entry.updatedAt, true
+}
+
+// Remove 设备离线时丢弃其缩略图并通知订阅者
+func (m *ThumbnailManager) Remove(deviceID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry := m.entries[deviceID]
+	if entry == nil {
+		return
+	}
+	delete(m.entries, deviceID)
+	if entry.jpeg != nil {
+		m.publish(protocol.ThumbnailMessage{Type: protocol.TypeThumbnailRemove, DeviceID: deviceID})
+	}
+}
+
+// Subscribe 添加订阅者，返回当前已缓存的全部缩略图
+func (m *ThumbnailManager) Subscribe() (*ThumbnailFeed, []protocol.ThumbnailMessage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	feed := &ThumbnailFeed{messages: make(chan protocol.ThumbnailMessage, thumbnailFeedBuffer)}
+	m.feeds[feed] = struct{}{}
+
+	snapshot := make([]protocol.ThumbnailMessage, 0, len(m.entries))
+	for deviceID, entry := range m.entries {
+		if entry.jpeg == nil {
+			continue
+		}
+		snapshot = append(snapshot, protocol.ThumbnailMessage{
+			Type:      protocol.TypeThumbnailUpdate,
+			DeviceID:  deviceID,
+			UpdatedAt: entry.updatedAt.UnixMilli(),
+			JPEG:      entry.jpeg,
+		})
+	}
+	return feed, snapshot
+}
+
+// Unsubscribe 移除订阅者
+func (m *ThumbnailManager) Unsubscribe(feed *ThumbnailFeed) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.feeds, feed)
+}
+
+// publish 推送给所有订阅者，订阅者处理不过来时丢弃（下次更新会带上最新画面）
+func (m *ThumbnailManager) publish(msg protocol.ThumbnailMessage) {
+	for feed := range m.feeds {
+		select {
+		case feed.messages <- msg:
+		default:
+		}
+	}
+}
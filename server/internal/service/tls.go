@@ -0,0 +1,157 @@
+package service
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certWatchPeriod 检查证书文件是否更新的间隔
+const certWatchPeriod = 10 * time.Second
+
+// CertReloader 从文件加载 TLS 证书，收到重载请求、重载信号或文件变化时替换
+// 证书只在握手时读取，替换不影响已建立的连接
+type CertReloader struct {
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	modTime   time.Time // 已加载证书和私钥文件的最后修改时间（取较晚者）
+	mutex     sync.RWMutex
+	done      chan struct{} // Close 后关闭，文件检查和信号监听随之退出
+	closeOnce sync.Once
+}
+
+// NewCertReloader 加载证书并每 10 秒检查一次文件，文件无效时返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	return newCertReloader(certFile, keyFile, certWatchPeriod)
+}
+
+// newCertReloader 加载证书，按 period 检查文件变化（测试可缩短）
+func newCertReloader(certFile, keyFile string, period time.Duration) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, done: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch(period)
+	return r, nil
+}
+
+// Close 停止文件检查和信号监听，已加载的证书继续可用
+func (r *CertReloader) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// ReloadOn 收到指定信号（如 SIGHUP）时重新加载证书
+func (r *CertReloader) ReloadOn(sig ...os.Signal) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-signals:
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("重新加载 TLS 证书失败，继续使用原证书: %v", err)
+				continue
+			}
+			log.Printf("TLS 证书已重新加载")
+		}
+	}()
+}
+
+// Reload 重新加载证书，失败时继续使用原证书
+func (r *CertReloader) Reload() error {
+	modTime := r.fileModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mutex.Unlock()
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用，返回当前证书
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig 使用当前证书的服务端 TLS 配置
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// watch 定期检查证书文件，修改时间变化后重新加载
+// 证书和私钥可能先后写入，加载失败时下次检查重试
+func (r *CertReloader) watch(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		r.mutex.RLock()
+		loaded := r.modTime
+		r.mutex.RUnlock()
+		if modTime := r.fileModTime(); modTime.IsZero() || modTime.Equal(loaded) {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			log.Printf("证书文件已变化，重新加载失败: %v", err)
+			continue
+		}
+		log.Printf("证书文件已变化，重新加载成功")
+	}
+}
+
+// fileModTime 证书和私钥文件中较晚的修改时间，读取失败时返回零值
+func (r *CertReloader) fileModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// HTTPSRedirect 把 HTTP 请求重定向到 httpsPort 端口的 HTTPS 地址
+// 使用 308 永久重定向，保留请求方法和请求体
+func HTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := strings.Trim(req.Host, "[]")
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 地址
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertPair 在 dir 中写入 CommonName 为 name 的自签名证书和私钥，修改时间设为 modTime
+func writeCertPair(t *testing.T, dir, name string, modTime time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+	return certFile, keyFile
+}
+
+// writeFile 写入文件并设置修改时间（避免依赖文件系统的时间精度）
+func writeFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commonName 当前证书的 CommonName
+func commonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("读取证书失败: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// waitCommonName 等待证书切换为 name
+func waitCommonName(t *testing.T, r *CertReloader, name string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for commonName(t, r) != name {
+		if time.Now().After(deadline) {
+			t.Fatalf("证书为 %q, 期望 %q", commonName(t, r), name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := writeCertPair(t, dir, "first", start)
+
+	r, err := newCertReloader(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if name := commonName(t, r); name != "first" {
+		t.Fatalf("初始证书 %q", name)
+	}
+
+	// 文件修改后自动加载
+	writeCertPair(t, dir, "second", start.Add(time.Minute))
+	waitCommonName(t, r, "second")
+
+	// 新私钥无效时继续使用原证书，手动重载返回错误
+	writeFile(t, keyFile, []byte("not a key"), start.Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if err := r.Reload(); err == nil {
+		t.Fatal("无效私钥应加载失败")
+	}
+	if name := commonName(t, r); name != "second" {
+		t.Fatalf("加载失败后证书变为 %q", name)
+	}
+
+	// 修复后下一次检查重新加载
+	writeCertPair(t, dir, "third", start.Add(3*time.Minute))
+	waitCommonName(t, r, "third")
+}
+
+func TestCertReloaderMissingFile(t *testing.T) {
+	if _, err := NewCertReloader(filepath.Join(t.TempDir(), "cert.pem"), filepath.Join(t.TempDir(), "key.pem")); err == nil {
+		t.Fatal("证书文件不存在时应返回错误")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	cases := []struct {
+		name     string
+		port     string
+		host     string
+		target   string
+		location string
+	}{
+		{"default port", "443", "example.com", "/api/devices?status=online", "https://example.com/api/devices?status=online"},
+		{"strip http port", "443", "example.com:80", "/", "https://example.com/"},
+		{"custom port", "8443", "example.com:8080", "/ws/device", "https://example.com:8443/ws/device"},
+		{"ipv6 default port", "443", "[::1]:80", "/", "https://[::1]/"},
+		{"ipv6 without port", "443", "[::1]", "/", "https://[::1]/"},
+		{"ipv6 custom port", "8443", "[::1]", "/", "https://[::1]:8443/"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			req.Host = tc.host
+			rec := httptest.NewRecorder()
+			HTTPSRedirect(tc.port).ServeHTTP(rec, req)
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Fatalf("状态码 %d, 期望 308", rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tc.location {
+				t.Fatalf("Location %q, 期望 %q", location, tc.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package service
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := writeCertPair(t, dir, "first", start)
+
+	// 文件检查间隔足够长，只有信号会触发重载
+	r, err := newCertReloader(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.ReloadOn(syscall.SIGHUP)
+
+	writeCertPair(t, dir, "second", start.Add(time.Minute))
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitCommonName(t, r, "second")
+
+	// 新证书无效时继续使用原证书
+	writeFile(t, certFile, []byte("not a certificate"), start.Add(2*time.Minute))
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if name := commonName(t, r); name != "second" {
+		t.Fatalf("加载失败后证书变为 %q", name)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/pion/turn/v2"
+)
+
+const turnRealm = "shushu"
+
+// TURNServer 内置 TURN 服务（UDP 和 TCP 监听同一端口），使用共享密钥凭据认证
+type TURNServer struct {
+	URLs   []string // 下发给客户端的地址
+	server *turn.Server
+}
+
+// StartTURNServer 启动内置 TURN 服务，publicIP 为客户端可访问的本机公网地址（中继地址）
+func StartTURNServer(listen, publicIP, secret string) (*TURNServer, error) {
+	ip := net.ParseIP(publicIP)
+	if ip == nil {
+		return nil, errors.New("invalid turn public ip")
+	}
+
+	udpConn, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener, err := net.Listen("tcp", listen)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+
+	relay := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: ip, Address: "0.0.0.0"}
+	}
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: turnRealm,
+		AuthHandler: func(username, realm string, _ net.Addr) ([]byte, bool) {
+			if !turnUsernameValid(username, time.Now()) {
+				return nil, false
+			}
+			return turn.GenerateAuthKey(username, realm, turnPassword(secret, username)), true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udpConn, RelayAddressGenerator: relay(), PermissionHandler: turnPeerAllowed}},
+		ListenerConfigs:   []turn.ListenerConfig{{Listener: tcpListener, RelayAddressGenerator: relay(), PermissionHandler: turnPeerAllowed}},
+	})
+	if err != nil {
+		udpConn.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	_, port, _ := net.SplitHostPort(udpConn.LocalAddr().String())
+	host := net.JoinHostPort(publicIP, port)
+	return &TURNServer{
+		URLs: []string{
+			"stun:" + host,
+			"turn:" + host + "?transport=udp",
+			"turn:" + host + "?transport=tcp",
+		},
+		server: server,
+	}, nil
+}
+
+// turnPeerAllowed 拒绝中继到内网地址，避免持有凭据的客户端经 TURN 访问服务端所在网络
+// 拒绝回环、私有地址（RFC1918 / IPv6 ULA）、链路本地、未指定和组播地址
+func turnPeerAllowed(_ net.Addr, peerIP net.IP) bool {
+	return !(peerIP.IsLoopback() ||
+		peerIP.IsPrivate() ||
+		peerIP.IsLinkLocalUnicast() ||
+		peerIP.IsLinkLocalMulticast() ||
+		peerIP.IsUnspecified() ||
+		peerIP.IsMulticast())
+}
+
+// Close 停止 TURN 服务
+func (s *TURNServer) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTURNPeerAllowed(t *testing.T) {
+	cases := []struct {
+		peer    string
+		allowed bool
+	}{
+		{"203.0.113.10", true},
+		{"8.8.8.8", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // 云厂商元数据服务
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.peer, func(t *testing.T) {
+			if got := turnPeerAllowed(nil, net.ParseIP(tc.peer)); got != tc.allowed {
+				t.Fatalf("turnPeerAllowed(%s) = %v, 期望 %v", tc.peer, got, tc.allowed)
+			}
+		})
+	}
+}
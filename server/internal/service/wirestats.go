@@ -0,0 +1,53 @@
+package service
+
+import "sync/atomic"
+
+// WireStats 单个 WebSocket 端点的发送统计，用于观察 permessage-deflate 压缩效果
+// 文本消息记录原始字节和实际写出字节（含帧头），二进制帧不压缩，只记录字节数
+type WireStats struct {
+	textMessages   atomic.Int64
+	textBytes      atomic.Int64
+	textWireBytes  atomic.Int64
+	binaryMessages atomic.Int64
+	binaryBytes    atomic.Int64
+}
+
+// WireStatsSnapshot 统计快照
+type WireStatsSnapshot struct {
+	Compression      bool    `json:"compression"` // 端点是否开启压缩协商
+	TextMessages     int64   `json:"textMessages"`
+	TextBytes        int64   `json:"textBytes"`
+	TextWireBytes    int64   `json:"textWireBytes"`
+	CompressionRatio float64 `json:"compressionRatio"` // textWireBytes / textBytes，越小压缩效果越好
+	BinaryMessages   int64   `json:"binaryMessages"`
+	BinaryBytes      int64   `json:"binaryBytes"`
+}
+
+// RecordText 记录一条文本消息
+func (s *WireStats) RecordText(payload, wire int64) {
+	s.textMessages.Add(1)
+	s.textBytes.Add(payload)
+	s.textWireBytes.Add(wire)
+}
+
+// RecordBinary 记录一条二进制消息
+func (s *WireStats) RecordBinary(payload int64) {
+	s.binaryMessages.Add(1)
+	s.binaryBytes.Add(payload)
+}
+
+// Snapshot 读取当前统计
+func (s *WireStats) Snapshot(compression bool) WireStatsSnapshot {
+	snap := WireStatsSnapshot{
+		Compression:    compression,
+		TextMessages:   s.textMessages.Load(),
+		TextBytes:      s.textBytes.Load(),
+		TextWireBytes:  s.textWireBytes.Load(),
+		BinaryMessages: s.binaryMessages.Load(),
+		BinaryBytes:    s.binaryBytes.Load(),
+	}
+	if snap.TextBytes > 0 {
+		snap.CompressionRatio = float64(snap.TextWireBytes) / float64(snap.TextBytes)
+	}
+	return snap
+}
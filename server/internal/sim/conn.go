@@ -0,0 +1,174 @@
+// Package sim 提供模拟设备和模拟控制端，用于本地联调和集成测试
+package sim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const writeWait = 5 * time.Second
+
+// ErrTimeout 等待消息或帧超时
+var ErrTimeout = errors.New("sim: timeout")
+
+// Message 收到的协议消息
+type Message struct {
+	Type      string `json:"type"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// Decode 将消息解析到具体结构
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal(m.Raw, v)
+}
+
+// ServerError 服务端返回的 error / control.denied 消息
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// wsConn 封装连接的编码和并发写
+type wsConn struct {
+	conn     *websocket.Conn
+	encoding string
+	mutex    sync.Mutex
+}
+
+func dial(url, subprotocol string) (*wsConn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &wsConn{conn: conn, encoding: protocol.EncodingForSubprotocol(conn.Subprotocol())}, nil
+}
+
+// sendJSON 按协商的编码发送协议消息
+func (c *wsConn) sendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	messageType := websocket.TextMessage
+	if c.encoding == protocol.EncodingMsgpack {
+		if data, err = protocol.JSONToMsgpack(data); err != nil {
+			return err
+		}
+		messageType = websocket.BinaryMessage
+	}
+	return c.write(messageType, data)
+}
+
+func (c *wsConn) write(messageType int, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// read 读取一条消息，返回协议消息或屏幕帧（二者其一）
+func (c *wsConn) read() (*Message, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if messageType == websocket.BinaryMessage {
+		if c.encoding != protocol.EncodingMsgpack || !protocol.IsMsgpackMessage(data) {
+			return nil, data, nil
+		}
+		if data, err = protocol.MsgpackToJSON(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	msg := &Message{Raw: data}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, nil, err
+	}
+	return msg, nil, nil
+}
+
+func (c *wsConn) close() error {
+	c.mutex.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.mutex.Unlock()
+	return c.conn.Close()
+}
+
+// closeCode 从读取错误中提取关闭码
+func closeCode(err error) int {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code
+	}
+	return 0
+}
+
+// deliver 非阻塞投递消息，缓冲区满时丢弃最旧的消息
+func deliver(ch chan Message, msg Message) {
+	for {
+		select {
+		case ch <- msg:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// waitMessage 等待指定类型的消息，其他消息被丢弃；types 为空时返回下一条消息
+func waitMessage(ch <-chan Message, done <-chan struct{}, timeout time.Duration, types ...string) (Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-ch:
+			if len(types) == 0 || containsType(types, msg.Type) {
+				return msg, nil
+			}
+		case <-done:
+			// 连接已关闭，先取完缓冲区中的消息
+			select {
+			case msg := <-ch:
+				if len(types) == 0 || containsType(types, msg.Type) {
+					return msg, nil
+				}
+				continue
+			default:
+			}
+			return Message{}, errors.New("sim: connection closed")
+		case <-timer.C:
+			return Message{}, ErrTimeout
+		}
+	}
+}
+
+func containsType(types []string, t string) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package sim
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+const maxFrameLog = 64 // 记录最早收到的帧类型数量
+
+// ControllerConfig 模拟控制端参数
+type ControllerConfig struct {
+	ServerURL   string // 服务端地址，如 ws://127.0.0.1:9222
+	DeviceID    string
+	Token       string // 控制 Token（rc_devices.token）
+	Subprotocol string // 消息编码子协议，为空使用 JSON
+}
+
+// FakeController 模拟控制端：请求控制、发送输入并统计收到的屏幕帧
+type FakeController struct {
+	cfg  ControllerConfig
+	conn *wsConn
+
+	messages  chan Message
+	done      chan struct{}
+	closeCode atomic.Int32
+	frames    atomic.Int64
+
+	frameMutex sync.Mutex
+	frameLog   []byte // 最早收到的帧类型，用于检查配置帧是否先于视频帧到达
+	seq        atomic.Int64
+}
+
+// ConnectController 连接服务端
+// Token 校验失败时服务端在握手后关闭连接，可通过 Done / CloseCode 获取结果
+func ConnectController(cfg ControllerConfig) (*FakeController, error) {
+	query := url.Values{}
+	query.Set("deviceId", cfg.DeviceID)
+	query.Set("token", cfg.Token)
+	conn, err := dial(strings.TrimRight(cfg.ServerURL, "/")+"/ws/controller?"+query.Encode(), cfg.Subprotocol)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &FakeController{
+		cfg:      cfg,
+		conn:     conn,
+		messages: make(chan Message, 256),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// RequestControl 请求控制设备，返回授权消息；被拒绝时返回 *ServerError
+func (c *FakeController) RequestControl(timeout time.Duration) (*protocol.ControlGrantedMessage, error) {
+	err := c.conn.sendJSON(protocol.ControlRequestMessage{
+		Type:     protocol.TypeControlRequest,
+		DeviceID: c.cfg.DeviceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := c.WaitMessage(timeout, protocol.TypeControlGranted, protocol.TypeControlDenied, protocol.TypeError)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != protocol.TypeControlGranted {
+		var errMsg protocol.ErrorMessage
+		msg.Decode(&errMsg)
+		return nil, &ServerError{Code: errMsg.Code, Message: errMsg.Message}
+	}
+
+	var granted protocol.ControlGrantedMessage
+	if err := msg.Decode(&granted); err != nil {
+		return nil, err
+	}
+	return &granted, nil
+}
+
+// Send 发送协议消息
+func (c *FakeController) Send(v interface{}) error {
+	return c.conn.sendJSON(v)
+}
+
+// NextRequestID 生成请求 ID
+func (c *FakeController) NextRequestID() string {
+	return fmt.Sprintf("sim-%d", c.seq.Add(1))
+}
+
+// WaitMessage 等待指定类型的消息，期间收到的其他消息被丢弃
+func (c *FakeController) WaitMessage(timeout time.Duration, types ...string) (Message, error) {
+	return waitMessage(c.messages, c.done, timeout, types...)
+}
+
+// WaitFrames 等待累计收到至少 n 帧
+func (c *FakeController) WaitFrames(n int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for c.frames.Load() < n {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: 收到 %d/%d 帧", ErrTimeout, c.frames.Load(), n)
+		}
+		select {
+		case <-c.done:
+			return fmt.Errorf("sim: connection closed after %d frames", c.frames.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Frames 累计收到的帧数
+func (c *FakeController) Frames() int64 {
+	return c.frames.Load()
+}
+
+// FrameTypes 最早收到的帧类型（0x01 MJPEG，含不带帧头的 JPEG、0x02 H264、0x03 H264 配置）
+func (c *FakeController) FrameTypes() []byte {
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+	return append([]byte(nil), c.frameLog...)
+}
+
+// Done 连接断开时关闭
+func (c *FakeController) Done() <-chan struct{} {
+	return c.done
+}
+
+// CloseCode 服务端关闭连接时的关闭码（连接断开后有效）
+func (c *FakeController) CloseCode() int {
+	return int(c.closeCode.Load())
+}
+
+// Close 断开连接
+func (c *FakeController) Close() error {
+	return c.conn.close()
+}
+
+func (c *FakeController) readLoop() {
+	defer close(c.done)
+
+	for {
+		msg, frame, err := c.conn.read()
+		if err != nil {
+			c.closeCode.Store(int32(closeCode(err)))
+			return
+		}
+		if msg != nil {
+			deliver(c.messages, *msg)
+			continue
+		}
+		if frameType, _, _, ok := protocol.ParseFrame(frame); ok {
+			c.frameMutex.Lock()
+			if len(c.frameLog) < maxFrameLog {
+				c.frameLog = append(c.frameLog, frameType)
+			}
+			c.frameMutex.Unlock()
+		}
+		c.frames.Add(1)
+	}
+}
@@ -0,0 +1,292 @@
+package sim
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// DefaultCapabilities 模拟设备默认上报的能力，与 Android 端 WebSocketClient.CAPABILITIES 一致
+// 模拟设备没有 WebRTC 协议栈，不上报 webrtc；隐私屏、现场确认和聊天只做协议层应答
+var DefaultCapabilities = []string{
+	protocol.CapH264,
+	protocol.CapMJPEG,
+	protocol.CapPrivacy,
+	protocol.CapClipboard,
+	protocol.CapCommand,
+	protocol.CapAck,
+	protocol.CapMultiTouch,
+	protocol.CapKeyframe,
+	protocol.CapThumbnail,
+	protocol.CapRegion,
+	protocol.CapConsent,
+	protocol.CapChat,
+}
+
+// DeviceConfig 模拟设备参数
+type DeviceConfig struct {
+	ServerURL    string // 服务端地址，如 ws://127.0.0.1:9222
+	DeviceID     string
+	DeviceName   string
+	Token        string // 设备连接 Token
+	ScreenWidth  int
+	ScreenHeight int
+	Capabilities []string      // 为空时使用 DefaultCapabilities
+	Commands     []string      // 为空时按服务端默认（hide_keyboard）
+	Heartbeat    time.Duration // 心跳间隔，默认 15s
+	Subprotocol  string        // 消息编码子协议，为空使用 JSON
+	FailInput    bool          // 输入消息回执 failure（模拟执行失败）
+	DenyConsent  bool          // 拒绝控制请求（默认收到 control.pending 后立即同意）
+	NoConsent    bool          // 不答复控制请求（模拟现场无人响应）
+}
+
+// FakeDevice 模拟被控设备：注册、心跳，收到 stream.start 后推送合成帧，并回执输入消息
+type FakeDevice struct {
+	cfg  DeviceConfig
+	conn *wsConn
+
+	messages   chan Message
+	done       chan struct{}
+	closeCode  atomic.Int32
+	framesSent atomic.Int64
+
+	streamMutex sync.Mutex
+	streamStop  chan struct{}
+	source      *FrameSource
+}
+
+// ConnectDevice 连接服务端并发送注册消息
+func ConnectDevice(cfg DeviceConfig) (*FakeDevice, error) {
+	if cfg.DeviceName == "" {
+		cfg.DeviceName = cfg.DeviceID
+	}
+	if cfg.ScreenWidth == 0 || cfg.ScreenHeight == 0 {
+		cfg.ScreenWidth, cfg.ScreenHeight = 720, 1280
+	}
+	if len(cfg.Capabilities) == 0 {
+		cfg.Capabilities = DefaultCapabilities
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = 15 * time.Second
+	}
+
+	conn, err := dial(strings.TrimRight(cfg.ServerURL, "/")+"/ws/device", cfg.Subprotocol)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &FakeDevice{
+		cfg:      cfg,
+		conn:     conn,
+		messages: make(chan Message, 256),
+		done:     make(chan struct{}),
+	}
+	err = conn.sendJSON(protocol.DeviceRegisterMessage{
+		Type:            protocol.TypeDeviceRegister,
+		DeviceID:        cfg.DeviceID,
+		DeviceName:      cfg.DeviceName,
+		ScreenWidth:     cfg.ScreenWidth,
+		ScreenHeight:    cfg.ScreenHeight,
+		Token:           cfg.Token,
+		ProtocolVersion: protocol.ProtocolVersion,
+		Capabilities:    cfg.Capabilities,
+		Commands:        cfg.Commands,
+	})
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	go d.readLoop()
+	go d.heartbeatLoop()
+	return d, nil
+}
+
+// Messages 收到的协议消息（缓冲区满时丢弃最旧的消息）
+func (d *FakeDevice) Messages() <-chan Message {
+	return d.messages
+}
+
+// WaitMessage 等待指定类型的消息，期间收到的其他消息被丢弃
+func (d *FakeDevice) WaitMessage(timeout time.Duration, types ...string) (Message, error) {
+	return waitMessage(d.messages, d.done, timeout, types...)
+}
+
+// Done 连接断开时关闭
+func (d *FakeDevice) Done() <-chan struct{} {
+	return d.done
+}
+
+// CloseCode 服务端关闭连接时的关闭码（连接断开后有效）
+func (d *FakeDevice) CloseCode() int {
+	return int(d.closeCode.Load())
+}
+
+// FramesSent 已发送的帧数
+func (d *FakeDevice) FramesSent() int64 {
+	return d.framesSent.Load()
+}
+
+// Streaming 是否正在推流
+func (d *FakeDevice) Streaming() bool {
+	d.streamMutex.Lock()
+	defer d.streamMutex.Unlock()
+	return d.streamStop != nil
+}
+
+// Send 发送协议消息（如 chat.message、clipboard.update）
+func (d *FakeDevice) Send(v interface{}) error {
+	return d.conn.sendJSON(v)
+}
+
+// Close 断开连接
+func (d *FakeDevice) Close() error {
+	d.stopStream()
+	return d.conn.close()
+}
+
+func (d *FakeDevice) readLoop() {
+	defer func() {
+		d.stopStream()
+		close(d.done)
+	}()
+
+	for {
+		msg, _, err := d.conn.read()
+		if err != nil {
+			d.closeCode.Store(int32(closeCode(err)))
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		d.handle(*msg)
+		deliver(d.messages, *msg)
+	}
+}
+
+func (d *FakeDevice) handle(msg Message) {
+	switch msg.Type {
+	case protocol.TypeStreamStart:
+		var params protocol.StreamControlMessage
+		msg.Decode(&params)
+		d.startStream(params)
+	case protocol.TypeStreamStop:
+		d.stopStream()
+	case protocol.TypeStreamKeyframe:
+		d.streamMutex.Lock()
+		if d.source != nil {
+			d.source.ForceKeyframe()
+		}
+		d.streamMutex.Unlock()
+	case protocol.TypeScreenThumbnail:
+		thumbnail := NewFrameSource("mjpeg", 1).jpeg()
+		d.conn.write(websocket.BinaryMessage, frame(protocol.BinaryTypeThumbnail, 0, thumbnail))
+	case protocol.TypeControlPending:
+		if d.cfg.NoConsent {
+			break
+		}
+		var pending protocol.ControlPendingMessage
+		msg.Decode(&pending)
+		reply := protocol.ControlConsentMessage{
+			Type:      protocol.TypeControlConsent,
+			SessionID: pending.SessionID,
+			Approved:  !d.cfg.DenyConsent,
+		}
+		if d.cfg.DenyConsent {
+			reply.Reason = "simulated denial"
+		}
+		d.conn.sendJSON(reply)
+	}
+
+	if msg.RequestID != "" && (strings.HasPrefix(msg.Type, "input.") || msg.Type == protocol.TypeClipboardSet) {
+		status := protocol.CommandStatusSuccess
+		errText := ""
+		if d.cfg.FailInput {
+			status, errText = protocol.CommandStatusFailure, "simulated failure"
+		}
+		d.conn.sendJSON(protocol.CommandResultMessage{
+			Type:      protocol.TypeCommandResult,
+			RequestID: msg.RequestID,
+			Status:    status,
+			Error:     errText,
+		})
+	}
+}
+
+func (d *FakeDevice) heartbeatLoop() {
+	ticker := time.NewTicker(d.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.conn.sendJSON(protocol.BaseMessage{Type: protocol.TypeDeviceHeartbeat}); err != nil {
+				return
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// startStream 按推流参数开始推送合成帧，重复的 stream.start 会重启推流
+func (d *FakeDevice) startStream(params protocol.StreamControlMessage) {
+	d.stopStream()
+
+	fps := params.FPS
+	if params.Mode != "h264" {
+		fps = params.MaxFPS
+	}
+	if fps <= 0 {
+		fps = 30
+	}
+	mode := params.Mode
+	if mode == "" {
+		mode = "mjpeg"
+	}
+	source := NewFrameSource(mode, fps)
+	stop := make(chan struct{})
+
+	d.streamMutex.Lock()
+	d.source = source
+	d.streamStop = stop
+	d.streamMutex.Unlock()
+
+	log.Printf("模拟设备开始推流: %s %s %dfps", d.cfg.DeviceID, mode, fps)
+	go func() {
+		ticker := time.NewTicker(time.Second / time.Duration(fps))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			d.streamMutex.Lock()
+			frames := source.Next()
+			d.streamMutex.Unlock()
+			for _, f := range frames {
+				if err := d.conn.write(websocket.BinaryMessage, f); err != nil {
+					return
+				}
+				d.framesSent.Add(1)
+			}
+		}
+	}()
+}
+
+func (d *FakeDevice) stopStream() {
+	d.streamMutex.Lock()
+	defer d.streamMutex.Unlock()
+	if d.streamStop != nil {
+		close(d.streamStop)
+		d.streamStop = nil
+		d.source = nil
+	}
+}
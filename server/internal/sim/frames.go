@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// 合成的 H264 Baseline 参数集（320x240），切片数据不可解码，仅用于验证转发链路
+var (
+	syntheticSPS = []byte{0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+	syntheticPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// FrameSource 按推流模式生成合成屏幕帧，与 Android 端一致：H264 帧带 [type][flags] 帧头，MJPEG 直接发送 JPEG 数据
+type FrameSource struct {
+	mode     string
+	gop      int // 关键帧间隔（帧数）
+	seq      int
+	forceKey bool
+}
+
+// NewFrameSource 创建帧源，mode 为 h264 或 mjpeg，fps 用于计算 2 秒的关键帧间隔
+func NewFrameSource(mode string, fps int) *FrameSource {
+	if fps <= 0 {
+		fps = 30
+	}
+	return &FrameSource{mode: mode, gop: fps * 2, forceKey: true}
+}
+
+// ForceKeyframe 下一帧输出关键帧（响应 stream.keyframe）
+func (s *FrameSource) ForceKeyframe() {
+	s.forceKey = true
+}
+
+// Next 返回下一次需要发送的帧，H264 关键帧前先输出配置帧
+func (s *FrameSource) Next() [][]byte {
+	defer func() { s.seq++ }()
+
+	if s.mode != "h264" {
+		return [][]byte{s.jpeg()}
+	}
+
+	key := s.forceKey || s.seq%s.gop == 0
+	if !key {
+		return [][]byte{frame(protocol.BinaryTypeH264Frame, 0, s.slice(0x41))}
+	}
+	s.forceKey = false
+	config := append(append(append(append([]byte{}, startCode...), syntheticSPS...), startCode...), syntheticPPS...)
+	return [][]byte{
+		frame(protocol.BinaryTypeH264Config, 0, config),
+		frame(protocol.BinaryTypeH264Frame, protocol.FrameFlagKeyFrame, s.slice(0x65)),
+	}
+}
+
+// slice 生成一个 NAL 单元，负载中写入帧序号便于排查
+func (s *FrameSource) slice(header byte) []byte {
+	nal := append([]byte{}, startCode...)
+	nal = append(nal, header, 0x88, byte(s.seq>>8), byte(s.seq))
+	return append(nal, bytes.Repeat([]byte{0x5a}, 64)...)
+}
+
+// jpeg 生成一张颜色随帧序号变化的小图
+func (s *FrameSource) jpeg() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	c := color.RGBA{R: byte(s.seq * 7), G: byte(s.seq * 3), B: 128, A: 255}
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 50})
+	return buf.Bytes()
+}
+
+func frame(frameType, flags byte, payload []byte) []byte {
+	return append([]byte{frameType, flags}, payload...)
+}
@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// ScriptStep 脚本中的一步：等待 Delay 后发送 Message（Message 为空表示仅等待）
+type ScriptStep struct {
+	Delay   time.Duration
+	Message map[string]interface{}
+}
+
+// ParseScript 解析输入脚本
+// 每行一条 JSON 协议消息；"sleep 500ms" 表示等待；空行和 # 开头的行忽略
+func ParseScript(r io.Reader) ([]ScriptStep, error) {
+	var steps []ScriptStep
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(text, "sleep "); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(rest))
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %w", line, err)
+			}
+			steps = append(steps, ScriptStep{Delay: d})
+			continue
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &msg); err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", line, err)
+		}
+		if _, ok := msg["type"].(string); !ok {
+			return nil, fmt.Errorf("第 %d 行: 缺少 type", line)
+		}
+		steps = append(steps, ScriptStep{Message: msg})
+	}
+	return steps, scanner.Err()
+}
+
+// DefaultScript 默认输入脚本：点击屏幕中心、上滑、返回键、输入文本
+func DefaultScript(width, height int) []ScriptStep {
+	cx, cy := width/2, height/2
+	return []ScriptStep{
+		{Message: map[string]interface{}{"type": protocol.TypeInputTouch, "action": protocol.TouchActionTap, "x": cx, "y": cy}},
+		{Delay: 300 * time.Millisecond},
+		{Message: map[string]interface{}{"type": protocol.TypeInputTouch, "action": protocol.TouchActionSwipe,
+			"startX": cx, "startY": height * 3 / 4, "endX": cx, "endY": height / 4, "duration": 300}},
+		{Delay: 300 * time.Millisecond},
+		{Message: map[string]interface{}{"type": protocol.TypeInputKey, "keyCode": 4, "action": protocol.KeyActionDown}},
+		{Message: map[string]interface{}{"type": protocol.TypeInputKey, "keyCode": 4, "action": protocol.KeyActionUp}},
+		{Delay: 300 * time.Millisecond},
+		{Message: map[string]interface{}{"type": protocol.TypeInputText, "text": "hello"}},
+	}
+}
+
+// RunScript 依次执行脚本，每条消息附加 requestId 并等待 command.result
+// 设备回执 failure / timeout 时返回错误
+func (c *FakeController) RunScript(steps []ScriptStep, timeout time.Duration) error {
+	for i, step := range steps {
+		if step.Delay > 0 {
+			time.Sleep(step.Delay)
+		}
+		if step.Message == nil {
+			continue
+		}
+
+		requestID := c.NextRequestID()
+		step.Message["requestId"] = requestID
+		if err := c.Send(step.Message); err != nil {
+			return fmt.Errorf("第 %d 步发送失败: %w", i+1, err)
+		}
+
+		msg, err := c.WaitMessage(timeout, protocol.TypeCommandResult, protocol.TypeError)
+		if err != nil {
+			return fmt.Errorf("第 %d 步等待回执失败: %w", i+1, err)
+		}
+		if msg.Type == protocol.TypeError {
+			var errMsg protocol.ErrorMessage
+			msg.Decode(&errMsg)
+			return fmt.Errorf("第 %d 步: %w", i+1, &ServerError{Code: errMsg.Code, Message: errMsg.Message})
+		}
+		var result protocol.CommandResultMessage
+		msg.Decode(&result)
+		if result.Status != protocol.CommandStatusSuccess && result.Status != protocol.CommandStatusSent {
+			return fmt.Errorf("第 %d 步执行失败: %s %s", i+1, result.Status, result.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"shushu-remote-control/internal/model"
+)
+
+var ErrBandwidthQuotaNotFound = errors.New("bandwidth quota not found")
+
+const bandwidthDayLayout = "2006-01-02"
+
+// AddBandwidthUsage adds relayed traffic to the per-device daily aggregates.
+func (s *DeviceStore) AddBandwidthUsage(records []model.BandwidthDaily) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	const query = `
+INSERT INTO rc_bandwidth_daily (device_id, day, device_in, device_out, controller_in, controller_out, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, NOW())
+ON DUPLICATE KEY UPDATE
+  device_in = device_in + VALUES(device_in),
+  device_out = device_out + VALUES(device_out),
+  controller_in = controller_in + VALUES(controller_in),
+  controller_out = controller_out + VALUES(controller_out),
+  updated_at = NOW()
+`
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if _, err := stmt.Exec(r.DeviceID, r.Day, r.Device.BytesIn, r.Device.BytesOut, r.Controller.BytesIn, r.Controller.BytesOut); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListBandwidthUsage lists a device's daily aggregates between from and to (inclusive).
+func (s *DeviceStore) ListBandwidthUsage(deviceID string, from, to time.Time) ([]model.BandwidthDaily, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+	const query = `
+SELECT device_id, DATE_FORMAT(day, '%Y-%m-%d'), device_in, device_out, controller_in, controller_out
+FROM rc_bandwidth_daily
+WHERE device_id = ? AND day BETWEEN ? AND ?
+ORDER BY day
+`
+	rows, err := s.db.Query(query, deviceID, from.Format(bandwidthDayLayout), to.Format(bandwidthDayLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.BandwidthDaily, 0)
+	for rows.Next() {
+		var r model.BandwidthDaily
+		if err := rows.Scan(&r.DeviceID, &r.Day, &r.Device.BytesIn, &r.Device.BytesOut, &r.Controller.BytesIn, &r.Controller.BytesOut); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// SaveBandwidthQuota inserts or replaces a device's monthly quota.
+func (s *DeviceStore) SaveBandwidthQuota(quota *model.BandwidthQuota) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	const query = `
+INSERT INTO rc_bandwidth_quotas (device_id, monthly_bytes, action, profile, updated_at)
+VALUES (?, ?, ?, ?, NOW())
+ON DUPLICATE KEY UPDATE
+  monthly_bytes = VALUES(monthly_bytes),
+  action = VALUES(action),
+  profile = VALUES(profile),
+  updated_at = NOW()
+`
+	_, err := s.db.Exec(query, quota.DeviceID, quota.MonthlyBytes, quota.Action, quota.Profile)
+	return err
+}
+
+// ListBandwidthQuotas lists all device quotas.
+func (s *DeviceStore) ListBandwidthQuotas() ([]model.BandwidthQuota, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+	rows, err := s.db.Query(`SELECT device_id, monthly_bytes, action, profile, updated_at FROM rc_bandwidth_quotas ORDER BY device_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.BandwidthQuota, 0)
+	for rows.Next() {
+		var q model.BandwidthQuota
+		if err := rows.Scan(&q.DeviceID, &q.MonthlyBytes, &q.Action, &q.Profile, &q.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// DeleteBandwidthQuota removes a device's quota.
+func (s *DeviceStore) DeleteBandwidthQuota(deviceID string) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	result, err := s.db.Exec(`DELETE FROM rc_bandwidth_quotas WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBandwidthQuotaNotFound
+	}
+	return nil
+}
+
+// DeviceBandwidthQuota returns a device's quota, with the downgrade profile resolved,
+// and the device-link traffic persisted since the given day.
+// Returns a nil quota when the device has none.
+func (s *DeviceStore) DeviceBandwidthQuota(deviceID string, since time.Time) (*model.BandwidthQuota, int64, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, errors.New("store not initialized")
+	}
+
+	var q model.BandwidthQuota
+	err := s.db.QueryRow(`SELECT device_id, monthly_bytes, action, profile, updated_at FROM rc_bandwidth_quotas WHERE device_id = ?`, deviceID).
+		Scan(&q.DeviceID, &q.MonthlyBytes, &q.Action, &q.Profile, &q.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	if q.Action == model.QuotaActionDowngrade && q.Profile != "" {
+		rows, err := s.db.Query(`SELECT `+streamProfileColumns+` FROM rc_stream_profiles WHERE name = ?`, q.Profile)
+		if err != nil {
+			return nil, 0, err
+		}
+		profiles, err := scanStreamProfiles(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(profiles) > 0 {
+			q.Downgrade = &profiles[0]
+		}
+	}
+
+	var used int64
+	const query = `
+SELECT COALESCE(SUM(device_in + device_out), 0)
+FROM rc_bandwidth_daily
+WHERE device_id = ? AND day >= ?
+`
+	if err := s.db.QueryRow(query, deviceID, since.Format(bandwidthDayLayout)).Scan(&used); err != nil {
+		return nil, 0, err
+	}
+	return &q, used, nil
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"shushu-remote-control/internal/protocol"
+)
+
+// InsertChatTranscript stores the chat messages relayed during a session.
+func (s *DeviceStore) InsertChatTranscript(sessionID, deviceID string, messages []protocol.ChatMessage) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO rc_session_chat (session_id, device_id, message_id, sender, text, sent_at) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, msg := range messages {
+		if _, err := stmt.Exec(sessionID, deviceID, msg.ID, msg.From, msg.Text, time.UnixMilli(msg.Timestamp)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
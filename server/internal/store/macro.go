@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"shushu-remote-control/internal/model"
+)
+
+var ErrMacroNotFound = errors.New("macro not found")
+
+// SaveMacro inserts or replaces a named input macro.
+func (s *DeviceStore) SaveMacro(macro *model.Macro) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	events, err := json.Marshal(macro.Events)
+	if err != nil {
+		return err
+	}
+	const query = `
+INSERT INTO rc_macros (name, screen_width, screen_height, events, created_at)
+VALUES (?, ?, ?, ?, NOW())
+ON DUPLICATE KEY UPDATE
+  screen_width = VALUES(screen_width),
+  screen_height = VALUES(screen_height),
+  events = VALUES(events),
+  created_at = NOW()
+`
+	_, err = s.db.Exec(query, macro.Name, macro.ScreenWidth, macro.ScreenHeight, events)
+	return err
+}
+
+// GetMacro loads a macro with its events.
+func (s *DeviceStore) GetMacro(name string) (*model.Macro, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+
+	var (
+		macro  model.Macro
+		events []byte
+	)
+	err := s.db.QueryRow(
+		`SELECT name, screen_width, screen_height, events, created_at FROM rc_macros WHERE name = ?`,
+		name,
+	).Scan(&macro.Name, &macro.ScreenWidth, &macro.ScreenHeight, &events, &macro.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMacroNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(events, &macro.Events); err != nil {
+		return nil, err
+	}
+	return &macro, nil
+}
+
+// ListMacros lists macros without their events.
+func (s *DeviceStore) ListMacros() ([]model.Macro, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+
+	rows, err := s.db.Query(`SELECT name, screen_width, screen_height, created_at FROM rc_macros ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.Macro, 0)
+	for rows.Next() {
+		var macro model.Macro
+		if err := rows.Scan(&macro.Name, &macro.ScreenWidth, &macro.ScreenHeight, &macro.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, macro)
+	}
+	return list, rows.Err()
+}
+
+// DeleteMacro removes a macro by name.
+func (s *DeviceStore) DeleteMacro(name string) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	result, err := s.db.Exec(`DELETE FROM rc_macros WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMacroNotFound
+	}
+	return nil
+}
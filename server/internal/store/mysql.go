@@ -76,7 +76,7 @@ func (s *DeviceStore) ValidateControlToken(deviceID, token string) (*model.Devic
 	}
 
 	const query = `
-SELECT id, name, alias, screen_width, screen_height, token, token_expires, online
+SELECT id, name, alias, screen_width, screen_height, token, token_expires, online, require_consent
 FROM rc_devices
 WHERE id = ?
 `
@@ -89,6 +89,7 @@ WHERE id = ?
 		dbToken      string
 		tokenExpires sql.NullTime
 		online       bool
+		consent      bool
 	)
 
 	if err := s.db.QueryRow(query, deviceID).Scan(
@@ -100,6 +101,7 @@ WHERE id = ?
 		&dbToken,
 		&tokenExpires,
 		&online,
+		&consent,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrDeviceNotFound
@@ -121,14 +123,29 @@ WHERE id = ?
 	}
 
 	return &model.Device{
-		ID:           id,
-		Name:         displayName,
-		ScreenWidth:  screenWidth,
-		ScreenHeight: screenHeight,
-		Online:       online,
+		ID:              id,
+		Name:            displayName,
+		ScreenWidth:     screenWidth,
+		ScreenHeight:    screenHeight,
+		Online:          online,
+		ConsentRequired: consent,
 	}, nil
 }
 
+// DeviceConsentRequired reports whether the device requires on-site consent before it can be controlled.
+func (s *DeviceStore) DeviceConsentRequired(deviceID string) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, nil
+	}
+
+	var consent bool
+	err := s.db.QueryRow(`SELECT require_consent FROM rc_devices WHERE id = ?`, deviceID).Scan(&consent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return consent, err
+}
+
 // SetOnline updates device online status and last seen timestamp.
 func (s *DeviceStore) SetOnline(deviceID string, online bool) error {
 	if s == nil || s.db == nil {
@@ -152,3 +169,19 @@ func (s *DeviceStore) UpdateDeviceInfo(device *model.Device) error {
 	)
 	return err
 }
+
+// InsertSessionAudit appends an event to the session audit trail.
+func (s *DeviceStore) InsertSessionAudit(sessionID, deviceID, controllerID, event, detail string) error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO rc_session_audit (session_id, device_id, controller_id, event, detail, created_at) VALUES (?, ?, ?, ?, ?, NOW())`,
+		sessionID,
+		deviceID,
+		controllerID,
+		event,
+		detail,
+	)
+	return err
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"shushu-remote-control/internal/model"
+	"shushu-remote-control/internal/protocol"
+)
+
+var (
+	ErrStreamProfileNotFound    = errors.New("stream profile not found")
+	ErrStreamAssignmentNotFound = errors.New("stream assignment not found")
+)
+
+const streamProfileColumns = `name, mode, bitrate, fps, mjpeg_quality, max_width, max_height`
+
+// SaveStreamProfile inserts or replaces a named stream profile.
+func (s *DeviceStore) SaveStreamProfile(profile *protocol.StreamProfile) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	const query = `
+INSERT INTO rc_stream_profiles (` + streamProfileColumns + `, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, NOW())
+ON DUPLICATE KEY UPDATE
+  mode = VALUES(mode),
+  bitrate = VALUES(bitrate),
+  fps = VALUES(fps),
+  mjpeg_quality = VALUES(mjpeg_quality),
+  max_width = VALUES(max_width),
+  max_height = VALUES(max_height),
+  updated_at = NOW()
+`
+	_, err := s.db.Exec(query, profile.Name, profile.Mode, profile.Bitrate, profile.FPS, profile.MJPEGQuality, profile.MaxWidth, profile.MaxHeight)
+	return err
+}
+
+// ListStreamProfiles lists all stream profiles.
+func (s *DeviceStore) ListStreamProfiles() ([]protocol.StreamProfile, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+	rows, err := s.db.Query(`SELECT ` + streamProfileColumns + ` FROM rc_stream_profiles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return scanStreamProfiles(rows)
+}
+
+// DeleteStreamProfile removes a stream profile. Assignments that still reference it
+// fall back to the built-in defaults until they are updated.
+func (s *DeviceStore) DeleteStreamProfile(name string) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	result, err := s.db.Exec(`DELETE FROM rc_stream_profiles WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrStreamProfileNotFound
+	}
+	return nil
+}
+
+// SaveStreamAssignment assigns a profile (and the profiles controllers may switch to)
+// to a device, a group or the global default.
+func (s *DeviceStore) SaveStreamAssignment(assignment *model.StreamAssignment) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	allowed, err := json.Marshal(assignment.Allowed)
+	if err != nil {
+		return err
+	}
+	const query = `
+INSERT INTO rc_stream_assignments (scope, target_id, profile, allowed, updated_at)
+VALUES (?, ?, ?, ?, NOW())
+ON DUPLICATE KEY UPDATE
+  profile = VALUES(profile),
+  allowed = VALUES(allowed),
+  updated_at = NOW()
+`
+	_, err = s.db.Exec(query, assignment.Scope, assignment.TargetID, assignment.Profile, allowed)
+	return err
+}
+
+// ListStreamAssignments lists all profile assignments.
+func (s *DeviceStore) ListStreamAssignments() ([]model.StreamAssignment, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("store not initialized")
+	}
+	rows, err := s.db.Query(`SELECT scope, target_id, profile, allowed, updated_at FROM rc_stream_assignments ORDER BY scope, target_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.StreamAssignment, 0)
+	for rows.Next() {
+		var (
+			assignment model.StreamAssignment
+			allowed    []byte
+		)
+		if err := rows.Scan(&assignment.Scope, &assignment.TargetID, &assignment.Profile, &allowed, &assignment.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(allowed, &assignment.Allowed); err != nil {
+			return nil, err
+		}
+		list = append(list, assignment)
+	}
+	return list, rows.Err()
+}
+
+// DeleteStreamAssignment removes a profile assignment.
+func (s *DeviceStore) DeleteStreamAssignment(scope, targetID string) error {
+	if s == nil || s.db == nil {
+		return errors.New("store not initialized")
+	}
+	result, err := s.db.Exec(`DELETE FROM rc_stream_assignments WHERE scope = ? AND target_id = ?`, scope, targetID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrStreamAssignmentNotFound
+	}
+	return nil
+}
+
+// DeviceStreamProfiles resolves the profile a device starts its sessions with
+// (device assignment, then its group, then the global default) and the profiles
+// its controllers may switch to, the assigned profile first.
+// Returns nil when no assignment applies.
+func (s *DeviceStore) DeviceStreamProfiles(deviceID string) (*protocol.StreamProfile, []protocol.StreamProfile, error) {
+	if s == nil || s.db == nil {
+		return nil, nil, errors.New("store not initialized")
+	}
+
+	const query = `
+SELECT a.profile, a.allowed
+FROM rc_stream_assignments a
+LEFT JOIN rc_devices d ON d.id = ?
+WHERE (a.scope = 'device' AND a.target_id = ?)
+   OR (a.scope = 'group' AND d.group_id <> '' AND a.target_id = d.group_id)
+   OR a.scope = 'global'
+ORDER BY FIELD(a.scope, 'device', 'group', 'global')
+LIMIT 1
+`
+	var (
+		name       string
+		allowedRaw []byte
+		allowed    []string
+	)
+	if err := s.db.QueryRow(query, deviceID, deviceID).Scan(&name, &allowedRaw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(allowedRaw, &allowed); err != nil {
+		return nil, nil, err
+	}
+
+	names := append([]string{name}, allowed...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	args := make([]interface{}, len(names))
+	for i, n := range names {
+		args[i] = n
+	}
+	rows, err := s.db.Query(`SELECT `+streamProfileColumns+` FROM rc_stream_profiles WHERE name IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	found, err := scanStreamProfiles(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byName := make(map[string]protocol.StreamProfile, len(found))
+	for _, profile := range found {
+		byName[profile.Name] = profile
+	}
+	current, ok := byName[name]
+	if !ok {
+		return nil, nil, ErrStreamProfileNotFound
+	}
+	profiles := make([]protocol.StreamProfile, 0, len(names))
+	for _, n := range names {
+		if profile, ok := byName[n]; ok {
+			profiles = append(profiles, profile)
+			delete(byName, n)
+		}
+	}
+	return &current, profiles, nil
+}
+
+func scanStreamProfiles(rows *sql.Rows) ([]protocol.StreamProfile, error) {
+	defer rows.Close()
+
+	list := make([]protocol.StreamProfile, 0)
+	for rows.Next() {
+		var p protocol.StreamProfile
+		if err := rows.Scan(&p.Name, &p.Mode, &p.Bitrate, &p.FPS, &p.MJPEGQuality, &p.MaxWidth, &p.MaxHeight); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
@@ -42,6 +42,15 @@ export class WebRTCClient {
   onIceConnectionStateChange: ((state: RTCIceConnectionState) => void) | null = null
   onError: ((error: string) => void) | null = null
 
+  /**
+   * 使用服务器下发的 STUN/TURN 配置（替换内置的公共 STUN），需在 initialize 之前调用
+   */
+  setIceServers(servers: IceServerConfig[]) {
+    if (servers.length > 0) {
+      this.config.iceServers = servers
+    }
+  }
+
   /**
    * 添加自定义 TURN 服务器
    */